@@ -0,0 +1,56 @@
+package gophertags
+
+import (
+	"sync/atomic"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+// Accelerator performs the group operations that dominate DetectionKey.Test,
+// so they can be offloaded to a GPU or a dedicated service. Implementations
+// must be safe for concurrent use and must compute exactly what the pure-Go
+// default does; a wrong result silently turns into missed or spurious matches.
+type Accelerator interface {
+	// MultiScalarMult returns the sum of scalars[i]·points[i]. Test calls it
+	// with two terms to compute w = m·B + y·u.
+	MultiScalarMult(scalars []*r255.Scalar, points []*r255.Element) *r255.Element
+
+	// ScalarMults sets out[i] = scalars[i]·point for every i. Test calls it
+	// with the detection key's secret scalars and the flag's u. out has the
+	// same length as scalars and its elements are allocated.
+	ScalarMults(out []*r255.Element, scalars []*r255.Scalar, point *r255.Element)
+}
+
+type pureGo struct{}
+
+func (pureGo) MultiScalarMult(scalars []*r255.Scalar, points []*r255.Element) *r255.Element {
+	return r255.NewElement().MultiScalarMult(scalars, points)
+}
+
+func (pureGo) ScalarMults(out []*r255.Element, scalars []*r255.Scalar, point *r255.Element) {
+	for i, x := range scalars {
+		out[i].ScalarMult(x, point)
+	}
+}
+
+// acceleratorBox gives atomic.Value a single concrete type to store.
+type acceleratorBox struct{ Accelerator }
+
+var accelerator atomic.Value
+
+func init() {
+	accelerator.Store(acceleratorBox{pureGo{}})
+}
+
+// SetAccelerator routes the group operations of every subsequent Test through
+// a. Nil restores the pure-Go implementation.
+func SetAccelerator(a Accelerator) {
+	if a == nil {
+		a = pureGo{}
+	}
+	accelerator.Store(acceleratorBox{a})
+}
+
+func currentAccelerator() Accelerator {
+	return accelerator.Load().(acceleratorBox).Accelerator
+}
@@ -0,0 +1,48 @@
+package gophertags
+
+import (
+	"sync/atomic"
+	"testing"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+// countingAccelerator delegates to the pure-Go implementation, counting calls.
+type countingAccelerator struct {
+	msm, mults int64
+}
+
+func (c *countingAccelerator) MultiScalarMult(scalars []*r255.Scalar, points []*r255.Element) *r255.Element {
+	atomic.AddInt64(&c.msm, 1)
+	return pureGo{}.MultiScalarMult(scalars, points)
+}
+
+func (c *countingAccelerator) ScalarMults(out []*r255.Element, scalars []*r255.Scalar, point *r255.Element) {
+	atomic.AddInt64(&c.mults, int64(len(scalars)))
+	pureGo{}.ScalarMults(out, scalars, point)
+}
+
+func TestAccelerator(t *testing.T) {
+	acc := new(countingAccelerator)
+	SetAccelerator(acc)
+	defer SetAccelerator(nil)
+
+	sk := NewSecretKey(16)
+	dk := sk.ExtractDetectionKey(10)
+	if !dk.Test(sk.PublicKey().GenerateFlag()) {
+		t.Error("flag doesn't match through the accelerator")
+	}
+	other := NewSecretKey(16).PublicKey()
+	first, second := dk.Test(other.GenerateFlag()), dk.Test(other.GenerateFlag())
+	if first && second {
+		t.Error("unrelated flags match through the accelerator")
+	}
+	if acc.msm != 3 || acc.mults != 30 {
+		t.Errorf("accelerator saw %d multi-scalar and %d scalar multiplications, want 3 and 30", acc.msm, acc.mults)
+	}
+
+	SetAccelerator(nil)
+	if _, ok := currentAccelerator().(pureGo); !ok {
+		t.Error("SetAccelerator(nil) didn't restore the default")
+	}
+}
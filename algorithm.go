@@ -0,0 +1,51 @@
+package gophertags
+
+import (
+	"sort"
+	"strings"
+)
+
+// Every key and flag encoding starts with an algorithm ID. This package
+// implements one family of algorithms, FMD2 over ristretto255, whose members
+// differ only in their hash functions, so the algorithm ID is the ID of the
+// HashScheme and the registry of algorithms is the registry of hash schemes.
+// A future variant over another group or with another tagging scheme would
+// take an unused ID, and artifacts of different algorithms can then be
+// handled side by side on one server: decoding picks the implementation by
+// ID, flags never match keys of another algorithm, and neither KeyIDs nor
+// fingerprints collide across algorithms.
+
+const algorithmPrefix = "fmd2-ristretto255-"
+
+// AlgorithmName returns the name of the algorithm instantiated with h, such
+// as "fmd2-ristretto255-sha3", for text formats and configuration.
+func AlgorithmName(h HashScheme) string {
+	return algorithmPrefix + strings.ToLower(schemeOf(h).Name())
+}
+
+// ParseAlgorithm returns the registered scheme whose AlgorithmName is name.
+func ParseAlgorithm(name string) (HashScheme, error) {
+	for _, h := range HashSchemes() {
+		if AlgorithmName(h) == name {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownHashScheme
+}
+
+// HashSchemes returns every registered scheme, in ID order.
+func HashSchemes() []HashScheme {
+	hashSchemesMu.RLock()
+	defer hashSchemesMu.RUnlock()
+	schemes := make([]HashScheme, 0, len(hashSchemes))
+	for _, h := range hashSchemes {
+		schemes = append(schemes, h)
+	}
+	sort.Slice(schemes, func(i, j int) bool { return schemes[i].ID() < schemes[j].ID() })
+	return schemes
+}
+
+// HashScheme returns the hash functions the flag was generated with.
+func (f *Flag) HashScheme() HashScheme {
+	return schemeOf(f.hash)
+}
@@ -0,0 +1,44 @@
+package gophertags
+
+import "testing"
+
+func TestAlgorithmRegistry(t *testing.T) {
+	schemes := HashSchemes()
+	if len(schemes) < 2 || schemes[0] != SHA3 || schemes[1] != BLAKE2b {
+		t.Fatalf("HashSchemes = %v", schemes)
+	}
+	for _, h := range schemes {
+		got, err := ParseAlgorithm(AlgorithmName(h))
+		if err != nil || got != h {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v", AlgorithmName(h), got, err)
+		}
+		if got, ok := LookupHashScheme(h.ID()); !ok || got != h {
+			t.Errorf("LookupHashScheme(%#x) = %v, %v", h.ID(), got, ok)
+		}
+	}
+	if AlgorithmName(nil) != "fmd2-ristretto255-sha3" {
+		t.Errorf("default algorithm is %q", AlgorithmName(nil))
+	}
+	if _, err := ParseAlgorithm("fmd2-ristretto255-md5"); err != ErrUnknownHashScheme {
+		t.Errorf("unknown algorithm: %v", err)
+	}
+}
+
+func TestAlgorithmsCoexist(t *testing.T) {
+	// The same secret under two algorithms must not be confused.
+	sk := NewSecretKey(8)
+	other, err := DecodeSecretKey(append([]byte{BLAKE2b.ID()}, sk.Encode(nil)[1:]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sk.PublicKey().KeyID() == other.PublicKey().KeyID() {
+		t.Error("KeyIDs collide across algorithms")
+	}
+	if other.PublicKey().KeyID() != other.ExtractDetectionKey(4).KeyID() {
+		t.Error("BLAKE2b public and detection key IDs differ")
+	}
+	f := other.PublicKey().GenerateFlag()
+	if f.HashScheme() != BLAKE2b || !other.ExtractDetectionKey(8).Test(f) {
+		t.Errorf("BLAKE2b flag: scheme %v", f.HashScheme().Name())
+	}
+}
@@ -0,0 +1,74 @@
+// Package analysis helps operators and users reason about the privacy a
+// deployment of fuzzy tags actually delivers.
+package analysis
+
+import "github.com/gtank/gophertags"
+
+// Recipient describes one registered detection key.
+type Recipient struct {
+	KeyID gophertags.KeyID
+
+	// FalsePositiveRate is the key's rate, 2^-n for a key of precision n.
+	FalsePositiveRate float64
+
+	// Messages is the number of messages truly addressed to the recipient in
+	// the period under study. It can be an estimate, or zero if unknown.
+	Messages float64
+}
+
+// RecipientOf describes a detection key that receives the given number of messages.
+func RecipientOf(dk *gophertags.DetectionKey, messages float64) Recipient {
+	return Recipient{KeyID: dk.KeyID(), FalsePositiveRate: dk.FalsePositiveRate(), Messages: messages}
+}
+
+// Estimate is the expected view a detection server has of one recipient.
+type Estimate struct {
+	KeyID gophertags.KeyID
+
+	// FalsePositives is the expected number of other recipients' messages
+	// that match the recipient's key.
+	FalsePositives float64
+
+	// Matches is the expected total number of messages matching the key,
+	// true and false. It is the recipient's anonymity set: the messages the
+	// server can't tell apart from the recipient's real traffic.
+	Matches float64
+
+	// TrueFraction is the fraction of Matches that are truly for the
+	// recipient, which is the server's best guess at the probability that
+	// any single match is real. Values near 1 mean the tags hide little.
+	TrueFraction float64
+
+	// Cohort is the expected number of registered recipients, including this
+	// one, whose keys match each message truly sent to the recipient.
+	Cohort float64
+}
+
+// EstimateAnonymity estimates each recipient's anonymity set when the server
+// sees traffic messages in total over the period, assuming every flag was
+// generated honestly for some recipient.
+func EstimateAnonymity(recipients []Recipient, traffic float64) []Estimate {
+	var totalRate float64
+	for _, r := range recipients {
+		totalRate += r.FalsePositiveRate
+	}
+
+	estimates := make([]Estimate, len(recipients))
+	for i, r := range recipients {
+		others := traffic - r.Messages
+		if others < 0 {
+			others = 0
+		}
+		e := Estimate{
+			KeyID:          r.KeyID,
+			FalsePositives: others * r.FalsePositiveRate,
+			Cohort:         1 + totalRate - r.FalsePositiveRate,
+		}
+		e.Matches = r.Messages + e.FalsePositives
+		if e.Matches > 0 {
+			e.TrueFraction = r.Messages / e.Matches
+		}
+		estimates[i] = e
+	}
+	return estimates
+}
@@ -0,0 +1,33 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestEstimateAnonymity(t *testing.T) {
+	recipients := []Recipient{
+		{KeyID: gophertags.KeyID{1}, FalsePositiveRate: 1.0 / 16, Messages: 100},
+		{KeyID: gophertags.KeyID{2}, FalsePositiveRate: 1.0 / 4, Messages: 0},
+	}
+	estimates := EstimateAnonymity(recipients, 1700)
+
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+	first := estimates[0]
+	if !near(first.FalsePositives, 100) || !near(first.Matches, 200) || !near(first.TrueFraction, 0.5) || !near(first.Cohort, 1.25) {
+		t.Errorf("first recipient: %+v", first)
+	}
+	second := estimates[1]
+	if !near(second.Matches, 425) || second.TrueFraction != 0 || !near(second.Cohort, 1.0625) {
+		t.Errorf("second recipient: %+v", second)
+	}
+}
+
+func TestRecipientOf(t *testing.T) {
+	dk := gophertags.NewSecretKey(8).ExtractDetectionKey(3)
+	if r := RecipientOf(dk, 5); r.FalsePositiveRate != 0.125 || r.KeyID != dk.KeyID() || r.Messages != 5 {
+		t.Errorf("RecipientOf = %+v", r)
+	}
+}
@@ -0,0 +1,37 @@
+package analysis
+
+import "math"
+
+// ExpectedMatches returns the mean and standard deviation of the number of
+// messages a detection key of precision n matches in a mailbox holding
+// totalTraffic messages, trueMessages of which are for the key's recipient.
+// Each of the other messages matches independently with probability 2^-n, so
+// the false positives are binomially distributed and the true matches fixed.
+func ExpectedMatches(n int, trueMessages, totalTraffic int) (mean, stddev float64) {
+	p := math.Ldexp(1, -n)
+	others := float64(totalTraffic - trueMessages)
+	if others < 0 {
+		others = 0
+	}
+	return float64(trueMessages) + others*p, math.Sqrt(others * p * (1 - p))
+}
+
+// PrecisionForCover returns the highest precision n <= gamma whose expected
+// false positives are at least cover, so the recipient downloads that much
+// cover traffic while revealing as little as possible to the server. It
+// returns 0, which matches everything, if no precision yields enough cover.
+func PrecisionForCover(cover float64, trueMessages, totalTraffic, gamma int) int {
+	others := float64(totalTraffic - trueMessages)
+	if cover <= 0 {
+		return gamma
+	}
+	if others <= cover {
+		return 0
+	}
+	// others * 2^-n >= cover  <=>  n <= log2(others / cover)
+	n := int(math.Floor(math.Log2(others / cover)))
+	if n > gamma {
+		return gamma
+	}
+	return n
+}
@@ -0,0 +1,38 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExpectedMatches(t *testing.T) {
+	mean, stddev := ExpectedMatches(4, 10, 1610)
+	if mean != 110 || math.Abs(stddev-math.Sqrt(1600.0/16*15/16)) > 1e-9 {
+		t.Errorf("ExpectedMatches(4, 10, 1610) = %v, %v", mean, stddev)
+	}
+	if mean, stddev := ExpectedMatches(0, 3, 50); mean != 50 || stddev != 0 {
+		t.Errorf("precision 0 = %v, %v, want every message exactly", mean, stddev)
+	}
+}
+
+func TestPrecisionForCover(t *testing.T) {
+	for _, tc := range []struct {
+		cover                       float64
+		trueMessages, traffic, want int
+	}{
+		{100, 0, 1600, 4},
+		{100, 0, 1599, 3},
+		{1, 0, 1 << 30, 24}, // capped at gamma
+		{500, 0, 400, 0},
+		{0, 0, 1000, 24},
+	} {
+		if got := PrecisionForCover(tc.cover, tc.trueMessages, tc.traffic, 24); got != tc.want {
+			t.Errorf("PrecisionForCover(%v, %d, %d) = %d, want %d", tc.cover, tc.trueMessages, tc.traffic, got, tc.want)
+		}
+		if n := PrecisionForCover(tc.cover, tc.trueMessages, tc.traffic, 24); n > 0 && n < 24 {
+			if mean, _ := ExpectedMatches(n, tc.trueMessages, tc.traffic); mean < tc.cover {
+				t.Errorf("precision %d yields only %v expected matches", n, mean)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package archive stores flags in fixed-size records and scans them with
+// detection keys, for recipients catching up on long mailbox histories. On
+// Unix systems archives are memory-mapped, so scanning copies nothing: each
+// flag is decoded in place with gophertags.DecodeFlagBorrowed. Archives larger
+// than memory can be scanned in bounded memory, and resumed, with ScanReader,
+// and archives kept in object storage such as S3 can be scanned in parallel
+// range reads with ScanObject.
+//
+// An archive is an 8-byte header followed by the flags' encodings:
+//
+//	"gtfa" || version (1 byte) || reserved (1 byte, zero) || gamma (2 bytes, big-endian) || flag || flag || ...
+//
+// Every flag is gophertags.FlagSize(gamma) bytes. ConvertToColumns rewrites an
+// archive in a columnar format, opened with OpenColumns, whose blocks keep
+// each flag field in its own array and decode in batches.
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/gtank/gophertags"
+)
+
+const (
+	magic      = "gtfa"
+	version    = 1
+	headerSize = 8
+	maxGamma   = 1<<16 - 1
+)
+
+// ErrFormat is returned for files that aren't archives, or whose length isn't
+// a whole number of records.
+var ErrFormat = errors.New("archive: not a flag archive")
+
+// Writer appends flags to an archive.
+type Writer struct {
+	w     *bufio.Writer
+	gamma int
+	buf   []byte
+}
+
+// NewWriter writes an archive header for flags of the given gamma to w.
+func NewWriter(w io.Writer, gamma int) (*Writer, error) {
+	if gamma < 0 || gamma > maxGamma {
+		return nil, errors.New("archive: gamma out of range")
+	}
+	aw := &Writer{w: bufio.NewWriter(w), gamma: gamma}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[4] = version
+	binary.BigEndian.PutUint16(header[6:], uint16(gamma))
+	if _, err := aw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Append writes a flag, which must have the archive's gamma.
+func (w *Writer) Append(f *gophertags.Flag) error {
+	w.buf = f.Encode(w.buf[:0])
+	if len(w.buf) != gophertags.FlagSize(w.gamma) {
+		return errors.New("archive: flag gamma doesn't match archive")
+	}
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Flush writes any buffered flags to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Archive is an open archive. It is safe for concurrent use until Close.
+type Archive struct {
+	data  []byte // the whole file, mapped or read
+	gamma int
+	size  int // bytes per record
+	unmap func() error
+}
+
+// Open opens the archive at path, memory-mapping it where supported.
+func Open(path string) (*Archive, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < headerSize {
+		return nil, ErrFormat
+	}
+	data, unmap, err := mapFile(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	a, err := newArchive(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	a.unmap = unmap
+	return a, nil
+}
+
+func newArchive(data []byte) (*Archive, error) {
+	if len(data) < headerSize || string(data[:4]) != magic || data[4] != version || data[5] != 0 {
+		return nil, ErrFormat
+	}
+	gamma := int(binary.BigEndian.Uint16(data[6:]))
+	size := gophertags.FlagSize(gamma)
+	if (len(data)-headerSize)%size != 0 {
+		return nil, ErrFormat
+	}
+	return &Archive{data: data, gamma: gamma, size: size}, nil
+}
+
+// Close releases the archive. Flags returned by Record must not be used afterwards.
+func (a *Archive) Close() error {
+	a.data = nil
+	if a.unmap == nil {
+		return nil
+	}
+	unmap := a.unmap
+	a.unmap = nil
+	return unmap()
+}
+
+// Gamma returns the gamma of the archive's flags.
+func (a *Archive) Gamma() int {
+	return a.gamma
+}
+
+// Len returns the number of flags in the archive.
+func (a *Archive) Len() int {
+	return (len(a.data) - headerSize) / a.size
+}
+
+// Record returns the encoding of flag i. It aliases the archive.
+func (a *Archive) Record(i int) []byte {
+	start := headerSize + i*a.size
+	return a.data[start : start+a.size : start+a.size]
+}
+
+// Match is a flag that matched during a scan.
+type Match struct {
+	Index int // record number in the archive
+	Key   int // index of the matching key in the DetectionKeySet
+}
+
+// ScanOptions configures a scan.
+type ScanOptions struct {
+	// Progress, if set, is called with the number of records scanned so far
+	// and the total, every ProgressInterval records and once at the end.
+	Progress func(done, total int)
+
+	// ProgressInterval is how often Progress is called. Zero means 65536.
+	ProgressInterval int
+}
+
+const defaultProgressInterval = 1 << 16
+
+// Scan tests every flag in the archive against the key set, returning the
+// matches in archive order. Records that don't decode are skipped. It checks
+// ctx every ProgressInterval records and returns the matches so far with
+// ctx.Err() if it is done.
+func (a *Archive) Scan(ctx context.Context, keys *gophertags.DetectionKeySet, opts ScanOptions) ([]Match, error) {
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	total := a.Len()
+	var matches []Match
+	for i := 0; i < total; i++ {
+		if i%interval == 0 && i > 0 {
+			if opts.Progress != nil {
+				opts.Progress(i, total)
+			}
+			if err := ctx.Err(); err != nil {
+				return matches, err
+			}
+		}
+		f, err := gophertags.DecodeFlagBorrowed(a.Record(i), a.gamma)
+		if err != nil {
+			continue
+		}
+		if key, ok := keys.Test(f); ok {
+			matches = append(matches, Match{Index: i, Key: key})
+		}
+	}
+	if opts.Progress != nil {
+		opts.Progress(total, total)
+	}
+	return matches, nil
+}
+
+// ScanKey is Scan with a single detection key, returning the indices of the
+// matching records.
+func (a *Archive) ScanKey(ctx context.Context, dk *gophertags.DetectionKey, opts ScanOptions) ([]int, error) {
+	matches, err := a.Scan(ctx, gophertags.NewDetectionKeySet(dk), opts)
+	indices := make([]int, len(matches))
+	for i, m := range matches {
+		indices[i] = m.Index
+	}
+	return indices, err
+}
@@ -0,0 +1,126 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func writeArchive(t *testing.T, flags []*gophertags.Flag, gamma int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "flags.gtfa")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := NewWriter(file, gamma)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range flags {
+		if err := w.Append(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScan(t *testing.T) {
+	alice, bob, carol := gophertags.NewSecretKey(16), gophertags.NewSecretKey(16), gophertags.NewSecretKey(16)
+	var flags []*gophertags.Flag
+	for i := 0; i < 30; i++ {
+		switch i % 3 {
+		case 0:
+			flags = append(flags, alice.PublicKey().GenerateFlag())
+		case 1:
+			flags = append(flags, bob.PublicKey().GenerateFlag())
+		default:
+			flags = append(flags, carol.PublicKey().GenerateFlag())
+		}
+	}
+	a, err := Open(writeArchive(t, flags, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if a.Len() != 30 || a.Gamma() != 16 {
+		t.Fatalf("archive has %d flags of gamma %d", a.Len(), a.Gamma())
+	}
+
+	keys := gophertags.NewDetectionKeySet(alice.ExtractDetectionKey(16), bob.ExtractDetectionKey(16))
+	var progress []int
+	matches, err := a.Scan(context.Background(), keys, ScanOptions{
+		ProgressInterval: 10,
+		Progress:         func(done, total int) { progress = append(progress, done) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 20 {
+		t.Fatalf("%d matches, want 20", len(matches))
+	}
+	for _, m := range matches {
+		if m.Key != m.Index%3 {
+			t.Errorf("record %d matched key %d", m.Index, m.Key)
+		}
+	}
+	if len(progress) != 3 || progress[2] != 30 {
+		t.Errorf("progress reports %v", progress)
+	}
+
+	indices, err := a.ScanKey(context.Background(), alice.ExtractDetectionKey(16), ScanOptions{})
+	if err != nil || len(indices) != 10 || indices[1] != 3 {
+		t.Errorf("ScanKey = %v, %v", indices, err)
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	sk := gophertags.NewSecretKey(8)
+	flags := make([]*gophertags.Flag, 8)
+	for i := range flags {
+		flags[i] = sk.PublicKey().GenerateFlag()
+	}
+	a, err := Open(writeArchive(t, flags, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	matches, err := a.ScanKey(ctx, sk.ExtractDetectionKey(8), ScanOptions{
+		ProgressInterval: 4,
+		Progress:         func(done, total int) { cancel() },
+	})
+	if err != context.Canceled || len(matches) != 4 {
+		t.Errorf("cancelled scan = %d matches, %v", len(matches), err)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	dir := t.TempDir()
+	valid, _ := ioutil.ReadFile(writeArchive(t, []*gophertags.Flag{gophertags.NewSecretKey(8).PublicKey().GenerateFlag()}, 8))
+	for name, data := range map[string][]byte{
+		"short":     []byte("gtfa"),
+		"magic":     append([]byte("xxxx"), valid[4:]...),
+		"truncated": valid[:len(valid)-1],
+	} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, data, 0o600)
+		if _, err := Open(path); err != ErrFormat {
+			t.Errorf("%s: got %v, want ErrFormat", name, err)
+		}
+	}
+
+	w, _ := NewWriter(ioutil.Discard, 8)
+	if err := w.Append(gophertags.NewSecretKey(16).PublicKey().GenerateFlag()); err == nil {
+		t.Error("appended a flag of the wrong gamma")
+	}
+}
@@ -0,0 +1,302 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/gtank/gophertags"
+)
+
+// A columnar archive holds the same records as an archive, but splits each
+// block of records into one contiguous column per flag field, followed by an
+// index of the blocks:
+//
+//	header:  "gtfc" || version (1 byte) || reserved (1 byte, zero) || gamma (2 bytes, big-endian)
+//	block:   schemes (n bytes) || u (32n bytes) || y (32n bytes) || ciphertexts (n·ceil(gamma/8) bytes)
+//	index:   offset (8 bytes) || n (4 bytes), for each block
+//	trailer: blocks (4 bytes) || index offset (8 bytes) || "gtfc"
+//
+// Integers are big-endian, and offsets count from the start of the file. A
+// block's columns decode in one gophertags.DecodeFlagColumns call, with no
+// per-record copying or allocation, and tools that need one field, such as
+// the u points for a duplicate check, read only its column.
+
+const (
+	columnMagic       = "gtfc"
+	columnVersion     = 1
+	indexEntrySize    = 12
+	columnTrailerSize = 16
+	elementSize       = 32 // of an encoded u or y
+)
+
+// ColumnWriter writes a columnar archive. Nothing is complete until Close.
+type ColumnWriter struct {
+	w      *bufio.Writer
+	gamma  int
+	size   int // bytes per record
+	block  int // records per block
+	offset int64
+	index  []byte
+	blocks uint32
+
+	// The columns of the block being filled.
+	n                  int
+	schemes, us, ys, c []byte
+	buf                []byte
+}
+
+// NewColumnWriter writes a columnar archive header for flags of the given
+// gamma to w. Records are grouped blockRecords to a block, 65536 if zero.
+func NewColumnWriter(w io.Writer, gamma, blockRecords int) (*ColumnWriter, error) {
+	if gamma < 0 || gamma > maxGamma {
+		return nil, errors.New("archive: gamma out of range")
+	}
+	if blockRecords <= 0 {
+		blockRecords = defaultProgressInterval
+	}
+	cw := &ColumnWriter{w: bufio.NewWriter(w), gamma: gamma, size: gophertags.FlagSize(gamma), block: blockRecords}
+	header := make([]byte, headerSize)
+	copy(header, columnMagic)
+	header[4] = columnVersion
+	binary.BigEndian.PutUint16(header[6:], uint16(gamma))
+	if _, err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	cw.offset = headerSize
+	return cw, nil
+}
+
+// Append adds a flag, which must have the archive's gamma.
+func (w *ColumnWriter) Append(f *gophertags.Flag) error {
+	w.buf = f.Encode(w.buf[:0])
+	return w.appendRecord(w.buf)
+}
+
+// appendRecord adds an encoded flag, valid or not, split into its fields.
+func (w *ColumnWriter) appendRecord(record []byte) error {
+	if len(record) != w.size {
+		return errors.New("archive: flag gamma doesn't match archive")
+	}
+	w.schemes = append(w.schemes, record[0])
+	w.us = append(w.us, record[1:1+elementSize]...)
+	w.ys = append(w.ys, record[1+elementSize:1+2*elementSize]...)
+	w.c = append(w.c, record[1+2*elementSize:]...)
+	if w.n++; w.n == w.block {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+func (w *ColumnWriter) flushBlock() error {
+	if w.n == 0 {
+		return nil
+	}
+	var entry [indexEntrySize]byte
+	binary.BigEndian.PutUint64(entry[:8], uint64(w.offset))
+	binary.BigEndian.PutUint32(entry[8:], uint32(w.n))
+	w.index = append(w.index, entry[:]...)
+	w.blocks++
+	for _, column := range [][]byte{w.schemes, w.us, w.ys, w.c} {
+		if _, err := w.w.Write(column); err != nil {
+			return err
+		}
+		w.offset += int64(len(column))
+	}
+	w.n = 0
+	w.schemes, w.us, w.ys, w.c = w.schemes[:0], w.us[:0], w.ys[:0], w.c[:0]
+	return nil
+}
+
+// Close writes the last block and the index, and flushes the archive to the
+// underlying writer, which it doesn't close.
+func (w *ColumnWriter) Close() error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.index); err != nil {
+		return err
+	}
+	var trailer [columnTrailerSize]byte
+	binary.BigEndian.PutUint32(trailer[:4], w.blocks)
+	binary.BigEndian.PutUint64(trailer[4:12], uint64(w.offset))
+	copy(trailer[12:], columnMagic)
+	if _, err := w.w.Write(trailer[:]); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// ConvertToColumns writes the records of a to w as a columnar archive, with
+// blockRecords records to a block. Records keep their numbers, whether or not
+// they decode.
+func ConvertToColumns(w io.Writer, a *Archive, blockRecords int) error {
+	cw, err := NewColumnWriter(w, a.gamma, blockRecords)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < a.Len(); i++ {
+		if err := cw.appendRecord(a.Record(i)); err != nil {
+			return err
+		}
+	}
+	return cw.Close()
+}
+
+// ColumnArchive is an open columnar archive. It is safe for concurrent use
+// until Close.
+type ColumnArchive struct {
+	data   []byte
+	gamma  int
+	blocks []ColumnBlock
+	total  int
+	unmap  func() error
+}
+
+// ColumnBlock is a block of a columnar archive. Its columns alias the archive.
+type ColumnBlock struct {
+	First int // number of the block's first record
+
+	Schemes     []byte // hash scheme IDs, one byte per record
+	U, Y        []byte // 32 bytes per record
+	Ciphertexts []byte // ceil(gamma/8) bytes per record
+}
+
+// Len returns the number of records in the block.
+func (b ColumnBlock) Len() int {
+	return len(b.Schemes)
+}
+
+// OpenColumns opens the columnar archive at path, memory-mapping it where
+// supported.
+func OpenColumns(path string) (*ColumnArchive, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < headerSize+columnTrailerSize {
+		return nil, ErrFormat
+	}
+	data, unmap, err := mapFile(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	a, err := newColumnArchive(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	a.unmap = unmap
+	return a, nil
+}
+
+// newColumnArchive checks the header, trailer and index, which must describe
+// blocks laid end to end from the header to the index.
+func newColumnArchive(data []byte) (*ColumnArchive, error) {
+	if len(data) < headerSize+columnTrailerSize || string(data[:4]) != columnMagic || data[4] != columnVersion || data[5] != 0 {
+		return nil, ErrFormat
+	}
+	trailer := data[len(data)-columnTrailerSize:]
+	if string(trailer[12:]) != columnMagic {
+		return nil, ErrFormat
+	}
+	gamma := int(binary.BigEndian.Uint16(data[6:]))
+	count := int64(binary.BigEndian.Uint32(trailer[:4]))
+	indexOffset := binary.BigEndian.Uint64(trailer[4:12])
+	if indexOffset < headerSize || indexOffset > uint64(len(data)) || uint64(len(data))-indexOffset != uint64(count*indexEntrySize+columnTrailerSize) {
+		return nil, ErrFormat
+	}
+	bitSize := int64((gamma + 7) / 8)
+	a := &ColumnArchive{data: data, gamma: gamma, blocks: make([]ColumnBlock, count)}
+	index := data[indexOffset:]
+	offset := int64(headerSize)
+	for i := range a.blocks {
+		entry := index[i*indexEntrySize:]
+		n := int64(binary.BigEndian.Uint32(entry[8:12]))
+		if binary.BigEndian.Uint64(entry[:8]) != uint64(offset) || n == 0 || offset+n*(1+2*elementSize+bitSize) > int64(indexOffset) {
+			return nil, ErrFormat
+		}
+		column := func(width int64) []byte {
+			c := data[offset : offset+n*width : offset+n*width]
+			offset += n * width
+			return c
+		}
+		a.blocks[i] = ColumnBlock{First: a.total, Schemes: column(1), U: column(elementSize), Y: column(elementSize), Ciphertexts: column(bitSize)}
+		a.total += int(n)
+	}
+	if offset != int64(indexOffset) {
+		return nil, ErrFormat
+	}
+	return a, nil
+}
+
+// Close releases the archive. Flags decoded from it must not be used afterwards.
+func (a *ColumnArchive) Close() error {
+	a.data, a.blocks = nil, nil
+	if a.unmap == nil {
+		return nil
+	}
+	unmap := a.unmap
+	a.unmap = nil
+	return unmap()
+}
+
+// Gamma returns the gamma of the archive's flags.
+func (a *ColumnArchive) Gamma() int {
+	return a.gamma
+}
+
+// Len returns the number of records in the archive.
+func (a *ColumnArchive) Len() int {
+	return a.total
+}
+
+// Blocks returns the archive's blocks, in record order.
+func (a *ColumnArchive) Blocks() []ColumnBlock {
+	return a.blocks
+}
+
+// Scan is like Archive.Scan: it tests every flag against the key set and
+// returns the matches in archive order, skipping records that don't decode.
+// It decodes a block at a time, and checks ctx and reports progress between
+// blocks, once ProgressInterval records have passed since the last report.
+func (a *ColumnArchive) Scan(ctx context.Context, keys *gophertags.DetectionKeySet, opts ScanOptions) ([]Match, error) {
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	var matches []Match
+	reported := 0
+	for _, b := range a.blocks {
+		if done := b.First; done-reported >= interval {
+			if opts.Progress != nil {
+				opts.Progress(done, a.total)
+			}
+			reported = done
+			if err := ctx.Err(); err != nil {
+				return matches, err
+			}
+		}
+		flags, _ := gophertags.DecodeFlagColumns(b.Schemes, b.U, b.Y, b.Ciphertexts, a.gamma)
+		for i, f := range flags {
+			if f == nil {
+				continue
+			}
+			if key, ok := keys.Test(f); ok {
+				matches = append(matches, Match{Index: b.First + i, Key: key})
+			}
+		}
+	}
+	if opts.Progress != nil {
+		opts.Progress(a.total, a.total)
+	}
+	return matches, nil
+}
@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestColumnArchive(t *testing.T) {
+	alice, bob := gophertags.NewSecretKey(12), gophertags.NewSecretKey(12)
+	flags := make([]*gophertags.Flag, 30)
+	for i := range flags {
+		flags[i] = []*gophertags.SecretKey{alice, bob}[i%2].PublicKey().GenerateFlag()
+	}
+	// Record 4 doesn't decode; conversion keeps it, and scans skip it.
+	rows := writeArchive(t, flags, 12)
+	data, _ := ioutil.ReadFile(rows)
+	copy(data[headerSize+4*gophertags.FlagSize(12)+1:], make([]byte, 32))
+	ioutil.WriteFile(rows, data, 0o600)
+	a, err := Open(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	keys := gophertags.NewDetectionKeySet(alice.ExtractDetectionKey(12))
+	want, _ := a.Scan(context.Background(), keys, ScanOptions{})
+
+	path := filepath.Join(t.TempDir(), "flags.gtfc")
+	file, _ := os.Create(path)
+	if err := ConvertToColumns(file, a, 8); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	c, err := OpenColumns(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Len() != 30 || c.Gamma() != 12 || len(c.Blocks()) != 4 || c.Blocks()[3].First != 24 || c.Blocks()[3].Len() != 6 {
+		t.Fatalf("archive of %d flags of gamma %d in blocks %+v", c.Len(), c.Gamma(), c.Blocks())
+	}
+	if b := c.Blocks()[1]; !bytes.Equal(b.U[32:64], a.Record(9)[1:33]) || !bytes.Equal(b.Ciphertexts[2:4], a.Record(9)[65:]) {
+		t.Error("block columns don't hold the records' fields")
+	}
+
+	var progress []int
+	got, err := c.Scan(context.Background(), keys, ScanOptions{
+		ProgressInterval: 10,
+		Progress:         func(done, total int) { progress = append(progress, done) },
+	})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan = %v, %v; want %v", got, err, want)
+	}
+	for _, m := range got {
+		if m.Index == 4 {
+			t.Error("undecodable record matched")
+		}
+	}
+	if !reflect.DeepEqual(progress, []int{16, 30}) {
+		t.Errorf("progress reports %v", progress)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Scan(ctx, keys, ScanOptions{ProgressInterval: 8}); err != context.Canceled {
+		t.Errorf("cancelled Scan: %v", err)
+	}
+}
+
+func TestColumnWriter(t *testing.T) {
+	sk := gophertags.NewSecretKey(8)
+	var buf bytes.Buffer
+	w, err := NewColumnWriter(&buf, 8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.Append(sk.PublicKey().GenerateFlag()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Append(gophertags.NewSecretKey(16).PublicKey().GenerateFlag()); err == nil {
+		t.Error("appended a flag of the wrong gamma")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newColumnArchive(buf.Bytes())
+	if err != nil || c.Len() != 3 || len(c.Blocks()) != 1 {
+		t.Fatalf("archive = %+v, %v", c, err)
+	}
+	matches, _ := c.Scan(context.Background(), gophertags.NewDetectionKeySet(sk.ExtractDetectionKey(8)), ScanOptions{})
+	if len(matches) != 3 {
+		t.Errorf("%d matches, want 3", len(matches))
+	}
+
+	var empty bytes.Buffer
+	w, _ = NewColumnWriter(&empty, 8, 0)
+	w.Close()
+	if c, err := newColumnArchive(empty.Bytes()); err != nil || c.Len() != 0 {
+		t.Errorf("empty archive = %+v, %v", c, err)
+	}
+}
+
+func TestOpenColumnsInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewColumnWriter(&buf, 8, 2)
+	for i := 0; i < 3; i++ {
+		w.Append(gophertags.NewSecretKey(8).PublicKey().GenerateFlag())
+	}
+	w.Close()
+	valid := buf.Bytes()
+	corrupt := func(off int, b byte) []byte {
+		d := append([]byte(nil), valid...)
+		d[off] = b
+		return d
+	}
+	indexOffset := int(binary.BigEndian.Uint64(valid[len(valid)-12:]))
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"short":         valid[:10],
+		"header magic":  corrupt(0, 'x'),
+		"trailer magic": corrupt(len(valid)-1, 'x'),
+		"truncated":     append(append([]byte(nil), valid[:indexOffset-1]...), valid[indexOffset:]...),
+		"block offset":  corrupt(indexOffset+7, 9),
+		"block length":  corrupt(indexOffset+11, 3),
+		"block count":   corrupt(len(valid)-13, 3),
+		"row archive":   []byte("gtfa\x01\x00\x00\x08"),
+	} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, data, 0o600)
+		if _, err := OpenColumns(path); err != ErrFormat {
+			t.Errorf("%s: got %v, want ErrFormat", name, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package archive
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/gtank/gophertags"
+)
+
+// RangeReader reads byte ranges of a remote object, such as an archive kept
+// in object storage. S3Object is one.
+type RangeReader interface {
+	// Size returns the object's length in bytes.
+	Size(ctx context.Context) (int64, error)
+
+	// ReadRange fills p with the object's bytes starting at off, returning
+	// io.ErrUnexpectedEOF if the object ends first.
+	ReadRange(ctx context.Context, p []byte, off int64) error
+}
+
+// ReaderAtRange adapts an io.ReaderAt of the given size, such as an
+// *os.File, to a RangeReader.
+func ReaderAtRange(r io.ReaderAt, size int64) RangeReader {
+	return readerAtRange{r, size}
+}
+
+type readerAtRange struct {
+	r    io.ReaderAt
+	size int64
+}
+
+func (r readerAtRange) Size(ctx context.Context) (int64, error) {
+	return r.size, nil
+}
+
+func (r readerAtRange) ReadRange(ctx context.Context, p []byte, off int64) error {
+	n, err := r.r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ObjectOptions configures ScanObject.
+type ObjectOptions struct {
+	// PartRecords is how many records are fetched in each range read. Zero
+	// means 65536, a few megabytes for typical gammas, which amortizes the
+	// latency of object storage requests.
+	PartRecords int
+
+	// Parallelism is how many parts are fetched and scanned at once. Zero
+	// means GOMAXPROCS. Working memory is about Parallelism parts.
+	Parallelism int
+
+	// Progress, if set, is called with the number of records scanned so far
+	// and the total after each part. Parts finish out of order, so the
+	// records scanned aren't always a prefix of the archive. Calls aren't
+	// concurrent.
+	Progress func(done, total int)
+}
+
+const defaultPartRecords = 1 << 16
+
+// ScanObject tests every flag in the archive read from r against the key set,
+// fetching it in parts with range reads, several at once, as suits object
+// storage, where each request is slow but many can be in flight. It returns
+// the matches in archive order. Records that don't decode are skipped.
+//
+// If a read fails or ctx is done, the scan stops and returns the error with
+// the matches of the parts before the first part not scanned, so a caller can
+// resume from there.
+func ScanObject(ctx context.Context, r RangeReader, keys *gophertags.DetectionKeySet, opts ObjectOptions) ([]Match, error) {
+	size, err := r.Size(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if size < headerSize {
+		return nil, ErrFormat
+	}
+	header := make([]byte, headerSize)
+	if err := r.ReadRange(ctx, header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != magic || header[4] != version || header[5] != 0 {
+		return nil, ErrFormat
+	}
+	gamma := int(binary.BigEndian.Uint16(header[6:]))
+	recordSize := gophertags.FlagSize(gamma)
+	if (size-headerSize)%int64(recordSize) != 0 {
+		return nil, ErrFormat
+	}
+	total := int((size - headerSize) / int64(recordSize))
+
+	partRecords := opts.PartRecords
+	if partRecords <= 0 {
+		partRecords = defaultPartRecords
+	}
+	workers := opts.Parallelism
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	parts := (total + partRecords - 1) / partRecords
+	if workers > parts {
+		workers = parts
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		part    int
+		matches []Match
+		err     error
+	}
+	next := make(chan int)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, partRecords*recordSize)
+			for part := range next {
+				first := part * partRecords
+				data := buf[:partSize(part, partRecords, total)*recordSize]
+				res := result{part: part}
+				if res.err = r.ReadRange(ctx, data, headerSize+int64(first)*int64(recordSize)); res.err == nil {
+					res.matches = scanRecords(data, recordSize, gamma, first, keys)
+				}
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(next)
+		for part := 0; part < parts; part++ {
+			select {
+			case next <- part:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	scanned := make([][]Match, parts)
+	done := make([]bool, parts)
+	records := 0
+	for res := range results {
+		if res.err != nil {
+			if err == nil {
+				err = res.err
+			}
+			cancel()
+			continue
+		}
+		scanned[res.part], done[res.part] = res.matches, true
+		if records += partSize(res.part, partRecords, total); opts.Progress != nil {
+			opts.Progress(records, total)
+		}
+	}
+	var matches []Match
+	for part := 0; part < parts && done[part]; part++ {
+		matches = append(matches, scanned[part]...)
+	}
+	if records < total && err == nil {
+		err = parent.Err() // parts were left unsent
+	}
+	return matches, err
+}
+
+func partSize(part, partRecords, total int) int {
+	first := part * partRecords
+	if first+partRecords > total {
+		return total - first
+	}
+	return partRecords
+}
+
+// scanRecords tests the records in data, the first of which is record first.
+func scanRecords(data []byte, size, gamma, first int, keys *gophertags.DetectionKeySet) []Match {
+	var matches []Match
+	for i, off := first, 0; off < len(data); i, off = i+1, off+size {
+		f, err := gophertags.DecodeFlagBorrowed(data[off:off+size:off+size], gamma)
+		if err != nil {
+			continue
+		}
+		if key, ok := keys.Test(f); ok {
+			matches = append(matches, Match{Index: i, Key: key})
+		}
+	}
+	return matches
+}
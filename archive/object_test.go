@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+// failingRange fails reads at or past offset fail.
+type failingRange struct {
+	RangeReader
+	fail int64
+}
+
+var errRead = errors.New("read failed")
+
+func (r failingRange) ReadRange(ctx context.Context, p []byte, off int64) error {
+	if off >= r.fail {
+		return errRead
+	}
+	return r.RangeReader.ReadRange(ctx, p, off)
+}
+
+func TestScanObject(t *testing.T) {
+	alice, bob, carol := gophertags.NewSecretKey(8), gophertags.NewSecretKey(8), gophertags.NewSecretKey(8)
+	flags := make([]*gophertags.Flag, 50)
+	for i := range flags {
+		flags[i] = []*gophertags.SecretKey{alice, bob, carol}[i%3].PublicKey().GenerateFlag()
+	}
+	data, err := ioutil.ReadFile(writeArchive(t, flags, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := gophertags.NewDetectionKeySet(alice.ExtractDetectionKey(8), bob.ExtractDetectionKey(8))
+	a, _ := newArchive(data)
+	want, _ := a.Scan(context.Background(), keys, ScanOptions{})
+
+	r := ReaderAtRange(bytes.NewReader(data), int64(len(data)))
+	var progress []int
+	got, err := ScanObject(context.Background(), r, keys, ObjectOptions{
+		PartRecords: 7,
+		Parallelism: 3,
+		Progress:    func(done, total int) { progress = append(progress, done) },
+	})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanObject = %v, %v; want %v", got, err, want)
+	}
+	if len(progress) != 8 || progress[7] != 50 {
+		t.Errorf("progress reports %v", progress)
+	}
+
+	// A failed read keeps the matches of the parts before it.
+	size := int64(gophertags.FlagSize(8))
+	var prefix []Match
+	for _, m := range want {
+		if m.Index < 21 {
+			prefix = append(prefix, m)
+		}
+	}
+	got, err = ScanObject(context.Background(), failingRange{r, headerSize + 21*size}, keys, ObjectOptions{PartRecords: 7, Parallelism: 1})
+	if err != errRead || !reflect.DeepEqual(got, prefix) {
+		t.Errorf("failing ScanObject = %v, %v; want %v", got, err, prefix)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ScanObject(ctx, r, keys, ObjectOptions{PartRecords: 7}); err != context.Canceled {
+		t.Errorf("cancelled ScanObject: %v", err)
+	}
+
+	for name, bad := range map[string][]byte{"short": data[:4], "truncated": data[:len(data)-1]} {
+		if _, err := ScanObject(context.Background(), ReaderAtRange(bytes.NewReader(bad), int64(len(bad))), keys, ObjectOptions{}); err != ErrFormat {
+			t.Errorf("%s: got %v, want ErrFormat", name, err)
+		}
+	}
+}
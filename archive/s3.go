@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Object is an archive in S3-compatible object storage, read with ranged
+// GET requests signed with AWS Signature Version 4. It is a RangeReader for
+// ScanObject and is safe for concurrent use.
+type S3Object struct {
+	// Endpoint is the storage service's base URL, such as
+	// "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000".
+	Endpoint string
+
+	// Bucket and Key name the object.
+	Bucket, Key string
+
+	// PathStyle addresses the object as Endpoint/Bucket/Key instead of at
+	// the bucket's own host name, as most S3-compatible services other than
+	// AWS expect.
+	PathStyle bool
+
+	// Region is the bucket's region. Empty means "us-east-1".
+	Region string
+
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials.
+	// Requests are sent unsigned if AccessKeyID is empty, for public
+	// objects. SessionToken is needed only with temporary credentials.
+	AccessKeyID, SecretAccessKey, SessionToken string
+
+	// Client sends the requests. Nil means http.DefaultClient.
+	Client *http.Client
+
+	now func() time.Time // for tests
+}
+
+// ErrS3Status is returned, wrapped, for responses from the storage service
+// other than success.
+var ErrS3Status = errors.New("archive: object storage request failed")
+
+// Size implements RangeReader with a HEAD request.
+func (o *S3Object) Size(ctx context.Context) (int64, error) {
+	resp, err := o.do(ctx, http.MethodHead, "")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: HEAD %s: %s", ErrS3Status, o.Key, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("%w: HEAD %s: no Content-Length", ErrS3Status, o.Key)
+	}
+	return resp.ContentLength, nil
+}
+
+// ReadRange implements RangeReader with a ranged GET request.
+func (o *S3Object) ReadRange(ctx context.Context, p []byte, off int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+	resp, err := o.do(ctx, http.MethodGet, "bytes="+strconv.FormatInt(off, 10)+"-"+strconv.FormatInt(off+int64(len(p))-1, 10))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && off == 0:
+		// The service ignored the range and is sending the whole object.
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		return io.ErrUnexpectedEOF
+	default:
+		return fmt.Errorf("%w: GET %s: %s", ErrS3Status, o.Key, resp.Status)
+	}
+	_, err = io.ReadFull(resp.Body, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (o *S3Object) do(ctx context.Context, method, byteRange string) (*http.Response, error) {
+	u, err := o.url()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	if o.AccessKeyID != "" {
+		now := time.Now
+		if o.now != nil {
+			now = o.now
+		}
+		o.sign(req, now().UTC())
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (o *S3Object) url() (*url.URL, error) {
+	u, err := url.Parse(o.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if o.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + o.Bucket + "/" + o.Key
+	} else {
+		u.Host = o.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + o.Key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return u, nil
+}
+
+// emptySHA256 is the hex SHA-256 of an empty body, the payload hash of GET
+// and HEAD requests.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds Signature Version 4 headers for a bodiless request made at t.
+func (o *S3Object) sign(req *http.Request, t time.Time) {
+	region := o.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := t.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if o.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", o.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n" + req.URL.EscapedPath() + "\n" + req.URL.RawQuery + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n" + emptySHA256)
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+	key := []byte("AWS4" + o.SecretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+o.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath percent-encodes everything in a path but unreserved
+// characters and slashes, as Signature Version 4 requires.
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+var _ RangeReader = (*S3Object)(nil)
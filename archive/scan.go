@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/gtank/gophertags"
+	"golang.org/x/crypto/sha3"
+)
+
+// Checkpoint is a resume token for ScanReader: the number of records already
+// scanned and a digest of the last of them, which detects resuming against a
+// different or rewritten archive.
+type Checkpoint struct {
+	Next   int64   // records scanned
+	Digest [8]byte // of record Next-1; zero if Next is zero
+}
+
+// ErrCheckpoint is returned when a checkpoint doesn't fit the archive being
+// scanned.
+var ErrCheckpoint = errors.New("archive: checkpoint doesn't match archive")
+
+// MarshalText encodes the checkpoint as "<next>-<hex digest>".
+func (c Checkpoint) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(c.Next, 10) + "-" + hex.EncodeToString(c.Digest[:])), nil
+}
+
+// UnmarshalText decodes a checkpoint from MarshalText.
+func (c *Checkpoint) UnmarshalText(text []byte) error {
+	parts := strings.SplitN(string(text), "-", 2)
+	if len(parts) != 2 {
+		return ErrCheckpoint
+	}
+	next, err := strconv.ParseInt(parts[0], 10, 64)
+	digest, derr := hex.DecodeString(parts[1])
+	if err != nil || next < 0 || derr != nil || len(digest) != len(c.Digest) {
+		return ErrCheckpoint
+	}
+	c.Next = next
+	copy(c.Digest[:], digest)
+	return nil
+}
+
+func recordDigest(record []byte) (d [8]byte) {
+	sum := sha3.Sum256(record)
+	copy(d[:], sum[:])
+	return d
+}
+
+// StreamOptions configures ScanReader.
+type StreamOptions struct {
+	// Resume, if set, skips the records a previous scan got through. Seekable
+	// readers seek past them; others are read and discarded.
+	Resume *Checkpoint
+
+	// Checkpoint, if set, is called every CheckpointInterval records and once
+	// at the end, after every match before the checkpoint has been passed to
+	// the match callback. Persisting it with the matches handled so far lets
+	// an interrupted scan resume without missing or repeating any. An error
+	// stops the scan.
+	Checkpoint func(Checkpoint) error
+
+	// CheckpointInterval is how often Checkpoint is called. Zero means 65536.
+	CheckpointInterval int
+
+	// BufferRecords is how many records are read at once, which bounds the
+	// scan's working memory. Zero means 4096.
+	BufferRecords int
+}
+
+const defaultBufferRecords = 4096
+
+// ScanReader tests every flag in the archive read from r against the key
+// set, passing each match to match in archive order. Unlike Archive.Scan it
+// neither maps nor reads the whole archive and keeps no list of matches, so
+// its memory use is bounded by opts.BufferRecords whatever the archive's
+// size, and it can be resumed from a checkpoint.
+//
+// It returns the checkpoint at which it stopped with any error, from r, ctx,
+// match or opts.Checkpoint, or with nil at the end of the archive. Records
+// that don't decode are skipped. ctx is checked once per buffer.
+func ScanReader(ctx context.Context, r io.Reader, keys *gophertags.DetectionKeySet, match func(Match) error, opts StreamOptions) (Checkpoint, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrFormat
+		}
+		return Checkpoint{}, err
+	}
+	if string(header[:4]) != magic || header[4] != version || header[5] != 0 {
+		return Checkpoint{}, ErrFormat
+	}
+	gamma := int(binary.BigEndian.Uint16(header[6:]))
+	size := gophertags.FlagSize(gamma)
+
+	// last is record next-1, kept across buffer refills for checkpoints.
+	var next int64
+	last := make([]byte, size)
+	checkpoint := func() Checkpoint {
+		cp := Checkpoint{Next: next}
+		if next > 0 {
+			cp.Digest = recordDigest(last)
+		}
+		return cp
+	}
+	if opts.Resume != nil && opts.Resume.Next > 0 {
+		if err := skipRecords(r, opts.Resume.Next-1, size); err != nil {
+			return Checkpoint{}, err
+		}
+		if _, err := io.ReadFull(r, last); err != nil || recordDigest(last) != opts.Resume.Digest {
+			return Checkpoint{}, ErrCheckpoint
+		}
+		next = opts.Resume.Next
+	}
+
+	interval := int64(opts.CheckpointInterval)
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	records := opts.BufferRecords
+	if records <= 0 {
+		records = defaultBufferRecords
+	}
+	buf := make([]byte, records*size)
+	for {
+		if err := ctx.Err(); err != nil {
+			return checkpoint(), err
+		}
+		n, err := io.ReadFull(r, buf)
+		if err == io.ErrUnexpectedEOF && n%size != 0 {
+			return checkpoint(), ErrFormat
+		}
+		for off := 0; off+size <= n; off += size {
+			record := buf[off : off+size : off+size]
+			if f, err := gophertags.DecodeFlagBorrowed(record, gamma); err == nil {
+				if key, ok := keys.Test(f); ok {
+					if err := match(Match{Index: int(next), Key: key}); err != nil {
+						return checkpoint(), err
+					}
+				}
+			}
+			copy(last, record)
+			if next++; next%interval == 0 && opts.Checkpoint != nil {
+				if err := opts.Checkpoint(checkpoint()); err != nil {
+					return checkpoint(), err
+				}
+			}
+		}
+		switch err {
+		case nil:
+			continue
+		case io.EOF, io.ErrUnexpectedEOF:
+			if opts.Checkpoint != nil && (next == 0 || next%interval != 0) {
+				if err := opts.Checkpoint(checkpoint()); err != nil {
+					return checkpoint(), err
+				}
+			}
+			return checkpoint(), nil
+		default:
+			return checkpoint(), err
+		}
+	}
+}
+
+// skipRecords advances r past n records.
+func skipRecords(r io.Reader, n int64, size int) error {
+	offset := n * int64(size)
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(offset, io.SeekCurrent)
+		return err
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+		return ErrCheckpoint
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestScanReaderResume(t *testing.T) {
+	alice, bob := gophertags.NewSecretKey(8), gophertags.NewSecretKey(8)
+	flags := make([]*gophertags.Flag, 25)
+	for i := range flags {
+		if i%2 == 0 {
+			flags[i] = alice.PublicKey().GenerateFlag()
+		} else {
+			flags[i] = bob.PublicKey().GenerateFlag()
+		}
+	}
+	path := writeArchive(t, flags, 8)
+	keys := gophertags.NewDetectionKeySet(alice.ExtractDetectionKey(8))
+
+	// Stop the first scan at its second checkpoint, as if it crashed.
+	errStop := errors.New("stop")
+	var saved Checkpoint
+	var first []int
+	file, _ := os.Open(path)
+	defer file.Close()
+	calls := 0
+	_, err := ScanReader(context.Background(), file, keys, func(m Match) error {
+		first = append(first, m.Index)
+		return nil
+	}, StreamOptions{CheckpointInterval: 5, BufferRecords: 3, Checkpoint: func(c Checkpoint) error {
+		if calls++; calls == 2 {
+			saved = c
+			return errStop
+		}
+		return nil
+	}})
+	if err != errStop || saved.Next != 10 {
+		t.Fatalf("first scan stopped at %+v with %v", saved, err)
+	}
+
+	// Resume it from the saved token, through a reader that can't seek.
+	text, _ := saved.MarshalText()
+	var resume Checkpoint
+	if err := resume.UnmarshalText(text); err != nil || resume != saved {
+		t.Fatalf("checkpoint %q round trip: %+v, %v", text, resume, err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	var second []int
+	end, err := ScanReader(context.Background(), bytes.NewReader(data), keys, func(m Match) error {
+		second = append(second, m.Index)
+		return nil
+	}, StreamOptions{Resume: &resume})
+	if err != nil || end.Next != 25 {
+		t.Fatalf("resumed scan ended at %+v with %v", end, err)
+	}
+	all := append(first, second...)
+	if len(all) < 13 {
+		t.Fatalf("matched %v, want every even record", all)
+	}
+	for i, index := range all {
+		if i > 0 && index <= all[i-1] {
+			t.Errorf("matches %v repeat or go backwards", all)
+			break
+		}
+	}
+	for _, even := range []int{0, 8, 10, 24} {
+		found := false
+		for _, index := range all {
+			found = found || index == even
+		}
+		if !found {
+			t.Errorf("record %d missing from matches %v", even, all)
+		}
+	}
+
+	// A checkpoint from another archive is rejected.
+	other, _ := ioutil.ReadFile(writeArchive(t, flags[1:], 8))
+	if _, err := ScanReader(context.Background(), bytes.NewReader(other), keys, func(Match) error { return nil }, StreamOptions{Resume: &resume}); err != ErrCheckpoint {
+		t.Errorf("resuming another archive: %v, want ErrCheckpoint", err)
+	}
+}
+
+func TestScanReaderInvalid(t *testing.T) {
+	valid, _ := ioutil.ReadFile(writeArchive(t, []*gophertags.Flag{gophertags.NewSecretKey(8).PublicKey().GenerateFlag()}, 8))
+	keys := gophertags.NewDetectionKeySet()
+	for name, data := range map[string][]byte{
+		"short":     []byte("gtfa"),
+		"magic":     append([]byte("xxxx"), valid[4:]...),
+		"truncated": valid[:len(valid)-1],
+	} {
+		if _, err := ScanReader(context.Background(), bytes.NewReader(data), keys, func(Match) error { return nil }, StreamOptions{}); err != ErrFormat {
+			t.Errorf("%s: got %v, want ErrFormat", name, err)
+		}
+	}
+	var c Checkpoint
+	for _, text := range []string{"", "12", "-1-0000000000000000", "3-00"} {
+		if c.UnmarshalText([]byte(text)) == nil {
+			t.Errorf("checkpoint %q accepted", text)
+		}
+	}
+}
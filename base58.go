@@ -0,0 +1,156 @@
+package gophertags
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Base58Check strings, as used by Bitcoin-style wallets, are
+//
+//	Base58(version || encoding || SHA256(SHA256(version || encoding))[:4])
+//
+// where encoding is the key's wire encoding and version tells key types apart.
+
+// Version bytes for Base58Check key strings.
+const (
+	Base58PublicKeyVersion    byte = 0x50
+	Base58DetectionKeyVersion byte = 0x44
+)
+
+// Reasons a Base58Check string can be rejected.
+var (
+	ErrBase58Character = newKindError("gophertags: invalid Base58 character", ErrInvalidEncoding)
+	ErrBase58Checksum  = newKindError("gophertags: Base58Check checksum mismatch", ErrInvalidEncoding)
+	ErrBase58Version   = newKindError("gophertags: wrong Base58Check version byte", ErrInvalidEncoding)
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base58Index = func() (index [256]int8) {
+	for i := range index {
+		index[i] = -1
+	}
+	for i := 0; i < len(base58Alphabet); i++ {
+		index[base58Alphabet[i]] = int8(i)
+	}
+	return index
+}()
+
+// base58Encode converts in to base 58, keeping each leading zero byte as a '1'.
+func base58Encode(in []byte) string {
+	zeros := 0
+	for zeros < len(in) && in[zeros] == 0 {
+		zeros++
+	}
+	// log(256)/log(58) < 1.37, so this many digits always suffice.
+	digits := make([]byte, 0, (len(in)-zeros)*137/100+1)
+	for _, b := range in[zeros:] {
+		carry := int(b)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+
+	out := make([]byte, zeros+len(digits))
+	for i := 0; i < zeros; i++ {
+		out[i] = '1'
+	}
+	for i, d := range digits {
+		out[len(out)-1-i] = base58Alphabet[d]
+	}
+	return string(out)
+}
+
+// base58Decode is the inverse of base58Encode.
+func base58Decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	bytesLE := make([]byte, 0, len(s)*733/1000+1) // log(58)/log(256) < 0.733
+	for i := zeros; i < len(s); i++ {
+		digit := base58Index[s[i]]
+		if digit < 0 {
+			return nil, ErrBase58Character
+		}
+		carry := int(digit)
+		for j := range bytesLE {
+			carry += int(bytesLE[j]) * 58
+			bytesLE[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			bytesLE = append(bytesLE, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	out := make([]byte, zeros+len(bytesLE))
+	for i, b := range bytesLE {
+		out[len(out)-1-i] = b
+	}
+	return out, nil
+}
+
+func base58Checksum(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
+
+func base58CheckEncode(version byte, payload []byte) string {
+	data := append([]byte{version}, payload...)
+	return base58Encode(append(data, base58Checksum(data)...))
+}
+
+func base58CheckDecode(version byte, s string) ([]byte, error) {
+	data, err := base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 5 {
+		return nil, ErrBase58Checksum
+	}
+	data, checksum := data[:len(data)-4], data[len(data)-4:]
+	if !bytes.Equal(checksum, base58Checksum(data)) {
+		return nil, ErrBase58Checksum
+	}
+	if data[0] != version {
+		return nil, ErrBase58Version
+	}
+	return data[1:], nil
+}
+
+// Base58 returns the public key as a Base58Check string.
+func (pk *PublicKey) Base58() string {
+	return base58CheckEncode(Base58PublicKeyVersion, pk.Encode(nil))
+}
+
+// DecodePublicKeyBase58 parses a public key from a string made by PublicKey.Base58.
+func DecodePublicKeyBase58(s string) (*PublicKey, error) {
+	encoded, err := base58CheckDecode(Base58PublicKeyVersion, s)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePublicKey(encoded)
+}
+
+// Base58 returns the detection key as a Base58Check string.
+func (dk *DetectionKey) Base58() string {
+	return base58CheckEncode(Base58DetectionKeyVersion, dk.Encode(nil))
+}
+
+// DecodeDetectionKeyBase58 parses a detection key from a string made by DetectionKey.Base58.
+func DecodeDetectionKeyBase58(s string) (*DetectionKey, error) {
+	encoded, err := base58CheckDecode(Base58DetectionKeyVersion, s)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeDetectionKey(encoded)
+}
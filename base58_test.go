@@ -0,0 +1,59 @@
+package gophertags
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestBase58Vectors(t *testing.T) {
+	// From the Bitcoin Core base58 test vectors.
+	for _, tc := range []struct{ hex, b58 string }{
+		{"", ""},
+		{"61", "2g"},
+		{"626262", "a3gV"},
+		{"636363", "aPEr"},
+		{"00000000000000000000", "1111111111"},
+		{"000111d38e5fc9071ffcd20b4a763cc9ae4f252bb4e48fd66a835e252ada93ff480d6dd43dc62a641155a5", "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"},
+		{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+	} {
+		in, _ := hex.DecodeString(tc.hex)
+		if got := base58Encode(in); got != tc.b58 {
+			t.Errorf("encode %s = %s, want %s", tc.hex, got, tc.b58)
+		}
+		if got, err := base58Decode(tc.b58); err != nil || !bytes.Equal(got, in) {
+			t.Errorf("decode %s = %x, %v", tc.b58, got, err)
+		}
+	}
+}
+
+func TestBase58Keys(t *testing.T) {
+	sk := NewSecretKey(16)
+	pk, dk := sk.PublicKey(), sk.ExtractDetectionKey(4)
+
+	gotPK, err := DecodePublicKeyBase58(pk.Base58())
+	if err != nil || gotPK.Fingerprint() != pk.Fingerprint() {
+		t.Errorf("public key round trip: %v", err)
+	}
+	gotDK, err := DecodeDetectionKeyBase58(dk.Base58())
+	if err != nil || gotDK.Fingerprint() != dk.Fingerprint() {
+		t.Errorf("detection key round trip: %v", err)
+	}
+
+	if _, err := DecodeDetectionKeyBase58(pk.Base58()); !errors.Is(err, ErrBase58Version) {
+		t.Errorf("public key string as detection key: got %v", err)
+	}
+	s := []byte(dk.Base58())
+	if s[5] == 'z' {
+		s[5] = 'y'
+	} else {
+		s[5] = 'z'
+	}
+	if _, err := DecodeDetectionKeyBase58(string(s)); !errors.Is(err, ErrBase58Checksum) {
+		t.Errorf("corrupted string: got %v", err)
+	}
+	if _, err := DecodeDetectionKeyBase58("0OIl"); !errors.Is(err, ErrBase58Character) {
+		t.Errorf("invalid characters: got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package gophertags
+
+import "strings"
+
+// Bech32 strings (BIP 173) are
+//
+//	hrp || "1" || Base32(encoding) || checksum (6 characters)
+//
+// where encoding is the key's wire encoding and the human-readable part hrp
+// tells key types apart. Keys are longer than the 90 characters BIP 173
+// allows for addresses, so that limit isn't enforced. Past it the checksum no
+// longer guarantees catching every error of up to four characters, though it
+// still misses a random error with probability only about 2^-30.
+
+// Human-readable parts of Bech32 key strings.
+const (
+	Bech32PublicKeyHRP    = "gtpk"
+	Bech32DetectionKeyHRP = "gtdk"
+)
+
+// Reasons a Bech32 string can be rejected.
+var (
+	ErrBech32Character = newKindError("gophertags: invalid Bech32 character or mixed case", ErrInvalidEncoding)
+	ErrBech32Checksum  = newKindError("gophertags: Bech32 checksum mismatch", ErrInvalidEncoding)
+	ErrBech32HRP       = newKindError("gophertags: wrong Bech32 human-readable part", ErrInvalidEncoding)
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Index = func() (index [256]int8) {
+	for i := range index {
+		index[i] = -1
+	}
+	for i := 0; i < len(bech32Charset); i++ {
+		index[bech32Charset[i]] = int8(i)
+	}
+	return index
+}()
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range generator {
+			if (top>>i)&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+// bech32Checked returns the values the checksum covers: the expanded hrp, then data.
+func bech32Checked(hrp string, data []byte) []byte {
+	values := make([]byte, 0, 2*len(hrp)+1+len(data)+6)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	return append(values, data...)
+}
+
+// bech32Encode encodes 5-bit values under a lower-case hrp.
+func bech32Encode(hrp string, data []byte) string {
+	values := append(bech32Checked(hrp, data), 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ 1
+	var b strings.Builder
+	b.Grow(len(hrp) + 1 + len(data) + 6)
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range data {
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		b.WriteByte(bech32Charset[(mod>>(5*(5-i)))&31])
+	}
+	return b.String()
+}
+
+// bech32Decode is the inverse of bech32Encode. The hrp is returned in lower case.
+func bech32Decode(s string) (hrp string, data []byte, err error) {
+	lower, upper := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", nil, ErrBech32Character
+		}
+		lower = lower || ('a' <= c && c <= 'z')
+		upper = upper || ('A' <= c && c <= 'Z')
+	}
+	if lower && upper {
+		return "", nil, ErrBech32Character
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, ErrBech32Character
+	}
+	hrp = s[:sep]
+	data = make([]byte, len(s)-sep-1)
+	for i := range data {
+		v := bech32Index[s[sep+1+i]]
+		if v < 0 {
+			return "", nil, ErrBech32Character
+		}
+		data[i] = byte(v)
+	}
+	if bech32Polymod(bech32Checked(hrp, data)) != 1 {
+		return "", nil, ErrBech32Checksum
+	}
+	return hrp, data[:len(data)-6], nil
+}
+
+// convertBits regroups the bits of in from groups of from bits to groups of
+// to bits. Encoding pads the last group with zeros; decoding rejects more than
+// a group's worth of padding or padding that isn't zero.
+func convertBits(in []byte, from, to uint, pad bool) ([]byte, bool) {
+	var acc, bits uint
+	out := make([]byte, 0, (uint(len(in))*from+to-1)/to)
+	for _, v := range in {
+		acc = acc<<from | uint(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits)&(1<<to-1))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits))&(1<<to-1))
+		}
+	} else if bits >= from || (acc<<(to-bits))&(1<<to-1) != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+func bech32EncodeBytes(hrp string, payload []byte) string {
+	data, _ := convertBits(payload, 8, 5, true)
+	return bech32Encode(hrp, data)
+}
+
+func bech32DecodeBytes(hrp, s string) ([]byte, error) {
+	got, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if got != hrp {
+		return nil, ErrBech32HRP
+	}
+	payload, ok := convertBits(data, 5, 8, false)
+	if !ok {
+		return nil, ErrBech32Character
+	}
+	return payload, nil
+}
+
+// Bech32 returns the public key as a Bech32 string with the gtpk prefix.
+func (pk *PublicKey) Bech32() string {
+	return bech32EncodeBytes(Bech32PublicKeyHRP, pk.Encode(nil))
+}
+
+// DecodePublicKeyBech32 parses a public key from a string made by PublicKey.Bech32.
+func DecodePublicKeyBech32(s string) (*PublicKey, error) {
+	encoded, err := bech32DecodeBytes(Bech32PublicKeyHRP, s)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePublicKey(encoded)
+}
+
+// Bech32 returns the detection key as a Bech32 string with the gtdk prefix.
+func (dk *DetectionKey) Bech32() string {
+	return bech32EncodeBytes(Bech32DetectionKeyHRP, dk.Encode(nil))
+}
+
+// DecodeDetectionKeyBech32 parses a detection key from a string made by DetectionKey.Bech32.
+func DecodeDetectionKeyBech32(s string) (*DetectionKey, error) {
+	encoded, err := bech32DecodeBytes(Bech32DetectionKeyHRP, s)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeDetectionKey(encoded)
+}
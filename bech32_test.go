@@ -0,0 +1,78 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBech32Vectors(t *testing.T) {
+	// Valid checksums from BIP 173.
+	for _, s := range []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	} {
+		hrp, data, err := bech32Decode(s)
+		if err != nil {
+			t.Errorf("decode %s: %v", s, err)
+			continue
+		}
+		if got := bech32Encode(hrp, data); got != strings.ToLower(s) {
+			t.Errorf("encode %s = %s", s, got)
+		}
+	}
+	// Invalid strings from BIP 173, less the one that is only too long.
+	for _, s := range []string{
+		"\x201nwldj5",
+		"\x7f1axkwrx",
+		"pzry9x0s0muk",
+		"1pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"de1lg7wt\xff",
+		"A1G7SGD8",
+		"10a06t8",
+		"1qzzfhee",
+	} {
+		if _, _, err := bech32Decode(s); err == nil {
+			t.Errorf("accepted %q", s)
+		}
+	}
+}
+
+func TestBech32Keys(t *testing.T) {
+	sk := NewSecretKey(16)
+	pk, dk := sk.PublicKey(), sk.ExtractDetectionKey(4)
+
+	if s := pk.Bech32(); !strings.HasPrefix(s, "gtpk1") {
+		t.Errorf("public key string %s lacks its prefix", s)
+	}
+	gotPK, err := DecodePublicKeyBech32(pk.Bech32())
+	if err != nil || gotPK.Fingerprint() != pk.Fingerprint() {
+		t.Errorf("public key round trip: %v", err)
+	}
+	gotDK, err := DecodeDetectionKeyBech32(strings.ToUpper(dk.Bech32()))
+	if err != nil || !bytes.Equal(gotDK.Encode(nil), dk.Encode(nil)) {
+		t.Errorf("upper-case detection key round trip: %v", err)
+	}
+
+	if _, err := DecodeDetectionKeyBech32(pk.Bech32()); !errors.Is(err, ErrBech32HRP) {
+		t.Errorf("public key string as detection key: got %v", err)
+	}
+	s := []byte(dk.Bech32())
+	if s[10] == 'q' {
+		s[10] = 'p'
+	} else {
+		s[10] = 'q'
+	}
+	if _, err := DecodeDetectionKeyBech32(string(s)); !errors.Is(err, ErrBech32Checksum) {
+		t.Errorf("corrupted string: got %v", err)
+	}
+	if _, err := DecodeDetectionKeyBech32("gtdk1Qpzry9x8gf2tvdw0s3jn54khce6mua7l"); !errors.Is(err, ErrBech32Character) {
+		t.Errorf("mixed case: got %v", err)
+	}
+}
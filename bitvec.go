@@ -0,0 +1,24 @@
+//go:build !tinygo
+// +build !tinygo
+
+package gophertags
+
+import "math/big"
+
+// bitVector holds a flag's ciphertext bits. It is a big.Int except under
+// TinyGo; see bitvec_tinygo.go.
+type bitVector = big.Int
+
+// setBits sets z to the bits packed in in, inverting appendBits, and returns z.
+func setBits(z *bitVector, in []byte) *bitVector {
+	var buf [64]byte // enough for gamma up to 512 without allocating
+	bigEndian := buf[:]
+	if len(in) > len(buf) {
+		bigEndian = make([]byte, len(in))
+	}
+	bigEndian = bigEndian[:len(in)]
+	for i, c := range in {
+		bigEndian[len(in)-1-i] = c
+	}
+	return z.SetBytes(bigEndian)
+}
@@ -0,0 +1,63 @@
+package gophertags
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestBitVector checks the operations flags use on ciphertext bits. Run it
+// with -tags tinygo too, to check the packed bitVector against big.Int.
+func TestBitVector(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, gamma := range []int{1, 7, 8, 9, 24, 63, 64, 65, 256, 600} {
+		packed := make([]byte, (gamma+7)/8)
+		rng.Read(packed)
+		if gamma%8 != 0 {
+			packed[len(packed)-1] &= 1<<(gamma%8) - 1
+		}
+		packed[len(packed)-1] |= 1 << ((gamma - 1) % 8)
+
+		z := setBits(new(bitVector), packed)
+		if got := appendBits(nil, z, gamma); !bytes.Equal(got, packed) {
+			t.Errorf("gamma %d: appendBits(setBits(%x)) = %x", gamma, packed, got)
+		}
+		if n := z.BitLen(); n != gamma {
+			t.Errorf("gamma %d: BitLen = %d", gamma, n)
+		}
+		for i := 0; i < gamma+16; i++ {
+			want := uint(0)
+			if i < gamma {
+				want = uint(packed[i/8]>>(i%8)) & 1
+			}
+			if b := z.Bit(i); b != want {
+				t.Fatalf("gamma %d: Bit(%d) = %d, want %d", gamma, i, b, want)
+			}
+		}
+
+		// Set copies, and SetBit sets and clears bits in and past the vector.
+		c := new(bitVector).Set(z)
+		c.SetBit(c, 0, 1-c.Bit(0))
+		if z.Bit(0) == c.Bit(0) {
+			t.Errorf("gamma %d: changing a copy changed the original", gamma)
+		}
+		n := c.BitLen()
+		c.SetBit(c, gamma+20, 1)
+		if c.Bit(gamma+20) != 1 || c.BitLen() != gamma+21 {
+			t.Errorf("gamma %d: SetBit past the end: Bit = %d, BitLen = %d", gamma, c.Bit(gamma+20), c.BitLen())
+		}
+		c.SetBit(c, gamma+20, 0)
+		c.SetBit(c, gamma+40, 0)
+		if c.BitLen() != n {
+			t.Errorf("gamma %d: BitLen after clearing = %d, want %d", gamma, c.BitLen(), n)
+		}
+		d := new(bitVector).SetBit(z, gamma-1, 0)
+		if d.Bit(gamma-1) != 0 || z.Bit(gamma-1) != 1 {
+			t.Errorf("gamma %d: SetBit into another vector changed its source", gamma)
+		}
+	}
+
+	if n := setBits(new(bitVector), nil).BitLen(); n != 0 {
+		t.Errorf("empty vector has BitLen %d", n)
+	}
+}
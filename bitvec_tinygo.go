@@ -0,0 +1,64 @@
+//go:build tinygo
+// +build tinygo
+
+package gophertags
+
+import "math/bits"
+
+// bitVector holds a flag's ciphertext bits packed little-endian, as in the
+// encoding. TinyGo's math/big is large and slow on microcontrollers, and
+// flags only need a handful of its methods, which these mirror.
+type bitVector struct {
+	b []byte
+}
+
+// Bit returns the value of the i'th bit.
+func (z *bitVector) Bit(i int) uint {
+	if i/8 >= len(z.b) {
+		return 0
+	}
+	return uint(z.b[i/8]>>(i%8)) & 1
+}
+
+// SetBit sets z to x with the i'th bit set to b, and returns z.
+func (z *bitVector) SetBit(x *bitVector, i int, b uint) *bitVector {
+	if z != x {
+		z.Set(x)
+	}
+	for i/8 >= len(z.b) {
+		if b == 0 {
+			return z
+		}
+		z.b = append(z.b, 0)
+	}
+	if b == 0 {
+		z.b[i/8] &^= 1 << (i % 8)
+	} else {
+		z.b[i/8] |= 1 << (i % 8)
+	}
+	return z
+}
+
+// BitLen returns the position of the highest set bit plus one.
+func (z *bitVector) BitLen() int {
+	for n := len(z.b); n > 0; n-- {
+		if c := z.b[n-1]; c != 0 {
+			return 8*(n-1) + bits.Len8(c)
+		}
+	}
+	return 0
+}
+
+// Set sets z to x and returns z.
+func (z *bitVector) Set(x *bitVector) *bitVector {
+	if z != x {
+		z.b = append(z.b[:0], x.b...)
+	}
+	return z
+}
+
+// setBits sets z to the bits packed in in, inverting appendBits, and returns z.
+func setBits(z *bitVector, in []byte) *bitVector {
+	z.b = append(z.b[:0], in...)
+	return z
+}
@@ -0,0 +1,147 @@
+package gophertags
+
+import (
+	"encoding/binary"
+	"errors"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+// Tier names a detection key precision within a bundle, such as "relay" for
+// a coarse key given to an untrusted relay.
+type Tier struct {
+	Name      string
+	Precision int
+}
+
+// DetectionKeyBundle holds detection keys of several precisions extracted from
+// one secret key, so a recipient can give each server the false positive rate
+// it warrants. Because every detection key is a prefix of the secret key, the
+// bundle is as sensitive as its most precise key.
+type DetectionKeyBundle struct {
+	tiers []Tier
+	keys  map[string]*DetectionKey
+}
+
+// ErrTier is returned for bundles with duplicate tier names or precisions
+// outside the secret key's gamma.
+var ErrTier = errors.New("gophertags: invalid detection key tier")
+
+// ExtractDetectionKeyBundle extracts a detection key for each tier.
+func (sk *SecretKey) ExtractDetectionKeyBundle(tiers ...Tier) (*DetectionKeyBundle, error) {
+	b := &DetectionKeyBundle{keys: make(map[string]*DetectionKey, len(tiers))}
+	for _, t := range tiers {
+		if t.Precision < 0 || t.Precision > len(sk.sk) {
+			return nil, ErrTier
+		}
+		if _, dup := b.keys[t.Name]; dup {
+			return nil, ErrTier
+		}
+		b.tiers = append(b.tiers, t)
+		b.keys[t.Name] = sk.ExtractDetectionKey(t.Precision)
+	}
+	return b, nil
+}
+
+// Tiers returns the bundle's tiers in the order they were given.
+func (b *DetectionKeyBundle) Tiers() []Tier {
+	return append([]Tier(nil), b.tiers...)
+}
+
+// Key returns the detection key for the named tier, or nil if there is none.
+func (b *DetectionKeyBundle) Key(name string) *DetectionKey {
+	return b.keys[name]
+}
+
+// maxPrecision returns the key with the highest precision, which contains every other.
+func (b *DetectionKeyBundle) maxPrecision() *DetectionKey {
+	var max *DetectionKey
+	for _, t := range b.tiers {
+		if dk := b.keys[t.Name]; max == nil || len(dk.internal) > len(max.internal) {
+			max = dk
+		}
+	}
+	return max
+}
+
+const bundleType = "detection key bundle"
+
+// Encode appends the bundle's encoding to b. Scalars shared between tiers are
+// stored once:
+//
+//	id || uvarint(count) || count * (uvarint(len(name)) || name || uvarint(precision)) || x_1 || ... || x_max
+func (b *DetectionKeyBundle) Encode(out []byte) []byte {
+	max := b.maxPrecision()
+	if max == nil {
+		max = &DetectionKey{}
+	}
+	out = append(out, max.scheme().ID())
+	out = appendUvarint(out, uint64(len(b.tiers)))
+	for _, t := range b.tiers {
+		out = appendUvarint(out, uint64(len(t.Name)))
+		out = append(out, t.Name...)
+		out = appendUvarint(out, uint64(t.Precision))
+	}
+	for _, x := range max.internal {
+		out = x.Encode(out)
+	}
+	return out
+}
+
+// DecodeDetectionKeyBundle decodes a bundle encoded by DetectionKeyBundle.Encode.
+func DecodeDetectionKeyBundle(in []byte) (*DetectionKeyBundle, error) {
+	h, body, err := decodeScheme(bundleType, in)
+	if err != nil {
+		return nil, err
+	}
+	offset := func() int { return len(in) - len(body) }
+
+	count, n := binary.Uvarint(body)
+	if n <= 0 || count > uint64(len(body)) {
+		return nil, &DecodeError{bundleType, offset(), ErrLength}
+	}
+	body = body[n:]
+
+	tiers := make([]Tier, 0, count)
+	max := 0
+	for i := uint64(0); i < count; i++ {
+		nameLen, n := binary.Uvarint(body)
+		if n <= 0 || nameLen > uint64(len(body)-n) {
+			return nil, &DecodeError{bundleType, offset(), ErrLength}
+		}
+		name := string(body[n : n+int(nameLen)])
+		body = body[n+int(nameLen):]
+		precision, n := binary.Uvarint(body)
+		if n <= 0 || precision > uint64(len(body)/scalarSize) {
+			return nil, &DecodeError{bundleType, offset(), ErrLength}
+		}
+		body = body[n:]
+		tiers = append(tiers, Tier{name, int(precision)})
+		if int(precision) > max {
+			max = int(precision)
+		}
+	}
+
+	if len(body) != max*scalarSize {
+		return nil, &DecodeError{bundleType, offset(), ErrLength}
+	}
+	scalars := make([]*r255.Scalar, max)
+	for i := range scalars {
+		scalars[i] = r255.NewScalar()
+		if err := scalars[i].Decode(body[i*scalarSize : (i+1)*scalarSize]); err != nil {
+			return nil, &DecodeError{bundleType, offset() + i*scalarSize, ErrNonCanonicalScalar}
+		}
+	}
+
+	sk := &SecretKey{sk: scalars, params: params{hash: h}}
+	b, err := sk.ExtractDetectionKeyBundle(tiers...)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
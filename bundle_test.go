@@ -0,0 +1,63 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDetectionKeyBundle(t *testing.T) {
+	sk := NewSecretKeyWithHash(24, BLAKE2b)
+	tiers := []Tier{{"relay", 2}, {"semi-trusted", 10}, {"self", 24}}
+	b, err := sk.ExtractDetectionKeyBundle(tiers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tier := range tiers {
+		if !bytes.Equal(b.Key(tier.Name).Encode(nil), sk.ExtractDetectionKey(tier.Precision).Encode(nil)) {
+			t.Errorf("tier %s: wrong key", tier.Name)
+		}
+	}
+	if b.Key("nobody") != nil {
+		t.Error("unknown tier has a key")
+	}
+
+	encoded := b.Encode(nil)
+	if len(encoded) > DetectionKeySize(24)+len("relay semi-trusted self")+len(tiers)*2+1 {
+		t.Errorf("bundle encoding is %d bytes; shared scalars aren't stored once", len(encoded))
+	}
+	decoded, err := DecodeDetectionKeyBundle(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Encode(nil), encoded) {
+		t.Error("bundle doesn't round-trip")
+	}
+	if got := decoded.Tiers(); len(got) != 3 || got[1] != tiers[1] {
+		t.Errorf("decoded tiers %v", got)
+	}
+	if !decoded.Key("relay").Test(sk.PublicKey().GenerateFlag()) {
+		t.Error("decoded key doesn't detect the recipient's flags")
+	}
+}
+
+func TestDetectionKeyBundleErrors(t *testing.T) {
+	sk := NewSecretKey(8)
+	if _, err := sk.ExtractDetectionKeyBundle(Tier{"a", 9}); !errors.Is(err, ErrTier) {
+		t.Errorf("precision above gamma: got %v", err)
+	}
+	if _, err := sk.ExtractDetectionKeyBundle(Tier{"a", 1}, Tier{"a", 2}); !errors.Is(err, ErrTier) {
+		t.Errorf("duplicate tier: got %v", err)
+	}
+
+	b, _ := sk.ExtractDetectionKeyBundle(Tier{"a", 1}, Tier{"b", 3})
+	encoded := b.Encode(nil)
+	for i := 0; i < len(encoded); i++ {
+		if _, err := DecodeDetectionKeyBundle(encoded[:i]); err == nil {
+			t.Errorf("truncated to %d bytes: decoded", i)
+		}
+	}
+	if _, err := DecodeDetectionKeyBundle(append(encoded, 0)); !errors.Is(err, ErrLength) {
+		t.Errorf("trailing byte: got %v", err)
+	}
+}
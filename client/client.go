@@ -0,0 +1,306 @@
+// Package client talks to the HTTP detection API of package server. It is
+// written for recipients and senders who also want network-layer privacy: it
+// can dial through Tor or any SOCKS5 proxy, pads request bodies to a fixed
+// bucket size, and retries with jittered backoff.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// RetryPolicy controls how failed requests are retried. Requests are retried
+// after network errors and 429, 502, 503 and 504 responses, waiting for the
+// server's Retry-After if it is longer than the backoff.
+type RetryPolicy struct {
+	// MaxAttempts bounds attempts per request, including the first. Zero
+	// means 4; one disables retries.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, doubled for each
+	// retry after that up to MaxDelay. The actual delay is chosen
+	// uniformly between half and all of it, so clients don't retry in step.
+	// Zero means 500ms and 30s.
+	BaseDelay, MaxDelay time.Duration
+}
+
+// Config configures a Client.
+type Config struct {
+	// BaseURL is the server's address, e.g. "http://abcdef….onion".
+	BaseURL string
+
+	// Dialer opens connections to the server. Use a *SOCKS5 to go through
+	// Tor. Nil means a *net.Dialer.
+	Dialer ContextDialer
+
+	// PadTo, if positive, pads JSON request bodies to a multiple of PadTo
+	// bytes, so an observer of the encrypted connection can't tell requests
+	// apart by size. Responses are not padded.
+	PadTo int
+
+	// Retry is the retry policy.
+	Retry RetryPolicy
+
+	// Timeout bounds each attempt. Zero means one minute, which suits the
+	// latency of Tor circuits.
+	Timeout time.Duration
+
+	// APIToken, if set, is sent with every request, for servers that
+	// require API keys.
+	APIToken string
+}
+
+// Client is a detection API client. It is safe for concurrent use.
+type Client struct {
+	base    string
+	http    *http.Client
+	padTo   int
+	token   string
+	retry   RetryPolicy
+	timeout time.Duration
+	sleep   func(context.Context, time.Duration) error
+}
+
+// New returns a client for the server at config.BaseURL.
+func New(config Config) *Client {
+	dialer := config.Dialer
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+	c := &Client{
+		base: strings.TrimSuffix(config.BaseURL, "/"),
+		http: &http.Client{Transport: &http.Transport{
+			DialContext: dialer.DialContext,
+			// Ignore HTTP_PROXY and friends, which would bypass Dialer.
+			Proxy:           nil,
+			IdleConnTimeout: 90 * time.Second,
+		}},
+		padTo:   config.PadTo,
+		token:   config.APIToken,
+		retry:   config.Retry,
+		timeout: config.Timeout,
+		sleep:   sleep,
+	}
+	if c.retry.MaxAttempts <= 0 {
+		c.retry.MaxAttempts = 4
+	}
+	if c.retry.BaseDelay <= 0 {
+		c.retry.BaseDelay = 500 * time.Millisecond
+	}
+	if c.retry.MaxDelay <= 0 {
+		c.retry.MaxDelay = 30 * time.Second
+	}
+	if c.timeout <= 0 {
+		c.timeout = time.Minute
+	}
+	return c
+}
+
+// StatusError is returned for responses other than the expected one.
+type StatusError struct {
+	StatusCode int
+	Message    string // the server's error message, if any
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("client: server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("client: server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// RegisterKey registers a detection key, returning its ID.
+func (c *Client) RegisterKey(ctx context.Context, dk *gophertags.DetectionKey) (gophertags.KeyID, error) {
+	var resp struct {
+		KeyID string `json:"key_id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/v1/keys", "application/octet-stream", dk.Encode(nil), http.StatusCreated, &resp); err != nil {
+		return gophertags.KeyID{}, err
+	}
+	var id gophertags.KeyID
+	b, err := hex.DecodeString(resp.KeyID)
+	if err != nil || len(b) != len(id) {
+		return id, errors.New("client: malformed key ID in response")
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// Submit sends a flagged message, returning the ID the server assigned it.
+// Retried submissions are safe on servers that deduplicate flags: a
+// duplicate is reported with duplicate set and a zero ID.
+func (c *Client) Submit(ctx context.Context, f *gophertags.Flag, payload []byte) (id uint64, duplicate bool, err error) {
+	req := struct {
+		Flag    []byte  `json:"flag"`
+		Payload []byte  `json:"payload"`
+		Padding *string `json:"padding,omitempty"`
+	}{Flag: f.Encode(nil), Payload: payload}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, false, err
+	}
+	if c.padTo > 0 {
+		// The padding field adds `,"padding":""`, 13 bytes, plus its contents.
+		padding := strings.Repeat(" ", (c.padTo-(len(body)+13)%c.padTo)%c.padTo)
+		req.Padding = &padding
+		if body, err = json.Marshal(req); err != nil {
+			return 0, false, err
+		}
+	}
+	var resp struct {
+		ID        uint64 `json:"id"`
+		Duplicate bool   `json:"duplicate"`
+	}
+	err = c.do(ctx, http.MethodPost, "/v1/messages", "application/json", body, 0, &resp)
+	return resp.ID, resp.Duplicate, err
+}
+
+// Matches returns all the messages matching the key with the given ID,
+// fetching them a page at a time.
+func (c *Client) Matches(ctx context.Context, id gophertags.KeyID) ([]mailbox.Message, error) {
+	var all []mailbox.Message
+	var cursor uint64
+	for {
+		messages, more, err := c.MatchesSince(ctx, id, cursor, 0)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, messages...)
+		if !more || len(messages) == 0 {
+			return all, nil
+		}
+		cursor = messages[len(messages)-1].ID
+	}
+}
+
+// MatchesSince returns one page of the messages matching the key with the
+// given ID whose IDs are greater than cursor, oldest first, and whether more
+// remain after it. A limit of zero means the server's default page size.
+func (c *Client) MatchesSince(ctx context.Context, id gophertags.KeyID, cursor uint64, limit int) (messages []mailbox.Message, more bool, err error) {
+	var resp struct {
+		Messages []struct {
+			ID       uint64    `json:"id"`
+			Flag     []byte    `json:"flag"`
+			Payload  []byte    `json:"payload"`
+			Received time.Time `json:"received"`
+		} `json:"messages"`
+		More bool `json:"more"`
+	}
+	path := "/v1/matches?key=" + url.QueryEscape(id.String())
+	if cursor != 0 {
+		path += "&after=" + strconv.FormatUint(cursor, 10)
+	}
+	if limit > 0 {
+		path += "&limit=" + strconv.Itoa(limit)
+	}
+	if err := c.do(ctx, http.MethodGet, path, "", nil, http.StatusOK, &resp); err != nil {
+		return nil, false, err
+	}
+	messages = make([]mailbox.Message, len(resp.Messages))
+	for i, m := range resp.Messages {
+		messages[i] = mailbox.Message{ID: m.ID, Flag: m.Flag, Payload: m.Payload, Received: m.Received}
+	}
+	return messages, resp.More, nil
+}
+
+// do sends a request, retrying per the policy, and decodes a response with
+// status want (or any 2xx if want is zero) into v.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte, want int, v interface{}) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.attempt(ctx, method, path, contentType, body, want, v)
+		if retryAfter < 0 || attempt+1 >= c.retry.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		delay := c.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if serr := c.sleep(ctx, delay); serr != nil {
+			return err
+		}
+	}
+}
+
+// attempt makes one request. It returns a negative retryAfter if the error,
+// if any, is not worth retrying.
+func (c *Client) attempt(ctx context.Context, method, path, contentType string, body []byte, want int, v interface{}) (retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return -1, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err // network errors are retried
+	}
+	defer resp.Body.Close()
+
+	ok := resp.StatusCode == want || (want == 0 && resp.StatusCode/100 == 2)
+	if ok {
+		return -1, json.NewDecoder(resp.Body).Decode(v)
+	}
+
+	serr := &StatusError{StatusCode: resp.StatusCode}
+	var e struct {
+		Error string `json:"error"`
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(data, &e) == nil {
+		serr.Message = e.Error
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, serr
+	}
+	return -1, serr
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retry.BaseDelay << uint(attempt)
+	if d > c.retry.MaxDelay || d <= 0 {
+		d = c.retry.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/server"
+)
+
+func TestClient(t *testing.T) {
+	var mu sync.Mutex
+	var lengths []int64
+	s := server.New(server.Config{Dedup: server.NewDedupIndex(server.DedupConfig{})})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/messages" {
+			mu.Lock()
+			lengths = append(lengths, r.ContentLength)
+			mu.Unlock()
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	c := New(Config{BaseURL: ts.URL, PadTo: 512})
+	ctx := context.Background()
+	sk := gophertags.NewSecretKey(16)
+
+	id, err := c.RegisterKey(ctx, sk.ExtractDetectionKey(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != sk.PublicKey().KeyID() {
+		t.Errorf("registered %v, want %v", id, sk.PublicKey().KeyID())
+	}
+
+	f := sk.PublicKey().GenerateFlag()
+	if _, dup, err := c.Submit(ctx, f, []byte("hi")); err != nil || dup {
+		t.Fatalf("Submit: %v, duplicate %v", err, dup)
+	}
+	if _, dup, err := c.Submit(ctx, f, []byte("hi")); err != nil || !dup {
+		t.Errorf("resubmitting: %v, duplicate %v", err, dup)
+	}
+	if _, _, err := c.Submit(ctx, sk.PublicKey().GenerateFlag(), make([]byte, 700)); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range lengths {
+		if n%512 != 0 {
+			t.Errorf("request body of %d bytes isn't padded to 512", n)
+		}
+	}
+
+	messages, err := c.Matches(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || string(messages[0].Payload) != "hi" {
+		t.Errorf("matches = %+v", messages)
+	}
+
+	page, more, err := c.MatchesSince(ctx, id, 0, 1)
+	if err != nil || len(page) != 1 || page[0].ID != messages[0].ID || !more {
+		t.Errorf("first page = %+v, more %v, %v", page, more, err)
+	}
+	page, more, err = c.MatchesSince(ctx, id, page[0].ID, 1)
+	if err != nil || len(page) != 1 || page[0].ID != messages[1].ID || more {
+		t.Errorf("last page = %+v, more %v, %v", page, more, err)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	keys := server.NewAPIKeys()
+	token, err := keys.Issue(server.APIKey{Tenant: "alice", Scopes: server.ScopeRegister})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.New(server.Config{APIKeys: keys}))
+	defer ts.Close()
+	dk := gophertags.NewSecretKey(8).ExtractDetectionKey(4)
+
+	if _, err := New(Config{BaseURL: ts.URL, APIToken: token}).RegisterKey(context.Background(), dk); err != nil {
+		t.Errorf("with token: %v", err)
+	}
+	_, err = New(Config{BaseURL: ts.URL}).RegisterKey(context.Background(), dk)
+	if serr, ok := err.(*StatusError); !ok || serr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/v1/keys":
+			http.Error(w, `{"error":"bad key"}`, http.StatusBadRequest)
+		case n < 3:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"messages":[]}`))
+		}
+	}))
+	defer ts.Close()
+
+	c := New(Config{BaseURL: ts.URL, Retry: RetryPolicy{BaseDelay: time.Second}})
+	var delays []time.Duration
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	if _, err := c.Matches(context.Background(), gophertags.KeyID{}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(delays) != 2 {
+		t.Fatalf("%d calls, %d delays; want 3 and 2", calls, len(delays))
+	}
+	for _, d := range delays {
+		if d != 7*time.Second {
+			t.Errorf("delay %v doesn't honor Retry-After", d)
+		}
+	}
+
+	calls, delays = 0, nil
+	_, err := c.RegisterKey(context.Background(), gophertags.NewSecretKey(8).ExtractDetectionKey(1))
+	if serr, ok := err.(*StatusError); !ok || serr.StatusCode != http.StatusBadRequest || serr.Message != "bad key" {
+		t.Errorf("RegisterKey error = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("client error retried: %d calls", calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := New(Config{Retry: RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}})
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := c.backoff(attempt); d < max/2 || d > max {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", attempt, d, max/2, max)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// CoverPolicy decides when a CoverScheduler sends.
+type CoverPolicy struct {
+	// Rate is the mean number of messages sent per second.
+	Rate float64
+
+	// Poisson makes the gaps between sends exponentially distributed, as in
+	// a Poisson process, instead of constant. Constant gaps are easier to
+	// budget for; Poisson gaps don't let an observer predict the next send.
+	Poisson bool
+}
+
+// CoverConfig configures a CoverScheduler.
+type CoverConfig struct {
+	// Client submits messages.
+	Client *Client
+
+	// Policy schedules sends.
+	Policy CoverPolicy
+
+	// Gamma is the gamma of the decoy public key, which should match the
+	// recipients' keys so decoy flags are the same size as real ones.
+	Gamma int
+
+	// PayloadSize is the size of decoy payloads. Real payloads should be
+	// padded to it, or to one of a few sizes, by the caller.
+	PayloadSize int
+
+	// MaxQueue bounds how many real messages may wait for a send slot.
+	// Zero means 64.
+	MaxQueue int
+}
+
+// ErrQueueFull is returned by CoverScheduler.Send when MaxQueue real
+// messages are already waiting.
+var ErrQueueFull = errors.New("client: cover traffic queue is full")
+
+// CoverScheduler sends at a rate set by its policy whatever the application
+// does, filling each slot with a queued real message if there is one and a
+// decoy otherwise, so a network observer, or the server, can't tell from a
+// client's sends how active it really is. Decoys carry flags for a throwaway
+// public key, which match detection keys only at their false positive rate,
+// and random payloads. It is safe for concurrent use.
+type CoverScheduler struct {
+	client *Client
+	policy CoverPolicy
+	decoy  *gophertags.PublicKey
+	size   int
+	queue  chan *coverItem
+	wait   func(context.Context, time.Duration) error
+}
+
+type coverItem struct {
+	flag    *gophertags.Flag
+	payload []byte
+	done    chan error
+}
+
+// NewCoverScheduler returns a scheduler. Call Run to start sending.
+func NewCoverScheduler(config CoverConfig) *CoverScheduler {
+	if !(config.Policy.Rate > 0) {
+		panic("client: cover traffic rate must be positive")
+	}
+	if config.MaxQueue <= 0 {
+		config.MaxQueue = 64
+	}
+	return &CoverScheduler{
+		client: config.Client,
+		policy: config.Policy,
+		decoy:  gophertags.NewLazySecretKey(config.Gamma).PublicKey(),
+		size:   config.PayloadSize,
+		queue:  make(chan *coverItem, config.MaxQueue),
+		wait:   sleep,
+	}
+}
+
+// Send queues a real message for the next send slot and waits until it has
+// been submitted, returning the submission's error. It returns ErrQueueFull
+// at once if the queue is full, and ctx.Err() if ctx is done first, in which
+// case the message may still be sent.
+func (s *CoverScheduler) Send(ctx context.Context, f *gophertags.Flag, payload []byte) error {
+	item := &coverItem{flag: f, payload: payload, done: make(chan error, 1)}
+	select {
+	case s.queue <- item:
+	default:
+		return ErrQueueFull
+	}
+	select {
+	case err := <-item.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run sends until ctx is done, then returns ctx.Err(). Failed decoy sends
+// are ignored; failed real sends are reported to their Send callers.
+func (s *CoverScheduler) Run(ctx context.Context) error {
+	for {
+		if err := s.wait(ctx, s.gap()); err != nil {
+			return err
+		}
+		select {
+		case item := <-s.queue:
+			_, _, err := s.client.Submit(ctx, item.flag, item.payload)
+			item.done <- err
+		default:
+			payload := make([]byte, s.size)
+			rand.Read(payload)
+			s.client.Submit(ctx, s.decoy.GenerateFlag(), payload)
+		}
+	}
+}
+
+// gap returns the time until the next send.
+func (s *CoverScheduler) gap() time.Duration {
+	mean := float64(time.Second) / s.policy.Rate
+	if !s.policy.Poisson {
+		return time.Duration(mean)
+	}
+	// Inverse transform sampling; 1-u is in (0, 1], so the log is finite.
+	return time.Duration(-math.Log(1-uniform()) * mean)
+}
+
+// uniform returns a float64 uniformly distributed in [0, 1). It uses crypto/rand
+// so that observers can't predict the schedule.
+func uniform() float64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("client: crypto/rand failed: " + err.Error())
+	}
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
+}
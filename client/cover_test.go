@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/server"
+)
+
+func TestCoverScheduler(t *testing.T) {
+	var submitted int32
+	s := server.New(server.Config{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/messages" {
+			atomic.AddInt32(&submitted, 1)
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	c := New(Config{BaseURL: ts.URL})
+	sk := gophertags.NewSecretKey(16)
+	id, err := c.RegisterKey(context.Background(), sk.ExtractDetectionKey(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := NewCoverScheduler(CoverConfig{Client: c, Policy: CoverPolicy{Rate: 1}, Gamma: 16, PayloadSize: 32})
+	slots := make(chan struct{})
+	cs.wait = func(ctx context.Context, d time.Duration) error {
+		if d != time.Second {
+			t.Errorf("constant-rate gap = %v", d)
+		}
+		select {
+		case <-slots:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cs.Run(ctx)
+
+	// Two slots with nothing queued send decoys.
+	slots <- struct{}{}
+	slots <- struct{}{}
+	sent := make(chan error)
+	go func() { sent <- cs.Send(ctx, sk.PublicKey().GenerateFlag(), []byte("real")) }()
+	for {
+		slots <- struct{}{}
+		select {
+		case err := <-sent:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Millisecond):
+			continue
+		}
+		break
+	}
+	cancel()
+
+	if n := atomic.LoadInt32(&submitted); n < 3 {
+		t.Errorf("%d submissions, want at least 3", n)
+	}
+	messages, err := c.Matches(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || string(messages[0].Payload) != "real" {
+		t.Errorf("recipient's matches = %+v", messages)
+	}
+}
+
+func TestCoverPoissonGaps(t *testing.T) {
+	cs := NewCoverScheduler(CoverConfig{Policy: CoverPolicy{Rate: 10, Poisson: true}, Gamma: 1})
+	const n = 5000
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += cs.gap().Seconds()
+	}
+	if mean := sum / n; math.Abs(mean-0.1) > 0.01 {
+		t.Errorf("mean Poisson gap %.3fs, want 0.1s", mean)
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/envelope"
+)
+
+// SubmitEnvelope submits an envelope from package envelope, sending its flag
+// for detection and the whole envelope as the payload.
+func (c *Client) SubmitEnvelope(ctx context.Context, env []byte) (id uint64, duplicate bool, err error) {
+	f, err := envelope.Flag(env)
+	if err != nil {
+		return 0, false, err
+	}
+	return c.Submit(ctx, f, env)
+}
+
+// InboxConfig configures an Inbox.
+type InboxConfig struct {
+	// Client fetches matches.
+	Client *Client
+
+	// Key is the ID of the recipient's registered detection key.
+	Key gophertags.KeyID
+
+	// PrivateKey opens the envelopes in matched payloads.
+	PrivateKey *[envelope.KeySize]byte
+
+	// AdditionalData is passed to envelope.Open.
+	AdditionalData []byte
+
+	// Signed expects envelopes sealed with envelope.SealSigned, and delivers
+	// only those whose sender signature verifies.
+	Signed bool
+
+	// Interval is the time between polls. Zero means 30 seconds.
+	Interval time.Duration
+
+	// Cursor resumes after the message with this ID, as returned by
+	// Inbox.Cursor before a restart. Zero means from the beginning.
+	Cursor uint64
+}
+
+// Message is a decrypted message delivered by an Inbox.
+type Message struct {
+	ID        uint64
+	Plaintext []byte
+	Sender    ed25519.PublicKey // nil unless InboxConfig.Signed
+	Received  time.Time
+}
+
+// Inbox polls a server for a recipient's matches and decrypts them, so
+// applications only see plaintext messages. Matches that don't decrypt are
+// false positives, flags for other recipients that a detection key matches by
+// design, and are silently skipped. An Inbox is not safe for concurrent use.
+type Inbox struct {
+	config InboxConfig
+	cursor uint64
+	wait   func(context.Context, time.Duration) error
+}
+
+const defaultPollInterval = 30 * time.Second
+
+// NewInbox returns an inbox.
+func NewInbox(config InboxConfig) *Inbox {
+	if config.Interval <= 0 {
+		config.Interval = defaultPollInterval
+	}
+	return &Inbox{config: config, cursor: config.Cursor, wait: sleep}
+}
+
+// Cursor returns the ID of the last message Poll has seen. Persist it to
+// resume without seeing messages twice.
+func (in *Inbox) Cursor() uint64 {
+	return in.cursor
+}
+
+// Poll fetches matches newer than the cursor once, a page at a time,
+// returning those that decrypt, oldest first, and advances the cursor past
+// all of them. If fetching a page fails, Poll returns the messages from
+// earlier pages along with the error.
+func (in *Inbox) Poll(ctx context.Context) ([]Message, error) {
+	var messages []Message
+	for {
+		matches, more, err := in.config.Client.MatchesSince(ctx, in.config.Key, in.cursor, 0)
+		if err != nil {
+			return messages, err
+		}
+		for _, m := range matches {
+			in.cursor = m.ID
+			msg := Message{ID: m.ID, Received: m.Received}
+			if in.config.Signed {
+				msg.Plaintext, msg.Sender, err = envelope.OpenSigned(in.config.PrivateKey, m.Payload, in.config.AdditionalData)
+			} else {
+				msg.Plaintext, err = envelope.Open(in.config.PrivateKey, m.Payload, in.config.AdditionalData)
+			}
+			if err != nil {
+				continue
+			}
+			messages = append(messages, msg)
+		}
+		if !more || len(matches) == 0 {
+			return messages, nil
+		}
+	}
+}
+
+// Run polls every config.Interval and passes each new message to handle,
+// until ctx is done or handle returns an error, which Run returns. The cursor
+// is left before the message handle failed on, so it is delivered again
+// after a restart. Failed polls, already retried by the Client, are retried
+// after the next interval, which doubles for each consecutive failure up to
+// ten times its configured value.
+func (in *Inbox) Run(ctx context.Context, handle func(Message) error) error {
+	interval := in.config.Interval
+	for {
+		previous := in.cursor
+		messages, err := in.Poll(ctx)
+		if err != nil {
+			if interval *= 2; interval > 10*in.config.Interval {
+				interval = 10 * in.config.Interval
+			}
+		} else {
+			interval = in.config.Interval
+		}
+		for i, msg := range messages {
+			if err := handle(msg); err != nil {
+				in.cursor = previous
+				if i > 0 {
+					in.cursor = messages[i-1].ID
+				}
+				return err
+			}
+		}
+		if err := in.wait(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
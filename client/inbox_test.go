@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/envelope"
+	"github.com/gtank/gophertags/server"
+)
+
+func TestInbox(t *testing.T) {
+	ts := httptest.NewServer(server.New(server.Config{}))
+	defer ts.Close()
+	c := New(Config{BaseURL: ts.URL})
+	ctx := context.Background()
+
+	sk := gophertags.NewSecretKey(16)
+	id, err := c.RegisterKey(ctx, sk.ExtractDetectionKey(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, _ := envelope.GenerateKey(nil)
+	send := func(plaintext string) {
+		t.Helper()
+		env, err := envelope.Seal(sk.PublicKey(), pub, []byte(plaintext), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.SubmitEnvelope(ctx, env); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send("one")
+	// A match that isn't for us, as a false positive would be.
+	otherPub, _, _ := envelope.GenerateKey(nil)
+	env, _ := envelope.Seal(sk.PublicKey(), otherPub, []byte("not ours"), nil)
+	c.SubmitEnvelope(ctx, env)
+	send("two")
+
+	in := NewInbox(InboxConfig{Client: c, Key: id, PrivateKey: priv})
+	messages, err := in.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || string(messages[0].Plaintext) != "one" || string(messages[1].Plaintext) != "two" {
+		t.Fatalf("first poll = %+v", messages)
+	}
+	if messages, _ := in.Poll(ctx); len(messages) != 0 {
+		t.Errorf("second poll redelivered %d messages", len(messages))
+	}
+
+	// Resuming from a saved cursor delivers only newer messages.
+	send("three")
+	resumed := NewInbox(InboxConfig{Client: c, Key: id, PrivateKey: priv, Cursor: in.Cursor()})
+	if messages, _ := resumed.Poll(ctx); len(messages) != 1 || string(messages[0].Plaintext) != "three" {
+		t.Errorf("resumed poll = %+v", messages)
+	}
+}
+
+func TestInboxRun(t *testing.T) {
+	ts := httptest.NewServer(server.New(server.Config{}))
+	defer ts.Close()
+	c := New(Config{BaseURL: ts.URL})
+	ctx := context.Background()
+
+	sk := gophertags.NewSecretKey(16)
+	id, _ := c.RegisterKey(ctx, sk.ExtractDetectionKey(4))
+	pub, priv, _ := envelope.GenerateKey(nil)
+	senderPub, sender, _ := ed25519.GenerateKey(nil)
+	for _, s := range []string{"a", "b", "c"} {
+		env, _ := envelope.SealSigned(sk.PublicKey(), pub, sender, []byte(s), nil)
+		c.SubmitEnvelope(ctx, env)
+	}
+
+	in := NewInbox(InboxConfig{Client: c, Key: id, PrivateKey: priv, Signed: true})
+	in.wait = func(context.Context, time.Duration) error { return errors.New("stop") }
+	failAt := errors.New("handler failed")
+	var got []string
+	err := in.Run(ctx, func(m Message) error {
+		if !m.Sender.Equal(senderPub) {
+			t.Errorf("sender %x", m.Sender)
+		}
+		if string(m.Plaintext) == "b" {
+			return failAt
+		}
+		got = append(got, string(m.Plaintext))
+		return nil
+	})
+	if err != failAt || len(got) != 1 {
+		t.Fatalf("Run = %v after %q", err, got)
+	}
+
+	// The message the handler failed on is delivered again.
+	if messages, _ := in.Poll(ctx); len(messages) != 2 || string(messages[0].Plaintext) != "b" {
+		t.Errorf("poll after failed handler = %+v", messages)
+	}
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ContextDialer dials network connections. *net.Dialer and SOCKS5 implement it.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// SOCKS5 dials through a SOCKS5 proxy (RFC 1928), such as Tor's SocksPort.
+// Hostnames are sent to the proxy unresolved, so .onion addresses work and
+// no DNS queries leak from the client.
+//
+// With Tor, connections with different credentials never share a circuit
+// (IsolateSOCKSAuth is on by default), so use a distinct Username per
+// identity to keep their traffic unlinkable at the network layer.
+type SOCKS5 struct {
+	Addr string // proxy address, e.g. "127.0.0.1:9050"
+
+	// Username and Password, if Username is set, are sent with RFC 1929
+	// authentication.
+	Username, Password string
+
+	// Forward dials the proxy. Nil means a *net.Dialer.
+	Forward ContextDialer
+}
+
+// SOCKS5 reply codes from RFC 1928, section 6.
+var socksReplies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// ErrSOCKS is returned when the proxy violates the SOCKS5 protocol or
+// rejects the client's credentials.
+var ErrSOCKS = errors.New("client: SOCKS5 proxy failure")
+
+// DialContext connects to address through the proxy. Only "tcp" networks are
+// supported.
+func (s *SOCKS5) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("client: SOCKS5 does not support network %q", network)
+	}
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("client: bad port in %q", address)
+	}
+	if len(host) > 255 {
+		return nil, fmt.Errorf("client: hostname too long for SOCKS5")
+	}
+
+	forward := s.Forward
+	if forward == nil {
+		forward = new(net.Dialer)
+	}
+	conn, err := forward.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the handshake by ctx, then clear the deadline for the caller.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	err = s.handshake(conn, host, uint16(port))
+	close(done)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (s *SOCKS5) handshake(conn net.Conn, host string, port uint16) error {
+	method := byte(0x00) // no authentication
+	if s.Username != "" {
+		method = 0x02 // username/password
+	}
+	if _, err := conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != method {
+		return ErrSOCKS
+	}
+
+	if method == 0x02 {
+		if len(s.Username) > 255 || len(s.Password) > 255 {
+			return errors.New("client: SOCKS5 credentials too long")
+		}
+		auth := []byte{1, byte(len(s.Username))}
+		auth = append(auth, s.Username...)
+		auth = append(auth, byte(len(s.Password)))
+		auth = append(auth, s.Password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return ErrSOCKS
+		}
+	}
+
+	req := []byte{5, 1, 0} // CONNECT
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 1), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 4), ip.To16()...)
+	} else {
+		req = append(append(req, 3, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[0] != 5 {
+		return ErrSOCKS
+	}
+	if header[1] != 0 {
+		if msg, ok := socksReplies[header[1]]; ok {
+			return fmt.Errorf("client: SOCKS5 proxy: %s", msg)
+		}
+		return ErrSOCKS
+	}
+	// Skip the bound address and port.
+	var skip int
+	switch header[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0]) + 2
+	default:
+		return ErrSOCKS
+	}
+	_, err := io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+var _ ContextDialer = (*SOCKS5)(nil)
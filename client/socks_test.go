@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/server"
+)
+
+// fakeProxy is a SOCKS5 proxy requiring the given credentials. It records the
+// destinations clients ask for.
+func fakeProxy(t *testing.T, user, pass string, reply byte) (addr string, targets <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	seen := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS(conn, user, pass, reply, seen)
+		}
+	}()
+	return ln.Addr().String(), seen
+}
+
+func serveSOCKS(conn net.Conn, user, pass string, reply byte, seen chan<- string) {
+	defer conn.Close()
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	greeting := read(2)
+	if greeting == nil || read(int(greeting[1])) == nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+	auth := read(2)
+	if auth == nil {
+		return
+	}
+	gotUser := string(read(int(auth[1])))
+	gotPass := string(read(int(read(1)[0])))
+	if gotUser != user || gotPass != pass {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	req := read(4)
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	}
+	port := read(2)
+	target := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+	seen <- target
+	if reply != 0 {
+		conn.Write([]byte{5, reply, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestSOCKS5(t *testing.T) {
+	ts := httptest.NewServer(server.New(server.Config{}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	proxy, targets := fakeProxy(t, "alice", "hunter2", 0)
+
+	// The hostname must reach the proxy unresolved.
+	c := New(Config{
+		BaseURL: "http://localhost:" + port,
+		Dialer:  &SOCKS5{Addr: proxy, Username: "alice", Password: "hunter2"},
+	})
+	if _, err := c.RegisterKey(context.Background(), gophertags.NewSecretKey(8).ExtractDetectionKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if target := <-targets; target != "localhost:"+port {
+		t.Errorf("proxy asked for %q", target)
+	}
+
+	bad := &SOCKS5{Addr: proxy, Username: "alice", Password: "wrong"}
+	if _, err := bad.DialContext(context.Background(), "tcp", "localhost:"+port); err != ErrSOCKS {
+		t.Errorf("wrong password: got %v, want ErrSOCKS", err)
+	}
+}
+
+func TestSOCKS5Refused(t *testing.T) {
+	proxy, _ := fakeProxy(t, "u", "p", 5)
+	d := &SOCKS5{Addr: proxy, Username: "u", Password: "p"}
+	_, err := d.DialContext(context.Background(), "tcp", "example.onion:80")
+	if err == nil || err.Error() != "client: SOCKS5 proxy: connection refused" {
+		t.Errorf("refused connection: got %v", err)
+	}
+	if _, err := d.DialContext(context.Background(), "udp", "example.onion:80"); err == nil {
+		t.Error("dialed a UDP network")
+	}
+}
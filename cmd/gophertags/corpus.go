@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gtank/gophertags"
+)
+
+// runCorpus writes inputs for the root package's fuzz targets in the format
+// of go test's seed corpus, so that
+//
+//	gophertags corpus -dir testdata/fuzz
+//
+// run in the package directory has the fuzzer start from well-formed keys and
+// flags, and from the near misses and hostile inputs around them, instead of
+// from the few seeds added in fuzz_test.go.
+func runCorpus(args []string) error {
+	fs := newFlagSet("corpus")
+	dir := fs.String("dir", "testdata/fuzz", "corpus `directory`, with a subdirectory per fuzz target")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	n, err := writeCorpus(*dir, corpusInputs())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d new inputs to %s\n", n, *dir)
+	return nil
+}
+
+var corpusGammas = []int{1, 8, 16, 24}
+
+// corpusInputs returns the inputs for each fuzz target: valid encodings of
+// every hash scheme and a range of sizes, each mutated in the ways decoders
+// most need to reject, and inputs built to be expensive or degenerate.
+func corpusInputs() map[string][][]byte {
+	var flags, publicKeys, detectionKeys [][]byte
+	for _, h := range gophertags.HashSchemes() {
+		for _, gamma := range corpusGammas {
+			sk := gophertags.NewSecretKeyWithHash(gamma, h)
+			pk := sk.PublicKey()
+			flags = append(flags, nearValid(pk.GenerateFlag().Encode(nil), 1+32)...)
+			publicKeys = append(publicKeys, nearValid(pk.Encode(nil), -1)...)
+			for _, n := range []int{1, gamma} {
+				detectionKeys = append(detectionKeys, nearValid(sk.ExtractDetectionKey(n).Encode(nil), 1)...)
+			}
+		}
+		detectionKeys = append(detectionKeys, []byte{h.ID()})
+	}
+
+	id := gophertags.SHA3.ID()
+	identity := make([]byte, 32)
+	nonCanonical := bytes.Repeat([]byte{0xff}, 32)
+	valid := gophertags.NewSecretKey(8).PublicKey().GenerateFlag().Encode(nil)
+	flags = append(flags,
+		nil,
+		[]byte{id},
+		join([]byte{id}, identity, identity, []byte{0xff}),                       // U is the identity
+		join([]byte{id}, nonCanonical, identity, []byte{0xff}),                   // U is not canonical
+		join(valid[:1+64], bytes.Repeat([]byte{0xff}, 1<<13)),                    // a 64Ki-bit ciphertext
+		join([]byte{id}, valid[1:33], bytes.Repeat([]byte{0xff}, 32), []byte{0}), // y is not canonical
+	)
+	publicKeys = append(publicKeys,
+		nil,
+		[]byte{id},
+		join([]byte{id}, bytes.Repeat(identity, 1024)),
+		join([]byte{id}, nonCanonical),
+		join([]byte{id}, identity[:31]),
+	)
+	detectionKeys = append(detectionKeys,
+		nil,
+		join([]byte{id}, bytes.Repeat(identity, 4096)), // zero scalars
+		join([]byte{id}, nonCanonical),
+		join([]byte{id}, identity[:31]),
+	)
+	return map[string][][]byte{
+		"FuzzFlagDecode":         flags,
+		"FuzzPublicKeyDecode":    publicKeys,
+		"FuzzDetectionKeyDecode": detectionKeys,
+	}
+}
+
+// nearValid returns enc and copies of it that are off by a little: truncated,
+// extended, with an unknown hash scheme, with bits flipped at the start and
+// end and, if scalar is not negative, with the 32-byte scalar at that offset
+// replaced by a non-canonical one.
+func nearValid(enc []byte, scalar int) [][]byte {
+	out := [][]byte{enc, enc[:len(enc)-1], join(enc, []byte{0})}
+	mutate := func(f func(b []byte)) {
+		b := join(enc)
+		f(b)
+		out = append(out, b)
+	}
+	mutate(func(b []byte) { b[0] = 0xff })
+	mutate(func(b []byte) { b[1] ^= 1 })
+	mutate(func(b []byte) { b[len(b)-1] ^= 0x80 })
+	if scalar >= 0 && len(enc) >= scalar+32 {
+		mutate(func(b []byte) { copy(b[scalar:], bytes.Repeat([]byte{0xff}, 32)) })
+	}
+	return out
+}
+
+func join(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// writeCorpus writes each target's inputs to dir/<target>, naming files by
+// their content as go test does, and returns how many files were new.
+func writeCorpus(dir string, inputs map[string][][]byte) (int, error) {
+	written := 0
+	for target, in := range inputs {
+		targetDir := filepath.Join(dir, target)
+		if err := os.MkdirAll(targetDir, 0755); err != nil {
+			return written, err
+		}
+		for _, b := range in {
+			content := corpusFile(b)
+			path := filepath.Join(targetDir, fmt.Sprintf("%x", sha256.Sum256(content))[:16])
+			if _, err := os.Stat(path); err == nil {
+				continue
+			}
+			if err := ioutil.WriteFile(path, content, 0644); err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+	return written, nil
+}
+
+// corpusFile encodes a []byte input in go test's corpus file format.
+func corpusFile(b []byte) []byte {
+	return []byte(fmt.Sprintf("go test fuzz v1\n[]byte(%q)\n", b))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestCorpusInputs(t *testing.T) {
+	inputs := corpusInputs()
+	decoders := map[string]func([]byte) error{
+		"FuzzFlagDecode":         new(gophertags.Flag).Decode,
+		"FuzzPublicKeyDecode":    new(gophertags.PublicKey).Decode,
+		"FuzzDetectionKeyDecode": new(gophertags.DetectionKey).Decode,
+	}
+	for target, decode := range decoders {
+		valid, invalid := 0, 0
+		for _, in := range inputs[target] {
+			if decode(in) == nil {
+				valid++
+			} else {
+				invalid++
+			}
+		}
+		if valid == 0 || invalid == 0 {
+			t.Errorf("%s: %d valid and %d invalid inputs, want some of each", target, valid, invalid)
+		}
+	}
+}
+
+func TestWriteCorpus(t *testing.T) {
+	dir := t.TempDir()
+	inputs := map[string][][]byte{"FuzzX": {nil, []byte("a\x00b"), []byte("a\x00b")}}
+	if n, err := writeCorpus(dir, inputs); err != nil || n != 2 {
+		t.Fatalf("writeCorpus = %d, %v; want 2 new files", n, err)
+	}
+	if n, _ := writeCorpus(dir, inputs); n != 0 {
+		t.Errorf("rewriting the corpus wrote %d new files", n)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "FuzzX", "*"))
+	found := false
+	for _, path := range files {
+		content, _ := ioutil.ReadFile(path)
+		lines := strings.Split(string(content), "\n")
+		if len(lines) != 3 || lines[0] != "go test fuzz v1" || !strings.HasPrefix(lines[1], "[]byte(") {
+			t.Fatalf("%s isn't a corpus file: %q", path, content)
+		}
+		if s, err := strconv.Unquote(strings.TrimSuffix(strings.TrimPrefix(lines[1], "[]byte("), ")")); err == nil && s == "a\x00b" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("input missing from the corpus")
+	}
+}
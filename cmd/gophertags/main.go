@@ -0,0 +1,74 @@
+// Command gophertags is a toolbox for developing gophertags and testing the
+// implementations it interoperates with.
+//
+// Usage:
+//
+//	gophertags <command> [flags]
+//
+// The commands are:
+//
+//	corpus   write a seed corpus for the package's fuzz targets
+//	vectors  write known-answer test vectors as JSON
+//
+// Run "gophertags <command> -h" for a command's flags.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+type command struct {
+	name, summary string
+	run           func(args []string) error
+}
+
+var commands = []command{
+	{"corpus", "write a seed corpus for the package's fuzz targets", runCorpus},
+	{"vectors", "write known-answer test vectors as JSON", runVectors},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage(os.Stdout)
+		return
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(os.Args[2:]); err != nil {
+			if err == flag.ErrHelp {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "gophertags %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "gophertags: unknown command %q\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: gophertags <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+}
+
+// newFlagSet returns a flag set for a command that reports errors rather than
+// exiting, so commands can be tested.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("gophertags "+name, flag.ContinueOnError)
+}
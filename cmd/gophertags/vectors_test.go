@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestKnownAnswers(t *testing.T) {
+	kat, err := knownAnswers("interop", "8, 16", "SHA3,fmd2-ristretto255-blake2b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kat.Vectors) != 4 {
+		t.Fatalf("got %d vectors, want 4", len(kat.Vectors))
+	}
+	if v := kat.Vectors[3]; v.Seed != "interop" || v.HashScheme != gophertags.BLAKE2b.Name() || v.Gamma != 16 {
+		t.Errorf("last vector is %s/%s/%d", v.Seed, v.HashScheme, v.Gamma)
+	}
+
+	for _, bad := range [][2]string{{"0", "SHA3"}, {"x", "SHA3"}, {"8", "MD5"}} {
+		if _, err := knownAnswers("interop", bad[0], bad[1]); err == nil {
+			t.Errorf("gamma %q, hash %q: no error", bad[0], bad[1])
+		}
+	}
+}
+
+// TestKnownAnswersMatchPackage checks that the command reproduces the
+// package's own vectors from their seeds.
+func TestKnownAnswersMatchPackage(t *testing.T) {
+	raw, err := ioutil.ReadFile("../../testdata/kat.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want gophertags.KnownAnswers
+	if err := json.Unmarshal(raw, &want); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range want.Vectors {
+		got, err := knownAnswers(v.Seed, strconv.Itoa(v.Gamma), v.HashScheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Vectors[0], v) {
+			t.Errorf("%s: regenerated vector differs", v.Seed)
+		}
+	}
+}
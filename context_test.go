@@ -0,0 +1,42 @@
+package gophertags
+
+import "testing"
+
+func TestContextSeparation(t *testing.T) {
+	sk := NewSecretKeyWithContext(16, "app one")
+	pk := sk.PublicKey()
+	dk := sk.ExtractDetectionKey(16)
+
+	if pk.Context() != "app one" || dk.Context() != "app one" {
+		t.Fatal("derived keys don't inherit the secret key's context")
+	}
+
+	for i := 0; i < 8; i++ {
+		f := pk.GenerateFlag()
+		if !dk.Test(f) {
+			t.Fatal("flag not detected under its own context")
+		}
+		// Each of these matches by chance with probability 2^-16.
+		if dk.WithContext("app two").Test(f) || dk.WithContext("").Test(f) {
+			t.Error("flag detected under a different context")
+		}
+		if !dk.WithContext("app one").Test(f) {
+			t.Error("rebinding to the same context broke detection")
+		}
+	}
+
+	if dk.WithContext("").Test(pk.WithContext("app two").GenerateFlag()) {
+		t.Error("flag from a rebound public key detected under the wrong context")
+	}
+}
+
+func TestContextSurvivesDecode(t *testing.T) {
+	sk := NewSecretKeyWithContext(8, "app")
+	pk := (&PublicKey{}).WithContext("app")
+	if err := pk.Decode(sk.PublicKey().Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if !sk.ExtractDetectionKey(8).Test(pk.GenerateFlag()) {
+		t.Error("decoded, context-bound public key doesn't produce detectable flags")
+	}
+}
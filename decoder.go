@@ -0,0 +1,67 @@
+package gophertags
+
+import r255 "github.com/gtank/ristretto255"
+
+// Decoder decodes flags and keys in one of two modes. The zero value is
+// strict: like the Decode methods, it rejects encodings with trailing bytes,
+// wrong lengths or non-canonical points, so that every value has exactly one
+// accepted encoding, which is what a server deduplicating or storing flags
+// needs. A Lenient decoder instead ignores data it doesn't understand after
+// the fields it knows, so that clients keep working when a later version
+// appends extensions to an encoding.
+//
+// Both modes reject non-canonical elements and scalars and degenerate flags:
+// leniency is about the length of an encoding, never about its values.
+type Decoder struct {
+	// Lenient selects lenient mode.
+	Lenient bool
+
+	// Gamma, if positive, is the expected gamma of flags and public keys.
+	// Without it the length of the encoding sets gamma, so neither mode can
+	// tell trailing bytes of a flag from ciphertext bits.
+	Gamma int
+}
+
+// Flag decodes a flag. In strict mode, with Gamma set, it is DecodeFlag. In
+// lenient mode, with Gamma set, it ignores bytes past the ciphertext field and
+// keeps rather than rejects set padding bits, which are hashed as they are.
+// Without Gamma, both modes are Flag.Decode.
+func (d Decoder) Flag(in []byte) (*Flag, error) {
+	gamma := d.Gamma
+	if gamma <= 0 {
+		gamma = -1
+	}
+	f := new(Flag)
+	if err := f.decodeInto(in, gamma, d.Lenient, r255.NewElement(), r255.NewScalar(), new(bitVector)); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// PublicKey decodes a public key. In strict mode it is PublicKey.Decode,
+// which also requires Gamma elements if Gamma is set. In lenient mode it
+// ignores bytes past the last whole element, or past the first Gamma
+// elements if Gamma is set.
+func (d Decoder) PublicKey(in []byte) (*PublicKey, error) {
+	gamma := d.Gamma
+	if gamma < 0 {
+		gamma = 0
+	}
+	pk := new(PublicKey)
+	if err := pk.decode(in, gamma, d.Lenient); err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
+
+// DetectionKey decodes a detection key. In strict mode it is
+// DetectionKey.Decode. In lenient mode an unrecognized tail after the
+// scalars is ignored; a threshold or watermark followed by an extension is
+// then not recognized either, and is ignored with it. Gamma is not used.
+func (d Decoder) DetectionKey(in []byte) (*DetectionKey, error) {
+	dk := new(DetectionKey)
+	if err := dk.decode(in, d.Lenient); err != nil {
+		return nil, err
+	}
+	return dk, nil
+}
@@ -0,0 +1,97 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecoderModes(t *testing.T) {
+	sk := NewSecretKey(20)
+	pk := sk.PublicKey()
+	dk := sk.ExtractDetectionKey(20)
+	flag := pk.GenerateFlag().Encode(nil)
+	extension := []byte{0xde, 0xad, 0xbe}
+
+	strict := Decoder{Gamma: 20}
+	lenient := Decoder{Gamma: 20, Lenient: true}
+
+	// Canonical encodings decode the same in both modes.
+	for _, d := range []Decoder{strict, lenient, {}, {Lenient: true}} {
+		f, err := d.Flag(flag)
+		if err != nil || !dk.Test(f) || !bytes.Equal(f.Encode(nil), flag) {
+			t.Errorf("%+v: canonical flag: %v", d, err)
+		}
+		pk2, err := d.PublicKey(pk.Encode(nil))
+		if err != nil || !bytes.Equal(pk2.Encode(nil), pk.Encode(nil)) {
+			t.Errorf("%+v: canonical public key: %v", d, err)
+		}
+		dk2, err := d.DetectionKey(dk.Encode(nil))
+		if err != nil || !bytes.Equal(dk2.Encode(nil), dk.Encode(nil)) {
+			t.Errorf("%+v: canonical detection key: %v", d, err)
+		}
+	}
+
+	// Trailing bytes are rejected by strict decoders and ignored by lenient
+	// ones.
+	extended := append(append([]byte{}, flag...), extension...)
+	if _, err := strict.Flag(extended); !errors.Is(err, ErrBitVectorTooLong) {
+		t.Errorf("strict flag with trailing bytes: got %v", err)
+	}
+	if f, err := lenient.Flag(extended); err != nil || !dk.Test(f) || !bytes.Equal(f.Encode(nil), flag) {
+		t.Errorf("lenient flag with trailing bytes: %v", err)
+	}
+
+	extended = append(pk.Encode(nil), extension...)
+	if _, err := strict.PublicKey(extended); !errors.Is(err, ErrLength) {
+		t.Errorf("strict public key with trailing bytes: got %v", err)
+	}
+	if pk2, err := lenient.PublicKey(extended); err != nil || !bytes.Equal(pk2.Encode(nil), pk.Encode(nil)) {
+		t.Errorf("lenient public key with trailing bytes: %v", err)
+	}
+	extended = append(pk.Encode(nil), pk.Encode(nil)[1:33]...)
+	if _, err := strict.PublicKey(extended); !errors.Is(err, ErrLength) {
+		t.Errorf("strict public key with an extra element: got %v", err)
+	}
+	if pk2, err := lenient.PublicKey(extended); err != nil || len(pk2.internal) != 20 {
+		t.Errorf("lenient public key with an extra element: %v", err)
+	}
+
+	extended = append(dk.Encode(nil), extension...)
+	if _, err := (Decoder{}).DetectionKey(extended); !errors.Is(err, ErrLength) {
+		t.Errorf("strict detection key with trailing bytes: got %v", err)
+	}
+	if dk2, err := lenient.DetectionKey(extended); err != nil || !bytes.Equal(dk2.Encode(nil), dk.Encode(nil)) {
+		t.Errorf("lenient detection key with trailing bytes: %v", err)
+	}
+	rated := sk.ExtractDetectionKeyRate(0.3).Encode(nil)
+	if dk2, err := lenient.DetectionKey(rated); err != nil || !bytes.Equal(dk2.Encode(nil), rated) {
+		t.Errorf("lenient detection key lost its threshold: %v", err)
+	}
+
+	// Set padding bits are kept by lenient decoders, and the flag round-trips.
+	padded := append([]byte{}, flag...)
+	padded[len(padded)-1] |= 0x80
+	if _, err := strict.Flag(padded); !errors.Is(err, ErrBitVectorTooLong) {
+		t.Errorf("strict flag with padding bits: got %v", err)
+	}
+	f, err := lenient.Flag(padded)
+	if err != nil || !bytes.Equal(f.Encode(nil), padded) || !bytes.Equal(f.Clone().Encode(nil), padded) {
+		t.Errorf("lenient flag with padding bits: %v", err)
+	}
+
+	// Leniency doesn't extend to values or short encodings.
+	bad := append([]byte{}, flag...)
+	for i := 1; i <= 32; i++ {
+		bad[i] = 0xff
+	}
+	if _, err := lenient.Flag(bad); !errors.Is(err, ErrNonCanonicalElement) {
+		t.Errorf("lenient flag with an invalid element: got %v", err)
+	}
+	if _, err := lenient.Flag(flag[:len(flag)-1]); !errors.Is(err, ErrLength) {
+		t.Errorf("lenient truncated flag: got %v", err)
+	}
+	if _, err := lenient.PublicKey(pk.Encode(nil)[:33+16]); !errors.Is(err, ErrLength) {
+		t.Errorf("lenient short public key: got %v", err)
+	}
+}
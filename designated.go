@@ -0,0 +1,122 @@
+package gophertags
+
+import (
+	"errors"
+	"io"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+// Designated-verifier flags can only be tested by one detection server,
+// which holds a VerifierSecretKey s in addition to the detection key. The
+// sender computes r·S for the server's public key S = s·B and binds the flag
+// to it, as GenerateBoundFlag binds to a message hash:
+//
+//	binding = "gophertags designated verifier" || r·S
+//
+// The server recomputes the binding as s·u. Anyone else holding the detection
+// key, such as a party harvesting flags from the wire, computes the wrong m,
+// and finds the flag matches no more often than a flag for someone else. So
+// does the recipient, unless it also holds s.
+
+const designatedLabel = "gophertags designated verifier"
+
+// VerifierKeySize is the length in bytes of encoded verifier keys.
+const VerifierKeySize = 32
+
+// VerifierSecretKey is the additional secret of a designated detection server.
+type VerifierSecretKey struct {
+	s *r255.Scalar
+}
+
+// VerifierPublicKey is published by a designated detection server for senders.
+type VerifierPublicKey struct {
+	S *r255.Element
+}
+
+// NewVerifierSecretKey generates a verifier secret key.
+func NewVerifierSecretKey() *VerifierSecretKey {
+	return newVerifierSecretKey(randReader)
+}
+
+func newVerifierSecretKey(entropy io.Reader) *VerifierSecretKey {
+	randBytes := make([]byte, 64)
+	if _, err := io.ReadFull(entropy, randBytes); err != nil {
+		panic(entropyError(err))
+	}
+	s := r255.NewScalar().FromUniformBytes(randBytes)
+	if s.Equal(zeroScalar) == 1 {
+		// Only a broken source of randomness gets here.
+		panic(entropyError(errors.New("zero verifier secret")))
+	}
+	return &VerifierSecretKey{s: s}
+}
+
+// PublicKey returns the verifier's public key.
+func (v *VerifierSecretKey) PublicKey() *VerifierPublicKey {
+	return &VerifierPublicKey{S: r255.NewElement().ScalarBaseMult(v.s)}
+}
+
+// Encode appends the 32-byte encoding of v to b.
+func (v *VerifierSecretKey) Encode(b []byte) []byte {
+	return v.s.Encode(b)
+}
+
+// Decode sets v to the decoded value of in.
+func (v *VerifierSecretKey) Decode(in []byte) error {
+	if len(in) != VerifierKeySize {
+		return &DecodeError{verifierKeyType, len(in), ErrLength}
+	}
+	s := r255.NewScalar()
+	if err := s.Decode(in); err != nil {
+		return &DecodeError{verifierKeyType, 0, ErrNonCanonicalScalar}
+	}
+	if s.Equal(zeroScalar) == 1 {
+		return &DecodeError{verifierKeyType, 0, ErrDegenerateVerifier}
+	}
+	v.s = s
+	return nil
+}
+
+// Encode appends the 32-byte encoding of v to b.
+func (v *VerifierPublicKey) Encode(b []byte) []byte {
+	return v.S.Encode(b)
+}
+
+// Decode sets v to the decoded value of in.
+func (v *VerifierPublicKey) Decode(in []byte) error {
+	if len(in) != VerifierKeySize {
+		return &DecodeError{verifierKeyType, len(in), ErrLength}
+	}
+	S := r255.NewElement()
+	if err := S.Decode(in); err != nil {
+		return &DecodeError{verifierKeyType, 0, ErrNonCanonicalElement}
+	}
+	if S.Equal(identityElement) == 1 {
+		return &DecodeError{verifierKeyType, 0, ErrDegenerateVerifier}
+	}
+	v.S = S
+	return nil
+}
+
+// GenerateDesignatedFlag is like GenerateFlag, but only a detection server
+// holding the secret key for verifier can test the flag, with TestDesignated.
+// It panics if verifier is the identity, for which r·S is the identity and
+// anyone holding the detection key could test the flag.
+func (pk *PublicKey) GenerateDesignatedFlag(verifier *VerifierPublicKey) *Flag {
+	if verifier.S.Equal(identityElement) == 1 {
+		panic("gophertags: designated verifier key is the identity")
+	}
+	return pk.generateFlag(randReader, nil, verifier.S)
+}
+
+// TestDesignated is like Test for flags from GenerateDesignatedFlag that
+// designate the verifier with secret key v.
+func (dk *DetectionKey) TestDesignated(f *Flag, v *VerifierSecretKey) bool {
+	return dk.test(f, designatedBinding(r255.NewElement().ScalarMult(v.s, f.u)))
+}
+
+// designatedBinding returns the binding for the shared point r·S = s·u.
+func designatedBinding(shared *r255.Element) []byte {
+	return shared.Encode([]byte(designatedLabel))
+}
@@ -0,0 +1,70 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+func TestDesignatedFlag(t *testing.T) {
+	sk := NewSecretKey(16)
+	dk := sk.ExtractDetectionKey(16)
+	server, otherServer := NewVerifierSecretKey(), NewVerifierSecretKey()
+
+	f := sk.PublicKey().GenerateDesignatedFlag(server.PublicKey())
+	if !dk.TestDesignated(f, server) {
+		t.Fatal("designated server doesn't detect the flag")
+	}
+	if dk.Test(f) {
+		t.Error("flag matches without the verifier secret")
+	}
+	if dk.TestDesignated(f, otherServer) {
+		t.Error("flag matches under another server's secret")
+	}
+	if dk.TestDesignated(sk.PublicKey().GenerateFlag(), server) {
+		t.Error("undesignated flag matches TestDesignated")
+	}
+
+	// Designated flags survive encoding, and verifier keys round trip.
+	decoded, err := DecodeFlag(f.Encode(nil), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v VerifierSecretKey
+	if err := v.Decode(server.Encode(nil)); err != nil || !dk.TestDesignated(decoded, &v) {
+		t.Errorf("decoded flag and verifier key: %v", err)
+	}
+	var pub VerifierPublicKey
+	if err := pub.Decode(server.PublicKey().Encode(nil)); err != nil || pub.S.Equal(server.PublicKey().S) != 1 {
+		t.Errorf("verifier public key round trip: %v", err)
+	}
+	if err := pub.Decode(make([]byte, 31)); !errors.Is(err, ErrLength) {
+		t.Errorf("short verifier key: got %v, want ErrLength", err)
+	}
+
+	// Zero keys would make every designated flag testable by anyone.
+	if err := pub.Decode(make([]byte, VerifierKeySize)); !errors.Is(err, ErrDegenerateVerifier) {
+		t.Errorf("identity verifier public key: got %v, want ErrDegenerateVerifier", err)
+	}
+	if err := v.Decode(make([]byte, VerifierKeySize)); !errors.Is(err, ErrDegenerateVerifier) {
+		t.Errorf("zero verifier secret key: got %v, want ErrDegenerateVerifier", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("GenerateDesignatedFlag accepted the identity")
+			}
+		}()
+		sk.PublicKey().GenerateDesignatedFlag(&VerifierPublicKey{S: r255.NewElement()})
+	}()
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrEntropyFailure) {
+				t.Errorf("newVerifierSecretKey from zeros panicked with %v", err)
+			}
+		}()
+		newVerifierSecretKey(bytes.NewReader(make([]byte, 64)))
+	}()
+}
@@ -0,0 +1,58 @@
+package gophertags
+
+import "context"
+
+// Detector tests serialized flags. It lets server code and middleware be
+// written once for single keys, key sets, and future schemes alike.
+//
+// The method is called Detect rather than Test because DetectionKey.Test
+// already takes a decoded *Flag.
+type Detector interface {
+	// Detect reports whether the encoded flag matches. It returns an error,
+	// and false, if the flag can't be decoded.
+	Detect(flag []byte) (bool, error)
+}
+
+// Detect decodes the flag and tests it against the detection key.
+func (dk *DetectionKey) Detect(flag []byte) (bool, error) {
+	f := new(Flag)
+	if err := f.Decode(flag); err != nil {
+		return false, err
+	}
+	return dk.Test(f), nil
+}
+
+var _ Detector = (*DetectionKey)(nil)
+
+// BatchTest tests every flag against the detection key, reporting the results
+// in order. It checks ctx between flags and returns ctx.Err() if it is done,
+// so scans over millions of flags can be cancelled or bounded by a deadline.
+//
+// BatchTest does not use randomized batch verification. That technique adds
+// random multiples of several group equations and checks the sum once. A flag
+// test has no group equation to add. It computes w = m·B + y·u and x_i·u, and
+// it compares a hash of those points with each ciphertext bit. Hash outputs
+// don't combine linearly, so each flag costs its own scalar multiplications.
+func (dk *DetectionKey) BatchTest(ctx context.Context, flags []*Flag) ([]bool, error) {
+	results := make([]bool, len(flags))
+	for i, f := range flags {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		results[i] = dk.Test(f)
+	}
+	return results, nil
+}
+
+// FilterIndices tests every flag against the detection key and returns the
+// positions of the matching ones, in order. Matches are usually sparse, so
+// this allocates only for them, where BatchTest allocates a result per flag.
+func (dk *DetectionKey) FilterIndices(flags []*Flag) []int {
+	var matches []int
+	for i, f := range flags {
+		if dk.Test(f) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,61 @@
+package gophertags
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDetectionKeyDetect(t *testing.T) {
+	sk := NewSecretKey(16)
+	var d Detector = sk.ExtractDetectionKey(16)
+
+	ok, err := d.Detect(sk.PublicKey().GenerateFlag().Encode(nil))
+	if err != nil || !ok {
+		t.Errorf("Detect(own flag) = %v, %v", ok, err)
+	}
+	if ok, err := d.Detect([]byte{SHA3.ID()}); err == nil || ok {
+		t.Errorf("Detect(malformed) = %v, %v", ok, err)
+	}
+}
+
+func TestBatchTest(t *testing.T) {
+	alice, bob := NewSecretKey(16), NewSecretKey(16)
+	flags := []*Flag{alice.PublicKey().GenerateFlag(), bob.PublicKey().GenerateFlag(), alice.PublicKey().GenerateFlag()}
+	dk := alice.ExtractDetectionKey(16)
+
+	results, err := dk.BatchTest(context.Background(), flags)
+	if err != nil || len(results) != 3 || !results[0] || results[1] || !results[2] {
+		t.Errorf("BatchTest = %v, %v", results, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dk.BatchTest(ctx, flags); err != context.Canceled {
+		t.Errorf("cancelled BatchTest returned %v", err)
+	}
+}
+
+func TestFilterIndices(t *testing.T) {
+	alice, bob := NewSecretKey(16), NewSecretKey(16)
+	flags := []*Flag{bob.PublicKey().GenerateFlag(), alice.PublicKey().GenerateFlag(), bob.PublicKey().GenerateFlag(), alice.PublicKey().GenerateFlag()}
+	dk := alice.ExtractDetectionKey(16)
+	if got := dk.FilterIndices(flags); len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("FilterIndices = %v, want [1 3]", got)
+	}
+	if got := dk.FilterIndices(flags[:1]); got != nil {
+		t.Errorf("FilterIndices without matches = %v", got)
+	}
+}
+
+func BenchmarkBatchTest(b *testing.B) {
+	dk := NewSecretKey(24).ExtractDetectionKey(8)
+	other := NewSecretKey(24).PublicKey()
+	flags := make([]*Flag, 64)
+	for i := range flags {
+		flags[i] = other.GenerateFlag()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dk.BatchTest(context.Background(), flags)
+	}
+}
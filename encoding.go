@@ -0,0 +1,546 @@
+package gophertags
+
+import (
+	"encoding/binary"
+	"io"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+// Every encoding starts with the one-byte ID of its HashScheme, so artifacts of
+// different instantiations can't be mixed up. The rest is:
+//
+//	Flag:         id || u (32 bytes) || y (32 bytes) || ciphertexts (ceil(gamma/8) bytes)
+//	PublicKey:    id || H_1 || ... || H_gamma, 32 bytes each [|| path]
+//	DetectionKey: id || x_1 || ... || x_n, 32 bytes each [|| threshold (8 bytes)] [|| watermark (16 bytes)] [|| path]
+//	SecretKey:    id || x_1 || ... || x_gamma, 32 bytes each [|| path]
+//
+// Ciphertext bits are packed little-endian: bit i is bit (i mod 8) of byte i/8,
+// with the bits past gamma in the last byte zero. These same ceil(gamma/8)
+// bytes, whatever the values of the bits, are what the scalar m hashes.
+//
+// The layout is modeled on the Rust crate `fuzzytags` but isn't compatible
+// with it: the scheme ID alone keeps encodings from being byte-identical, and
+// Rust bit vectors serialize bit 0 as the most significant bit of a byte, the
+// opposite of this packing. Neither the encodings nor the hashes have been
+// checked against that crate.
+// Only detection keys from ExtractDetectionKeyRate carry a threshold, a
+// big-endian uint64, and only those from WatermarkDetectionKey a watermark.
+// Neither tail is a multiple of 32 bytes long, so the length of an encoding
+// says which it has. Only keys from NewSecretKeyFromPath, and the keys
+// extracted from them, carry a derivation path, in a tail of odd length
+// described with DerivationPath. The *Size functions give the lengths of keys
+// without tails.
+//
+// Application contexts are not encoded. Decoding into a key keeps the
+// receiver's context, so a context-bound zero value can be decoded into.
+
+const (
+	schemeIDSize = 1
+	elementSize  = 32
+	scalarSize   = 32
+)
+
+// FlagSize returns the length in bytes of an encoded flag for a public key with the given gamma.
+func FlagSize(gamma int) int {
+	return schemeIDSize + elementSize + scalarSize + (gamma+7)/8
+}
+
+// PublicKeySize returns the length in bytes of an encoded public key with the given gamma.
+func PublicKeySize(gamma int) int {
+	return schemeIDSize + gamma*elementSize
+}
+
+// DetectionKeySize returns the length in bytes of an encoded detection key of precision n.
+func DetectionKeySize(n int) int {
+	return schemeIDSize + n*scalarSize
+}
+
+// SecretKeySize returns the length in bytes of an encoded secret key with the given gamma.
+func SecretKeySize(gamma int) int {
+	return schemeIDSize + gamma*scalarSize
+}
+
+const (
+	flagType         = "flag"
+	publicKeyType    = "public key"
+	detectionKeyType = "detection key"
+	secretKeyType    = "secret key"
+	verifierKeyType  = "verifier key"
+)
+
+// decodeScheme splits the hash scheme ID off the front of an encoding.
+func decodeScheme(typ string, in []byte) (HashScheme, []byte, error) {
+	if len(in) == 0 {
+		return nil, nil, &DecodeError{typ, 0, ErrLength}
+	}
+	h, ok := LookupHashScheme(in[0])
+	if !ok {
+		return nil, nil, &DecodeError{typ, 0, ErrUnknownHashScheme}
+	}
+	return h, in[schemeIDSize:], nil
+}
+
+// Encode appends the wire encoding of f to b.
+func (f *Flag) Encode(b []byte) []byte {
+	b = append(b, schemeOf(f.hash).ID())
+	b = append(b, f.encodedU()...)
+	b = f.y.Encode(b)
+	if f.borrowed != nil {
+		return append(b, f.borrowed...)
+	}
+	return appendBits(b, f.ciphertexts, f.gamma)
+}
+
+// Decode sets f to the decoded value of in. The flag's gamma is taken to be
+// the number of bits in the ciphertext field; use DecodeFlag when the expected
+// gamma is known. If in is not a valid encoding, Decode returns a *DecodeError
+// and the receiver is unchanged.
+func (f *Flag) Decode(in []byte) error {
+	return f.decode(in, -1)
+}
+
+// DecodeFlag decodes a flag for public keys with the given gamma. Unlike
+// Flag.Decode, it rejects ciphertext fields that are longer than gamma bits or
+// have padding bits set, so every flag has exactly one accepted encoding.
+func DecodeFlag(in []byte, gamma int) (*Flag, error) {
+	f := new(Flag)
+	if err := f.decode(in, gamma); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// DecodeFlagBorrowed is like DecodeFlag, or Flag.Decode if gamma is negative,
+// but the returned flag keeps a reference to the ciphertext bits in in
+// instead of copying them, for ingestion paths where the copy matters.
+//
+// The flag aliases in: the caller must not modify in for as long as the flag
+// is used. Encode and Clone return data that doesn't alias in, so a flag that
+// needs to outlive the buffer should be cloned.
+func DecodeFlagBorrowed(in []byte, gamma int) (*Flag, error) {
+	f := new(Flag)
+	if err := f.decodeInto(in, gamma, false, r255.NewElement(), r255.NewScalar(), nil); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// DecodeFlags decodes a batch of flags, as DecodeFlag does for each of them,
+// or as Flag.Decode does if gamma is negative. The flags and their elements
+// are allocated together, which saves most of the per-flag overhead when
+// ingesting many flags at once.
+//
+// If every flag decodes, errs is nil. Otherwise errs has an entry for every
+// input, and the flags whose entry is non-nil are nil.
+func DecodeFlags(in [][]byte, gamma int) (flags []*Flag, errs []error) {
+	flags = make([]*Flag, len(in))
+	storage := make([]Flag, len(in))
+	elements := make([]r255.Element, len(in))
+	scalars := make([]r255.Scalar, len(in))
+	bitVecs := make([]bitVector, len(in))
+	for i, b := range in {
+		f := &storage[i]
+		if err := f.decodeInto(b, gamma, false, &elements[i], &scalars[i], &bitVecs[i]); err != nil {
+			if errs == nil {
+				errs = make([]error, len(in))
+			}
+			errs[i] = err
+			continue
+		}
+		flags[i] = f
+	}
+	return flags, errs
+}
+
+// decode implements Decode and DecodeFlag. A negative gamma accepts any length.
+func (f *Flag) decode(in []byte, gamma int) error {
+	return f.decodeInto(in, gamma, false, r255.NewElement(), r255.NewScalar(), new(bitVector))
+}
+
+var (
+	identityElement = r255.NewElement()
+	zeroScalar      = r255.NewScalar()
+)
+
+// decodeInto is decode with caller-provided storage for u, y and the
+// ciphertexts. A nil bitVec makes the flag borrow the ciphertext bytes of in.
+// If lenient is set and gamma is known, bytes past the ciphertext field are
+// ignored and padding bits are kept rather than rejected.
+func (f *Flag) decodeInto(in []byte, gamma int, lenient bool, u *r255.Element, y *r255.Scalar, bitVec *bitVector) error {
+	h, body, err := decodeScheme(flagType, in)
+	if err != nil {
+		return err
+	}
+	if len(body) < elementSize+scalarSize {
+		return &DecodeError{flagType, len(in), ErrLength}
+	}
+	return f.decodeParts(h, body[:elementSize], body[elementSize:elementSize+scalarSize], body[elementSize+scalarSize:], gamma, lenient, u, y, bitVec)
+}
+
+// decodeParts is decodeInto for a flag already split into its fields.
+func (f *Flag) decodeParts(h HashScheme, uBytes, yBytes, bitBytes []byte, gamma int, lenient bool, u *r255.Element, y *r255.Scalar, bitVec *bitVector) error {
+	if err := u.Decode(uBytes); err != nil {
+		return &DecodeError{flagType, schemeIDSize, ErrNonCanonicalElement}
+	}
+	if err := y.Decode(yBytes); err != nil {
+		return &DecodeError{flagType, schemeIDSize + elementSize, ErrNonCanonicalScalar}
+	}
+	if u.Equal(identityElement) == 1 || y.Equal(zeroScalar) == 1 {
+		return &DecodeError{flagType, schemeIDSize, ErrDegenerateFlag}
+	}
+
+	bitsOffset := schemeIDSize + elementSize + scalarSize
+	if gamma < 0 {
+		gamma = 8 * len(bitBytes)
+	} else {
+		if len(bitBytes) < (gamma+7)/8 {
+			return &DecodeError{flagType, bitsOffset + len(bitBytes), errGammaLength}
+		}
+		if lenient {
+			bitBytes = bitBytes[:(gamma+7)/8]
+		} else if len(bitBytes) > (gamma+7)/8 || (gamma%8 != 0 && bitBytes[gamma/8]>>(gamma%8) != 0) {
+			return &DecodeError{flagType, bitsOffset + gamma/8, ErrBitVectorTooLong}
+		}
+	}
+
+	f.u, f.y = u, y
+	if bitVec == nil {
+		f.ciphertexts, f.borrowed = nil, bitBytes[:len(bitBytes):len(bitBytes)]
+	} else {
+		f.ciphertexts, f.borrowed = setBits(bitVec, bitBytes), nil
+	}
+	f.gamma = gamma
+	f.hash = h
+	f.cache(params{hash: h}, uBytes)
+	return nil
+}
+
+// DecodeFlagColumns decodes a batch of flags stored column by column, as in a
+// columnar archive: schemes holds each flag's hash scheme ID, us and ys its
+// 32-byte u and y, and ciphertexts its ceil(gamma/8) ciphertext bytes, each
+// column in flag order. It checks each flag as DecodeFlag does and returns
+// results as DecodeFlags does. Flags missing from a column that is too short
+// fail with ErrLength.
+//
+// Like those from DecodeFlagBorrowed, the flags alias ciphertexts, and the
+// caller must not modify it for as long as they are used.
+func DecodeFlagColumns(schemes, us, ys, ciphertexts []byte, gamma int) (flags []*Flag, errs []error) {
+	n := len(schemes)
+	bitSize := (gamma + 7) / 8
+	flags = make([]*Flag, n)
+	storage := make([]Flag, n)
+	elements := make([]r255.Element, n)
+	scalars := make([]r255.Scalar, n)
+	fail := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, n)
+		}
+		errs[i] = err
+	}
+	for i, id := range schemes {
+		if gamma < 0 || len(us) < (i+1)*elementSize || len(ys) < (i+1)*scalarSize || len(ciphertexts) < (i+1)*bitSize {
+			fail(i, &DecodeError{flagType, 0, ErrLength})
+			continue
+		}
+		h, ok := LookupHashScheme(id)
+		if !ok {
+			fail(i, &DecodeError{flagType, 0, ErrUnknownHashScheme})
+			continue
+		}
+		bits := ciphertexts[i*bitSize : (i+1)*bitSize]
+		if err := storage[i].decodeParts(h, us[i*elementSize:(i+1)*elementSize], ys[i*scalarSize:(i+1)*scalarSize], bits, gamma, false, &elements[i], &scalars[i], nil); err != nil {
+			fail(i, err)
+			continue
+		}
+		flags[i] = &storage[i]
+	}
+	return flags, errs
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (f *Flag) MarshalBinary() ([]byte, error) {
+	return f.Encode(nil), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (f *Flag) UnmarshalBinary(data []byte) error {
+	return f.Decode(data)
+}
+
+// Encode appends the wire encoding of pk to b.
+func (pk *PublicKey) Encode(b []byte) []byte {
+	b = append(b, pk.scheme().ID())
+	for _, H := range pk.internal {
+		b = H.Encode(b)
+	}
+	return appendPath(b, pk.path)
+}
+
+// Decode sets pk to the decoded value of in. If in is not a valid encoding,
+// Decode returns a *DecodeError and the receiver is unchanged.
+func (pk *PublicKey) Decode(in []byte) error {
+	return pk.decode(in, 0, false)
+}
+
+// decode implements Decode and Decoder.PublicKey. A zero gamma accepts any
+// number of elements. If lenient is set, bytes past the last whole element,
+// or past gamma elements if gamma is known, are ignored, unless they are a
+// well-formed path.
+func (pk *PublicKey) decode(in []byte, gamma int, lenient bool) error {
+	h, body, err := decodeScheme(publicKeyType, in)
+	if err != nil {
+		return err
+	}
+	rest, path, err := splitPath(publicKeyType, body)
+	if err == nil {
+		body = rest
+	} else if !lenient {
+		return err
+	}
+	if lenient {
+		if gamma > 0 && len(body) > gamma*elementSize {
+			body = body[:gamma*elementSize]
+		}
+		body = body[:len(body)-len(body)%elementSize]
+	}
+	if len(body) == 0 || len(body)%elementSize != 0 {
+		return &DecodeError{publicKeyType, len(in), ErrLength}
+	}
+	if gamma > 0 && len(body) != gamma*elementSize {
+		return &DecodeError{publicKeyType, len(in), errGammaLength}
+	}
+
+	elements := make([]*r255.Element, len(body)/elementSize)
+	for i := range elements {
+		elements[i] = r255.NewElement()
+		if err := elements[i].Decode(body[i*elementSize : (i+1)*elementSize]); err != nil {
+			return &DecodeError{publicKeyType, schemeIDSize + i*elementSize, ErrNonCanonicalElement}
+		}
+	}
+	pk.internal = elements
+	pk.hash = h
+	pk.path = path
+	return nil
+}
+
+// DecodePublicKey returns a new public key decoded from in.
+func DecodePublicKey(in []byte) (*PublicKey, error) {
+	pk := new(PublicKey)
+	if err := pk.Decode(in); err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (pk *PublicKey) MarshalBinary() ([]byte, error) {
+	return pk.Encode(nil), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (pk *PublicKey) UnmarshalBinary(data []byte) error {
+	return pk.Decode(data)
+}
+
+// Encode appends the wire encoding of dk to b.
+func (dk *DetectionKey) Encode(b []byte) []byte {
+	b = append(b, dk.scheme().ID())
+	for _, x := range dk.internal {
+		b = x.Encode(b)
+	}
+	if dk.threshold != 0 {
+		var t [rateSize]byte
+		binary.BigEndian.PutUint64(t[:], dk.threshold)
+		b = append(b, t[:]...)
+	}
+	b = append(b, dk.watermark...)
+	return appendPath(b, dk.path)
+}
+
+// Decode sets dk to the decoded value of in. If in is not a valid encoding,
+// Decode returns a *DecodeError and the receiver is unchanged.
+func (dk *DetectionKey) Decode(in []byte) error {
+	return dk.decode(in, false)
+}
+
+// decode implements Decode and Decoder.DetectionKey. If lenient is set, a tail
+// that is neither a threshold nor a watermark, nor both, nor either of those
+// followed by a path, is ignored whole.
+func (dk *DetectionKey) decode(in []byte, lenient bool) error {
+	h, body, err := decodeScheme(detectionKeyType, in)
+	if err != nil {
+		return err
+	}
+	rest, path, err := splitPath(detectionKeyType, body)
+	if err == nil {
+		body = rest
+	} else if !lenient {
+		return err
+	}
+	if tail := len(body) % scalarSize; lenient && tail != 0 && tail != rateSize && tail != watermarkSize && tail != watermarkSize+rateSize {
+		body = body[:len(body)-tail]
+	}
+	var watermark []byte
+	if tail := len(body) % scalarSize; tail == watermarkSize || tail == watermarkSize+rateSize {
+		watermark = append([]byte(nil), body[len(body)-watermarkSize:]...)
+		body = body[:len(body)-watermarkSize]
+	}
+	var threshold uint64
+	if len(body)%scalarSize == rateSize && len(body) > rateSize {
+		threshold = binary.BigEndian.Uint64(body[len(body)-rateSize:])
+		if threshold == 0 {
+			return &DecodeError{detectionKeyType, schemeIDSize + len(body) - rateSize, ErrLength}
+		}
+		body = body[:len(body)-rateSize]
+	} else if len(body)%scalarSize != 0 {
+		return &DecodeError{detectionKeyType, len(in), ErrLength}
+	}
+
+	scalars := make([]*r255.Scalar, len(body)/scalarSize)
+	for i := range scalars {
+		scalars[i] = r255.NewScalar()
+		if err := scalars[i].Decode(body[i*scalarSize : (i+1)*scalarSize]); err != nil {
+			return &DecodeError{detectionKeyType, schemeIDSize + i*scalarSize, ErrNonCanonicalScalar}
+		}
+	}
+	dk.internal = scalars
+	dk.hash = h
+	dk.threshold = threshold
+	dk.watermark = watermark
+	dk.path = path
+	return nil
+}
+
+// DecodeDetectionKey returns a new detection key decoded from in.
+func DecodeDetectionKey(in []byte) (*DetectionKey, error) {
+	dk := new(DetectionKey)
+	if err := dk.Decode(in); err != nil {
+		return nil, err
+	}
+	return dk, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (dk *DetectionKey) MarshalBinary() ([]byte, error) {
+	return dk.Encode(nil), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (dk *DetectionKey) UnmarshalBinary(data []byte) error {
+	return dk.Decode(data)
+}
+
+// Encode appends the wire encoding of sk to b. The encoding contains every
+// secret scalar; the public key of a decoded key is recomputed when first needed.
+func (sk *SecretKey) Encode(b []byte) []byte {
+	b = append(b, sk.scheme().ID())
+	for _, x := range sk.sk {
+		b = x.Encode(b)
+	}
+	return appendPath(b, sk.path)
+}
+
+// Decode sets sk to the decoded value of in. If in is not a valid encoding,
+// Decode returns a *DecodeError and the receiver is unchanged.
+func (sk *SecretKey) Decode(in []byte) error {
+	h, body, err := decodeScheme(secretKeyType, in)
+	if err != nil {
+		return err
+	}
+	body, path, err := splitPath(secretKeyType, body)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 || len(body)%scalarSize != 0 {
+		return &DecodeError{secretKeyType, len(in), ErrLength}
+	}
+
+	scalars := make([]*r255.Scalar, len(body)/scalarSize)
+	for i := range scalars {
+		scalars[i] = r255.NewScalar()
+		if err := scalars[i].Decode(body[i*scalarSize : (i+1)*scalarSize]); err != nil {
+			return &DecodeError{secretKeyType, schemeIDSize + i*scalarSize, ErrNonCanonicalScalar}
+		}
+	}
+	sk.pkMu.Lock()
+	sk.sk, sk.pk = scalars, nil
+	sk.pkMu.Unlock()
+	sk.hash = h
+	sk.path = path
+	return nil
+}
+
+// DecodeSecretKey returns a new secret key decoded from in.
+func DecodeSecretKey(in []byte) (*SecretKey, error) {
+	sk := new(SecretKey)
+	if err := sk.Decode(in); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// ExtractDetectionKeyFrom reads an encoded secret key from r and returns its
+// detection key of precision n, like SecretKey.ExtractDetectionKey. Only the
+// scheme ID and the first n scalars are read, and no public key is computed,
+// so keys with a large gamma never have to be held in memory. Because the
+// rest of the encoding is not read, its length is not checked, and the
+// returned key has no path even if the encoding ends with one.
+func ExtractDetectionKeyFrom(r io.Reader, n int) (*DetectionKey, error) {
+	if n < 0 {
+		return nil, errNegativePrecision
+	}
+	var buf [scalarSize]byte
+	if _, err := io.ReadFull(r, buf[:schemeIDSize]); err != nil {
+		return nil, streamError(err, 0)
+	}
+	h, _, err := decodeScheme(secretKeyType, buf[:schemeIDSize])
+	if err != nil {
+		return nil, err
+	}
+
+	scalars := make([]*r255.Scalar, n)
+	for i := range scalars {
+		offset := schemeIDSize + i*scalarSize
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, streamError(err, offset)
+		}
+		scalars[i] = r255.NewScalar()
+		if err := scalars[i].Decode(buf[:]); err != nil {
+			return nil, &DecodeError{secretKeyType, offset, ErrNonCanonicalScalar}
+		}
+	}
+	return &DetectionKey{internal: scalars, params: params{hash: h}}, nil
+}
+
+var errNegativePrecision = newKindError("gophertags: negative detection key precision", ErrPrecisionOutOfRange)
+
+// streamError reports a short read as a *DecodeError and passes other read errors through.
+func streamError(err error, offset int) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &DecodeError{secretKeyType, offset, ErrLength}
+	}
+	return err
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (sk *SecretKey) MarshalBinary() ([]byte, error) {
+	return sk.Encode(nil), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (sk *SecretKey) UnmarshalBinary(data []byte) error {
+	return sk.Decode(data)
+}
+
+// appendBits appends the first gamma bits of bitVec to b, packed little-endian.
+func appendBits(b []byte, bitVec *bitVector, gamma int) []byte {
+	for i := 0; i < (gamma+7)/8; i++ {
+		var c byte
+		for j := 0; j < 8; j++ {
+			c |= byte(bitVec.Bit(8*i+j)) << j
+		}
+		b = append(b, c)
+	}
+	return b
+}
@@ -0,0 +1,334 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	r255 "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/sha3"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	sk := NewSecretKey(24)
+	pk := sk.PublicKey()
+	dk := sk.ExtractDetectionKey(5)
+	flag := pk.GenerateFlag()
+
+	pk2 := new(PublicKey)
+	if err := pk2.Decode(pk.Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+	dk2 := new(DetectionKey)
+	if err := dk2.Decode(dk.Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+	flag2 := new(Flag)
+	if err := flag2.Decode(flag.Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	sk2 := new(SecretKey)
+	if err := sk2.Decode(sk.Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sk.Encode(nil), sk2.Encode(nil)) || !bytes.Equal(pk.Encode(nil), sk2.PublicKey().Encode(nil)) {
+		t.Error("secret key changed across a round trip")
+	}
+	if !bytes.Equal(pk.Encode(nil), pk2.Encode(nil)) {
+		t.Error("public key changed across a round trip")
+	}
+	if !bytes.Equal(dk.Encode(nil), dk2.Encode(nil)) {
+		t.Error("detection key changed across a round trip")
+	}
+	if !bytes.Equal(flag.Encode(nil), flag2.Encode(nil)) {
+		t.Error("flag changed across a round trip")
+	}
+	if !dk2.Test(pk2.GenerateFlag()) || !dk.Test(flag2) {
+		t.Error("decoded keys and flags don't detect")
+	}
+}
+
+func TestDecodeRejectsMalformed(t *testing.T) {
+	flag := NewSecretKey(8).PublicKey().GenerateFlag().Encode(nil)
+	if err := new(Flag).Decode(flag[:64]); err == nil {
+		t.Error("accepted a truncated flag")
+	}
+
+	bad := append([]byte{}, flag...)
+	for i := 1; i <= 32; i++ {
+		bad[i] = 0xff
+	}
+	if err := new(Flag).Decode(bad); err == nil {
+		t.Error("accepted a flag with an invalid element")
+	}
+
+	if err := new(PublicKey).Decode(append([]byte{SHA3.ID()}, make([]byte, 33)...)); err == nil {
+		t.Error("accepted a public key of the wrong length")
+	}
+	if err := new(DetectionKey).Decode(append([]byte{SHA3.ID()}, bytes.Repeat([]byte{0xff}, 32)...)); err == nil {
+		t.Error("accepted a detection key with a non-canonical scalar")
+	}
+}
+
+func TestEncodedSizes(t *testing.T) {
+	for _, gamma := range []int{1, 8, 20, 24, 64, 65} {
+		sk := NewSecretKey(gamma)
+		pk := sk.PublicKey()
+
+		if got := len(pk.GenerateFlag().Encode(nil)); got != FlagSize(gamma) {
+			t.Errorf("gamma %d: flag is %d bytes, FlagSize says %d", gamma, got, FlagSize(gamma))
+		}
+		if got := len(pk.Encode(nil)); got != PublicKeySize(gamma) {
+			t.Errorf("gamma %d: public key is %d bytes, PublicKeySize says %d", gamma, got, PublicKeySize(gamma))
+		}
+		if got := len(sk.Encode(nil)); got != SecretKeySize(gamma) {
+			t.Errorf("gamma %d: secret key is %d bytes, SecretKeySize says %d", gamma, got, SecretKeySize(gamma))
+		}
+		for _, n := range []int{0, gamma} {
+			if got := len(sk.ExtractDetectionKey(n).Encode(nil)); got != DetectionKeySize(n) {
+				t.Errorf("n %d: detection key is %d bytes, DetectionKeySize says %d", n, got, DetectionKeySize(n))
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	gamma := 20
+	flag := NewSecretKey(gamma).PublicKey().GenerateFlag().Encode(nil)
+	if _, err := DecodeFlag(flag, gamma); err != nil {
+		t.Fatalf("strict decoding rejected a valid flag: %v", err)
+	}
+
+	modified := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte{}, flag...))
+	}
+	cases := []struct {
+		name string
+		in   []byte
+		want error
+	}{
+		{"empty", nil, ErrLength},
+		{"unknown scheme", modified(func(b []byte) []byte { b[0] = 0; return b }), ErrUnknownHashScheme},
+		{"truncated", flag[:len(flag)-1], ErrLength},
+		{"non-canonical u", modified(func(b []byte) []byte { b[1] = 0x01; b[32] = 0x80; return b }), ErrNonCanonicalElement},
+		{"non-canonical y", modified(func(b []byte) []byte { copy(b[33:65], bytes.Repeat([]byte{0xff}, 32)); return b }), ErrNonCanonicalScalar},
+		{"identity u", modified(func(b []byte) []byte { copy(b[1:33], make([]byte, 32)); return b }), ErrDegenerateFlag},
+		{"padding bit set", modified(func(b []byte) []byte { b[len(b)-1] |= 0x80; return b }), ErrBitVectorTooLong},
+		{"extra byte", append(append([]byte{}, flag...), 0), ErrBitVectorTooLong},
+	}
+	for _, c := range cases {
+		_, err := DecodeFlag(c.in, gamma)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want a *DecodeError wrapping %v", c.name, err, c.want)
+		}
+	}
+
+	// Lenient decoding still accepts the extra byte and the padding bit.
+	if err := new(Flag).Decode(cases[len(cases)-1].in); err != nil {
+		t.Errorf("Flag.Decode rejected a longer ciphertext field: %v", err)
+	}
+}
+
+func TestExtractDetectionKeyFrom(t *testing.T) {
+	sk := NewSecretKeyWithHash(24, BLAKE2b)
+	encoded := sk.Encode(nil)
+
+	dk, err := ExtractDetectionKeyFrom(bytes.NewReader(encoded), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dk.Encode(nil), sk.ExtractDetectionKey(5).Encode(nil)) {
+		t.Error("streamed detection key differs from ExtractDetectionKey")
+	}
+
+	// Only the prefix is needed.
+	if _, err := ExtractDetectionKeyFrom(bytes.NewReader(encoded[:DetectionKeySize(5)]), 5); err != nil {
+		t.Errorf("prefix of the encoding: %v", err)
+	}
+	var decErr *DecodeError
+	_, err = ExtractDetectionKeyFrom(bytes.NewReader(encoded[:DetectionKeySize(5)-1]), 5)
+	if !errors.As(err, &decErr) || !errors.Is(err, ErrLength) || decErr.Offset != DetectionKeySize(4) {
+		t.Errorf("truncated encoding: got %v", err)
+	}
+	if _, err := ExtractDetectionKeyFrom(bytes.NewReader([]byte{0}), 0); !errors.Is(err, ErrUnknownHashScheme) {
+		t.Errorf("unknown scheme: got %v", err)
+	}
+}
+
+func TestDecodeFlags(t *testing.T) {
+	pk := NewSecretKey(20).PublicKey()
+	var in [][]byte
+	for i := 0; i < 4; i++ {
+		in = append(in, pk.GenerateFlag().Encode(nil))
+	}
+
+	flags, errs := DecodeFlags(in, 20)
+	if errs != nil {
+		t.Fatalf("valid batch: %v", errs)
+	}
+	for i, f := range flags {
+		if !bytes.Equal(f.Encode(nil), in[i]) {
+			t.Errorf("flag %d doesn't round-trip", i)
+		}
+	}
+
+	in[2] = in[2][:10]
+	flags, errs = DecodeFlags(in, 20)
+	if len(errs) != len(in) || !errors.Is(errs[2], ErrLength) || flags[2] != nil {
+		t.Fatalf("batch with a truncated flag: %v", errs)
+	}
+	for _, i := range []int{0, 1, 3} {
+		if errs[i] != nil || flags[i] == nil {
+			t.Errorf("flag %d: %v", i, errs[i])
+		}
+	}
+}
+
+func TestDecodeFlagColumns(t *testing.T) {
+	sk := NewSecretKey(20)
+	var in [][]byte
+	var schemes, us, ys, ciphertexts []byte
+	for i := 0; i < 4; i++ {
+		enc := sk.PublicKey().GenerateFlag().Encode(nil)
+		in = append(in, enc)
+		schemes = append(schemes, enc[0])
+		us = append(us, enc[1:33]...)
+		ys = append(ys, enc[33:65]...)
+		ciphertexts = append(ciphertexts, enc[65:]...)
+	}
+
+	flags, errs := DecodeFlagColumns(schemes, us, ys, ciphertexts, 20)
+	if errs != nil {
+		t.Fatalf("valid columns: %v", errs)
+	}
+	for i, f := range flags {
+		if !bytes.Equal(f.Encode(nil), in[i]) || !sk.ExtractDetectionKey(20).Test(f) {
+			t.Errorf("flag %d doesn't round-trip", i)
+		}
+	}
+
+	ys[63] = 0xff // non-canonical y for flag 1
+	schemes[2] = 0xee
+	ciphertexts[len(ciphertexts)-1] |= 0x80 // padding bit of flag 3
+	flags, errs = DecodeFlagColumns(append(schemes, SHA3.ID()), us, ys, ciphertexts, 20)
+	if len(errs) != 5 || errs[0] != nil || flags[0] == nil {
+		t.Fatalf("damaged columns: %v", errs)
+	}
+	for i, want := range []error{nil, ErrNonCanonicalScalar, ErrUnknownHashScheme, ErrBitVectorTooLong, ErrLength} {
+		if want != nil && (!errors.Is(errs[i], want) || flags[i] != nil) {
+			t.Errorf("flag %d: got %v, want %v", i, errs[i], want)
+		}
+	}
+}
+
+func benchmarkFlags(b *testing.B) [][]byte {
+	pk := NewSecretKey(24).PublicKey()
+	in := make([][]byte, 256)
+	for i := range in {
+		in[i] = pk.GenerateFlag().Encode(nil)
+	}
+	return in
+}
+
+func BenchmarkDecodeFlag(b *testing.B) {
+	in := benchmarkFlags(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, flag := range in {
+			DecodeFlag(flag, 24)
+		}
+	}
+}
+
+func BenchmarkDecodeFlags(b *testing.B) {
+	in := benchmarkFlags(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DecodeFlags(in, 24)
+	}
+}
+
+func TestDecodeFlagBorrowed(t *testing.T) {
+	sk := NewSecretKey(20)
+	dk := sk.ExtractDetectionKey(20)
+	in := sk.PublicKey().GenerateFlag().Encode(nil)
+
+	f, err := DecodeFlagBorrowed(in, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dk.Test(f) || !bytes.Equal(f.Encode(nil), in) || f.Digest() != f.Clone().Digest() {
+		t.Error("borrowed flag differs from the encoded one")
+	}
+
+	// The flag aliases its input; a clone doesn't.
+	clone := f.Clone()
+	in[len(in)-1] ^= 0x01
+	if dk.Test(f) {
+		t.Error("borrowed flag doesn't alias its input")
+	}
+	if !dk.Test(clone) {
+		t.Error("clone of a borrowed flag aliases the input")
+	}
+	in[len(in)-1] ^= 0x01
+
+	// Padding bits are rejected as in DecodeFlag.
+	in[len(in)-1] |= 0x80
+	if _, err := DecodeFlagBorrowed(in, 20); !errors.Is(err, ErrBitVectorTooLong) {
+		t.Errorf("padding bits set: got %v", err)
+	}
+}
+
+// TestEncodingReference checks flags against a reimplementation of detection
+// that works only from the encodings as documented in encoding.go, at values
+// of gamma on both sides of byte boundaries: the ciphertext field is exactly
+// ceil(gamma/8) bytes with zero padding, m hashes exactly those bytes, and bit
+// i is the complement of the low bit of SHA3-256(u || x_i·u || w). It checks
+// the code against its own specification, not against another implementation.
+func TestEncodingReference(t *testing.T) {
+	withDeterministicRand(t, t.Name())
+	for _, gamma := range []int{1, 3, 7, 8, 9, 12, 15, 17, 20, 23, 31} {
+		sk := NewSecretKey(gamma)
+		secret := sk.Encode(nil)[schemeIDSize:]
+		for i := 0; i < 8; i++ {
+			enc := sk.PublicKey().GenerateFlag().Encode(nil)
+			if len(enc) != schemeIDSize+64+(gamma+7)/8 {
+				t.Fatalf("gamma %d: flag is %d bytes", gamma, len(enc))
+			}
+			uEnc, yEnc, ciphertexts := enc[1:33], enc[33:65], enc[65:]
+			if pad := gamma % 8; pad != 0 && ciphertexts[len(ciphertexts)-1]>>pad != 0 {
+				t.Errorf("gamma %d: padding bits set in %x", gamma, ciphertexts)
+			}
+
+			u, y := r255.NewElement(), r255.NewScalar()
+			if err := u.Decode(uEnc); err != nil {
+				t.Fatal(err)
+			}
+			if err := y.Decode(yEnc); err != nil {
+				t.Fatal(err)
+			}
+			digest := sha3.Sum512(append(append([]byte(nil), ciphertexts...), uEnc...))
+			m := r255.NewScalar().FromUniformBytes(digest[:])
+			w := r255.NewElement().MultiScalarMult([]*r255.Scalar{m, y}, []*r255.Element{r255.NewElement().Base(), u})
+			for j := 0; j < gamma; j++ {
+				x := r255.NewScalar()
+				if err := x.Decode(secret[32*j : 32*j+32]); err != nil {
+					t.Fatal(err)
+				}
+				h := sha3.New256()
+				h.Write(uEnc)
+				h.Write(r255.NewElement().ScalarMult(x, u).Encode(nil))
+				h.Write(w.Encode(nil))
+				want := h.Sum(nil)[0]&1 ^ 1
+				if got := ciphertexts[j/8] >> (j % 8) & 1; got != want {
+					t.Errorf("gamma %d: flag %d bit %d is %d, reference says %d", gamma, i, j, got, want)
+				}
+			}
+		}
+	}
+}
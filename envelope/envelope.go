@@ -0,0 +1,182 @@
+// Package envelope seals messages for anonymous delivery through an
+// untrusted mailbox. An envelope carries a flag for the recipient's public key
+// in the clear, so the mailbox can test it, followed by the message encrypted
+// to the recipient's X25519 key under a fresh ephemeral key:
+//
+//	version (1 byte) || uvarint(len(flag)) || flag || ephemeral public key (32 bytes) || ciphertext
+//
+// Nothing outside the ciphertext identifies the sender. Applications that
+// need sender authentication can seal with SealSigned, which signs inside the
+// ciphertext. SealHybrid adds an exact tag after the flag for the recipient's
+// own filtering.
+//
+// The plaintext is encrypted with ChaCha20-Poly1305 under
+//
+//	SHA3-256("gophertags envelope v1" || X25519(e, R) || e·B || R)
+//
+// with the version, flag and caller's additional data authenticated, so a
+// flag can't be moved onto another envelope.
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/gtank/gophertags"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// Version is the format version written by Seal.
+	Version = 1
+
+	// KeySize is the size of X25519 public and private keys.
+	KeySize = 32
+
+	keyLabel = "gophertags envelope v1"
+	tagSize  = 16
+)
+
+// ErrEnvelope is returned for envelopes that are malformed or fail to decrypt.
+var ErrEnvelope = errors.New("envelope: malformed or unauthentic envelope")
+
+// GenerateKey returns a new X25519 key pair for receiving envelopes, using
+// randomness from r, or from crypto/rand if r is nil.
+func GenerateKey(r io.Reader) (publicKey, privateKey *[KeySize]byte, err error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	privateKey = new([KeySize]byte)
+	if _, err := io.ReadFull(r, privateKey[:]); err != nil {
+		return nil, nil, err
+	}
+	pub, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey = new([KeySize]byte)
+	copy(publicKey[:], pub)
+	return publicKey, privateKey, nil
+}
+
+// Seal generates a flag for tagKey and encrypts plaintext to recipient,
+// returning the envelope. additionalData is authenticated but not included.
+func Seal(tagKey *gophertags.PublicKey, recipient *[KeySize]byte, plaintext, additionalData []byte) ([]byte, error) {
+	return SealFlag(tagKey.GenerateFlag(), recipient, plaintext, additionalData)
+}
+
+// SealFlag is like Seal with a flag the caller has already generated, such
+// as one from GenerateBoundFlag.
+func SealFlag(f *gophertags.Flag, recipient *[KeySize]byte, plaintext, additionalData []byte) ([]byte, error) {
+	return seal(appendPrefixed([]byte{Version}, f.Encode(nil)), recipient, plaintext, additionalData)
+}
+
+// seal appends an ephemeral key and the plaintext encrypted to recipient to
+// the envelope header env, which it authenticates.
+func seal(env []byte, recipient *[KeySize]byte, plaintext, additionalData []byte) ([]byte, error) {
+	_, ephemeral, err := GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublic, err := curve25519.X25519(ephemeral[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral[:], recipient[:])
+	if err != nil {
+		return nil, err
+	}
+
+	header := len(env)
+	env = append(env, ephemeralPublic...)
+
+	aead, _ := chacha20poly1305.New(deriveKey(shared, ephemeralPublic, recipient[:]))
+	nonce := make([]byte, chacha20poly1305.NonceSize) // each key seals one message
+	return aead.Seal(env, nonce, plaintext, associatedData(env[:header], additionalData)), nil
+}
+
+// Flag returns the flag of an envelope without decrypting it, for mailboxes
+// to test against detection keys.
+func Flag(env []byte) (*gophertags.Flag, error) {
+	flag, _, err := split(env)
+	if err != nil {
+		return nil, err
+	}
+	f := new(gophertags.Flag)
+	if err := f.Decode(flag); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Open decrypts an envelope with the recipient's private key.
+func Open(privateKey *[KeySize]byte, env, additionalData []byte) ([]byte, error) {
+	_, header, err := split(env)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublic := env[header : header+KeySize]
+	ciphertext := env[header+KeySize:]
+
+	shared, err := curve25519.X25519(privateKey[:], ephemeralPublic)
+	if err != nil {
+		return nil, ErrEnvelope
+	}
+	recipient, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, ErrEnvelope
+	}
+	aead, _ := chacha20poly1305.New(deriveKey(shared, ephemeralPublic, recipient))
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associatedData(env[:header], additionalData))
+	if err != nil {
+		return nil, ErrEnvelope
+	}
+	return plaintext, nil
+}
+
+// split returns the envelope's flag and the offset of its ephemeral key.
+func split(env []byte) (flag []byte, header int, err error) {
+	if len(env) == 0 || (env[0] != Version && env[0] != HybridVersion) {
+		return nil, 0, ErrEnvelope
+	}
+	n, size := binary.Uvarint(env[1:])
+	if size <= 0 || n > uint64(len(env)) {
+		return nil, 0, ErrEnvelope
+	}
+	start := 1 + size
+	header = start + int(n)
+	if env[0] == HybridVersion {
+		header += ExactTagSize
+	}
+	if len(env) < header+KeySize+tagSize {
+		return nil, 0, ErrEnvelope
+	}
+	return env[start : start+int(n)], header, nil
+}
+
+func deriveKey(shared, ephemeralPublic, recipient []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(keyLabel))
+	h.Write(shared)
+	h.Write(ephemeralPublic)
+	h.Write(recipient)
+	return h.Sum(nil)
+}
+
+// associatedData authenticates the envelope header along with the caller's
+// additional data, length-prefixing the header so the two can't be confused.
+func associatedData(header, additionalData []byte) []byte {
+	return append(appendPrefixed(nil, header), additionalData...)
+}
+
+// appendPrefixed appends b to dst, preceded by its uvarint length.
+func appendPrefixed(dst, b []byte) []byte {
+	var length [binary.MaxVarintLen64]byte
+	dst = append(dst, length[:binary.PutUvarint(length[:], uint64(len(b)))]...)
+	return append(dst, b...)
+}
@@ -0,0 +1,81 @@
+package envelope
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestSealOpen(t *testing.T) {
+	sk := gophertags.NewSecretKey(16)
+	pub, priv, err := GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := Seal(sk.PublicKey(), pub, []byte("hello"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Flag(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sk.ExtractDetectionKey(16).Test(f) {
+		t.Error("envelope's flag doesn't match the recipient's detection key")
+	}
+
+	plaintext, err := Open(priv, env, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, []byte("hello")) {
+		t.Errorf("opened %q", plaintext)
+	}
+
+	if _, err := Open(priv, env, []byte("other ad")); err != ErrEnvelope {
+		t.Errorf("wrong additional data: got %v, want ErrEnvelope", err)
+	}
+	_, other, _ := GenerateKey(nil)
+	if _, err := Open(other, env, []byte("ad")); err != ErrEnvelope {
+		t.Errorf("wrong private key: got %v, want ErrEnvelope", err)
+	}
+}
+
+func TestFlagSwap(t *testing.T) {
+	victim, attacker := gophertags.NewSecretKey(8), gophertags.NewSecretKey(8)
+	pub, priv, _ := GenerateKey(nil)
+
+	env, _ := Seal(victim.PublicKey(), pub, []byte("secret"), nil)
+	flag, header, err := split(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the flag with another of the same length.
+	swapped := append([]byte(nil), env[:header-len(flag)]...)
+	swapped = append(swapped, attacker.PublicKey().GenerateFlag().Encode(nil)...)
+	swapped = append(swapped, env[header:]...)
+	if _, err := Open(priv, swapped, nil); err != ErrEnvelope {
+		t.Errorf("envelope with a swapped flag opened: %v", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	pub, priv, _ := GenerateKey(nil)
+	env, _ := Seal(gophertags.NewSecretKey(8).PublicKey(), pub, nil, nil)
+
+	for _, bad := range [][]byte{
+		nil,
+		{2},
+		{Version, 0xff},
+		env[:len(env)-1],
+		append([]byte{2}, env[1:]...),
+	} {
+		if _, err := Open(priv, bad, nil); err != ErrEnvelope {
+			t.Errorf("Open(%x) = %v, want ErrEnvelope", bad, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package envelope
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/gtank/gophertags"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hybrid envelopes carry, besides the fuzzy flag the mailbox tests, an exact
+// tag that only holders of an exact key shared by the recipient can compute:
+//
+//	version 2 (1 byte) || uvarint(len(flag)) || flag || exact tag (16 bytes) || ephemeral public key (32 bytes) || ciphertext
+//
+// where
+//
+//	exact tag = HMAC-SHA3-256(exact key, "gophertags exact tag v1" || flag)[:16]
+//
+// The mailbox, which hands the recipient its flag's false positives along with
+// its messages, sees only an unlinkable random string, since every flag is
+// fresh. The recipient discards the false positives with one HMAC each,
+// without decrypting or testing flags with its full secret key. The exact tag
+// is authenticated with the rest of the header, and Open and OpenSigned open
+// hybrid envelopes as they do others.
+//
+// Everyone given an exact key can tell which envelopes were sealed under it,
+// so a recipient should give each correspondent its own.
+
+const (
+	// HybridVersion is the format version written by SealHybrid.
+	HybridVersion = 2
+
+	// ExactKeySize is the size of exact keys.
+	ExactKeySize = 32
+
+	// ExactTagSize is the size of exact tags.
+	ExactTagSize = 16
+
+	exactTagLabel = "gophertags exact tag v1"
+)
+
+// ErrInconsistent is returned by CheckHybrid for envelopes whose exact tag
+// is the recipient's but whose flag is not.
+var ErrInconsistent = errors.New("envelope: exact tag and flag disagree")
+
+// GenerateExactKey returns a new exact key, using randomness from r, or from
+// crypto/rand if r is nil.
+func GenerateExactKey(r io.Reader) (*[ExactKeySize]byte, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	key := new([ExactKeySize]byte)
+	if _, err := io.ReadFull(r, key[:]); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ExactTag returns the exact tag of a flag under key.
+func ExactTag(key *[ExactKeySize]byte, f *gophertags.Flag) [ExactTagSize]byte {
+	return exactTag(key, f.Encode(nil))
+}
+
+func exactTag(key *[ExactKeySize]byte, flag []byte) (tag [ExactTagSize]byte) {
+	mac := hmac.New(sha3.New256, key[:])
+	mac.Write([]byte(exactTagLabel))
+	mac.Write(flag)
+	copy(tag[:], mac.Sum(nil))
+	return tag
+}
+
+// SealHybrid is like Seal, but also tags the envelope under exactKey, which
+// the recipient has shared with the sender.
+func SealHybrid(tagKey *gophertags.PublicKey, exactKey *[ExactKeySize]byte, recipient *[KeySize]byte, plaintext, additionalData []byte) ([]byte, error) {
+	return SealHybridFlag(tagKey.GenerateFlag(), exactKey, recipient, plaintext, additionalData)
+}
+
+// SealHybridFlag is like SealHybrid with a flag the caller has already
+// generated. The exact tag is computed from the flag, so the two always agree.
+func SealHybridFlag(f *gophertags.Flag, exactKey *[ExactKeySize]byte, recipient *[KeySize]byte, plaintext, additionalData []byte) ([]byte, error) {
+	flag := f.Encode(nil)
+	tag := exactTag(exactKey, flag)
+	return seal(append(appendPrefixed([]byte{HybridVersion}, flag), tag[:]...), recipient, plaintext, additionalData)
+}
+
+// MatchExact reports whether env is a hybrid envelope tagged under key. It
+// neither decodes the flag nor decrypts anything, so it is the cheap first
+// test for a recipient sorting the envelopes a mailbox gave it.
+func MatchExact(key *[ExactKeySize]byte, env []byte) bool {
+	flag, header, err := split(env)
+	if err != nil || env[0] != HybridVersion {
+		return false
+	}
+	tag := exactTag(key, flag)
+	return hmac.Equal(tag[:], env[header-ExactTagSize:header])
+}
+
+// CheckHybrid checks that a hybrid envelope's exact tag and flag agree on its
+// recipient, which dk should be a full-precision detection key of. It returns
+// ErrEnvelope if the envelope is not tagged under key and ErrInconsistent if
+// its flag doesn't match dk, in which case a mailbox filtering with dk's
+// shorter detection keys could not be relied on to deliver it; either the
+// sender's tagging key is wrong or the sender is misbehaving.
+func CheckHybrid(key *[ExactKeySize]byte, dk *gophertags.DetectionKey, env []byte) error {
+	if !MatchExact(key, env) {
+		return ErrEnvelope
+	}
+	f, err := Flag(env)
+	if err != nil {
+		return ErrEnvelope
+	}
+	if !dk.Test(f) {
+		return ErrInconsistent
+	}
+	return nil
+}
+
+// OpenHybrid is like Open, but first checks the envelope's exact tag,
+// returning ErrEnvelope without decrypting if it isn't tagged under exactKey.
+func OpenHybrid(privateKey *[KeySize]byte, exactKey *[ExactKeySize]byte, env, additionalData []byte) ([]byte, error) {
+	if !MatchExact(exactKey, env) {
+		return nil, ErrEnvelope
+	}
+	return Open(privateKey, env, additionalData)
+}
@@ -0,0 +1,97 @@
+package envelope
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestSealHybrid(t *testing.T) {
+	sk := gophertags.NewSecretKey(16)
+	dk := sk.ExtractDetectionKey(16)
+	pub, priv, _ := GenerateKey(nil)
+	exact, err := GenerateExactKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := GenerateExactKey(nil)
+
+	env, err := SealHybrid(sk.PublicKey(), exact, pub, []byte("hello"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !MatchExact(exact, env) {
+		t.Error("envelope doesn't match its exact key")
+	}
+	if MatchExact(other, env) {
+		t.Error("envelope matches another exact key")
+	}
+	f, err := Flag(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dk.Test(f) {
+		t.Error("envelope's flag doesn't match the recipient's detection key")
+	}
+	if tag := ExactTag(exact, f); !bytes.Contains(env, tag[:]) {
+		t.Error("envelope doesn't carry the flag's exact tag")
+	}
+	if err := CheckHybrid(exact, dk, env); err != nil {
+		t.Errorf("CheckHybrid: %v", err)
+	}
+
+	for name, open := range map[string]func() ([]byte, error){
+		"Open":       func() ([]byte, error) { return Open(priv, env, []byte("ad")) },
+		"OpenHybrid": func() ([]byte, error) { return OpenHybrid(priv, exact, env, []byte("ad")) },
+	} {
+		plaintext, err := open()
+		if err != nil || !bytes.Equal(plaintext, []byte("hello")) {
+			t.Errorf("%s = %q, %v", name, plaintext, err)
+		}
+	}
+	if _, err := OpenHybrid(priv, other, env, []byte("ad")); err != ErrEnvelope {
+		t.Errorf("OpenHybrid with another exact key: got %v, want ErrEnvelope", err)
+	}
+
+	plain, _ := Seal(sk.PublicKey(), pub, nil, nil)
+	if MatchExact(exact, plain) {
+		t.Error("non-hybrid envelope matches an exact key")
+	}
+}
+
+func TestCheckHybridInconsistent(t *testing.T) {
+	recipient, stranger := gophertags.NewSecretKey(16), gophertags.NewSecretKey(16)
+	pub, _, _ := GenerateKey(nil)
+	exact, _ := GenerateExactKey(nil)
+
+	// The right exact key and the wrong tagging key.
+	env, _ := SealHybrid(stranger.PublicKey(), exact, pub, []byte("hello"), nil)
+	if err := CheckHybrid(exact, recipient.ExtractDetectionKey(16), env); err != ErrInconsistent {
+		t.Errorf("got %v, want ErrInconsistent", err)
+	}
+}
+
+func TestExactTagSwap(t *testing.T) {
+	sk := gophertags.NewSecretKey(8)
+	pub, priv, _ := GenerateKey(nil)
+	exact, _ := GenerateExactKey(nil)
+
+	env, _ := SealHybrid(sk.PublicKey(), exact, pub, []byte("secret"), nil)
+	_, header, err := split(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Retag the envelope under another exact key.
+	other, _ := GenerateExactKey(nil)
+	f, _ := Flag(env)
+	tag := ExactTag(other, f)
+	retagged := append([]byte(nil), env...)
+	copy(retagged[header-ExactTagSize:], tag[:])
+	if !MatchExact(other, retagged) {
+		t.Fatal("retagged envelope doesn't match")
+	}
+	if _, err := Open(priv, retagged, nil); err != ErrEnvelope {
+		t.Errorf("retagged envelope opened: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package envelope
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+
+	"github.com/gtank/gophertags"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Signed envelopes carry the sender's Ed25519 key and signature inside the
+// ciphertext, so the recipient can authenticate the sender and the mailbox
+// learns nothing new. The plaintext sealed is
+//
+//	sender public key (32 bytes) || signature (64 bytes) || message
+//
+// and the signature covers
+//
+//	"gophertags envelope signature v1" || uvarint(len(flag)) || flag || recipient || uvarint(len(ad)) || ad || message
+//
+// Signing the flag and the recipient's key stops a recipient re-sealing the
+// message to someone else as though it had been sent to them. Signed and
+// unsigned envelopes look alike from outside, apart from being 96 bytes
+// longer, so applications must know which kind to expect.
+
+const signatureLabel = "gophertags envelope signature v1"
+
+const signedOverhead = ed25519.PublicKeySize + ed25519.SignatureSize
+
+// ErrSignature is returned by OpenSigned for envelopes without a valid sender signature.
+var ErrSignature = errors.New("envelope: missing or invalid sender signature")
+
+// SealSigned is like Seal, but signs the envelope with the sender's key.
+func SealSigned(tagKey *gophertags.PublicKey, recipient *[KeySize]byte, sender ed25519.PrivateKey, plaintext, additionalData []byte) ([]byte, error) {
+	return SealSignedFlag(tagKey.GenerateFlag(), recipient, sender, plaintext, additionalData)
+}
+
+// SealSignedFlag is like SealSigned with a flag the caller has already generated.
+func SealSignedFlag(f *gophertags.Flag, recipient *[KeySize]byte, sender ed25519.PrivateKey, plaintext, additionalData []byte) ([]byte, error) {
+	signed := make([]byte, 0, signedOverhead+len(plaintext))
+	signed = append(signed, sender.Public().(ed25519.PublicKey)...)
+	signed = append(signed, ed25519.Sign(sender, signedMessage(f.Encode(nil), recipient[:], additionalData, plaintext))...)
+	signed = append(signed, plaintext...)
+	return SealFlag(f, recipient, signed, additionalData)
+}
+
+// OpenSigned decrypts a signed envelope and verifies its sender signature,
+// returning the message and the sender's public key. It returns ErrEnvelope
+// if decryption fails and ErrSignature if the signature doesn't verify.
+func OpenSigned(privateKey *[KeySize]byte, env, additionalData []byte) (plaintext []byte, sender ed25519.PublicKey, err error) {
+	signed, err := Open(privateKey, env, additionalData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(signed) < signedOverhead {
+		return nil, nil, ErrSignature
+	}
+	flag, _, _ := split(env)
+	recipient, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, ErrEnvelope
+	}
+	sender = ed25519.PublicKey(signed[:ed25519.PublicKeySize])
+	signature := signed[ed25519.PublicKeySize:signedOverhead]
+	plaintext = signed[signedOverhead:]
+	if !ed25519.Verify(sender, signedMessage(flag, recipient, additionalData, plaintext), signature) {
+		return nil, nil, ErrSignature
+	}
+	return plaintext, sender, nil
+}
+
+func signedMessage(flag, recipient, additionalData, plaintext []byte) []byte {
+	msg := make([]byte, 0, len(signatureLabel)+2*binary.MaxVarintLen64+len(flag)+KeySize+len(additionalData)+len(plaintext))
+	msg = append(msg, signatureLabel...)
+	msg = appendPrefixed(msg, flag)
+	msg = append(msg, recipient...)
+	msg = appendPrefixed(msg, additionalData)
+	return append(msg, plaintext...)
+}
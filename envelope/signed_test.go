@@ -0,0 +1,53 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestSealSigned(t *testing.T) {
+	pk := gophertags.NewSecretKey(16).PublicKey()
+	pub, priv, _ := GenerateKey(nil)
+	senderPub, sender, _ := ed25519.GenerateKey(nil)
+
+	env, err := SealSigned(pk, pub, sender, []byte("hello"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, from, err := OpenSigned(priv, env, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, []byte("hello")) || !from.Equal(senderPub) {
+		t.Errorf("opened %q from %x", plaintext, from)
+	}
+	if bytes.Contains(env, senderPub) {
+		t.Error("sender key visible outside the ciphertext")
+	}
+
+	unsigned, _ := Seal(pk, pub, []byte("hello"), []byte("ad"))
+	if _, _, err := OpenSigned(priv, unsigned, []byte("ad")); err != ErrSignature {
+		t.Errorf("unsigned envelope: got %v, want ErrSignature", err)
+	}
+}
+
+func TestSignedResealed(t *testing.T) {
+	pk := gophertags.NewSecretKey(16).PublicKey()
+	alicePub, alicePriv, _ := GenerateKey(nil)
+	bobPub, bobPriv, _ := GenerateKey(nil)
+	_, sender, _ := ed25519.GenerateKey(nil)
+
+	env, _ := SealSigned(pk, alicePub, sender, []byte("for alice"), nil)
+	inner, err := Open(alicePriv, env, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Alice re-seals the signed plaintext to Bob, under a fresh flag.
+	forwarded, _ := Seal(pk, bobPub, inner, nil)
+	if _, _, err := OpenSigned(bobPriv, forwarded, nil); err != ErrSignature {
+		t.Errorf("re-sealed envelope: got %v, want ErrSignature", err)
+	}
+}
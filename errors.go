@@ -0,0 +1,91 @@
+package gophertags
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Broad causes of failure, for callers that branch on why an operation
+// failed rather than on the details. The package's more specific errors match
+// them under errors.Is: every *DecodeError and every error for malformed text
+// is an ErrInvalidEncoding, for instance, and a flag of the wrong length for
+// the gamma it was decoded with is both an ErrLength and an ErrGammaMismatch.
+var (
+	// ErrInvalidEncoding is matched by errors for input that isn't a valid
+	// encoding of what it was decoded as.
+	ErrInvalidEncoding = errors.New("gophertags: invalid encoding")
+	// ErrPrecisionOutOfRange is matched by errors for detection key
+	// precisions that are negative or exceed the key's gamma.
+	ErrPrecisionOutOfRange = errors.New("gophertags: precision out of range")
+	// ErrGammaMismatch is matched by errors for flags and keys whose gamma
+	// isn't the one expected.
+	ErrGammaMismatch = errors.New("gophertags: gamma mismatch")
+	// ErrEntropyFailure is matched by errors for failed reads from the
+	// source of randomness. Functions that can't return an error, such as
+	// NewSecretKey, panic with one.
+	ErrEntropyFailure = errors.New("gophertags: reading randomness failed")
+)
+
+// Reasons a decoder can reject its input. They are wrapped in a *DecodeError,
+// so test for them with errors.Is.
+var (
+	ErrLength              = errors.New("wrong length")
+	ErrUnknownHashScheme   = errors.New("unknown hash scheme")
+	ErrNonCanonicalElement = errors.New("non-canonical Ristretto encoding")
+	ErrNonCanonicalScalar  = errors.New("non-canonical scalar encoding")
+	ErrBitVectorTooLong    = newKindError("ciphertext bits beyond gamma", ErrGammaMismatch)
+	ErrDegenerateFlag      = errors.New("flag has identity u or zero y")
+	ErrDegenerateVerifier  = errors.New("verifier key is zero or the identity")
+)
+
+// DecodeError is returned by decoders for input they reject.
+type DecodeError struct {
+	Type   string // "flag", "public key" or "detection key"
+	Offset int    // offset of the offending field in the input
+	Err    error  // one of the Err* reasons
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("gophertags: decoding %s at offset %d: %v", e.Type, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes every DecodeError match ErrInvalidEncoding.
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrInvalidEncoding
+}
+
+// errGammaLength is the reason for an encoding whose length is wrong for the
+// gamma it was decoded with.
+var errGammaLength = newKindError("wrong length for gamma", ErrLength, ErrGammaMismatch)
+
+// kindError is an error that also matches broader errors under errors.Is.
+type kindError struct {
+	msg   string
+	kinds []error
+}
+
+func newKindError(msg string, kinds ...error) error {
+	return &kindError{msg, kinds}
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Is(target error) bool {
+	for _, kind := range e.kinds {
+		if target == kind || errors.Is(kind, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// entropyError reports a failed read from the source of randomness.
+func entropyError(err error) error {
+	return fmt.Errorf("%w: %v", ErrEntropyFailure, err)
+}
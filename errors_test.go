@@ -0,0 +1,76 @@
+package gophertags
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrorCategories(t *testing.T) {
+	sk := NewSecretKey(12)
+	flag := sk.PublicKey().GenerateFlag().Encode(nil)
+
+	_, base58Err := DecodeDetectionKeyBase58("0OIl")
+	_, uriErr := ParseURI("gophertags:")
+	_, pathErr := ParseDerivationPath("m/x")
+	_, shortErr := DecodeFlag(flag[:len(flag)-1], 12)
+	_, longErr := DecodeFlag(flag, 8)
+	_, pkErr := Decoder{Gamma: 13}.PublicKey(sk.PublicKey().Encode(nil))
+	_, storeErr := MemoryKeyStore{sk}.ExtractDetectionKey(13)
+	_, negativeErr := ExtractDetectionKeyFrom(strings.NewReader(""), -1)
+	_, policyErr := sk.NegotiateDetectionKey(PrecisionPolicy{Min: 13}, 13)
+
+	tests := []struct {
+		name string
+		err  error
+		want []error
+	}{
+		{"malformed flag", new(Flag).Decode(flag[:10]), []error{ErrInvalidEncoding, ErrLength}},
+		{"Base58", base58Err, []error{ErrInvalidEncoding, ErrBase58Character}},
+		{"URI", uriErr, []error{ErrInvalidEncoding, ErrURI}},
+		{"derivation path", pathErr, []error{ErrInvalidEncoding, ErrDerivationPath}},
+		{"short flag for gamma", shortErr, []error{ErrInvalidEncoding, ErrLength, ErrGammaMismatch}},
+		{"long flag for gamma", longErr, []error{ErrInvalidEncoding, ErrBitVectorTooLong, ErrGammaMismatch}},
+		{"public key for gamma", pkErr, []error{ErrInvalidEncoding, ErrLength, ErrGammaMismatch}},
+		{"key store precision", storeErr, []error{ErrPrecisionOutOfRange}},
+		{"negative precision", negativeErr, []error{ErrPrecisionOutOfRange}},
+		{"policy precision", policyErr, []error{ErrPrecisionOutOfRange, ErrGammaTooSmall}},
+	}
+	for _, tt := range tests {
+		for _, want := range tt.want {
+			if !errors.Is(tt.err, want) {
+				t.Errorf("%s: %v doesn't match %v", tt.name, tt.err, want)
+			}
+		}
+	}
+
+	if errors.Is(storeErr, ErrInvalidEncoding) || errors.Is(shortErr, ErrPrecisionOutOfRange) {
+		t.Error("errors match causes they don't have")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestEntropyFailure(t *testing.T) {
+	saved := randReader
+	randReader = failingReader{}
+	defer func() { randReader = saved }()
+
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.Is(err, ErrEntropyFailure) {
+				t.Errorf("NewSecretKey panicked with %v", err)
+			}
+		}()
+		NewSecretKey(4)
+	}()
+
+	if _, err := new(SecretKey).Export(nil); !errors.Is(err, ErrEntropyFailure) {
+		t.Errorf("Export: got %v", err)
+	}
+}
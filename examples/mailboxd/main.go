@@ -0,0 +1,243 @@
+// Command mailboxd runs a complete gophertags mailbox on one machine: a
+// detection server with a message store, deduplication, live watches, rate
+// limiting and pruning, and, to show it working, a recipient and a sender
+// that exchange messages through it. It doubles as a reference for how the
+// packages fit together in a deployment:
+//
+//	sender ──client.Submit──▶ server.RateLimit ─▶ server.Server ─▶ mailbox.Store
+//	                                                   ▲                 │
+//	recipient ─client.RegisterKey (detection key)──────┘                 │
+//	recipient ◀─client.Inbox (matches, decrypted with envelope.Open)─────┘
+//
+// Usage:
+//
+//	mailboxd [-addr host:port] [-db file] [-max-ttl d] [-retention d] [-demo n] [-exit]
+//
+// With -db the store is an SQLite database, which survives restarts;
+// otherwise it is in memory. With -demo n, a recipient registers a detection
+// key and a sender seals n messages to it and n to someone else; the
+// recipient's inbox receives its own n messages, while the server also
+// matches some of the others, the false positives that give the recipient
+// cover. With -exit the command stops after the demo instead of serving
+// until interrupted.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/client"
+	"github.com/gtank/gophertags/envelope"
+	"github.com/gtank/gophertags/mailbox"
+	"github.com/gtank/gophertags/server"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// config is the command line.
+type config struct {
+	addr      string
+	db        string
+	maxTTL    time.Duration
+	retention time.Duration
+	demo      int
+	exit      bool
+}
+
+// Parameters of the demo's recipient: a key with gamma 24 registered at
+// precision 3, so one flag in eight for someone else matches it.
+const (
+	demoGamma     = 24
+	demoPrecision = 3
+)
+
+func main() {
+	var c config
+	fs := flag.NewFlagSet("mailboxd", flag.ExitOnError)
+	fs.StringVar(&c.addr, "addr", "127.0.0.1:8080", "`address` to listen on")
+	fs.StringVar(&c.db, "db", "", "SQLite database `file`; empty means an in-memory store")
+	fs.DurationVar(&c.maxTTL, "max-ttl", 24*time.Hour, "longest TTL a sender may set")
+	fs.DurationVar(&c.retention, "retention", 7*24*time.Hour, "how long messages are kept whatever their TTL")
+	fs.IntVar(&c.demo, "demo", 0, "exchange `n` demo messages once serving")
+	fs.BoolVar(&c.exit, "exit", false, "stop after the demo")
+	fs.Parse(os.Args[1:])
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ln, err := net.Listen("tcp", c.addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mailboxd:", err)
+		os.Exit(1)
+	}
+	if err := run(ctx, c, ln, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "mailboxd:", err)
+		os.Exit(1)
+	}
+}
+
+// run serves on ln until ctx is done, or until the demo is over if c.exit is
+// set, logging to out.
+func run(ctx context.Context, c config, ln net.Listener, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	store, closeStore, err := openStore(ctx, c.db)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	defer closeStore()
+
+	srv := server.New(server.Config{
+		Store:    store,
+		Dedup:    server.NewDedupIndex(server.DedupConfig{TTL: time.Hour}),
+		Watchers: server.NewWatchers(),
+		MaxTTL:   c.maxTTL,
+	})
+	httpServer := &http.Server{
+		Handler:           server.RateLimit(srv, server.RateLimitConfig{}),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	pruner := mailbox.NewPruner(store, mailbox.PrunerConfig{
+		Retention: c.retention,
+		OnPrune: func(n int) {
+			if n > 0 {
+				fmt.Fprintf(out, "pruned %d messages\n", n)
+			}
+		},
+	})
+
+	errs := make(chan error, 3)
+	go func() {
+		if err := httpServer.Serve(ln); err != http.ErrServerClosed {
+			errs <- err
+			return
+		}
+		errs <- nil
+	}()
+	go func() {
+		if err := pruner.Run(ctx); err != nil && ctx.Err() == nil {
+			errs <- err
+		}
+	}()
+	fmt.Fprintf(out, "serving on http://%s\n", ln.Addr())
+
+	if c.demo > 0 {
+		if err := demo(ctx, "http://"+ln.Addr().String(), c.demo, out); err != nil {
+			fmt.Fprintln(out, "demo failed:", err)
+			if c.exit {
+				cancel()
+				shutdown(httpServer)
+				return err
+			}
+		}
+	}
+	if !c.exit {
+		select {
+		case <-ctx.Done():
+		case err := <-errs:
+			if err != nil {
+				shutdown(httpServer)
+				return err
+			}
+		}
+	}
+	cancel()
+	return shutdown(httpServer)
+}
+
+// shutdown stops the server, giving requests in flight a few seconds.
+func shutdown(s *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+func openStore(ctx context.Context, path string) (mailbox.Store, func(), error) {
+	if path == "" {
+		return mailbox.NewMemoryStore(), func() {}, nil
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := mailbox.OpenSQLiteStore(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return store, func() { db.Close() }, nil
+}
+
+// demo plays a recipient and a sender against the server at baseURL. The
+// recipient keeps its secret key and envelope private key and gives the
+// server only a detection key; the sender knows only the recipient's public
+// keys.
+func demo(ctx context.Context, baseURL string, n int, out io.Writer) error {
+	c := client.New(client.Config{BaseURL: baseURL, Timeout: 10 * time.Second})
+
+	// The recipient publishes its tag public key and envelope public key, and
+	// registers a low-precision detection key.
+	tagKey := gophertags.NewSecretKey(demoGamma)
+	boxPublic, boxPrivate, err := envelope.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	keyID, err := c.RegisterKey(ctx, tagKey.ExtractDetectionKey(demoPrecision))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "recipient registered detection key %v at precision %d\n", keyID, demoPrecision)
+
+	// The sender seals n messages to the recipient and n to a stranger.
+	stranger := gophertags.NewSecretKey(demoGamma).PublicKey()
+	strangerBox, _, err := envelope.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		for _, to := range []struct {
+			tag *gophertags.PublicKey
+			box *[envelope.KeySize]byte
+		}{{tagKey.PublicKey(), boxPublic}, {stranger, strangerBox}} {
+			env, err := envelope.Seal(to.tag, to.box, []byte(fmt.Sprintf("message %d", i)), nil)
+			if err != nil {
+				return err
+			}
+			if _, _, err := c.SubmitEnvelope(ctx, env); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintf(out, "sender submitted %d messages for the recipient and %d for a stranger\n", n, n)
+
+	// The recipient fetches its matches and opens what it can.
+	matches, err := c.Matches(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	inbox := client.NewInbox(client.InboxConfig{Client: c, Key: keyID, PrivateKey: boxPrivate})
+	messages, err := inbox.Poll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		fmt.Fprintf(out, "recipient received %q\n", msg.Plaintext)
+	}
+	fmt.Fprintf(out, "server matched %d messages, of which %d were false positives\n", len(matches), len(matches)-len(messages))
+	if len(messages) != n {
+		return errors.New("recipient didn't receive every message")
+	}
+	return nil
+}
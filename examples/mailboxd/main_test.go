@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDemo(t *testing.T) {
+	for _, db := range []string{"", filepath.Join(t.TempDir(), "mailbox.db")} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		var out bytes.Buffer
+		err = run(ctx, config{db: db, maxTTL: time.Hour, retention: time.Hour, demo: 3, exit: true}, ln, &out)
+		cancel()
+		if err != nil {
+			t.Fatalf("store %q: %v\n%s", db, err, out.String())
+		}
+		for i := 0; i < 3; i++ {
+			if !strings.Contains(out.String(), `recipient received "message `+string(rune('0'+i))+`"`) {
+				t.Errorf("store %q: message %d not received:\n%s", db, i, out.String())
+			}
+		}
+	}
+}
+
+func TestServeUntilCanceled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, config{}, ln, new(bytes.Buffer)) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("run didn't return after cancellation")
+	}
+}
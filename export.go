@@ -0,0 +1,99 @@
+package gophertags
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Exported secret keys are sealed with XChaCha20-Poly1305 under a key derived
+// from the passphrase with Argon2id:
+//
+//	"gtsk" || version (1) || time (4) || memory KiB (4) || threads (1) || salt (16) || nonce (24) || ciphertext
+//
+// Everything before the ciphertext is authenticated as associated data.
+
+const (
+	exportMagic   = "gtsk"
+	exportVersion = 1
+	exportSaltLen = 16
+	exportTagLen  = 16 // Poly1305
+
+	// The Argon2id parameters follow the second recommendation of RFC 9106.
+	exportTime    = 3
+	exportMemory  = 64 * 1024
+	exportThreads = 4
+
+	// maxImportMemory and maxImportTime bound the work an attacker-supplied
+	// blob can demand: at most 1 GiB, and 16 passes over it.
+	maxImportMemory = 1024 * 1024
+	maxImportTime   = 16
+
+	exportHeaderLen = len(exportMagic) + 1 + 4 + 4 + 1 + exportSaltLen + chacha20poly1305.NonceSizeX
+)
+
+// ErrPassphrase is returned by ImportSecretKey when the passphrase is wrong or the blob was modified.
+var ErrPassphrase = errors.New("gophertags: wrong passphrase or corrupted export")
+
+var errExportFormat = newKindError("gophertags: not an exported secret key", ErrInvalidEncoding)
+
+// Export encrypts the secret key under a passphrase, for moving it between
+// machines without writing plaintext scalars to disk.
+func (sk *SecretKey) Export(passphrase []byte) ([]byte, error) {
+	header := make([]byte, 0, exportHeaderLen)
+	header = append(header, exportMagic...)
+	header = append(header, exportVersion)
+	header = appendUint32(header, exportTime)
+	header = appendUint32(header, exportMemory)
+	header = append(header, exportThreads)
+
+	random := make([]byte, exportSaltLen+chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(randReader, random); err != nil {
+		return nil, entropyError(err)
+	}
+	salt, nonce := random[:exportSaltLen], random[exportSaltLen:]
+	header = append(header, random...)
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, salt, exportTime, exportMemory, exportThreads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, sk.Encode(nil), header), nil
+}
+
+// ImportSecretKey decrypts a secret key produced by SecretKey.Export.
+func ImportSecretKey(blob, passphrase []byte) (*SecretKey, error) {
+	if len(blob) < exportHeaderLen+exportTagLen || string(blob[:len(exportMagic)]) != exportMagic {
+		return nil, errExportFormat
+	}
+	p := blob[len(exportMagic):]
+	if p[0] != exportVersion {
+		return nil, errExportFormat
+	}
+	time, memory, threads := binary.BigEndian.Uint32(p[1:5]), binary.BigEndian.Uint32(p[5:9]), p[9]
+	if time == 0 || time > maxImportTime || memory == 0 || memory > maxImportMemory || threads == 0 {
+		return nil, errExportFormat
+	}
+	salt := p[10 : 10+exportSaltLen]
+	nonce := p[10+exportSaltLen : 10+exportSaltLen+chacha20poly1305.NonceSizeX]
+	header := blob[:exportHeaderLen]
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, salt, time, memory, threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := aead.Open(nil, nonce, blob[exportHeaderLen:], header)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return DecodeSecretKey(encoded)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
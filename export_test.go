@@ -0,0 +1,62 @@
+package gophertags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	sk := NewSecretKey(16)
+	passphrase := []byte("correct horse battery staple")
+
+	blob, err := sk.Export(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(blob, sk.sk[0].Encode(nil)) {
+		t.Fatal("export contains a plaintext scalar")
+	}
+
+	imported, err := ImportSecretKey(blob, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(imported.Encode(nil), sk.Encode(nil)) {
+		t.Error("imported key differs from the exported one")
+	}
+
+	if _, err := ImportSecretKey(blob, []byte("wrong")); err != ErrPassphrase {
+		t.Errorf("wrong passphrase: got %v", err)
+	}
+
+	// The header is authenticated too, so weakening the KDF parameters is detected.
+	tampered := append([]byte{}, blob...)
+	tampered[len(exportMagic)+4] ^= 1
+	if _, err := ImportSecretKey(tampered, passphrase); err != ErrPassphrase {
+		t.Errorf("tampered header: got %v", err)
+	}
+
+	if _, err := ImportSecretKey(blob[:exportHeaderLen], passphrase); err == nil {
+		t.Error("accepted a truncated export")
+	}
+
+	// Blobs demanding too much work are refused before any hashing.
+	for _, tc := range []struct {
+		name   string
+		offset int
+		value  uint32
+	}{
+		{"huge time", 1, math.MaxUint32},
+		{"time over the bound", 1, maxImportTime + 1},
+		{"huge memory", 5, math.MaxUint32},
+	} {
+		costly := append([]byte{}, blob...)
+		binary.BigEndian.PutUint32(costly[len(exportMagic)+tc.offset:], tc.value)
+		if _, err := ImportSecretKey(costly, passphrase); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("%s: got %v, want ErrInvalidEncoding", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package gophertags
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	r255 "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/sha3"
+)
+
+// FingerprintSize is the length in bytes of a key fingerprint.
+const FingerprintSize = 16
+
+// Fingerprint is a short digest identifying a key without revealing its material.
+type Fingerprint [FingerprintSize]byte
+
+// String returns the fingerprint in hex.
+func (fp Fingerprint) String() string {
+	return hex.EncodeToString(fp[:])
+}
+
+// MarshalText implements encoding.TextMarshaler, encoding the fingerprint in hex.
+func (fp Fingerprint) MarshalText() ([]byte, error) {
+	return []byte(fp.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for hex fingerprints.
+func (fp *Fingerprint) UnmarshalText(text []byte) error {
+	var b [FingerprintSize]byte
+	if hex.DecodedLen(len(text)) != len(b) {
+		return errFingerprintText
+	}
+	if _, err := hex.Decode(b[:], text); err != nil {
+		return errFingerprintText
+	}
+	*fp = b
+	return nil
+}
+
+var errFingerprintText = newKindError("gophertags: fingerprint must be 32 hex digits", ErrInvalidEncoding)
+
+func fingerprintOf(encoding []byte) Fingerprint {
+	var fp Fingerprint
+	digest := sha3.Sum256(encoding)
+	copy(fp[:], digest[:])
+	return fp
+}
+
+// Fingerprint returns the SHA3-256 digest of the key's canonical encoding, truncated to FingerprintSize bytes.
+// The derivation path is metadata and is left out, so a key has the same
+// fingerprint whether or not it records its path.
+func (pk *PublicKey) Fingerprint() Fingerprint {
+	b := pk.Encode(nil)
+	return fingerprintOf(b[:len(b)-pathSize(pk.path)])
+}
+
+// Fingerprint returns the SHA3-256 digest of the key's canonical encoding, truncated to FingerprintSize bytes.
+// Detection keys of different precisions have unrelated fingerprints. As for
+// public keys, the derivation path is left out.
+func (dk *DetectionKey) Fingerprint() Fingerprint {
+	b := dk.Encode(nil)
+	return fingerprintOf(b[:len(b)-pathSize(dk.path)])
+}
+
+// KeyIDSize is the length in bytes of a key ID.
+const KeyIDSize = 8
+
+// KeyID is a short, stable identifier for a key family: a public key and every
+// detection key extracted from the same secret key share one KeyID, whatever
+// their precision. It is derived from the first public key element and, for
+// schemes other than SHA3, the scheme ID, so the same secret under two
+// algorithms gets two IDs.
+type KeyID [KeyIDSize]byte
+
+// String returns the key ID in hex.
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+const keyIDLabel = "gophertags key id"
+
+func keyIDOf(p params, H *r255.Element) KeyID {
+	var id KeyID
+	digest := sha3.New256()
+	digest.Write([]byte(keyIDLabel))
+	if scheme := p.scheme(); scheme.ID() != SHA3.ID() {
+		// SHA3 IDs predate algorithm IDs and are left as they were.
+		digest.Write([]byte{scheme.ID()})
+	}
+	digest.Write(H.Encode(nil))
+	copy(id[:], digest.Sum(nil))
+	return id
+}
+
+// KeyID returns the key's family identifier. A key with gamma 0 has the zero KeyID.
+func (pk *PublicKey) KeyID() KeyID {
+	if len(pk.internal) == 0 {
+		return KeyID{}
+	}
+	return keyIDOf(pk.params, pk.internal[0])
+}
+
+// KeyID returns the key's family identifier, matching the KeyID of the public
+// key it was extracted alongside. A key with precision 0 has the zero KeyID.
+func (dk *DetectionKey) KeyID() KeyID {
+	if len(dk.internal) == 0 {
+		return KeyID{}
+	}
+	return keyIDOf(dk.params, r255.NewElement().ScalarBaseMult(dk.internal[0]))
+}
+
+// String implements fmt.Stringer with the key's gamma and fingerprint.
+func (pk *PublicKey) String() string {
+	return fmt.Sprintf("PublicKey{gamma=%d fp=%v}", len(pk.internal), pk.Fingerprint())
+}
+
+// String implements fmt.Stringer with the key's precision and fingerprint.
+func (dk *DetectionKey) String() string {
+	return fmt.Sprintf("DetectionKey{n=%d fp=%v}", len(dk.internal), dk.Fingerprint())
+}
+
+// String implements fmt.Stringer without revealing any secret material. It
+// identifies the secret key by the fingerprint of its public key.
+func (sk *SecretKey) String() string {
+	return fmt.Sprintf("SecretKey{gamma=%d pk=%v}", len(sk.sk), sk.PublicKey().Fingerprint())
+}
@@ -0,0 +1,70 @@
+package gophertags
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestFingerprints(t *testing.T) {
+	sk := NewSecretKey(16)
+	pk := sk.PublicKey()
+
+	if pk.Fingerprint() != sk.PublicKey().Fingerprint() {
+		t.Error("fingerprint is not stable across copies of the same key")
+	}
+	if pk.Fingerprint() == NewSecretKey(16).PublicKey().Fingerprint() {
+		t.Error("distinct keys share a fingerprint")
+	}
+	if sk.ExtractDetectionKey(4).Fingerprint() == sk.ExtractDetectionKey(5).Fingerprint() {
+		t.Error("detection keys of different precision share a fingerprint")
+	}
+}
+
+func TestStringersHideKeyMaterial(t *testing.T) {
+	sk := NewSecretKey(4)
+	dk := sk.ExtractDetectionKey(4)
+
+	for _, s := range []string{sk.String(), dk.String(), sk.PublicKey().String()} {
+		for _, x := range sk.sk {
+			if strings.Contains(s, hex.EncodeToString(x.Encode(nil))) || strings.Contains(s, x.String()) {
+				t.Errorf("%q contains a secret scalar", s)
+			}
+		}
+	}
+	if !strings.Contains(sk.String(), sk.PublicKey().Fingerprint().String()) {
+		t.Error("secret key string doesn't identify its public key")
+	}
+}
+
+func TestKeyIDs(t *testing.T) {
+	sk := NewSecretKey(16)
+	id := sk.PublicKey().KeyID()
+
+	if sk.ExtractDetectionKey(3).KeyID() != id || sk.ExtractDetectionKey(16).KeyID() != id {
+		t.Error("detection keys don't share their public key's KeyID")
+	}
+	if NewSecretKey(16).PublicKey().KeyID() == id {
+		t.Error("distinct keys share a KeyID")
+	}
+	if sk.ExtractDetectionKey(0).KeyID() != (KeyID{}) {
+		t.Error("empty detection key has a nonzero KeyID")
+	}
+}
+
+func TestFingerprintText(t *testing.T) {
+	fp := NewSecretKey(4).PublicKey().Fingerprint()
+	text, err := fp.MarshalText()
+	if err != nil || string(text) != fp.String() {
+		t.Fatalf("MarshalText = %s, %v", text, err)
+	}
+	var got Fingerprint
+	if err := got.UnmarshalText(text); err != nil || got != fp {
+		t.Errorf("UnmarshalText round trip: %v", err)
+	}
+	for _, bad := range []string{"", "abcd", fp.String() + "00", strings.Repeat("zz", FingerprintSize)} {
+		if err := got.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q) succeeded", bad)
+		}
+	}
+}
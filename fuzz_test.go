@@ -0,0 +1,84 @@
+//go:build go1.18
+// +build go1.18
+
+package gophertags
+
+import (
+	"bytes"
+	"testing"
+)
+
+// These targets exercise the decoders that detection servers run on
+// attacker-controlled input. Run one with, e.g.:
+//
+//	go test -run '^$' -fuzz FuzzFlagDecode
+//
+// To start from a larger seed corpus, first run
+//
+//	go run ./cmd/gophertags corpus
+
+func FuzzFlagDecode(f *testing.F) {
+	f.Add(NewSecretKey(8).PublicKey().GenerateFlag().Encode(nil))
+	f.Add(NewSecretKey(24).PublicKey().GenerateFlag().Encode(nil))
+	f.Add(append([]byte{SHA3.ID()}, make([]byte, 64)...))
+
+	dk := NewSecretKey(24).ExtractDetectionKey(24)
+	f.Fuzz(func(t *testing.T, in []byte) {
+		flag := new(Flag)
+		if err := flag.Decode(in); err != nil {
+			return
+		}
+		out := flag.Encode(nil)
+		if !bytes.Equal(in, out) {
+			t.Fatalf("decode/encode round trip changed the flag:\n%x\n%x", in, out)
+		}
+		// Testing decoded flags must never panic, whatever their length.
+		matched := dk.Test(flag)
+
+		// Borrowed decoding sees the same flag.
+		borrowed, err := DecodeFlagBorrowed(in, -1)
+		if err != nil || dk.Test(borrowed) != matched {
+			t.Fatalf("borrowed decoding disagrees with Decode: %v", err)
+		}
+
+		// Strict decoding accepts exactly one encoding per flag.
+		strict, err := DecodeFlag(in, 20)
+		if err == nil && !bytes.Equal(in, strict.Encode(nil)) {
+			t.Fatalf("strict round trip changed the flag:\n%x\n%x", in, strict.Encode(nil))
+		}
+	})
+}
+
+func FuzzPublicKeyDecode(f *testing.F) {
+	f.Add(NewSecretKey(1).PublicKey().Encode(nil))
+	f.Add(NewSecretKey(8).PublicKey().Encode(nil))
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		pk := new(PublicKey)
+		if err := pk.Decode(in); err != nil {
+			return
+		}
+		out := pk.Encode(nil)
+		if !bytes.Equal(in, out) {
+			t.Fatalf("decode/encode round trip changed the public key:\n%x\n%x", in, out)
+		}
+	})
+}
+
+func FuzzDetectionKeyDecode(f *testing.F) {
+	f.Add([]byte{SHA3.ID()})
+	f.Add(NewSecretKey(8).ExtractDetectionKey(3).Encode(nil))
+
+	flag := NewSecretKey(8).PublicKey().GenerateFlag()
+	f.Fuzz(func(t *testing.T, in []byte) {
+		dk := new(DetectionKey)
+		if err := dk.Decode(in); err != nil {
+			return
+		}
+		out := dk.Encode(nil)
+		if !bytes.Equal(in, out) {
+			t.Fatalf("decode/encode round trip changed the detection key:\n%x\n%x", in, out)
+		}
+		dk.Test(flag)
+	})
+}
@@ -4,5 +4,7 @@ go 1.16
 
 require (
 	github.com/gtank/ristretto255 v0.1.2
+	github.com/mattn/go-sqlite3 v1.14.17
+	github.com/miekg/pkcs11 v1.1.2
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 )
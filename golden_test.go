@@ -0,0 +1,139 @@
+package gophertags
+
+import (
+	"bytes"
+	"embed"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// The golden files in testdata/golden record, byte for byte, what this
+// package produces from fixed seeds: every key encoding, key IDs and
+// fingerprints, URIs, and flags with and without a message binding. Unlike
+// the known-answer vectors, which other implementations consume, they exist
+// to catch any change to this package's output, and are embedded in the test
+// binary so that the comparison doesn't depend on the working directory.
+//
+// Changing them breaks wire compatibility. If that is intended, regenerate
+// with: go test -run TestGolden -update-golden
+var updateGolden = flag.Bool("update-golden", false, "regenerate testdata/golden")
+
+//go:embed testdata/golden
+var goldenFiles embed.FS
+
+const goldenDir = "testdata/golden"
+
+var goldenCases = []struct {
+	name    string
+	hash    HashScheme
+	gamma   int
+	context string
+}{
+	{"sha3-gamma8", SHA3, 8, ""},
+	{"sha3-gamma24", SHA3, 24, ""},
+	{"blake2b-gamma16", BLAKE2b, 16, ""},
+	{"sha3-gamma16-context", SHA3, 16, "gophertags golden"},
+}
+
+// goldenOutput renders a case's outputs as "<name> <hex or text>" lines.
+func goldenOutput(name string, h HashScheme, gamma int, context string) []byte {
+	seed := sha3.Sum256([]byte("gophertags golden " + name))
+	sk := NewSecretKeyFromSeed(gamma, seed[:])
+	sk.hash, sk.context = h, context
+	pk := sk.PublicKey()
+	dk := sk.ExtractDetectionKey(gamma / 2)
+
+	entropy := seededReader("gophertags golden " + name + " flags")
+	f := pk.generateFlag(entropy, nil, nil)
+	msgHash := sha3.Sum256([]byte("golden message"))
+	bound := pk.generateFlag(entropy, msgHash[:], nil)
+
+	var out bytes.Buffer
+	line := func(field, value string) { fmt.Fprintf(&out, "%s %s\n", field, value) }
+	line("secret_key", hex.EncodeToString(sk.Encode(nil)))
+	line("public_key", hex.EncodeToString(pk.Encode(nil)))
+	line("detection_key", hex.EncodeToString(dk.Encode(nil)))
+	line("key_id", pk.KeyID().String())
+	line("fingerprint", pk.Fingerprint().String())
+	line("public_key_uri", pk.URI())
+	line("detection_key_uri", dk.URI())
+	line("flag", hex.EncodeToString(f.Encode(nil)))
+	line("flag_digest", fmt.Sprintf("%x", f.Digest()))
+	line("bound_flag", hex.EncodeToString(bound.Encode(nil)))
+	return out.Bytes()
+}
+
+func TestGolden(t *testing.T) {
+	for _, c := range goldenCases {
+		got := goldenOutput(c.name, c.hash, c.gamma, c.context)
+		file := path.Join(goldenDir, c.name+".golden")
+		if *updateGolden {
+			if err := ioutil.WriteFile(file, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := goldenFiles.ReadFile(file)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if bytes.Equal(got, want) {
+			continue
+		}
+		gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+		for i := range wantLines {
+			if i >= len(gotLines) || gotLines[i] != wantLines[i] {
+				field := strings.SplitN(wantLines[i], " ", 2)[0]
+				t.Errorf("%s: %s differs from %s", c.name, field, file)
+			}
+		}
+	}
+}
+
+// TestGoldenDecode checks that the golden encodings still decode to keys and
+// flags that work together, so a regenerated file can't hide a broken decoder.
+func TestGoldenDecode(t *testing.T) {
+	if *updateGolden {
+		t.Skip("the embedded golden files are being regenerated")
+	}
+	for _, c := range goldenCases {
+		data, err := goldenFiles.ReadFile(path.Join(goldenDir, c.name+".golden"))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		fields := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			parts := strings.SplitN(line, " ", 2)
+			fields[parts[0]] = parts[1]
+		}
+
+		pk := new(PublicKey).WithContext(c.context)
+		if err := pk.Decode(mustHex(t, fields["public_key"])); err != nil {
+			t.Fatalf("%s: decoding public key: %v", c.name, err)
+		}
+		dk := new(DetectionKey).WithContext(c.context)
+		if err := dk.Decode(mustHex(t, fields["detection_key"])); err != nil {
+			t.Fatalf("%s: decoding detection key: %v", c.name, err)
+		}
+		if pk.KeyID() != dk.KeyID() || pk.KeyID().String() != fields["key_id"] {
+			t.Errorf("%s: decoded keys have IDs %v and %v", c.name, pk.KeyID(), dk.KeyID())
+		}
+		f := new(Flag)
+		if err := f.Decode(mustHex(t, fields["flag"])); err != nil {
+			t.Fatalf("%s: decoding flag: %v", c.name, err)
+		}
+		if !dk.Test(f) {
+			t.Errorf("%s: golden flag doesn't match golden detection key", c.name)
+		}
+		if !bytes.Equal(f.Encode(nil), mustHex(t, fields["flag"])) {
+			t.Errorf("%s: flag doesn't round trip", c.name)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package gophertags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync"
+
+	r255 "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// HashScheme is the pair of hash functions the scheme is instantiated with:
+// H: G^3 -> {0,1}, which takes the low bit of the first byte of its digest, and
+// G: G x {0,1}^gamma -> Z_q, which reduces a 64-byte digest to a scalar.
+// Hashes not provided here, such as BLAKE3, can be plugged in by implementing
+// HashScheme and calling RegisterHashScheme.
+type HashScheme interface {
+	// ID identifies the scheme in serialized keys and flags. Zero is reserved.
+	ID() byte
+	// Name is a human-readable name for the scheme.
+	Name() string
+	// NewBitHash returns a fresh hash for H.
+	NewBitHash() hash.Hash
+	// NewScalarHash returns a fresh hash for G. Its digests must be 64 bytes.
+	NewScalarHash() hash.Hash
+}
+
+type sha3Scheme struct{}
+
+func (sha3Scheme) ID() byte                 { return 0x01 }
+func (sha3Scheme) Name() string             { return "SHA3" }
+func (sha3Scheme) NewBitHash() hash.Hash    { return sha3.New256() }
+func (sha3Scheme) NewScalarHash() hash.Hash { return sha3.New512() }
+
+type blake2bScheme struct{}
+
+func (blake2bScheme) ID() byte     { return 0x02 }
+func (blake2bScheme) Name() string { return "BLAKE2b" }
+
+func (blake2bScheme) NewBitHash() hash.Hash {
+	h, _ := blake2b.New256(nil) // only fails for oversized keys
+	return h
+}
+
+func (blake2bScheme) NewScalarHash() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+var (
+	// SHA3 is the default scheme, using SHA3-256 and SHA3-512.
+	SHA3 HashScheme = sha3Scheme{}
+	// BLAKE2b uses BLAKE2b-256 and BLAKE2b-512.
+	BLAKE2b HashScheme = blake2bScheme{}
+)
+
+var (
+	hashSchemesMu sync.RWMutex
+	hashSchemes   = map[byte]HashScheme{}
+)
+
+func init() {
+	RegisterHashScheme(SHA3)
+	RegisterHashScheme(BLAKE2b)
+}
+
+// RegisterHashScheme makes a scheme available for decoding keys and flags that
+// carry its ID. It panics if the ID is zero or already registered, or if the
+// scheme's scalar hash doesn't produce 64-byte digests.
+func RegisterHashScheme(h HashScheme) {
+	hashSchemesMu.Lock()
+	defer hashSchemesMu.Unlock()
+
+	if h.ID() == 0 {
+		panic("gophertags: hash scheme ID 0 is reserved")
+	}
+	if _, dup := hashSchemes[h.ID()]; dup {
+		panic(fmt.Sprintf("gophertags: hash scheme ID %#x registered twice", h.ID()))
+	}
+	if h.NewScalarHash().Size() != 64 {
+		panic("gophertags: hash scheme " + h.Name() + " has a scalar hash without 64-byte digests")
+	}
+	hashSchemes[h.ID()] = h
+}
+
+// LookupHashScheme returns the registered scheme with the given ID.
+func LookupHashScheme(id byte) (HashScheme, bool) {
+	hashSchemesMu.RLock()
+	defer hashSchemesMu.RUnlock()
+	h, ok := hashSchemes[id]
+	return h, ok
+}
+
+func schemeOf(h HashScheme) HashScheme {
+	if h == nil {
+		return SHA3
+	}
+	return h
+}
+
+// params are the instantiation choices shared by a key family and its flags.
+type params struct {
+	hash    HashScheme // nil means SHA3
+	context string
+	path    string // canonical DerivationPath, or "" if not path-derived
+}
+
+// Context returns the application context the key is bound to.
+func (p params) Context() string {
+	return p.context
+}
+
+// Path returns the derivation path the key was derived at, such as
+// m/ft/0'/7', or "" if it wasn't derived by NewSecretKeyFromPath nor decoded
+// from the encoding of a key that was. The path is metadata only; it doesn't
+// affect tagging or detection.
+func (p params) Path() string {
+	return p.path
+}
+
+// HashScheme returns the hash functions the key is instantiated with.
+func (p params) HashScheme() HashScheme {
+	return p.scheme()
+}
+
+func (p params) scheme() HashScheme {
+	return schemeOf(p.hash)
+}
+
+const (
+	contextLabel = "gophertags context"
+	bindingLabel = "gophertags binding"
+)
+
+// contextPrefix returns the bytes absorbed ahead of every hash input under an
+// application context. The empty context absorbs nothing, so keys without one
+// hash exactly as they did before contexts existed.
+func (p params) contextPrefix() []byte {
+	if p.context == "" {
+		return nil
+	}
+	prefix := make([]byte, len(contextLabel), len(contextLabel)+binary.MaxVarintLen64+len(p.context))
+	copy(prefix, contextLabel)
+	var length [binary.MaxVarintLen64]byte
+	prefix = append(prefix, length[:binary.PutUvarint(length[:], uint64(len(p.context)))]...)
+	return append(prefix, p.context...)
+}
+
+// bitHasher implements H: G^3 -> {0,1} for all the bits of one flag. The first and last inputs,
+// u and w, are the same for every bit, so w is encoded once and one hash state
+// is reset and reused. Cloning a state with u already absorbed would save
+// nothing: without a long context the whole input fits in a single SHA3-256
+// block, so the permutation runs once per bit either way.
+type bitHasher struct {
+	digest hash.Hash
+	prefix []byte
+	u      []byte
+	w      [elementSize]byte
+	buf    [64]byte
+}
+
+// newBitHasher returns a bitHasher for the flag element u, already encoded,
+// and w.
+func (p params) newBitHasher(u []byte, w *r255.Element) *bitHasher {
+	b := &bitHasher{digest: p.scheme().NewBitHash(), prefix: p.contextPrefix(), u: u}
+	w.Encode(b.w[:0])
+	return b
+}
+
+// bit returns H(u, rH, w).
+func (b *bitHasher) bit(rH *r255.Element) uint {
+	b.digest.Reset()
+	b.digest.Write(b.prefix)
+	b.digest.Write(b.u)
+	b.digest.Write(rH.Encode(b.buf[:0]))
+	b.digest.Write(b.w[:])
+	return uint(b.digest.Sum(b.buf[:0])[0] & 0x01)
+}
+
+// hashToScalar hashes a Ristretto element and a bit vector of gamma
+// ciphertexts to a Ristretto scalar. A non-nil binding, the message hash of a bound flag, is hashed
+// in as well.
+func (p params) hashToScalar(u []byte, bitVec *bitVector, gamma int, binding []byte) *r255.Scalar {
+	return p.hashPackedToScalar(u, appendBits(make([]byte, 0, (gamma+7)/8), bitVec, gamma), binding)
+}
+
+// hashFlagToScalar is hashToScalar for the flag's u and ciphertexts, which may
+// be borrowed packed bytes rather than a bitVector.
+func (p params) hashFlagToScalar(f *Flag, binding []byte) *r255.Scalar {
+	if f.borrowed == nil {
+		return p.hashToScalar(f.encodedU(), f.ciphertexts, f.gamma, binding)
+	}
+	// Borrowed bytes are the encoding's ciphertext field, which appendBits
+	// reproduces.
+	return p.hashPackedToScalar(f.encodedU(), f.borrowed, binding)
+}
+
+// hashPackedToScalar hashes bits already packed little-endian into exactly
+// ceil(gamma/8) bytes, zero-padded, followed by the encoding of u and, if
+// non-nil, the length-prefixed binding. The packing depends only on the bits,
+// not on the platform's word size or on how many high bits are zero.
+func (p params) hashPackedToScalar(u, byteRepr, binding []byte) *r255.Scalar {
+	digest := p.scheme().NewScalarHash()
+	digest.Write(p.contextPrefix())
+	digest.Write(byteRepr)
+	digest.Write(u)
+	if binding != nil {
+		digest.Write([]byte(bindingLabel))
+		var length [binary.MaxVarintLen64]byte
+		digest.Write(length[:binary.PutUvarint(length[:], uint64(len(binding)))])
+		digest.Write(binding)
+	}
+	return r255.NewScalar().FromUniformBytes(digest.Sum(nil))
+}
@@ -0,0 +1,114 @@
+package gophertags
+
+import (
+	"errors"
+	"testing"
+
+	r255 "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/sha3"
+)
+
+func TestHashSchemes(t *testing.T) {
+	for _, h := range []HashScheme{SHA3, BLAKE2b} {
+		sk := NewSecretKeyWithHash(16, h)
+		dk := sk.ExtractDetectionKey(16)
+
+		f := new(Flag)
+		if err := f.Decode(sk.PublicKey().GenerateFlag().Encode(nil)); err != nil {
+			t.Fatal(err)
+		}
+		if schemeOf(f.hash).ID() != h.ID() {
+			t.Errorf("%s: flag encoding lost its hash scheme", h.Name())
+		}
+		if !dk.Test(f) {
+			t.Errorf("%s: flag not detected", h.Name())
+		}
+
+		decoded := new(DetectionKey)
+		if err := decoded.Decode(dk.Encode(nil)); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.HashScheme().ID() != h.ID() {
+			t.Errorf("%s: detection key encoding lost its hash scheme", h.Name())
+		}
+	}
+}
+
+func TestHashSchemesDontMix(t *testing.T) {
+	sk := NewSecretKeyWithHash(8, BLAKE2b)
+	f := sk.PublicKey().GenerateFlag()
+
+	// The same scalars under the default scheme must not even try.
+	sha3Key := &DetectionKey{internal: sk.ExtractDetectionKey(0).internal}
+	if sha3Key.Test(f) {
+		t.Error("empty SHA3 detection key matched a BLAKE2b flag")
+	}
+
+	encoded := f.Encode(nil)
+	encoded[0] = 0xee
+	if err := new(Flag).Decode(encoded); !errors.Is(err, ErrUnknownHashScheme) {
+		t.Errorf("decoding an unregistered scheme returned %v", err)
+	}
+}
+
+func TestRegisterHashSchemeRejectsDuplicates(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering SHA3 twice didn't panic")
+		}
+	}()
+	RegisterHashScheme(SHA3)
+}
+
+func TestHashToScalarPacking(t *testing.T) {
+	u := r255.NewElement().Base()
+	for _, tc := range []struct{ gamma, topBit int }{
+		{1, 0}, {7, 6}, {8, 7}, {9, 8}, {63, 62}, {64, 63}, {65, 64}, {128, 127}, {200, 199}, {256, 255},
+		// High bits clear: the packing is still ceil(gamma/8) bytes.
+		{8, 0}, {20, 3}, {24, 15}, {64, 0}, {65, 63}, {200, 7},
+	} {
+		bitVec := new(bitVector)
+		bitVec.SetBit(bitVec, tc.topBit, 1)
+		bitVec.SetBit(bitVec, 0, 1)
+
+		// The bits are hashed as exactly ceil(gamma/8) little-endian bytes, followed by u.
+		input := make([]byte, (tc.gamma+7)/8)
+		input[0] |= 1
+		input[tc.topBit/8] |= 1 << (tc.topBit % 8)
+		digest := sha3.Sum512(u.Encode(input))
+		want := r255.NewScalar().FromUniformBytes(digest[:])
+
+		if (params{}).hashToScalar(u.Encode(nil), bitVec, tc.gamma, nil).Equal(want) != 1 {
+			t.Errorf("gamma %d, top bit %d: bits not packed into ceil(gamma/8) bytes", tc.gamma, tc.topBit)
+		}
+	}
+}
+
+// TestHashHighZeroBits checks flags whose last ciphertext byte is zero, which
+// a packing that trimmed zero bytes would hash differently, on every path that
+// derives m.
+func TestHashHighZeroBits(t *testing.T) {
+	withDeterministicRand(t, t.Name())
+	sk := NewSecretKey(20)
+	dk := sk.ExtractDetectionKey(20)
+	found := 0
+	for i := 0; i < 1000 && found < 3; i++ {
+		f := sk.PublicKey().GenerateFlag()
+		enc := f.Encode(nil)
+		if enc[len(enc)-1] != 0 {
+			continue
+		}
+		found++
+		decoded, err := DecodeFlag(enc, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		borrowed, _ := DecodeFlagBorrowed(enc, 20)
+		if !dk.Test(f) || !dk.Test(decoded) || !dk.Test(borrowed) || !dk.TestScratch(decoded, new(Scratch)) {
+			t.Errorf("flag %x with a zero last byte doesn't match on every path", enc)
+		}
+	}
+	if found == 0 {
+		t.Fatal("no flag with a zero last byte generated")
+	}
+}
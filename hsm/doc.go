@@ -0,0 +1,12 @@
+// Package hsm keeps a gophertags root seed inside a hardware security module.
+//
+// The seed is an HMAC key on the token, and a gophertags.SeedKeyStore derives
+// public and detection keys through it, so the seed never enters process
+// memory. The PKCS#11 implementation depends on cgo and github.com/miekg/pkcs11
+// and is only built with the pkcs11 build tag:
+//
+//	go build -tags pkcs11
+//
+// Its tests run against a software stand-in for a token and, if SoftHSM v2 is
+// installed, against a fresh SoftHSM token too.
+package hsm
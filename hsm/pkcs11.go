@@ -0,0 +1,104 @@
+//go:build pkcs11
+// +build pkcs11
+
+package hsm
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gtank/gophertags"
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11Seed is a gophertags.SeedPRF backed by a generic secret key on a
+// PKCS#11 token, computed with CKM_SHA512_HMAC.
+type PKCS11Seed struct {
+	mu      sync.Mutex // a PKCS#11 session runs one operation at a time
+	ctx     module
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+}
+
+// module is the part of *pkcs11.Ctx a seed uses, so tests can stand in a
+// software token.
+type module interface {
+	GenerateKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error)
+	FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error
+	FindObjects(sh pkcs11.SessionHandle, max int) ([]pkcs11.ObjectHandle, bool, error)
+	FindObjectsFinal(sh pkcs11.SessionHandle) error
+	SignInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error
+	Sign(sh pkcs11.SessionHandle, message []byte) ([]byte, error)
+}
+
+// GenerateSeed creates a new, non-extractable seed on the token under the
+// given label. The session must be logged in as the token user.
+func GenerateSeed(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (*PKCS11Seed, error) {
+	return generateSeed(ctx, session, label)
+}
+
+func generateSeed(ctx module, session pkcs11.SessionHandle, label string) (*PKCS11Seed, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, gophertags.SeedSize),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_GENERIC_SECRET_KEY_GEN, nil)}
+	key, err := ctx.GenerateKey(session, mechanism, template)
+	if err != nil {
+		return nil, err
+	}
+	return &PKCS11Seed{ctx: ctx, session: session, key: key}, nil
+}
+
+var errSeedNotFound = errors.New("hsm: no unique seed with that label")
+
+// FindSeed looks up a seed previously created by GenerateSeed.
+func FindSeed(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (*PKCS11Seed, error) {
+	return findSeed(ctx, session, label)
+}
+
+func findSeed(ctx module, session pkcs11.SessionHandle, label string) (*PKCS11Seed, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return nil, err
+	}
+	handles, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(handles) != 1 {
+		return nil, errSeedNotFound
+	}
+	return &PKCS11Seed{ctx: ctx, session: session, key: handles[0]}, nil
+}
+
+// HMACSHA512 implements gophertags.SeedPRF on the token.
+func (s *PKCS11Seed) HMACSHA512(message []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA512_HMAC, nil)}
+	if err := s.ctx.SignInit(s.session, mechanism, s.key); err != nil {
+		return nil, err
+	}
+	return s.ctx.Sign(s.session, message)
+}
+
+// KeyStore returns a gophertags.SecretKeyStore for the key of the given gamma derived from this seed.
+func (s *PKCS11Seed) KeyStore(gamma int) *gophertags.SeedKeyStore {
+	return gophertags.NewSeedKeyStore(gamma, s)
+}
+
+var _ gophertags.SeedPRF = (*PKCS11Seed)(nil)
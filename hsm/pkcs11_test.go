@@ -0,0 +1,335 @@
+//go:build pkcs11
+// +build pkcs11
+
+package hsm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gtank/gophertags"
+	"github.com/miekg/pkcs11"
+)
+
+// fakeToken is a software module with the semantics the seed relies on: one
+// search and one signing operation at a time per session, and HMAC-SHA512
+// under generated generic secrets.
+type fakeToken struct {
+	mu      sync.Mutex
+	objects map[pkcs11.ObjectHandle][]*pkcs11.Attribute
+	next    pkcs11.ObjectHandle
+	found   []pkcs11.ObjectHandle
+	finding bool
+	signing []byte // key of the active signing operation
+}
+
+func newFakeToken() *fakeToken {
+	return &fakeToken{objects: make(map[pkcs11.ObjectHandle][]*pkcs11.Attribute)}
+}
+
+func attribute(attrs []*pkcs11.Attribute, typ uint) []byte {
+	for _, a := range attrs {
+		if a.Type == typ {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+func (t *fakeToken) GenerateKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(m) != 1 || m[0].Mechanism != pkcs11.CKM_GENERIC_SECRET_KEY_GEN {
+		return 0, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	}
+	value := make([]byte, gophertags.SeedSize)
+	rand.Read(value)
+	t.next++
+	t.objects[t.next] = append(append([]*pkcs11.Attribute(nil), temp...), pkcs11.NewAttribute(pkcs11.CKA_VALUE, value))
+	return t.next, nil
+}
+
+func (t *fakeToken) FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finding {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_ACTIVE)
+	}
+	t.finding, t.found = true, nil
+	for h, attrs := range t.objects {
+		matches := true
+		for _, a := range temp {
+			matches = matches && bytes.Equal(attribute(attrs, a.Type), a.Value)
+		}
+		if matches {
+			t.found = append(t.found, h)
+		}
+	}
+	sort.Slice(t.found, func(i, j int) bool { return t.found[i] < t.found[j] })
+	return nil
+}
+
+func (t *fakeToken) FindObjects(sh pkcs11.SessionHandle, max int) ([]pkcs11.ObjectHandle, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.finding {
+		return nil, false, pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	if max > len(t.found) {
+		max = len(t.found)
+	}
+	handles := t.found[:max]
+	t.found = t.found[max:]
+	return handles, len(t.found) > 0, nil
+}
+
+func (t *fakeToken) FindObjectsFinal(sh pkcs11.SessionHandle) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.finding {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	t.finding, t.found = false, nil
+	return nil
+}
+
+func (t *fakeToken) SignInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.signing != nil {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_ACTIVE)
+	}
+	if len(m) != 1 || m[0].Mechanism != pkcs11.CKM_SHA512_HMAC {
+		return pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	}
+	attrs, ok := t.objects[o]
+	if !ok || !bytes.Equal(attribute(attrs, pkcs11.CKA_SIGN), pkcs11.NewAttribute(pkcs11.CKA_SIGN, true).Value) {
+		return pkcs11.Error(pkcs11.CKR_KEY_HANDLE_INVALID)
+	}
+	t.signing = attribute(attrs, pkcs11.CKA_VALUE)
+	return nil
+}
+
+func (t *fakeToken) Sign(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	t.mu.Lock()
+	key := t.signing
+	t.mu.Unlock()
+	if key == nil {
+		return nil, pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	runtime.Gosched() // so that unserialized callers collide
+	mac := hmac.New(sha512.New, key)
+	mac.Write(message)
+
+	t.mu.Lock()
+	t.signing = nil
+	t.mu.Unlock()
+	return mac.Sum(nil), nil
+}
+
+func TestGenerateAndFindSeed(t *testing.T) {
+	token := newFakeToken()
+	seed, err := generateSeed(token, 1, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := token.objects[seed.key]
+	for _, want := range []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, gophertags.SeedSize),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	} {
+		if got := attribute(attrs, want.Type); !bytes.Equal(got, want.Value) {
+			t.Errorf("seed attribute %#x is %x, want %x", want.Type, got, want.Value)
+		}
+	}
+
+	found, err := findSeed(token, 1, "alice")
+	if err != nil || found.key != seed.key {
+		t.Errorf("FindSeed found %v, %v; want handle %v", found, err, seed.key)
+	}
+	if _, err := findSeed(token, 1, "bob"); err != errSeedNotFound {
+		t.Errorf("FindSeed with an unknown label: got %v, want errSeedNotFound", err)
+	}
+	if _, err := generateSeed(token, 1, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findSeed(token, 1, "alice"); err != errSeedNotFound {
+		t.Errorf("FindSeed with an ambiguous label: got %v, want errSeedNotFound", err)
+	}
+	if token.finding {
+		t.Error("FindSeed left a search active")
+	}
+}
+
+func TestSeedDerivesSoftwareKeys(t *testing.T) {
+	token := newFakeToken()
+	seed, err := generateSeed(token, 1, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := attribute(token.objects[seed.key], pkcs11.CKA_VALUE)
+	software := gophertags.NewSecretKeyFromSeed(16, value)
+
+	store := seed.KeyStore(16)
+	pk, err := store.PublicKey()
+	if err != nil || !bytes.Equal(pk.Encode(nil), software.PublicKey().Encode(nil)) {
+		t.Fatalf("token-derived public key differs from the software one: %v", err)
+	}
+	dk, err := store.ExtractDetectionKey(8)
+	if err != nil || !bytes.Equal(dk.Encode(nil), software.ExtractDetectionKey(8).Encode(nil)) {
+		t.Fatalf("token-derived detection key differs from the software one: %v", err)
+	}
+
+	// The session runs one signing operation at a time, which the seed's
+	// lock must enforce for concurrent callers.
+	mac := hmac.New(sha512.New, value)
+	mac.Write([]byte("message"))
+	want := mac.Sum(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if got, err := seed.HMACSHA512([]byte("message")); err != nil || !bytes.Equal(got, want) {
+					t.Errorf("concurrent HMACSHA512: %x, %v", got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// softHSMModule returns the path of the SoftHSM v2 library, from
+// SOFTHSM2_MODULE or the usual install locations, or "" if there is none.
+func softHSMModule() string {
+	if path := os.Getenv("SOFTHSM2_MODULE"); path != "" {
+		return path
+	}
+	for _, path := range []string{
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/lib64/pkcs11/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+		"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// TestSoftHSM runs the seed against a real PKCS#11 module, on a fresh token
+// in a temporary directory. It is skipped if SoftHSM isn't installed.
+func TestSoftHSM(t *testing.T) {
+	lib := softHSMModule()
+	if lib == "" {
+		t.Skip("SoftHSM not found; set SOFTHSM2_MODULE to its library")
+	}
+	dir, err := ioutil.TempDir("", "softhsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := ioutil.WriteFile(conf, []byte("directories.tokendir = "+dir+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("SOFTHSM2_CONF", os.Getenv("SOFTHSM2_CONF"))
+	os.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(lib)
+	if ctx == nil {
+		t.Fatalf("loading %s failed", lib)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Finalize()
+
+	const soPIN, userPIN, label = "5678", "1234", "gophertags"
+	slots, err := ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("no slots: %v", err)
+	}
+	if err := ctx.InitToken(slots[0], soPIN, label); err != nil {
+		t.Fatal(err)
+	}
+	// SoftHSM moves an initialized token to a new slot.
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot, ok := uint(0), false
+	for _, s := range slots {
+		if info, err := ctx.GetTokenInfo(s); err == nil && strings.TrimSpace(info.Label) == label {
+			slot, ok = s, true
+		}
+	}
+	if !ok {
+		t.Fatal("initialized token not found")
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.CloseSession(session)
+	if err := ctx.Login(session, pkcs11.CKU_SO, soPIN); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.InitPIN(session, userPIN); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Logout(session)
+	if err := ctx.Login(session, pkcs11.CKU_USER, userPIN); err != nil {
+		t.Fatal(err)
+	}
+
+	seed, err := GenerateSeed(ctx, session, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.GetAttributeValue(session, seed.key, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)}); err == nil {
+		t.Error("the seed's value can be read off the token")
+	}
+	found, err := FindSeed(ctx, session, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := seed.HMACSHA512([]byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := found.HMACSHA512([]byte("message"))
+	if err != nil || len(a) != sha512.Size || !bytes.Equal(a, b) {
+		t.Errorf("HMACSHA512 through the found seed: %x, %v; want %x", b, err, a)
+	}
+
+	store := found.KeyStore(16)
+	pk, err := store.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dk, err := store.ExtractDetectionKey(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dk.Test(pk.GenerateFlag()) {
+		t.Error("token-derived detection key doesn't detect its public key's flags")
+	}
+}
@@ -0,0 +1,81 @@
+//go:build interop
+// +build interop
+
+package gophertags
+
+import (
+	"encoding/hex"
+	"flag"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The differential tests below cross-check this package against another
+// implementation, normally a small driver around the Rust crate `fuzzytags`.
+// They only build with the interop tag:
+//
+//	FUZZYTAGS_INTEROP_BIN=/path/to/driver go test -tags interop -run Interop
+//
+// No such driver has been run yet. Since the encodings differ from those of
+// `fuzzytags` (see encoding.go), a driver around it must translate them. It
+// speaks the wire encodings from encoding.go, hex encoded, and implements
+// three subcommands, each printing a single line to stdout:
+//
+//	keygen <gamma> <n>           prints "<public key> <detection key of precision n>"
+//	flag <public key>            prints a fresh flag for the public key
+//	test <detection key> <flag>  prints "true" or "false"
+var interopIterations = flag.Int("interop.iterations", 64, "number of random keys to cross-check")
+
+func interopDriver(t *testing.T, args ...string) string {
+	t.Helper()
+	bin := os.Getenv("FUZZYTAGS_INTEROP_BIN")
+	if bin == "" {
+		t.Skip("FUZZYTAGS_INTEROP_BIN not set")
+	}
+	out, err := exec.Command(bin, args...).Output()
+	if err != nil {
+		t.Fatalf("interop driver %v: %v", args, err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// TestInteropGoKeys checks that flags generated by the driver for our keys are detected by us.
+func TestInteropGoKeys(t *testing.T) {
+	for i := 0; i < *interopIterations; i++ {
+		gamma := 8 + i%25
+		sk := NewSecretKey(gamma)
+		dk := sk.ExtractDetectionKey(gamma)
+
+		out := interopDriver(t, "flag", hex.EncodeToString(sk.PublicKey().Encode(nil)))
+		f := new(Flag)
+		if err := f.Decode(mustHex(t, out)); err != nil {
+			t.Fatalf("gamma %d: decoding driver flag: %v", gamma, err)
+		}
+		if !dk.Test(f) {
+			t.Errorf("gamma %d: driver flag for our public key was not detected", gamma)
+		}
+	}
+}
+
+// TestInteropDriverKeys checks that flags we generate for the driver's keys are detected by it.
+func TestInteropDriverKeys(t *testing.T) {
+	for i := 0; i < *interopIterations; i++ {
+		gamma := 8 + i%25
+		fields := strings.Fields(interopDriver(t, "keygen", strconv.Itoa(gamma), strconv.Itoa(gamma)))
+		if len(fields) != 2 {
+			t.Fatalf("gamma %d: malformed keygen output %q", gamma, fields)
+		}
+
+		pk := new(PublicKey)
+		if err := pk.Decode(mustHex(t, fields[0])); err != nil {
+			t.Fatalf("gamma %d: decoding driver public key: %v", gamma, err)
+		}
+		f := hex.EncodeToString(pk.GenerateFlag().Encode(nil))
+		if interopDriver(t, "test", fields[1], f) != "true" {
+			t.Errorf("gamma %d: our flag for the driver's public key was not detected", gamma)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package gophertags
+
+import (
+	"encoding/hex"
+	"io"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// KnownAnswers is a file of known-answer vectors, in the JSON format of
+// testdata/kat.json, which this package's tests check and other
+// implementations can consume. Each vector is reproducible from its seed:
+// SHAKE256 of the seed supplies all the randomness, first gamma 64-byte seeds
+// of the secret key's scalars and then 128 bytes, for r and z, per flag.
+type KnownAnswers struct {
+	Comment string              `json:"comment"`
+	Vectors []KnownAnswerVector `json:"vectors"`
+}
+
+// KnownAnswerVector is a recipient's keys and flags, four for the recipient
+// followed by eight for another key derived from the seed plus " other".
+type KnownAnswerVector struct {
+	Seed         string            `json:"seed"`
+	HashScheme   string            `json:"hash_scheme"` // the HashScheme's Name
+	Gamma        int               `json:"gamma"`
+	PublicKey    string            `json:"public_key"`
+	DetectionKey string            `json:"detection_key"` // full precision, n = gamma
+	Flags        []KnownAnswerFlag `json:"flags"`
+}
+
+// KnownAnswerFlag is a flag and the precision at which it stops matching.
+type KnownAnswerFlag struct {
+	Flag string `json:"flag"`
+	// Precision is the largest n for which the flag matches the first n
+	// scalars of the vector's detection key.
+	Precision int `json:"precision"`
+}
+
+const knownAnswersComment = "gophertags known-answer vectors. Randomness is SHAKE256(seed): the secret key's gamma 64-byte scalar seeds, then 128 bytes (r, z) per flag. See kat_test.go."
+
+const (
+	katOwnFlags   = 4
+	katOtherFlags = 8
+)
+
+// NewKnownAnswers returns a file of the given vectors.
+func NewKnownAnswers(vectors ...KnownAnswerVector) *KnownAnswers {
+	return &KnownAnswers{Comment: knownAnswersComment, Vectors: vectors}
+}
+
+// GenerateKnownAnswerVector derives a vector from seed for keys of the given
+// scheme and gamma.
+func GenerateKnownAnswerVector(seed string, h HashScheme, gamma int) KnownAnswerVector {
+	entropy := seededReader(seed)
+	sk := newSecretKey(gamma, entropy)
+	sk.hash = h
+	pk := sk.PublicKey()
+	dk := sk.ExtractDetectionKey(gamma)
+
+	other := seededReader(seed + " other")
+	otherSK := newSecretKey(gamma, other)
+	otherSK.hash = h
+	otherPK := otherSK.PublicKey()
+
+	v := KnownAnswerVector{
+		Seed:         seed,
+		HashScheme:   schemeOf(h).Name(),
+		Gamma:        gamma,
+		PublicKey:    hex.EncodeToString(pk.Encode(nil)),
+		DetectionKey: hex.EncodeToString(dk.Encode(nil)),
+	}
+	for i := 0; i < katOwnFlags+katOtherFlags; i++ {
+		var f *Flag
+		if i < katOwnFlags {
+			f = pk.generateFlag(entropy, nil, nil)
+		} else {
+			f = otherPK.generateFlag(other, nil, nil)
+		}
+		v.Flags = append(v.Flags, KnownAnswerFlag{
+			Flag:      hex.EncodeToString(f.Encode(nil)),
+			Precision: precisionOf(sk, f),
+		})
+	}
+	return v
+}
+
+// seededReader returns a deterministic stream of pseudorandom bytes, SHAKE256(seed).
+func seededReader(seed string) io.Reader {
+	shake := sha3.NewShake256()
+	shake.Write([]byte(seed))
+	return shake
+}
+
+// precisionOf returns the largest n such that f matches sk's detection key of precision n.
+func precisionOf(sk *SecretKey, f *Flag) int {
+	n := 0
+	for n < len(sk.sk) && sk.ExtractDetectionKey(n+1).Test(f) {
+		n++
+	}
+	return n
+}
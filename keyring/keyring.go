@@ -0,0 +1,48 @@
+// Package keyring persists gophertags secret keys in the operating system's
+// credential store, for desktop clients that embed the package:
+//
+//   - macOS: the login Keychain, through /usr/bin/security
+//   - Linux and BSDs: the Secret Service (GNOME Keyring, KWallet), through secret-tool
+//   - Windows: files under the user's config directory, encrypted with DPAPI
+//
+// Each key is stored under a (service, account) pair chosen by the application.
+// The stores encrypt at rest and scope access to the logged-in user.
+package keyring
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"github.com/gtank/gophertags"
+)
+
+// ErrNotFound is returned when no key is stored under the given service and account.
+var ErrNotFound = errors.New("keyring: no key stored for this service and account")
+
+// ErrUnsupported is returned on platforms without a supported credential store.
+var ErrUnsupported = errors.New("keyring: no credential store on this platform")
+
+// Save stores the secret key under service and account, replacing any existing entry.
+// Application contexts are not stored; see gophertags.NewSecretKeyWithContext.
+func Save(service, account string, sk *gophertags.SecretKey) error {
+	secret := base64.StdEncoding.EncodeToString(sk.Encode(nil))
+	return setSecret(service, account, secret)
+}
+
+// Load retrieves the secret key stored under service and account.
+func Load(service, account string) (*gophertags.SecretKey, error) {
+	secret, err := getSecret(service, account)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+	return gophertags.DecodeSecretKey(encoded)
+}
+
+// Delete removes the entry stored under service and account.
+func Delete(service, account string) error {
+	return deleteSecret(service, account)
+}
@@ -0,0 +1,51 @@
+package keyring
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const securityCmd = "/usr/bin/security"
+
+// errItemNotFound is the exit status security(1) uses for a missing item.
+const errItemNotFound = 44
+
+func setSecret(service, account, secret string) error {
+	// Run the command through security's interactive mode so the secret is
+	// read from stdin rather than appearing in the process list.
+	cmd := exec.Command(securityCmd, "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		quote(service), quote(account), quote(secret)))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("keyring: security: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func getSecret(service, account string) (string, error) {
+	out, err := exec.Command(securityCmd, "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return "", translate(err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func deleteSecret(service, account string) error {
+	return translate(exec.Command(securityCmd, "delete-generic-password", "-s", service, "-a", account).Run())
+}
+
+func translate(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == errItemNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// quote escapes a word for security's interactive command parser.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
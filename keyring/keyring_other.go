@@ -0,0 +1,12 @@
+//go:build !darwin && !windows && (!(linux || freebsd || openbsd || netbsd || dragonfly) || android)
+// +build !darwin
+// +build !windows
+// +build !linux,!freebsd,!openbsd,!netbsd,!dragonfly android
+
+package keyring
+
+func setSecret(service, account, secret string) error { return ErrUnsupported }
+
+func getSecret(service, account string) (string, error) { return "", ErrUnsupported }
+
+func deleteSecret(service, account string) error { return ErrUnsupported }
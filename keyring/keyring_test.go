@@ -0,0 +1,39 @@
+package keyring
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+// TestRoundTrip touches the real credential store, so it only runs when
+// GOPHERTAGS_KEYRING_TEST is set.
+func TestRoundTrip(t *testing.T) {
+	if os.Getenv("GOPHERTAGS_KEYRING_TEST") == "" {
+		t.Skip("set GOPHERTAGS_KEYRING_TEST=1 to use the OS credential store")
+	}
+	const service, account = "gophertags-test", "round-trip"
+
+	sk := gophertags.NewSecretKey(8)
+	if err := Save(service, account, sk); err != nil {
+		t.Fatal(err)
+	}
+	defer Delete(service, account)
+
+	loaded, err := Load(service, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Encode(nil), sk.Encode(nil)) {
+		t.Error("loaded key differs from the saved one")
+	}
+
+	if err := Delete(service, account); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(service, account); err != ErrNotFound {
+		t.Errorf("Load after Delete returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,47 @@
+//go:build (linux || freebsd || openbsd || netbsd || dragonfly) && !android
+// +build linux freebsd openbsd netbsd dragonfly
+// +build !android
+
+package keyring
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const secretToolCmd = "secret-tool"
+
+func attributes(service, account string) []string {
+	return []string{"service", service, "account", account}
+}
+
+func setSecret(service, account, secret string) error {
+	args := append([]string{"store", "--label=" + service + " (" + account + ")"}, attributes(service, account)...)
+	cmd := exec.Command(secretToolCmd, args...)
+	// secret-tool reads the secret from stdin, keeping it out of the process list.
+	cmd.Stdin = strings.NewReader(secret)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("keyring: secret-tool: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func getSecret(service, account string) (string, error) {
+	out, err := exec.Command(secretToolCmd, append([]string{"lookup"}, attributes(service, account)...)...).Output()
+	if err != nil {
+		// secret-tool exits with status 1 and no output for a missing item.
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 && len(out) == 0 {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func deleteSecret(service, account string) error {
+	return exec.Command(secretToolCmd, append([]string{"clear"}, attributes(service, account)...)...).Run()
+}
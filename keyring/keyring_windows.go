@@ -0,0 +1,106 @@
+package keyring
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+// cryptprotectUIForbidden makes DPAPI fail rather than prompt.
+const cryptprotectUIForbidden = 0x1
+
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(b)), data: &b[0]}
+}
+
+func (b *dataBlob) bytes() []byte {
+	out := make([]byte, b.size)
+	copy(out, (*[1 << 30]byte)(unsafe.Pointer(b.data))[:b.size:b.size])
+	return out
+}
+
+func dpapi(proc *syscall.LazyProc, in []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := proc.Call(uintptr(unsafe.Pointer(newBlob(in))), 0, 0, 0, 0,
+		cryptprotectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.data)))
+	return out.bytes(), nil
+}
+
+// secretPath maps service and account to a file name that is safe whatever they contain.
+func secretPath(service, account string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	name := sha256.Sum256([]byte(service + "\x00" + account))
+	return filepath.Join(dir, "gophertags", "keyring", hex.EncodeToString(name[:])+".dpapi"), nil
+}
+
+func setSecret(service, account, secret string) error {
+	path, err := secretPath(service, account)
+	if err != nil {
+		return err
+	}
+	sealed, err := dpapi(procCryptProtectData, []byte(secret))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, sealed, 0600)
+}
+
+func getSecret(service, account string) (string, error) {
+	path, err := secretPath(service, account)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	secret, err := dpapi(procCryptUnprotectData, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func deleteSecret(service, account string) error {
+	path, err := secretPath(service, account)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return ErrNotFound
+	} else {
+		return err
+	}
+}
@@ -0,0 +1,54 @@
+package gophertags
+
+// DetectionKeySet holds several detection keys belonging to one recipient,
+// such as the old and new keys during a rotation or keys bound to different
+// contexts, and tests flags against all of them at once.
+//
+// A set is safe for concurrent use once it is no longer being added to.
+type DetectionKeySet struct {
+	keys []*DetectionKey
+}
+
+// NewDetectionKeySet returns a set holding the given keys.
+func NewDetectionKeySet(keys ...*DetectionKey) *DetectionKeySet {
+	return &DetectionKeySet{keys: append([]*DetectionKey(nil), keys...)}
+}
+
+// Add appends a key to the set and returns its index.
+func (s *DetectionKeySet) Add(dk *DetectionKey) int {
+	s.keys = append(s.keys, dk)
+	return len(s.keys) - 1
+}
+
+// Len returns the number of keys in the set.
+func (s *DetectionKeySet) Len() int {
+	return len(s.keys)
+}
+
+// Key returns the key at index i.
+func (s *DetectionKeySet) Key(i int) *DetectionKey {
+	return s.keys[i]
+}
+
+// Test tests the flag against each key in order and returns the index of the
+// first one that matches. It returns -1 and false if none does.
+func (s *DetectionKeySet) Test(f *Flag) (int, bool) {
+	for i, dk := range s.keys {
+		if dk.Test(f) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Detect implements Detector, reporting whether the flag matches any key in the set.
+func (s *DetectionKeySet) Detect(flag []byte) (bool, error) {
+	f := new(Flag)
+	if err := f.Decode(flag); err != nil {
+		return false, err
+	}
+	_, ok := s.Test(f)
+	return ok, nil
+}
+
+var _ Detector = (*DetectionKeySet)(nil)
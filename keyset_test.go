@@ -0,0 +1,25 @@
+package gophertags
+
+import "testing"
+
+func TestDetectionKeySet(t *testing.T) {
+	old, rotated := NewSecretKey(16), NewSecretKey(16)
+	bound := NewSecretKeyWithContext(16, "other app")
+
+	s := NewDetectionKeySet(old.ExtractDetectionKey(16), rotated.ExtractDetectionKey(16))
+	if i := s.Add(bound.ExtractDetectionKey(16)); i != 2 || s.Len() != 3 {
+		t.Fatalf("Add returned %d, Len %d", i, s.Len())
+	}
+
+	for want, sk := range []*SecretKey{old, rotated, bound} {
+		if i, ok := s.Test(sk.PublicKey().GenerateFlag()); !ok || i != want {
+			t.Errorf("flag for key %d matched %d, %v", want, i, ok)
+		}
+	}
+	if i, ok := s.Test(NewSecretKey(16).PublicKey().GenerateFlag()); ok || i != -1 {
+		t.Errorf("stranger's flag matched %d", i)
+	}
+	if ok, err := s.Detect(rotated.PublicKey().GenerateFlag().Encode(nil)); !ok || err != nil {
+		t.Errorf("Detect = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,96 @@
+package gophertags
+
+import (
+	r255 "github.com/gtank/ristretto255"
+)
+
+// SecretKeyStore holds a recipient's root secret and hands out only what the
+// recipient distributes: the public key and detection keys. Implementations
+// may keep the secret outside process memory entirely.
+type SecretKeyStore interface {
+	// Gamma is the maximum precision of the stored key.
+	Gamma() int
+	// PublicKey returns the stored key's public key.
+	PublicKey() (*PublicKey, error)
+	// ExtractDetectionKey returns a detection key of precision n <= Gamma().
+	ExtractDetectionKey(n int) (*DetectionKey, error)
+}
+
+var errPrecisionTooHigh = newKindError("gophertags: requested precision exceeds the key's gamma", ErrPrecisionOutOfRange)
+
+// MemoryKeyStore is a SecretKeyStore backed by a SecretKey in memory.
+type MemoryKeyStore struct {
+	Key *SecretKey
+}
+
+// Gamma implements SecretKeyStore.
+func (m MemoryKeyStore) Gamma() int {
+	return len(m.Key.sk)
+}
+
+// PublicKey implements SecretKeyStore.
+func (m MemoryKeyStore) PublicKey() (*PublicKey, error) {
+	return m.Key.PublicKey(), nil
+}
+
+// ExtractDetectionKey implements SecretKeyStore.
+func (m MemoryKeyStore) ExtractDetectionKey(n int) (*DetectionKey, error) {
+	if n < 0 || n > m.Gamma() {
+		return nil, errPrecisionTooHigh
+	}
+	return m.Key.ExtractDetectionKey(n), nil
+}
+
+// SeedKeyStore is a SecretKeyStore for seed-derived keys (see
+// NewSecretKeyFromSeed) whose seed is only reachable through a SeedPRF, such
+// as an HMAC key held in an HSM. Secret scalars exist in memory only while a
+// public or detection key is being computed; the seed never does.
+type SeedKeyStore struct {
+	prf   SeedPRF
+	gamma int
+}
+
+// NewSeedKeyStore returns a store for the key of the given gamma derived from prf's seed.
+func NewSeedKeyStore(gamma int, prf SeedPRF) *SeedKeyStore {
+	return &SeedKeyStore{prf: prf, gamma: gamma}
+}
+
+// Gamma implements SecretKeyStore.
+func (s *SeedKeyStore) Gamma() int {
+	return s.gamma
+}
+
+// PublicKey implements SecretKeyStore.
+func (s *SeedKeyStore) PublicKey() (*PublicKey, error) {
+	elements := make([]*r255.Element, s.gamma)
+	for i := range elements {
+		x, err := deriveScalar(s.prf, i)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = r255.NewElement().ScalarBaseMult(x)
+		x.Zero()
+	}
+	return &PublicKey{internal: elements}, nil
+}
+
+// ExtractDetectionKey implements SecretKeyStore.
+func (s *SeedKeyStore) ExtractDetectionKey(n int) (*DetectionKey, error) {
+	if n < 0 || n > s.gamma {
+		return nil, errPrecisionTooHigh
+	}
+	scalars := make([]*r255.Scalar, n)
+	for i := range scalars {
+		x, err := deriveScalar(s.prf, i)
+		if err != nil {
+			return nil, err
+		}
+		scalars[i] = x
+	}
+	return &DetectionKey{internal: scalars}, nil
+}
+
+var (
+	_ SecretKeyStore = MemoryKeyStore{}
+	_ SecretKeyStore = (*SeedKeyStore)(nil)
+)
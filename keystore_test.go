@@ -0,0 +1,58 @@
+package gophertags
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSeedKeyStoreMatchesSeedDerivation(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x42}, 32)
+	sk := NewSecretKeyFromSeed(16, seed)
+	store := NewSeedKeyStore(16, softwareSeed(seed))
+
+	pk, err := store.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pk.Encode(nil), sk.PublicKey().Encode(nil)) {
+		t.Error("store public key differs from the seed-derived key")
+	}
+
+	dk, err := store.ExtractDetectionKey(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dk.Encode(nil), sk.ExtractDetectionKey(5).Encode(nil)) {
+		t.Error("store detection key differs from the seed-derived key")
+	}
+	if !dk.Test(pk.GenerateFlag()) {
+		t.Error("store keys don't detect each other's flags")
+	}
+
+	if _, err := store.ExtractDetectionKey(17); err == nil {
+		t.Error("extracted a detection key above gamma")
+	}
+}
+
+func TestSeedDerivationIsPrefixStable(t *testing.T) {
+	seed := []byte("a seed of exactly thirty-two b..")
+	short, long := NewSecretKeyFromSeed(8, seed), NewSecretKeyFromSeed(24, seed)
+	if !bytes.Equal(short.ExtractDetectionKey(8).Encode(nil), long.ExtractDetectionKey(8).Encode(nil)) {
+		t.Error("seed-derived keys of different gamma disagree on their prefix")
+	}
+
+	other := append([]byte{}, seed...)
+	other[SeedSize-1] ^= 1
+	if bytes.Equal(short.Encode(nil), NewSecretKeyFromSeed(8, other).Encode(nil)) {
+		t.Error("different seeds derived the same key")
+	}
+}
+
+func TestMemoryKeyStore(t *testing.T) {
+	var store SecretKeyStore = MemoryKeyStore{NewSecretKey(8)}
+	pk, _ := store.PublicKey()
+	dk, err := store.ExtractDetectionKey(8)
+	if err != nil || !dk.Test(pk.GenerateFlag()) {
+		t.Errorf("memory store keys don't work: %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package mailbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// Messages move between stores and analysis tools as JSON Lines: one JSON
+// object per line, such as
+//
+//	{"id":7,"flag":"<base64>","payload":"<base64>","received":"2026-10-14T09:30:00Z","matches":["0123456789abcdef"]}
+//
+// Only flag is required. Matches lists the hex IDs of the keys the message
+// matched, and expires, like received, is an RFC 3339 time. Tools that only
+// need flags can write lines with nothing else.
+
+// Record is a line of a JSON Lines export: a message and the keys it matched.
+type Record struct {
+	Message
+	Matches []gophertags.KeyID
+}
+
+type jsonRecord struct {
+	ID       uint64     `json:"id,omitempty"`
+	Flag     []byte     `json:"flag"`
+	Payload  []byte     `json:"payload,omitempty"`
+	Received *time.Time `json:"received,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Matches  []string   `json:"matches,omitempty"`
+}
+
+// MarshalJSON encodes the record as a line of the export format, without
+// the trailing newline.
+func (r Record) MarshalJSON() ([]byte, error) {
+	j := jsonRecord{ID: r.ID, Flag: r.Flag, Payload: r.Payload}
+	if !r.Received.IsZero() {
+		j.Received = &r.Received
+	}
+	if !r.Expires.IsZero() {
+		j.Expires = &r.Expires
+	}
+	for _, id := range r.Matches {
+		j.Matches = append(j.Matches, id.String())
+	}
+	return json.Marshal(j)
+}
+
+// Reasons a line can be rejected by ReadRecords.
+var (
+	ErrRecordFlag    = errors.New("mailbox: record has no valid flag")
+	ErrRecordKeyID   = errors.New("mailbox: record has a malformed key ID")
+	ErrRecordExpires = errors.New("mailbox: record expires before it was received")
+	ErrRecordTooLong = errors.New("mailbox: record line too long")
+)
+
+// UnmarshalJSON decodes a line of the export format, checking that it has a
+// flag that decodes and well-formed key IDs.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var j jsonRecord
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if len(j.Flag) == 0 {
+		return ErrRecordFlag
+	}
+	if err := new(gophertags.Flag).Decode(j.Flag); err != nil {
+		return fmt.Errorf("%w: %v", ErrRecordFlag, err)
+	}
+	rec := Record{Message: Message{ID: j.ID, Flag: j.Flag, Payload: j.Payload}}
+	if j.Received != nil {
+		rec.Received = *j.Received
+	}
+	if j.Expires != nil {
+		rec.Expires = *j.Expires
+		if !rec.Received.IsZero() && rec.Expires.Before(rec.Received) {
+			return ErrRecordExpires
+		}
+	}
+	for _, s := range j.Matches {
+		var id gophertags.KeyID
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != len(id) {
+			return fmt.Errorf("%w: %q", ErrRecordKeyID, s)
+		}
+		copy(id[:], b)
+		rec.Matches = append(rec.Matches, id)
+	}
+	*r = rec
+	return nil
+}
+
+// LineError is a line ReadRecords rejected.
+type LineError struct {
+	Line int // starting from 1
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("mailbox: line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error { return e.Err }
+
+// RecordOptions configures ReadRecords.
+type RecordOptions struct {
+	// Gamma, if positive, is the gamma every flag must have.
+	Gamma int
+
+	// MaxLineSize bounds a line's length in bytes. Zero means 1 MiB.
+	MaxLineSize int
+
+	// OnError, if set, is called with each rejected line, and reading
+	// continues unless it returns an error. If nil, the first rejected line
+	// stops reading.
+	OnError func(*LineError) error
+}
+
+const defaultMaxLineSize = 1 << 20
+
+// ReadRecords reads JSON Lines records from r, validating each line, and
+// passes each valid record to fn. Blank lines are skipped. It stops at the
+// end of r, returning nil, or at the first error from r, ctx, fn or, for
+// rejected lines, opts.OnError, which is a *LineError if OnError is nil.
+func ReadRecords(ctx context.Context, r io.Reader, fn func(Record) error, opts RecordOptions) error {
+	max := opts.MaxLineSize
+	if max <= 0 {
+		max = defaultMaxLineSize
+	}
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := readLine(br, max)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != ErrRecordTooLong {
+			return err
+		}
+		var rec Record
+		if err == nil {
+			if data = bytes.TrimSpace(data); len(data) == 0 {
+				continue
+			}
+			err = decodeRecord(data, &rec, opts.Gamma)
+		}
+		if err != nil {
+			lerr := &LineError{Line: line, Err: err}
+			if opts.OnError == nil {
+				return lerr
+			}
+			if err := opts.OnError(lerr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeRecord(data []byte, rec *Record, gamma int) error {
+	if err := json.Unmarshal(data, rec); err != nil {
+		return err
+	}
+	if gamma > 0 {
+		if _, err := gophertags.DecodeFlag(rec.Flag, gamma); err != nil {
+			return fmt.Errorf("%w: %v", ErrRecordFlag, err)
+		}
+	}
+	return nil
+}
+
+// readLine returns the next line without its newline, or the final line if
+// r ends without one. A line longer than max is consumed and reported as
+// ErrRecordTooLong.
+func readLine(r *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > max+1 {
+			line = nil
+			for err == bufio.ErrBufferFull {
+				_, err = r.ReadSlice('\n')
+			}
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return nil, ErrRecordTooLong
+		}
+		line = append(line, chunk...)
+		switch err {
+		case nil:
+			return line[:len(line)-1], nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if len(line) == 0 {
+				return nil, io.EOF
+			}
+			return line, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// ErrNoLog is returned by Export for stores that don't implement Log unless
+// ExportOptions.MatchedOnly is set.
+var ErrNoLog = errors.New("mailbox: store can't list every message")
+
+// ExportOptions configures Export.
+type ExportOptions struct {
+	// Keys are the keys whose matches are recorded in each record.
+	Keys []gophertags.KeyID
+
+	// MatchedOnly exports only the messages that matched one of Keys,
+	// instead of every message in the store's Log.
+	MatchedOnly bool
+
+	// After, if set, exports only messages with greater IDs.
+	After uint64
+}
+
+// exportPage is how many messages Export asks the store for at once.
+const exportPage = 1000
+
+// Export writes the store's messages to w as JSON Lines, oldest first, each
+// with those of opts.Keys it matched, and returns how many it wrote. The
+// matches of the keys are held in memory while the messages are written.
+func Export(ctx context.Context, w io.Writer, store Store, opts ExportOptions) (int, error) {
+	log, ok := store.(Log)
+	if !ok && !opts.MatchedOnly {
+		return 0, ErrNoLog
+	}
+	matches := make(map[uint64][]gophertags.KeyID)
+	var matched []Message
+	for _, key := range opts.Keys {
+		for cursor := opts.After; ; {
+			page, err := store.MatchesSince(ctx, key, cursor, exportPage)
+			if err != nil {
+				return 0, err
+			}
+			for _, msg := range page {
+				if _, seen := matches[msg.ID]; !seen && opts.MatchedOnly {
+					matched = append(matched, msg)
+				}
+				matches[msg.ID] = append(matches[msg.ID], key)
+				cursor = msg.ID
+			}
+			if len(page) < exportPage {
+				break
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	n := 0
+	write := func(msg Message) error {
+		if err := enc.Encode(Record{Message: msg, Matches: matches[msg.ID]}); err != nil {
+			return err
+		}
+		n++
+		return nil
+	}
+	if opts.MatchedOnly {
+		sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+		for _, msg := range matched {
+			if err := write(msg); err != nil {
+				return n, err
+			}
+		}
+		return n, bw.Flush()
+	}
+	for cursor := opts.After; ; {
+		page, err := log.MessagesSince(ctx, cursor, exportPage)
+		if err != nil {
+			return n, err
+		}
+		for _, msg := range page {
+			if err := write(msg); err != nil {
+				return n, err
+			}
+			cursor = msg.ID
+		}
+		if len(page) < exportPage {
+			return n, bw.Flush()
+		}
+	}
+}
+
+// ImportStats counts the lines Import read.
+type ImportStats struct {
+	Imported int
+	Rejected int
+}
+
+// Import stores the records read from r, as by ReadRecords, with the matches
+// they list. Stores assign their own IDs, so records' IDs are ignored, while
+// their received and expiry times are kept. Rejected lines are counted and
+// passed to opts.OnError; if it is nil, the first stops the import.
+func Import(ctx context.Context, r io.Reader, store Store, opts RecordOptions) (ImportStats, error) {
+	var stats ImportStats
+	onError := opts.OnError
+	opts.OnError = func(err *LineError) error {
+		stats.Rejected++
+		if onError == nil {
+			return err
+		}
+		return onError(err)
+	}
+	err := ReadRecords(ctx, r, func(rec Record) error {
+		msg := rec.Message
+		msg.ID = 0
+		if _, err := store.Put(ctx, msg, rec.Matches); err != nil {
+			return err
+		}
+		stats.Imported++
+		return nil
+	}, opts)
+	return stats, err
+}
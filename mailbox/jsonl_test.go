@@ -0,0 +1,110 @@
+package mailbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := gophertags.KeyID{1}, gophertags.KeyID{2}
+	flag := func() []byte { return gophertags.NewSecretKey(8).PublicKey().GenerateFlag().Encode(nil) }
+	received := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.Put(ctx, Message{Flag: flag(), Payload: []byte("one"), Received: received}, []gophertags.KeyID{alice})
+	s.Put(ctx, Message{Flag: flag(), Received: received, Expires: received.Add(time.Hour)}, []gophertags.KeyID{alice, bob})
+	s.Put(ctx, Message{Flag: flag(), Payload: []byte("three"), Received: received}, nil)
+
+	var out bytes.Buffer
+	n, err := Export(ctx, &out, s, ExportOptions{Keys: []gophertags.KeyID{alice, bob}})
+	if err != nil || n != 3 || strings.Count(out.String(), "\n") != 3 {
+		t.Fatalf("Export = %d, %v:\n%s", n, err, out.String())
+	}
+	if !strings.Contains(out.String(), `"matches":["`+alice.String()+`","`+bob.String()+`"]`) {
+		t.Errorf("export lacks the second message's matches:\n%s", out.String())
+	}
+
+	imported := NewMemoryStore()
+	stats, err := Import(ctx, bytes.NewReader(out.Bytes()), imported, RecordOptions{Gamma: 8})
+	if err != nil || stats != (ImportStats{Imported: 3}) {
+		t.Fatalf("Import = %+v, %v", stats, err)
+	}
+	var again bytes.Buffer
+	Export(ctx, &again, imported, ExportOptions{Keys: []gophertags.KeyID{alice, bob}})
+	if again.String() != out.String() {
+		t.Errorf("round trip changed the export:\n%s\nwant\n%s", again.String(), out.String())
+	}
+
+	var bobs bytes.Buffer
+	if n, err := Export(ctx, &bobs, struct{ Store }{s}, ExportOptions{Keys: []gophertags.KeyID{bob}, MatchedOnly: true}); err != nil || n != 1 {
+		t.Errorf("matched-only Export = %d, %v", n, err)
+	}
+	if _, err := Export(ctx, &bobs, struct{ Store }{s}, ExportOptions{}); err != ErrNoLog {
+		t.Errorf("Export from a store without a log: %v", err)
+	}
+	var after bytes.Buffer
+	if n, _ := Export(ctx, &after, s, ExportOptions{After: 2}); n != 1 || !strings.Contains(after.String(), `"id":3`) {
+		t.Errorf("Export after 2 = %d:\n%s", n, after.String())
+	}
+}
+
+func TestReadRecordsRejects(t *testing.T) {
+	f8 := base64.StdEncoding.EncodeToString(gophertags.NewSecretKey(8).PublicKey().GenerateFlag().Encode(nil))
+	f16 := base64.StdEncoding.EncodeToString(gophertags.NewSecretKey(16).PublicKey().GenerateFlag().Encode(nil))
+	lines := []string{
+		`{"flag":"` + f8 + `"}`,
+		``,
+		`{"flag":`,
+		`{"payload":"AA=="}`,
+		`{"flag":"AAAA"}`,
+		`{"flag":"` + f16 + `"}`,
+		`{"flag":"` + f8 + `","matches":["xyz"]}`,
+		`{"flag":"` + f8 + `","received":"2026-10-14T10:00:00Z","expires":"2026-10-14T09:00:00Z"}`,
+		`{"flag":"` + f8 + `","payload":"` + strings.Repeat("A", 200) + `"}`,
+		`  {"flag":"` + f8 + `","matches":["0102030405060708"]}  `,
+	}
+	input := strings.Join(lines, "\n")
+	want := map[int]error{4: ErrRecordFlag, 5: ErrRecordFlag, 6: ErrRecordFlag, 7: ErrRecordKeyID, 8: ErrRecordExpires, 9: ErrRecordTooLong}
+
+	var got []Record
+	rejected := map[int]error{}
+	err := ReadRecords(context.Background(), strings.NewReader(input), func(r Record) error {
+		got = append(got, r)
+		return nil
+	}, RecordOptions{Gamma: 8, MaxLineSize: 180, OnError: func(err *LineError) error {
+		rejected[err.Line] = err.Err
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got[1].Matches, []gophertags.KeyID{{1, 2, 3, 4, 5, 6, 7, 8}}) {
+		t.Errorf("records = %+v", got)
+	}
+	if _, ok := rejected[3]; !ok || len(rejected) != len(want)+1 {
+		t.Errorf("rejected lines %v", rejected)
+	}
+	for line, sentinel := range want {
+		if !errors.Is(rejected[line], sentinel) {
+			t.Errorf("line %d: got %v, want %v", line, rejected[line], sentinel)
+		}
+	}
+
+	err = ReadRecords(context.Background(), strings.NewReader(input), func(Record) error { return nil }, RecordOptions{})
+	var lerr *LineError
+	if !errors.As(err, &lerr) || lerr.Line != 3 {
+		t.Errorf("ReadRecords without OnError: %v", err)
+	}
+	stats, err := Import(context.Background(), strings.NewReader(input), NewMemoryStore(), RecordOptions{Gamma: 8, MaxLineSize: 180, OnError: func(*LineError) error { return nil }})
+	if err != nil || stats != (ImportStats{Imported: 2, Rejected: 7}) {
+		t.Errorf("Import = %+v, %v", stats, err)
+	}
+}
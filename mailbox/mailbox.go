@@ -0,0 +1,192 @@
+// Package mailbox stores messages received by a detection server, along with
+// which registered detection keys each message's flag matched.
+package mailbox
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// Message is a stored message and its flag.
+type Message struct {
+	ID       uint64    // assigned by the Store, increasing in arrival order
+	Flag     []byte    // encoded flag
+	Payload  []byte    // opaque to the server
+	Received time.Time // set by the Store if zero
+	Expires  time.Time // when Prune may delete it; zero means only by retention
+}
+
+// Store persists messages and the keys they matched.
+type Store interface {
+	// Put stores the message, recording that it matched the given keys, and
+	// returns its assigned ID.
+	Put(ctx context.Context, msg Message, matches []gophertags.KeyID) (uint64, error)
+	// Matches returns the messages that matched the key, oldest first.
+	Matches(ctx context.Context, key gophertags.KeyID) ([]Message, error)
+	// MatchesSince returns at most limit messages that matched the key with
+	// IDs greater than cursor, oldest first. The last message's ID is the
+	// cursor for the next page. A limit of zero or less means no limit.
+	MatchesSince(ctx context.Context, key gophertags.KeyID, cursor uint64, limit int) ([]Message, error)
+	// Prune deletes the messages that expired by now, with their matches:
+	// those whose Expires is not after now and, if retention is positive,
+	// those received more than retention before now. It returns how many it
+	// deleted. Expired messages are returned by queries until pruned.
+	Prune(ctx context.Context, now time.Time, retention time.Duration) (int, error)
+}
+
+// Usage is how much a store holds.
+type Usage struct {
+	Messages int64 // stored messages
+	Matches  int64 // stored (key, message) matches
+	Bytes    int64 // of flags and payloads
+}
+
+// UsageReporter is implemented by stores that can report their usage, for
+// operators watching a store's growth.
+type UsageReporter interface {
+	Usage(ctx context.Context) (Usage, error)
+}
+
+// MemoryStore is a Store that keeps everything in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	messages map[uint64]Message
+	matches  map[gophertags.KeyID][]uint64
+	nextID   uint64
+	now      func() time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		messages: make(map[uint64]Message),
+		matches:  make(map[gophertags.KeyID][]uint64),
+		nextID:   1,
+		now:      time.Now,
+	}
+}
+
+// Put implements Store.
+func (m *MemoryStore) Put(ctx context.Context, msg Message, matches []gophertags.KeyID) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg.ID = m.nextID
+	m.nextID++
+	if msg.Received.IsZero() {
+		msg.Received = m.now()
+	}
+	m.messages[msg.ID] = msg
+	for _, key := range matches {
+		m.matches[key] = append(m.matches[key], msg.ID)
+	}
+	return msg.ID, nil
+}
+
+// Matches implements Store.
+func (m *MemoryStore) Matches(ctx context.Context, key gophertags.KeyID) ([]Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.collect(m.matches[key]), nil
+}
+
+// MatchesSince implements Store.
+func (m *MemoryStore) MatchesSince(ctx context.Context, key gophertags.KeyID, cursor uint64, limit int) ([]Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// IDs are appended in increasing order, so each key's list is sorted.
+	ids := m.matches[key]
+	ids = ids[sort.Search(len(ids), func(i int) bool { return ids[i] > cursor }):]
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return m.collect(ids), nil
+}
+
+// Prune implements Store.
+func (m *MemoryStore) Prune(ctx context.Context, now time.Time, retention time.Duration) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pruned := 0
+	for id, msg := range m.messages {
+		if expired(msg, now, retention) {
+			delete(m.messages, id)
+			pruned++
+		}
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+	for key, ids := range m.matches {
+		kept := ids[:0]
+		for _, id := range ids {
+			if _, ok := m.messages[id]; ok {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == 0 {
+			delete(m.matches, key)
+		} else {
+			m.matches[key] = kept
+		}
+	}
+	return pruned, nil
+}
+
+// Usage implements UsageReporter.
+func (m *MemoryStore) Usage(ctx context.Context) (Usage, error) {
+	if err := ctx.Err(); err != nil {
+		return Usage{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u := Usage{Messages: int64(len(m.messages))}
+	for _, msg := range m.messages {
+		u.Bytes += int64(len(msg.Flag) + len(msg.Payload))
+	}
+	for _, ids := range m.matches {
+		u.Matches += int64(len(ids))
+	}
+	return u, nil
+}
+
+func expired(msg Message, now time.Time, retention time.Duration) bool {
+	if !msg.Expires.IsZero() && !msg.Expires.After(now) {
+		return true
+	}
+	return retention > 0 && msg.Received.Before(now.Add(-retention))
+}
+
+// collect returns the messages with the given IDs. The caller holds m.mu.
+func (m *MemoryStore) collect(ids []uint64) []Message {
+	out := make([]Message, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, m.messages[id])
+	}
+	return out
+}
+
+var (
+	_ Store         = (*MemoryStore)(nil)
+	_ UsageReporter = (*MemoryStore)(nil)
+)
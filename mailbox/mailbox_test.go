@@ -0,0 +1,108 @@
+package mailbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// testStore checks the behavior every Store must have.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice, bob := gophertags.KeyID{1}, gophertags.KeyID{2}
+
+	first, err := s.Put(ctx, Message{Flag: []byte("f1"), Payload: []byte("one")}, []gophertags.KeyID{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := s.Put(ctx, Message{Flag: []byte("f2"), Payload: []byte("two")}, []gophertags.KeyID{alice, bob})
+	if second <= first {
+		t.Errorf("IDs not increasing: %d then %d", first, second)
+	}
+
+	got, err := s.Matches(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || string(got[0].Payload) != "one" || string(got[1].Payload) != "two" || string(got[1].Flag) != "f2" {
+		t.Errorf("alice's matches = %+v", got)
+	}
+	if got[0].ID != first || got[0].Received.IsZero() {
+		t.Errorf("stored message %+v has the wrong ID or no received time", got[0])
+	}
+	if got, _ := s.Matches(ctx, bob); len(got) != 1 || got[0].ID != second {
+		t.Errorf("bob's matches = %+v", got)
+	}
+	if got, _ := s.Matches(ctx, gophertags.KeyID{3}); len(got) != 0 {
+		t.Errorf("unknown key has matches %+v", got)
+	}
+
+	third, _ := s.Put(ctx, Message{Flag: []byte("f3"), Payload: []byte("three")}, []gophertags.KeyID{alice})
+	if got, err := s.MatchesSince(ctx, alice, 0, 2); err != nil || len(got) != 2 || got[0].ID != first || got[1].ID != second {
+		t.Errorf("alice's first page = %+v, %v", got, err)
+	}
+	if got, _ := s.MatchesSince(ctx, alice, second, 2); len(got) != 1 || got[0].ID != third || string(got[0].Payload) != "three" {
+		t.Errorf("alice's second page = %+v", got)
+	}
+	if got, _ := s.MatchesSince(ctx, alice, third, 2); len(got) != 0 {
+		t.Errorf("page past the end = %+v", got)
+	}
+	if got, _ := s.MatchesSince(ctx, alice, first, 0); len(got) != 2 {
+		t.Errorf("unlimited page = %+v", got)
+	}
+	if got, _ := s.MatchesSince(ctx, alice, ^uint64(0), 0); len(got) != 0 {
+		t.Errorf("page after the largest cursor = %+v", got)
+	}
+
+	now := time.Now()
+	expiring, _ := s.Put(ctx, Message{Flag: []byte("f4"), Expires: now.Add(-time.Second)}, []gophertags.KeyID{alice, bob})
+	old, _ := s.Put(ctx, Message{Flag: []byte("f5"), Received: now.Add(-time.Hour), Expires: now.Add(time.Hour)}, []gophertags.KeyID{bob})
+	if got, _ := s.MatchesSince(ctx, bob, second, 0); len(got) != 2 || got[0].ID != expiring || !got[0].Expires.Equal(now.Add(-time.Second)) {
+		t.Errorf("bob's unpruned matches = %+v", got)
+	}
+	if n, err := s.Prune(ctx, now, 0); err != nil || n != 1 {
+		t.Errorf("Prune without retention = %d, %v; want 1", n, err)
+	}
+	if got, _ := s.MatchesSince(ctx, bob, second, 0); len(got) != 1 || got[0].ID != old {
+		t.Errorf("bob's matches after expiry = %+v", got)
+	}
+	if n, _ := s.Prune(ctx, now, 2*time.Hour); n != 0 {
+		t.Errorf("Prune within retention deleted %d", n)
+	}
+	if n, _ := s.Prune(ctx, now, 30*time.Minute); n != 1 {
+		t.Errorf("Prune past retention deleted %d, want 1", n)
+	}
+	if got, _ := s.Matches(ctx, bob); len(got) != 1 || got[0].ID != second {
+		t.Errorf("bob's matches after pruning = %+v", got)
+	}
+	if got, _ := s.Matches(ctx, alice); len(got) != 3 {
+		t.Errorf("alice's matches after pruning = %+v", got)
+	}
+
+	if log, ok := s.(Log); ok {
+		if got, err := log.MessagesSince(ctx, first, 0); err != nil || len(got) != 2 || got[0].ID != second || got[1].ID != third {
+			t.Errorf("log after the first message = %+v, %v", got, err)
+		}
+		if got, _ := log.MessagesSince(ctx, 0, 1); len(got) != 1 || got[0].ID != first || string(got[0].Flag) != "f1" {
+			t.Errorf("first log page = %+v", got)
+		}
+		if got, _ := log.MessagesSince(ctx, ^uint64(0), 0); len(got) != 0 {
+			t.Errorf("log after the largest cursor = %+v", got)
+		}
+	}
+
+	if r, ok := s.(UsageReporter); ok {
+		// Left are f1 "one", f2 "two" and f3 "three", matching alice, and
+		// f2 matching bob.
+		want := Usage{Messages: 3, Matches: 4, Bytes: 17}
+		if got, err := r.Usage(ctx); err != nil || got != want {
+			t.Errorf("Usage = %+v, %v; want %+v", got, err, want)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
@@ -0,0 +1,213 @@
+package mailbox
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"github.com/gtank/gophertags"
+)
+
+// Oblivious message retrieval (OMR) gives each recipient its messages without
+// the server learning which they are: instead of testing flags and keeping
+// match lists, the server runs a backend that evaluates every message's clue
+// homomorphically and returns a digest, encrypted under the recipient's key,
+// from which only the recipient can decode its own payloads. Match lists
+// reveal which messages each detection key flagged, false positives and all;
+// a digest reveals nothing beyond the range of messages it covers.
+//
+// This package defines the hooks an OMR construction plugs into, not a
+// construction. A backend needs every message in a range, matched or not,
+// which both stores provide through Log, and a Retriever pages through the
+// log for it:
+//
+//	digest, err := mailbox.Retriever{Log: store, Backend: backend}.Retrieve(ctx, key, cursor)
+//
+// The recipient passes the digest to its Decoder and retrieves again from
+// digest.To.
+
+// Log is a Store's messages in arrival order, whatever they matched.
+type Log interface {
+	// MessagesSince returns at most limit messages with IDs greater than
+	// cursor, oldest first. A limit of zero or less means no limit.
+	MessagesSince(ctx context.Context, cursor uint64, limit int) ([]Message, error)
+}
+
+// RetrievalKey is a recipient's registration with an OMR backend.
+type RetrievalKey struct {
+	Scheme string           // the backend's Scheme
+	KeyID  gophertags.KeyID // names the registration
+	Data   []byte           // backend-specific, such as a clue key and an encryption key
+}
+
+// Digest is an OMR backend's result for one recipient over the messages with
+// IDs in (From, To]. Only the recipient can decode Data.
+type Digest struct {
+	Scheme   string
+	KeyID    gophertags.KeyID
+	From, To uint64
+	Data     []byte
+}
+
+// Backend computes digests for an OMR construction.
+type Backend interface {
+	// Scheme names the construction and its parameters, for example
+	// "omr-phomr-v1". Keys and digests carry it so that neither is used
+	// with the wrong backend.
+	Scheme() string
+
+	// Digest processes every message in a range, returning data that
+	// encodes, encrypted under key, the payloads of those addressed to
+	// key's recipient. Its running time and output size must not depend
+	// on which messages those are.
+	Digest(ctx context.Context, key RetrievalKey, msgs []Message) ([]byte, error)
+}
+
+// Decoder recovers a recipient's messages from its digests.
+type Decoder interface {
+	Scheme() string
+
+	// Decode returns the messages in a digest. Their IDs and flags may be
+	// unknown to the recipient, and so zero.
+	Decode(d Digest) ([]Message, error)
+}
+
+// ErrScheme is returned for keys and digests of a scheme other than the
+// backend's or decoder's.
+var ErrScheme = errors.New("mailbox: OMR scheme mismatch")
+
+// Retriever runs a Backend over a Log.
+type Retriever struct {
+	Log     Log
+	Backend Backend
+
+	// MaxMessages bounds the messages in one digest. Zero means 65536.
+	MaxMessages int
+}
+
+const defaultMaxDigestMessages = 65536
+
+// Retrieve returns the digest for key over the next messages after cursor.
+// An empty range, with From equal to To, means there are no new messages.
+func (r Retriever) Retrieve(ctx context.Context, key RetrievalKey, cursor uint64) (Digest, error) {
+	if key.Scheme != r.Backend.Scheme() {
+		return Digest{}, ErrScheme
+	}
+	limit := r.MaxMessages
+	if limit <= 0 {
+		limit = defaultMaxDigestMessages
+	}
+	msgs, err := r.Log.MessagesSince(ctx, cursor, limit)
+	if err != nil {
+		return Digest{}, err
+	}
+	d := Digest{Scheme: key.Scheme, KeyID: key.KeyID, From: cursor, To: cursor}
+	if len(msgs) == 0 {
+		return d, nil
+	}
+	if d.Data, err = r.Backend.Digest(ctx, key, msgs); err != nil {
+		return Digest{}, err
+	}
+	d.To = msgs[len(msgs)-1].ID
+	return d, nil
+}
+
+// The binary forms of keys and digests are
+//
+//	version (1 byte) || uvarint(len(scheme)) || scheme || key ID (32 bytes) || data
+//	version (1 byte) || uvarint(len(scheme)) || scheme || key ID (32 bytes) || from (8 bytes) || to (8 bytes) || data
+//
+// with integers big-endian.
+
+const omrVersion = 1
+
+// ErrOMRFormat is returned for malformed encodings of keys and digests.
+var ErrOMRFormat = errors.New("mailbox: malformed OMR key or digest")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (k RetrievalKey) MarshalBinary() ([]byte, error) {
+	return append(appendOMRHeader(nil, k.Scheme, k.KeyID), k.Data...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (k *RetrievalKey) UnmarshalBinary(data []byte) error {
+	scheme, id, rest, err := parseOMRHeader(data)
+	if err != nil {
+		return err
+	}
+	*k = RetrievalKey{Scheme: scheme, KeyID: id, Data: append([]byte(nil), rest...)}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (d Digest) MarshalBinary() ([]byte, error) {
+	out := appendOMRHeader(nil, d.Scheme, d.KeyID)
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], d.From)
+	binary.BigEndian.PutUint64(b[8:], d.To)
+	return append(append(out, b[:]...), d.Data...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (d *Digest) UnmarshalBinary(data []byte) error {
+	scheme, id, rest, err := parseOMRHeader(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) < 16 {
+		return ErrOMRFormat
+	}
+	from, to := binary.BigEndian.Uint64(rest), binary.BigEndian.Uint64(rest[8:])
+	if to < from {
+		return ErrOMRFormat
+	}
+	*d = Digest{Scheme: scheme, KeyID: id, From: from, To: to, Data: append([]byte(nil), rest[16:]...)}
+	return nil
+}
+
+func appendOMRHeader(dst []byte, scheme string, id gophertags.KeyID) []byte {
+	dst = append(dst, omrVersion)
+	var length [binary.MaxVarintLen64]byte
+	dst = append(dst, length[:binary.PutUvarint(length[:], uint64(len(scheme)))]...)
+	dst = append(dst, scheme...)
+	return append(dst, id[:]...)
+}
+
+func parseOMRHeader(data []byte) (scheme string, id gophertags.KeyID, rest []byte, err error) {
+	if len(data) == 0 || data[0] != omrVersion {
+		return "", id, nil, ErrOMRFormat
+	}
+	n, size := binary.Uvarint(data[1:])
+	if size <= 0 || n > uint64(len(data)) {
+		return "", id, nil, ErrOMRFormat
+	}
+	data = data[1+size:]
+	if uint64(len(data)) < n+uint64(len(id)) {
+		return "", id, nil, ErrOMRFormat
+	}
+	scheme = string(data[:n])
+	copy(id[:], data[n:])
+	return scheme, id, data[n+uint64(len(id)):], nil
+}
+
+// MessagesSince implements Log.
+func (m *MemoryStore) MessagesSince(ctx context.Context, cursor uint64, limit int) ([]Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var out []Message
+	for id := cursor + 1; id > cursor && id < m.nextID && (limit <= 0 || len(out) < limit); id++ {
+		if msg, ok := m.messages[id]; ok {
+			out = append(out, msg)
+		}
+	}
+	return out, nil
+}
+
+var (
+	_ Log = (*MemoryStore)(nil)
+	_ Log = (*SQLiteStore)(nil)
+)
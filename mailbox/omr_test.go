@@ -0,0 +1,90 @@
+package mailbox
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+// plainBackend stands in for an OMR construction: its "digest" is every
+// payload in the range, which is oblivious but not compact.
+type plainBackend struct{ calls int }
+
+func (*plainBackend) Scheme() string { return "test-v1" }
+
+func (b *plainBackend) Digest(ctx context.Context, key RetrievalKey, msgs []Message) ([]byte, error) {
+	b.calls++
+	var out []byte
+	for _, msg := range msgs {
+		out = append(out, byte(len(msg.Payload)))
+		out = append(out, msg.Payload...)
+	}
+	return out, nil
+}
+
+func TestRetriever(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for _, p := range []string{"one", "two", "three"} {
+		store.Put(ctx, Message{Flag: []byte("f"), Payload: []byte(p)}, nil)
+	}
+	backend := new(plainBackend)
+	r := Retriever{Log: store, Backend: backend, MaxMessages: 2}
+	key := RetrievalKey{Scheme: "test-v1", KeyID: gophertags.KeyID{1}, Data: []byte("k")}
+
+	d, err := r.Retrieve(ctx, key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.From != 0 || d.To != 2 || d.KeyID != key.KeyID || !bytes.Equal(d.Data, []byte("\x03one\x03two")) {
+		t.Errorf("first digest = %+v", d)
+	}
+	if d, _ = r.Retrieve(ctx, key, d.To); d.From != 2 || d.To != 3 || !bytes.Equal(d.Data, []byte("\x05three")) {
+		t.Errorf("second digest = %+v", d)
+	}
+	if d, _ = r.Retrieve(ctx, key, d.To); d.From != 3 || d.To != 3 || d.Data != nil || backend.calls != 2 {
+		t.Errorf("empty digest = %+v after %d backend calls", d, backend.calls)
+	}
+
+	key.Scheme = "other-v1"
+	if _, err := r.Retrieve(ctx, key, 0); err != ErrScheme {
+		t.Errorf("key for another scheme: got %v, want ErrScheme", err)
+	}
+}
+
+func TestOMREncoding(t *testing.T) {
+	key := RetrievalKey{Scheme: "test-v1", KeyID: gophertags.KeyID{1, 2}, Data: []byte("key data")}
+	enc, _ := key.MarshalBinary()
+	var gotKey RetrievalKey
+	if err := gotKey.UnmarshalBinary(enc); err != nil || !reflect.DeepEqual(gotKey, key) {
+		t.Errorf("key round trip = %+v, %v", gotKey, err)
+	}
+
+	d := Digest{Scheme: "test-v1", KeyID: gophertags.KeyID{3}, From: 10, To: 20, Data: []byte("digest")}
+	enc, _ = d.MarshalBinary()
+	var gotDigest Digest
+	if err := gotDigest.UnmarshalBinary(enc); err != nil || !reflect.DeepEqual(gotDigest, d) {
+		t.Errorf("digest round trip = %+v, %v", gotDigest, err)
+	}
+
+	for _, bad := range [][]byte{
+		nil,
+		{2},
+		{omrVersion, 0xff},
+		enc[:10],
+		enc[:len(enc)-len(d.Data)-1],
+	} {
+		if err := gotDigest.UnmarshalBinary(bad); err != ErrOMRFormat {
+			t.Errorf("UnmarshalBinary(%x) = %v, want ErrOMRFormat", bad, err)
+		}
+	}
+	backwards := d
+	backwards.From, backwards.To = 20, 10
+	enc, _ = backwards.MarshalBinary()
+	if err := gotDigest.UnmarshalBinary(enc); err != ErrOMRFormat {
+		t.Errorf("digest ending before it starts: got %v, want ErrOMRFormat", err)
+	}
+}
@@ -0,0 +1,67 @@
+package mailbox
+
+import (
+	"context"
+	"time"
+)
+
+// PrunerConfig configures a Pruner.
+type PrunerConfig struct {
+	// Retention is how long messages are kept after they are received,
+	// whatever their Expires. Zero means they are kept until they expire.
+	Retention time.Duration
+
+	// Interval is how often the store is pruned. Zero means one minute.
+	Interval time.Duration
+
+	// OnPrune, if set, is called after each pruning with the number of
+	// messages deleted.
+	OnPrune func(n int)
+}
+
+const defaultPruneInterval = time.Minute
+
+// Pruner deletes expired messages from a Store in the background, bounding
+// its size and how long anything is kept without an external job.
+type Pruner struct {
+	store  Store
+	config PrunerConfig
+	now    func() time.Time
+}
+
+// NewPruner returns a Pruner for store. Call Run to start pruning.
+func NewPruner(store Store, config PrunerConfig) *Pruner {
+	if config.Interval <= 0 {
+		config.Interval = defaultPruneInterval
+	}
+	return &Pruner{store: store, config: config, now: time.Now}
+}
+
+// Run prunes the store now and then every config.Interval until ctx is done,
+// returning ctx.Err(), or until pruning fails, returning that error.
+func (p *Pruner) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := p.Prune(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune prunes the store once, returning the number of messages deleted.
+func (p *Pruner) Prune(ctx context.Context) (int, error) {
+	n, err := p.store.Prune(ctx, p.now(), p.config.Retention)
+	if err != nil {
+		return 0, err
+	}
+	if p.config.OnPrune != nil {
+		p.config.OnPrune(n)
+	}
+	return n, nil
+}
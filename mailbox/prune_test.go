@@ -0,0 +1,56 @@
+package mailbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestPruner(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	start := time.Now()
+	store.Put(ctx, Message{Received: start}, []gophertags.KeyID{{1}})
+	store.Put(ctx, Message{Received: start, Expires: start.Add(time.Minute)}, []gophertags.KeyID{{1}})
+
+	pruned := make(chan int, 10)
+	p := NewPruner(store, PrunerConfig{Retention: time.Hour, OnPrune: func(n int) { pruned <- n }})
+	p.now = func() time.Time { return start.Add(2 * time.Minute) }
+	if n, err := p.Prune(ctx); err != nil || n != 1 || <-pruned != 1 {
+		t.Errorf("Prune past expiry = %d, %v", n, err)
+	}
+	p.now = func() time.Time { return start.Add(2 * time.Hour) }
+	if n, _ := p.Prune(ctx); n != 1 {
+		t.Errorf("Prune past retention deleted %d", n)
+	}
+	<-pruned
+	if got, _ := store.Matches(ctx, gophertags.KeyID{1}); len(got) != 0 {
+		t.Errorf("matches after pruning = %+v", got)
+	}
+}
+
+func TestPrunerRun(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(context.Background(), Message{Expires: time.Now()}, nil)
+
+	pruned := make(chan int, 10)
+	p := NewPruner(store, PrunerConfig{Interval: time.Millisecond, OnPrune: func(n int) {
+		select {
+		case pruned <- n:
+		default:
+		}
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+	if n := <-pruned; n != 1 {
+		t.Errorf("first pruning deleted %d", n)
+	}
+	<-pruned // and again on the next tick
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+}
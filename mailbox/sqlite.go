@@ -0,0 +1,263 @@
+package mailbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// migrations bring the schema from version i to i+1. Append only; never edit
+// a migration that has shipped.
+var migrations = []string{
+	`CREATE TABLE messages (
+		id       INTEGER PRIMARY KEY AUTOINCREMENT,
+		flag     BLOB NOT NULL,
+		payload  BLOB,
+		received INTEGER NOT NULL -- Unix nanoseconds
+	);
+	CREATE TABLE matches (
+		key_id     BLOB NOT NULL,
+		message_id INTEGER NOT NULL REFERENCES messages (id),
+		PRIMARY KEY (key_id, message_id)
+	) WITHOUT ROWID;`,
+	`ALTER TABLE messages ADD COLUMN expires INTEGER; -- Unix nanoseconds, or NULL
+	CREATE INDEX messages_expires ON messages (expires) WHERE expires IS NOT NULL;
+	CREATE INDEX messages_received ON messages (received);
+	CREATE INDEX matches_message ON matches (message_id);`,
+}
+
+// SQLiteStore is a Store kept in a SQLite database, the default persistent
+// backend for small deployments. It works with any database/sql SQLite
+// driver, which the caller registers and opens.
+//
+// Matches are indexed by key, so querying one recipient doesn't scan other
+// recipients' traffic. Use PutBatch to insert many messages in one
+// transaction, which keeps WAL checkpoints cheap under load.
+type SQLiteStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// OpenSQLiteStore prepares db for use as a mailbox, creating or migrating the
+// schema as needed and switching the database to WAL journaling. The caller
+// keeps ownership of db.
+func OpenSQLiteStore(ctx context.Context, db *sql.DB) (*SQLiteStore, error) {
+	if _, err := db.ExecContext(ctx, `PRAGMA journal_mode = WAL`); err != nil {
+		return nil, fmt.Errorf("mailbox: enabling WAL: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return nil, fmt.Errorf("mailbox: creating schema_version: %v", err)
+	}
+	var version int
+	err := db.QueryRowContext(ctx, `SELECT version FROM schema_version`).Scan(&version)
+	if err == sql.ErrNoRows {
+		if _, err := db.ExecContext(ctx, `INSERT INTO schema_version (version) VALUES (0)`); err != nil {
+			return nil, fmt.Errorf("mailbox: initializing schema_version: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("mailbox: reading schema version: %v", err)
+	}
+	if version > len(migrations) {
+		return nil, fmt.Errorf("mailbox: database schema version %d is newer than this package supports (%d)", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		if err := migrate(ctx, db, version); err != nil {
+			return nil, fmt.Errorf("mailbox: migrating schema to version %d: %v", version+1, err)
+		}
+	}
+	return &SQLiteStore{db: db, now: time.Now}, nil
+}
+
+func migrate(ctx context.Context, db *sql.DB, from int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, migrations[from]); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE schema_version SET version = ?`, from+1); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Entry is a message and the keys it matched, for PutBatch.
+type Entry struct {
+	Message Message
+	Matches []gophertags.KeyID
+}
+
+// Put implements Store.
+func (s *SQLiteStore) Put(ctx context.Context, msg Message, matches []gophertags.KeyID) (uint64, error) {
+	ids, err := s.PutBatch(ctx, []Entry{{msg, matches}})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// PutBatch stores the entries in a single transaction and returns their IDs.
+// Either every entry is stored or none is.
+func (s *SQLiteStore) PutBatch(ctx context.Context, entries []Entry) ([]uint64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insertMessage, err := tx.PrepareContext(ctx, `INSERT INTO messages (flag, payload, received, expires) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	defer insertMessage.Close()
+	insertMatch, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO matches (key_id, message_id) VALUES (?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	defer insertMatch.Close()
+
+	now := s.now()
+	ids := make([]uint64, len(entries))
+	for i, e := range entries {
+		received := e.Message.Received
+		if received.IsZero() {
+			received = now
+		}
+		var expires sql.NullInt64
+		if !e.Message.Expires.IsZero() {
+			expires = sql.NullInt64{Int64: e.Message.Expires.UnixNano(), Valid: true}
+		}
+		result, err := insertMessage.ExecContext(ctx, e.Message.Flag, e.Message.Payload, received.UnixNano(), expires)
+		if err != nil {
+			return nil, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range e.Matches {
+			if _, err := insertMatch.ExecContext(ctx, key[:], id); err != nil {
+				return nil, err
+			}
+		}
+		ids[i] = uint64(id)
+	}
+	return ids, tx.Commit()
+}
+
+// Matches implements Store.
+func (s *SQLiteStore) Matches(ctx context.Context, key gophertags.KeyID) ([]Message, error) {
+	return s.MatchesSince(ctx, key, 0, 0)
+}
+
+// MatchesSince implements Store. The matches table's primary key orders each
+// key's messages by ID, so a page costs the same however far in it starts.
+func (s *SQLiteStore) MatchesSince(ctx context.Context, key gophertags.KeyID, cursor uint64, limit int) ([]Message, error) {
+	if cursor > math.MaxInt64 {
+		return nil, nil // past every SQLite rowid
+	}
+	if limit <= 0 {
+		limit = -1 // no limit, to SQLite
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.id, m.flag, m.payload, m.received, m.expires
+		FROM matches AS k JOIN messages AS m ON m.id = k.message_id
+		WHERE k.key_id = ? AND k.message_id > ?
+		ORDER BY k.message_id
+		LIMIT ?`, key[:], int64(cursor), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// MessagesSince implements Log.
+func (s *SQLiteStore) MessagesSince(ctx context.Context, cursor uint64, limit int) ([]Message, error) {
+	if cursor > math.MaxInt64 {
+		return nil, nil // past every SQLite rowid
+	}
+	if limit <= 0 {
+		limit = -1 // no limit, to SQLite
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, flag, payload, received, expires FROM messages
+		WHERE id > ? ORDER BY id LIMIT ?`, int64(cursor), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// Prune implements Store. Messages are indexed by expiry and by received
+// time, so pruning costs in proportion to what it deletes.
+func (s *SQLiteStore) Prune(ctx context.Context, now time.Time, retention time.Duration) (int, error) {
+	cutoff := int64(math.MinInt64) // no retention: nothing is received before
+	if retention > 0 {
+		cutoff = now.Add(-retention).UnixNano()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const expired = `SELECT id FROM messages WHERE expires <= ?1 UNION SELECT id FROM messages WHERE received < ?2`
+	if _, err := tx.ExecContext(ctx, `DELETE FROM matches WHERE message_id IN (`+expired+`)`, now.UnixNano(), cutoff); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE id IN (`+expired+`)`, now.UnixNano(), cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// Usage implements UsageReporter. Bytes counts the flags and payloads, not
+// the database's own overhead, which the file size shows.
+func (s *SQLiteStore) Usage(ctx context.Context) (Usage, error) {
+	var u Usage
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(LENGTH(flag) + COALESCE(LENGTH(payload), 0)), 0) FROM messages`).Scan(&u.Messages, &u.Bytes)
+	if err != nil {
+		return Usage{}, err
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM matches`).Scan(&u.Matches); err != nil {
+		return Usage{}, err
+	}
+	return u, nil
+}
+
+// scanMessages reads rows of id, flag, payload, received and expires.
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+	var out []Message
+	for rows.Next() {
+		var msg Message
+		var received int64
+		var expires sql.NullInt64
+		if err := rows.Scan(&msg.ID, &msg.Flag, &msg.Payload, &received, &expires); err != nil {
+			return nil, err
+		}
+		msg.Received = time.Unix(0, received)
+		if expires.Valid {
+			msg.Expires = time.Unix(0, expires.Int64)
+		}
+		out = append(out, msg)
+	}
+	return out, rows.Err()
+}
+
+var (
+	_ Store         = (*SQLiteStore)(nil)
+	_ UsageReporter = (*SQLiteStore)(nil)
+)
@@ -0,0 +1,72 @@
+//go:build cgo
+// +build cgo
+
+package mailbox
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/gtank/gophertags"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), "mailbox.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func TestSQLiteStore(t *testing.T) {
+	db, _ := openTestDB(t)
+	s, err := OpenSQLiteStore(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
+
+func TestSQLiteStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	db, path := openTestDB(t)
+	s, err := OpenSQLiteStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := s.PutBatch(ctx, []Entry{
+		{Message{Flag: []byte("a")}, []gophertags.KeyID{{1}}},
+		{Message{Flag: []byte("b")}, []gophertags.KeyID{{1}, {2}}},
+	})
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("PutBatch = %v, %v", ids, err)
+	}
+	db.Close()
+
+	// Opening an existing database skips applied migrations and keeps the data.
+	db, err = sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s, err = OpenSQLiteStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Matches(ctx, gophertags.KeyID{1}); err != nil || len(got) != 2 {
+		t.Errorf("matches after reopening: %+v, %v", got, err)
+	}
+
+	// Databases from a newer version of the package are refused.
+	if _, err := db.Exec(`UPDATE schema_version SET version = ?`, len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSQLiteStore(ctx, db); err == nil {
+		t.Error("opened a database with a newer schema")
+	}
+}
@@ -0,0 +1,133 @@
+// Package mobile wraps gophertags for iOS and Android apps built with
+// gomobile bind:
+//
+//	gomobile bind -target=android github.com/gtank/gophertags/mobile
+//
+// gomobile can only export a few kinds of types, so keys are opaque handles
+// with byte slice encodings, flags are passed as their encodings, and
+// invalid arguments are reported as errors rather than panics, which would
+// crash the app.
+package mobile
+
+import (
+	"errors"
+
+	"github.com/gtank/gophertags"
+)
+
+var (
+	errGamma     = errors.New("mobile: gamma must be positive")
+	errPrecision = errors.New("mobile: precision must not be negative")
+)
+
+// SecretKey is a recipient's secret key.
+type SecretKey struct {
+	sk *gophertags.SecretKey
+}
+
+// NewSecretKey generates a secret key with a false positive rate of at least
+// 2^-gamma.
+func NewSecretKey(gamma int) (*SecretKey, error) {
+	if gamma <= 0 {
+		return nil, errGamma
+	}
+	return &SecretKey{gophertags.NewSecretKey(gamma)}, nil
+}
+
+// DecodeSecretKey decodes a secret key from Encode.
+func DecodeSecretKey(b []byte) (*SecretKey, error) {
+	sk, err := gophertags.DecodeSecretKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretKey{sk}, nil
+}
+
+// Encode returns the key's encoding. Store it in the platform keychain.
+func (k *SecretKey) Encode() []byte {
+	return k.sk.Encode(nil)
+}
+
+// PublicKey returns the public key to give to senders.
+func (k *SecretKey) PublicKey() *PublicKey {
+	return &PublicKey{k.sk.PublicKey()}
+}
+
+// DetectionKey returns a detection key with false positive rate 2^-precision,
+// to register with a detection server.
+func (k *SecretKey) DetectionKey(precision int) (*DetectionKey, error) {
+	if precision < 0 {
+		return nil, errPrecision
+	}
+	// A policy allowing only precision fails, rather than panics, if it
+	// exceeds gamma.
+	dk, err := k.sk.NegotiateDetectionKey(gophertags.PrecisionPolicy{Min: precision, Max: precision}, precision)
+	if err != nil {
+		return nil, err
+	}
+	return &DetectionKey{dk}, nil
+}
+
+// PublicKey is a recipient's public key.
+type PublicKey struct {
+	pk *gophertags.PublicKey
+}
+
+// DecodePublicKey decodes a public key from Encode.
+func DecodePublicKey(b []byte) (*PublicKey, error) {
+	pk, err := gophertags.DecodePublicKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{pk}, nil
+}
+
+// Encode returns the key's encoding.
+func (k *PublicKey) Encode() []byte {
+	return k.pk.Encode(nil)
+}
+
+// KeyID returns the ID a detection server knows the recipient's keys by.
+func (k *PublicKey) KeyID() []byte {
+	id := k.pk.KeyID()
+	return id[:]
+}
+
+// GenerateFlag returns the encoding of a new flag for the key, to attach to
+// a message for the recipient.
+func (k *PublicKey) GenerateFlag() []byte {
+	return k.pk.GenerateFlag().Encode(nil)
+}
+
+// DetectionKey is a recipient's detection key.
+type DetectionKey struct {
+	dk *gophertags.DetectionKey
+}
+
+// DecodeDetectionKey decodes a detection key from Encode.
+func DecodeDetectionKey(b []byte) (*DetectionKey, error) {
+	dk, err := gophertags.DecodeDetectionKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return &DetectionKey{dk}, nil
+}
+
+// Encode returns the key's encoding.
+func (k *DetectionKey) Encode() []byte {
+	return k.dk.Encode(nil)
+}
+
+// Precision returns n for a key with false positive rate 2^-n.
+func (k *DetectionKey) Precision() int {
+	return k.dk.Precision()
+}
+
+// Test reports whether the encoded flag matches the key.
+func (k *DetectionKey) Test(flag []byte) (bool, error) {
+	f := new(gophertags.Flag)
+	if err := f.Decode(flag); err != nil {
+		return false, err
+	}
+	return k.dk.Test(f), nil
+}
@@ -0,0 +1,54 @@
+package mobile
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMobile(t *testing.T) {
+	sk, err := NewSecretKey(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err = DecodeSecretKey(sk.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := DecodePublicKey(sk.PublicKey().Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dk, err := sk.DetectionKey(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dk, err = DecodeDetectionKey(dk.Encode())
+	if err != nil || dk.Precision() != 8 {
+		t.Fatalf("decoded detection key: precision %d, %v", dk.Precision(), err)
+	}
+	if !bytes.Equal(pk.KeyID(), sk.PublicKey().KeyID()) {
+		t.Error("key IDs differ after decoding")
+	}
+
+	if ok, err := dk.Test(pk.GenerateFlag()); !ok || err != nil {
+		t.Errorf("Test = %v, %v", ok, err)
+	}
+	if _, err := dk.Test([]byte{1, 2, 3}); err == nil {
+		t.Error("malformed flag accepted")
+	}
+}
+
+func TestMobileRejectsBadArguments(t *testing.T) {
+	if _, err := NewSecretKey(0); err == nil {
+		t.Error("gamma 0 accepted")
+	}
+	sk, _ := NewSecretKey(4)
+	for _, n := range []int{-1, 5} {
+		if _, err := sk.DetectionKey(n); err == nil {
+			t.Errorf("precision %d accepted for gamma 4", n)
+		}
+	}
+	if _, err := DecodeSecretKey(nil); err == nil {
+		t.Error("empty secret key accepted")
+	}
+}
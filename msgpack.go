@@ -0,0 +1,179 @@
+package gophertags
+
+import (
+	"encoding/binary"
+)
+
+// Keys and flags encode to MessagePack as a single bin object holding their
+// wire encoding. The methods below satisfy the interfaces MessagePack
+// libraries look for without importing any of them:
+//
+//	MarshalMsgpack / UnmarshalMsgpack    github.com/vmihailenco/msgpack
+//	MarshalMsg / UnmarshalMsg / Msgsize  github.com/tinylib/msgp
+//
+// Libraries that fall back on encoding.BinaryMarshaler, such as
+// github.com/ugorji/go/codec, produce the same bin objects.
+
+// ErrMsgpack is returned when MessagePack input isn't a single well-formed bin object.
+var ErrMsgpack = newKindError("gophertags: expected a MessagePack bin object", ErrInvalidEncoding)
+
+const (
+	msgpackBin8  = 0xc4
+	msgpackBin16 = 0xc5
+	msgpackBin32 = 0xc6
+)
+
+func msgpackBinSize(n int) int {
+	switch {
+	case n <= 0xff:
+		return 2 + n
+	case n <= 0xffff:
+		return 3 + n
+	default:
+		return 5 + n
+	}
+}
+
+func appendMsgpackBin(b, data []byte) []byte {
+	switch n := len(data); {
+	case n <= 0xff:
+		b = append(b, msgpackBin8, byte(n))
+	case n <= 0xffff:
+		b = append(b, msgpackBin16, byte(n>>8), byte(n))
+	default:
+		b = append(b, msgpackBin32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, data...)
+}
+
+// readMsgpackBin splits a bin object off the front of b.
+func readMsgpackBin(b []byte) (data, rest []byte, err error) {
+	if len(b) < 2 {
+		return nil, nil, ErrMsgpack
+	}
+	var n, header int
+	switch b[0] {
+	case msgpackBin8:
+		n, header = int(b[1]), 2
+	case msgpackBin16:
+		if len(b) < 3 {
+			return nil, nil, ErrMsgpack
+		}
+		n, header = int(binary.BigEndian.Uint16(b[1:])), 3
+	case msgpackBin32:
+		if len(b) < 5 {
+			return nil, nil, ErrMsgpack
+		}
+		n, header = int(binary.BigEndian.Uint32(b[1:])), 5
+	default:
+		return nil, nil, ErrMsgpack
+	}
+	if n < 0 || len(b)-header < n {
+		return nil, nil, ErrMsgpack
+	}
+	return b[header : header+n], b[header+n:], nil
+}
+
+// unmarshalMsgpack decodes a lone bin object with decode.
+func unmarshalMsgpack(b []byte, decode func([]byte) error) error {
+	data, rest, err := readMsgpackBin(b)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return ErrMsgpack
+	}
+	return decode(data)
+}
+
+// unmarshalMsg decodes the bin object at the front of b with decode and returns the rest.
+func unmarshalMsg(b []byte, decode func([]byte) error) ([]byte, error) {
+	data, rest, err := readMsgpackBin(b)
+	if err != nil {
+		return b, err
+	}
+	if err := decode(data); err != nil {
+		return b, err
+	}
+	return rest, nil
+}
+
+// MarshalMsgpack encodes f as a MessagePack bin object.
+func (f *Flag) MarshalMsgpack() ([]byte, error) { return f.MarshalMsg(nil) }
+
+// UnmarshalMsgpack decodes a MessagePack bin object into f, as Decode does.
+func (f *Flag) UnmarshalMsgpack(b []byte) error { return unmarshalMsgpack(b, f.Decode) }
+
+// MarshalMsg appends f to b as a MessagePack bin object.
+func (f *Flag) MarshalMsg(b []byte) ([]byte, error) { return appendMsgpackBin(b, f.Encode(nil)), nil }
+
+// UnmarshalMsg decodes the MessagePack bin object at the front of b into f
+// and returns the remaining bytes.
+func (f *Flag) UnmarshalMsg(b []byte) ([]byte, error) { return unmarshalMsg(b, f.Decode) }
+
+// Msgsize returns the length of f's MessagePack encoding.
+func (f *Flag) Msgsize() int { return msgpackBinSize(FlagSize(f.gamma)) }
+
+// MarshalMsgpack encodes pk as a MessagePack bin object.
+func (pk *PublicKey) MarshalMsgpack() ([]byte, error) { return pk.MarshalMsg(nil) }
+
+// UnmarshalMsgpack decodes a MessagePack bin object into pk, as Decode does.
+func (pk *PublicKey) UnmarshalMsgpack(b []byte) error { return unmarshalMsgpack(b, pk.Decode) }
+
+// MarshalMsg appends pk to b as a MessagePack bin object.
+func (pk *PublicKey) MarshalMsg(b []byte) ([]byte, error) {
+	return appendMsgpackBin(b, pk.Encode(nil)), nil
+}
+
+// UnmarshalMsg decodes the MessagePack bin object at the front of b into pk
+// and returns the remaining bytes.
+func (pk *PublicKey) UnmarshalMsg(b []byte) ([]byte, error) { return unmarshalMsg(b, pk.Decode) }
+
+// Msgsize returns the length of pk's MessagePack encoding.
+func (pk *PublicKey) Msgsize() int {
+	return msgpackBinSize(PublicKeySize(len(pk.internal)) + pathSize(pk.path))
+}
+
+// MarshalMsgpack encodes dk as a MessagePack bin object.
+func (dk *DetectionKey) MarshalMsgpack() ([]byte, error) { return dk.MarshalMsg(nil) }
+
+// UnmarshalMsgpack decodes a MessagePack bin object into dk, as Decode does.
+func (dk *DetectionKey) UnmarshalMsgpack(b []byte) error { return unmarshalMsgpack(b, dk.Decode) }
+
+// MarshalMsg appends dk to b as a MessagePack bin object.
+func (dk *DetectionKey) MarshalMsg(b []byte) ([]byte, error) {
+	return appendMsgpackBin(b, dk.Encode(nil)), nil
+}
+
+// UnmarshalMsg decodes the MessagePack bin object at the front of b into dk
+// and returns the remaining bytes.
+func (dk *DetectionKey) UnmarshalMsg(b []byte) ([]byte, error) { return unmarshalMsg(b, dk.Decode) }
+
+// Msgsize returns the length of dk's MessagePack encoding.
+func (dk *DetectionKey) Msgsize() int {
+	size := DetectionKeySize(len(dk.internal))
+	if dk.threshold != 0 {
+		size += rateSize
+	}
+	return msgpackBinSize(size + len(dk.watermark) + pathSize(dk.path))
+}
+
+// MarshalMsgpack encodes sk as a MessagePack bin object.
+func (sk *SecretKey) MarshalMsgpack() ([]byte, error) { return sk.MarshalMsg(nil) }
+
+// UnmarshalMsgpack decodes a MessagePack bin object into sk, as Decode does.
+func (sk *SecretKey) UnmarshalMsgpack(b []byte) error { return unmarshalMsgpack(b, sk.Decode) }
+
+// MarshalMsg appends sk to b as a MessagePack bin object.
+func (sk *SecretKey) MarshalMsg(b []byte) ([]byte, error) {
+	return appendMsgpackBin(b, sk.Encode(nil)), nil
+}
+
+// UnmarshalMsg decodes the MessagePack bin object at the front of b into sk
+// and returns the remaining bytes.
+func (sk *SecretKey) UnmarshalMsg(b []byte) ([]byte, error) { return unmarshalMsg(b, sk.Decode) }
+
+// Msgsize returns the length of sk's MessagePack encoding.
+func (sk *SecretKey) Msgsize() int {
+	return msgpackBinSize(SecretKeySize(len(sk.sk)) + pathSize(sk.path))
+}
@@ -0,0 +1,74 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type msgpackValue interface {
+	MarshalMsgpack() ([]byte, error)
+	MarshalMsg([]byte) ([]byte, error)
+	Msgsize() int
+	MarshalBinary() ([]byte, error)
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	sk := NewSecretKey(24)
+	pk, dk := sk.PublicKey(), sk.ExtractDetectionKey(3)
+	flag := pk.GenerateFlag()
+
+	for name, tc := range map[string]struct {
+		v      msgpackValue
+		header []byte
+		decode func([]byte) ([]byte, error)
+	}{
+		"flag":          {flag, []byte{0xc4, byte(FlagSize(24))}, new(Flag).UnmarshalMsg},
+		"public key":    {pk, []byte{0xc5, byte(PublicKeySize(24) >> 8), byte(PublicKeySize(24))}, new(PublicKey).UnmarshalMsg},
+		"detection key": {dk, []byte{0xc4, byte(DetectionKeySize(3))}, new(DetectionKey).UnmarshalMsg},
+		"secret key":    {sk, []byte{0xc5, byte(SecretKeySize(24) >> 8), byte(SecretKeySize(24))}, new(SecretKey).UnmarshalMsg},
+	} {
+		encoded, _ := tc.v.MarshalMsgpack()
+		binary, _ := tc.v.MarshalBinary()
+		if want := append(tc.header, binary...); !bytes.Equal(encoded, want) {
+			t.Errorf("%s: not a bin object holding the wire encoding", name)
+		}
+		if len(encoded) != tc.v.Msgsize() {
+			t.Errorf("%s: Msgsize %d, encoding is %d bytes", name, tc.v.Msgsize(), len(encoded))
+		}
+
+		// MarshalMsg appends, and UnmarshalMsg returns what follows.
+		stream, _ := tc.v.MarshalMsg([]byte{0x01})
+		rest, err := tc.decode(append(stream[1:], 0x02))
+		if err != nil || !bytes.Equal(rest, []byte{0x02}) {
+			t.Errorf("%s: UnmarshalMsg = %x, %v", name, rest, err)
+		}
+	}
+
+	got := new(Flag)
+	encoded, _ := flag.MarshalMsgpack()
+	if err := got.UnmarshalMsgpack(encoded); err != nil || got.Digest() != flag.Digest() {
+		t.Errorf("flag round trip: %v", err)
+	}
+}
+
+func TestMsgpackRejectsMalformed(t *testing.T) {
+	encoded, _ := NewSecretKey(8).ExtractDetectionKey(2).MarshalMsgpack()
+	for name, in := range map[string][]byte{
+		"empty":          nil,
+		"not bin":        append([]byte{0xd9}, encoded[1:]...),
+		"truncated":      encoded[:len(encoded)-1],
+		"trailing bytes": append(encoded, 0),
+	} {
+		if err := new(DetectionKey).UnmarshalMsgpack(in); !errors.Is(err, ErrMsgpack) {
+			t.Errorf("%s: got %v, want ErrMsgpack", name, err)
+		}
+	}
+
+	// Invalid contents are reported by the wire decoder.
+	bad := append([]byte(nil), encoded...)
+	bad[2] = 0
+	if err := new(DetectionKey).UnmarshalMsgpack(bad); !errors.Is(err, ErrUnknownHashScheme) {
+		t.Errorf("bad scheme ID: got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package gophertags
+
+// The Must functions are for tests, tools and package-level variables holding
+// known-good values, where a failure is a bug and panicking is the right
+// response. They panic with the error their counterpart returns, so a
+// recovered value can still be tested with errors.Is. Application code
+// handling untrusted input should call the counterparts and check their
+// errors. Key generation already panics rather than return an error, so
+// NewSecretKey needs no Must form.
+
+// MustDecodeFlag is like DecodeFlag but panics if the flag doesn't decode.
+func MustDecodeFlag(in []byte, gamma int) *Flag {
+	f, err := DecodeFlag(in, gamma)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// MustDecodePublicKey is like DecodePublicKey but panics if the key doesn't decode.
+func MustDecodePublicKey(in []byte) *PublicKey {
+	pk, err := DecodePublicKey(in)
+	if err != nil {
+		panic(err)
+	}
+	return pk
+}
+
+// MustDecodeDetectionKey is like DecodeDetectionKey but panics if the key doesn't decode.
+func MustDecodeDetectionKey(in []byte) *DetectionKey {
+	dk, err := DecodeDetectionKey(in)
+	if err != nil {
+		panic(err)
+	}
+	return dk
+}
+
+// MustDecodeSecretKey is like DecodeSecretKey but panics if the key doesn't decode.
+func MustDecodeSecretKey(in []byte) *SecretKey {
+	sk, err := DecodeSecretKey(in)
+	if err != nil {
+		panic(err)
+	}
+	return sk
+}
+
+// MustParseDerivationPath is like ParseDerivationPath but panics if the path
+// is malformed. It simplifies initializing variables with literal paths.
+func MustParseDerivationPath(s string) DerivationPath {
+	path, err := ParseDerivationPath(s)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
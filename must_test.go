@@ -0,0 +1,38 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMust(t *testing.T) {
+	sk := NewSecretKey(8)
+	pk, dk := sk.PublicKey(), sk.ExtractDetectionKey(4)
+	flag := pk.GenerateFlag().Encode(nil)
+
+	if !bytes.Equal(MustDecodeFlag(flag, 8).Encode(nil), flag) ||
+		!bytes.Equal(MustDecodePublicKey(pk.Encode(nil)).Encode(nil), pk.Encode(nil)) ||
+		!bytes.Equal(MustDecodeDetectionKey(dk.Encode(nil)).Encode(nil), dk.Encode(nil)) ||
+		!bytes.Equal(MustDecodeSecretKey(sk.Encode(nil)).Encode(nil), sk.Encode(nil)) {
+		t.Error("Must functions changed their input")
+	}
+	if MustParseDerivationPath("m/ft/1'/2'").String() != "m/ft/1'/2'" {
+		t.Error("MustParseDerivationPath changed its input")
+	}
+
+	mustPanic := func(name string, want error, f func()) {
+		t.Helper()
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, want) {
+				t.Errorf("%s: panicked with %v, want %v", name, err, want)
+			}
+		}()
+		f()
+	}
+	mustPanic("flag", ErrLength, func() { MustDecodeFlag(flag[:10], 8) })
+	mustPanic("public key", ErrLength, func() { MustDecodePublicKey(pk.Encode(nil)[:20]) })
+	mustPanic("detection key", ErrInvalidEncoding, func() { MustDecodeDetectionKey(nil) })
+	mustPanic("secret key", ErrInvalidEncoding, func() { MustDecodeSecretKey([]byte{0}) })
+	mustPanic("derivation path", ErrDerivationPath, func() { MustParseDerivationPath("x") })
+}
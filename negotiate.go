@@ -0,0 +1,74 @@
+package gophertags
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PrecisionPolicy is the range of detection key precisions a detector
+// accepts. Detectors advertise it, for example as JSON, and recipients pass it
+// to NegotiateDetectionKey.
+type PrecisionPolicy struct {
+	Min int `json:"min_precision"`
+	Max int `json:"max_precision,omitempty"` // zero means no maximum
+}
+
+// Reasons a PrecisionPolicy can't be satisfied. They are wrapped in a
+// *PolicyError, so test for them with errors.Is.
+var (
+	ErrInvalidPolicy = errors.New("minimum precision exceeds maximum")
+	ErrGammaTooSmall = newKindError("gamma is below the minimum precision", ErrPrecisionOutOfRange)
+)
+
+// PolicyError is returned when a recipient's key can't satisfy a policy.
+type PolicyError struct {
+	Policy PrecisionPolicy
+	Gamma  int   // gamma of the recipient's key
+	Err    error // one of the reasons above
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("gophertags: precision policy [%d, %d] with gamma %d: %v", e.Policy.Min, e.Policy.Max, e.Gamma, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// Allows reports whether the policy accepts detection keys of precision n.
+func (p PrecisionPolicy) Allows(n int) bool {
+	return n >= p.Min && (p.Max == 0 || n <= p.Max)
+}
+
+// Select returns the precision closest to preferred that the policy allows
+// and a key with the given gamma can provide.
+func (p PrecisionPolicy) Select(gamma, preferred int) (int, error) {
+	if p.Max != 0 && p.Min > p.Max {
+		return 0, &PolicyError{Policy: p, Gamma: gamma, Err: ErrInvalidPolicy}
+	}
+	if gamma < p.Min {
+		return 0, &PolicyError{Policy: p, Gamma: gamma, Err: ErrGammaTooSmall}
+	}
+	n := preferred
+	if p.Max != 0 && n > p.Max {
+		n = p.Max
+	}
+	if n > gamma {
+		n = gamma
+	}
+	if n < p.Min {
+		n = p.Min
+	}
+	return n, nil
+}
+
+// NegotiateDetectionKey extracts a detection key the policy allows, with the
+// precision closest to preferred. Recipients should prefer the lowest
+// precision they can tolerate, since every extra bit halves their cover.
+func (sk *SecretKey) NegotiateDetectionKey(policy PrecisionPolicy, preferred int) (*DetectionKey, error) {
+	n, err := policy.Select(len(sk.sk), preferred)
+	if err != nil {
+		return nil, err
+	}
+	return sk.ExtractDetectionKey(n), nil
+}
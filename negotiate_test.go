@@ -0,0 +1,49 @@
+package gophertags
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPrecisionPolicySelect(t *testing.T) {
+	tests := []struct {
+		policy           PrecisionPolicy
+		gamma, preferred int
+		want             int
+		err              error
+	}{
+		{PrecisionPolicy{Min: 2, Max: 8}, 24, 5, 5, nil},
+		{PrecisionPolicy{Min: 2, Max: 8}, 24, 1, 2, nil},
+		{PrecisionPolicy{Min: 2, Max: 8}, 24, 12, 8, nil},
+		{PrecisionPolicy{Min: 2}, 24, 30, 24, nil},
+		{PrecisionPolicy{Min: 4, Max: 8}, 6, 8, 6, nil},
+		{PrecisionPolicy{Min: 10}, 8, 10, 0, ErrGammaTooSmall},
+		{PrecisionPolicy{Min: 8, Max: 4}, 24, 6, 0, ErrInvalidPolicy},
+	}
+	for _, tt := range tests {
+		n, err := tt.policy.Select(tt.gamma, tt.preferred)
+		if !errors.Is(err, tt.err) || n != tt.want {
+			t.Errorf("%+v.Select(%d, %d) = %d, %v; want %d, %v", tt.policy, tt.gamma, tt.preferred, n, err, tt.want, tt.err)
+		}
+		if err == nil && !tt.policy.Allows(n) {
+			t.Errorf("%+v selected disallowed precision %d", tt.policy, n)
+		}
+	}
+}
+
+func TestNegotiateDetectionKey(t *testing.T) {
+	sk := NewSecretKey(8)
+	dk, err := sk.NegotiateDetectionKey(PrecisionPolicy{Min: 3, Max: 6}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dk.Precision() != 3 {
+		t.Errorf("negotiated precision %d, want 3", dk.Precision())
+	}
+
+	_, err = sk.NegotiateDetectionKey(PrecisionPolicy{Min: 12}, 12)
+	var pe *PolicyError
+	if !errors.As(err, &pe) || pe.Gamma != 8 || !errors.Is(err, ErrGammaTooSmall) {
+		t.Errorf("unsatisfiable policy: got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package gophertags
+
+import "io"
+
+// Option configures NewSecretKey.
+type Option func(*keyOptions)
+
+type keyOptions struct {
+	rand    io.Reader
+	seed    []byte
+	context string
+	hash    HashScheme
+	lazy    bool
+}
+
+// WithRand makes NewSecretKey draw its scalars from r instead of crypto/rand.
+// r must be a cryptographically secure generator, unless the key is for a
+// test that needs to be reproducible.
+func WithRand(r io.Reader) Option {
+	return func(o *keyOptions) { o.rand = r }
+}
+
+// WithSeed makes NewSecretKey derive the key from a seed of SeedSize bytes,
+// as NewSecretKeyFromSeed does, instead of drawing random scalars. It takes
+// precedence over WithRand.
+func WithSeed(seed []byte) Option {
+	return func(o *keyOptions) { o.seed = seed }
+}
+
+// WithContext binds the key to an application context, as
+// NewSecretKeyWithContext does.
+func WithContext(context string) Option {
+	return func(o *keyOptions) { o.context = context }
+}
+
+// WithHash instantiates the scheme with h, as NewSecretKeyWithHash does.
+func WithHash(h HashScheme) Option {
+	return func(o *keyOptions) { o.hash = h }
+}
+
+// WithLazyPublicKey defers computing the public key until it is first
+// needed, as NewLazySecretKey does.
+func WithLazyPublicKey() Option {
+	return func(o *keyOptions) { o.lazy = true }
+}
@@ -0,0 +1,37 @@
+package gophertags
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewSecretKeyOptions(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, SeedSize)
+	if !bytes.Equal(NewSecretKey(12, WithSeed(seed)).Encode(nil), NewSecretKeyFromSeed(12, seed).Encode(nil)) {
+		t.Error("WithSeed differs from NewSecretKeyFromSeed")
+	}
+	if !bytes.Equal(NewSecretKey(12, WithSeed(seed), WithRand(seededReader("ignored"))).Encode(nil), NewSecretKeyFromSeed(12, seed).Encode(nil)) {
+		t.Error("WithRand overrode WithSeed")
+	}
+
+	a := NewSecretKey(12, WithRand(seededReader(t.Name())))
+	b := NewSecretKey(12, WithRand(seededReader(t.Name())), WithLazyPublicKey())
+	if !bytes.Equal(a.Encode(nil), b.Encode(nil)) {
+		t.Error("WithRand isn't reproducible")
+	}
+	if a.pk == nil || b.pk != nil {
+		t.Error("WithLazyPublicKey didn't defer the public key")
+	}
+	if !bytes.Equal(a.PublicKey().Encode(nil), b.PublicKey().Encode(nil)) {
+		t.Error("lazy public key differs")
+	}
+
+	sk := NewSecretKey(12, WithContext("app"), WithHash(BLAKE2b))
+	if sk.context != "app" || sk.scheme() != BLAKE2b {
+		t.Errorf("got context %q and scheme %v", sk.context, sk.scheme().Name())
+	}
+	dk := sk.ExtractDetectionKey(12)
+	if !dk.Test(sk.PublicKey().GenerateFlag()) {
+		t.Error("key with options doesn't detect its own flags")
+	}
+}
@@ -0,0 +1,41 @@
+package gophertags
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parallelMin is the smallest number of scalar multiplications worth spreading
+// over goroutines. Each costs tens of microseconds, so below this the
+// goroutines cost more than they save.
+const parallelMin = 16
+
+// parallelFor calls f(i) for every 0 <= i < n, spreading contiguous ranges of
+// i over up to GOMAXPROCS goroutines when n is at least parallelMin. Calls for
+// different i must be independent: since each i is computed by exactly one
+// call, results written to index i come out the same, and in the same place,
+// as from a sequential loop.
+func parallelFor(n int, f func(i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n/(parallelMin/2) {
+		workers = n / (parallelMin / 2)
+	}
+	if n < parallelMin || workers < 2 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := n*w/workers, n*(w+1)/workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,39 @@
+package gophertags
+
+import (
+	"bytes"
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelFor(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for _, n := range []int{0, 1, parallelMin - 1, parallelMin, 17, 100} {
+		counts := make([]int32, n)
+		parallelFor(n, func(i int) { atomic.AddInt32(&counts[i], 1) })
+		for i, c := range counts {
+			if c != 1 {
+				t.Fatalf("n = %d: index %d visited %d times", n, i, c)
+			}
+		}
+	}
+}
+
+// TestParallelKeygenDeterministic checks that keys derived from a seed or a
+// seeded stream don't depend on how many goroutines computed them.
+func TestParallelKeygenDeterministic(t *testing.T) {
+	seed := make([]byte, SeedSize)
+	generate := func(procs int) []byte {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		fromSeed := NewSecretKeyFromSeed(64, seed)
+		fromStream := newSecretKey(64, seededReader("parallel keygen"))
+		out := fromSeed.Encode(nil)
+		out = fromSeed.PublicKey().Encode(out)
+		out = fromStream.Encode(out)
+		return fromStream.PublicKey().Encode(out)
+	}
+	if !bytes.Equal(generate(1), generate(8)) {
+		t.Error("keys generated in parallel differ from keys generated sequentially")
+	}
+}
@@ -0,0 +1,95 @@
+package gophertags
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// ErrKeyString is returned by ParsePublicKey and ParseDetectionKey for strings
+// in none of the forms they recognize.
+var ErrKeyString = newKindError("gophertags: unrecognized key string", ErrInvalidEncoding)
+
+// ParsePublicKey parses a public key from any of the string forms in use: a
+// gophertags:pk URI, a string from PublicKey.QR, PublicKey.Bech32 or
+// PublicKey.Base58, or the wire encoding in hex or in base64, standard or
+// URL-safe, padded or not. Surrounding white space is ignored. A string with
+// the prefix of a form is parsed as that form only; otherwise the binary
+// forms are tried in that order and the first that decodes to a key is
+// returned. Keys parsed from URIs keep their context and path.
+func ParsePublicKey(s string) (*PublicKey, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case hasPrefixFold(s, URIScheme+":"):
+		return ParsePublicKeyURI(s)
+	case hasPrefixFold(s, QRPrefix):
+		return DecodePublicKeyQR(s)
+	case isBech32Key(s):
+		return DecodePublicKeyBech32(s)
+	}
+	pk := new(PublicKey)
+	if err := parseKeyString(s, Base58PublicKeyVersion, pk.Decode); err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
+
+// ParseDetectionKey is like ParsePublicKey for detection keys, whose string
+// forms are the same except that there is no QR form.
+func ParseDetectionKey(s string) (*DetectionKey, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case hasPrefixFold(s, URIScheme+":"):
+		return ParseDetectionKeyURI(s)
+	case isBech32Key(s):
+		return DecodeDetectionKeyBech32(s)
+	}
+	dk := new(DetectionKey)
+	if err := parseKeyString(s, Base58DetectionKeyVersion, dk.Decode); err != nil {
+		return nil, err
+	}
+	return dk, nil
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func isBech32Key(s string) bool {
+	return hasPrefixFold(s, Bech32PublicKeyHRP+"1") || hasPrefixFold(s, Bech32DetectionKeyHRP+"1")
+}
+
+// parseKeyString tries the binary forms of a key string in turn, passing the
+// bytes of each that s is valid in to decode. It returns nil once decode
+// succeeds, and otherwise the first error from decode, or ErrKeyString if s
+// is valid in none of them. A Base58Check string whose checksum matches but
+// whose version doesn't is a key of another type, and fails at once.
+func parseKeyString(s string, version byte, decode func([]byte) error) error {
+	forms := []func(string) ([]byte, error){
+		hex.DecodeString,
+		func(s string) ([]byte, error) { return base58CheckDecode(version, s) },
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	}
+	var first error
+	for _, form := range forms {
+		b, err := form(s)
+		if err == ErrBase58Version {
+			return err
+		}
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		if err := decode(b); err == nil {
+			return nil
+		} else if first == nil {
+			first = err
+		}
+	}
+	if first == nil {
+		return ErrKeyString
+	}
+	return first
+}
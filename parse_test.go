@@ -0,0 +1,73 @@
+package gophertags
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyStrings(t *testing.T) {
+	sk := NewSecretKeyWithContext(16, "app")
+	pk, dk := sk.PublicKey(), sk.ExtractDetectionKey(4)
+	pkBytes, dkBytes := pk.Encode(nil), dk.Encode(nil)
+
+	pkForms := map[string]string{
+		"URI":        pk.URI(),
+		"QR":         pk.QR(),
+		"Bech32":     pk.Bech32(),
+		"Base58":     pk.Base58(),
+		"hex":        hex.EncodeToString(pkBytes),
+		"base64":     base64.StdEncoding.EncodeToString(pkBytes),
+		"raw base64": base64.RawStdEncoding.EncodeToString(pkBytes),
+		"base64url":  base64.RawURLEncoding.EncodeToString(pkBytes),
+		"spaced hex": "  " + hex.EncodeToString(pkBytes) + "\n",
+	}
+	for name, s := range pkForms {
+		got, err := ParsePublicKey(s)
+		if err != nil || !bytes.Equal(got.Encode(nil), pkBytes) {
+			t.Errorf("public key from %s: %v", name, err)
+		}
+	}
+	dkForms := map[string]string{
+		"URI":        dk.URI(),
+		"Bech32":     dk.Bech32(),
+		"Base58":     dk.Base58(),
+		"hex":        hex.EncodeToString(dkBytes),
+		"base64":     base64.StdEncoding.EncodeToString(dkBytes),
+		"base64url":  base64.URLEncoding.EncodeToString(dkBytes),
+		"upper hex":  strings.ToUpper(hex.EncodeToString(dkBytes)),
+		"raw base64": base64.RawStdEncoding.EncodeToString(dkBytes),
+	}
+	for name, s := range dkForms {
+		got, err := ParseDetectionKey(s)
+		if err != nil || !bytes.Equal(got.Encode(nil), dkBytes) {
+			t.Errorf("detection key from %s: %v", name, err)
+		}
+	}
+	if got, _ := ParseDetectionKey(dk.URI()); got == nil || got.context != "app" {
+		t.Error("detection key from a URI lost its context")
+	}
+
+	for name, tc := range map[string]struct {
+		err  error
+		want error
+	}{
+		"pk URI as dk":    {errOf(ParseDetectionKey(pk.URI())), ErrURI},
+		"pk Base58 as dk": {errOf(ParseDetectionKey(pk.Base58())), ErrBase58Version},
+		"pk Bech32 as dk": {errOf(ParseDetectionKey(pk.Bech32())), ErrBech32HRP},
+		"garbage":         {errOf(ParsePublicKey("not a key!")), ErrKeyString},
+		"empty":           {errOf(ParsePublicKey("")), ErrKeyString},
+		"short hex":       {errOf(ParsePublicKey(hex.EncodeToString(pkBytes[:40]))), ErrLength},
+	} {
+		if !errors.Is(tc.err, tc.want) || !errors.Is(tc.err, ErrInvalidEncoding) {
+			t.Errorf("%s: got %v, want %v", name, tc.err, tc.want)
+		}
+	}
+}
+
+func errOf(_ interface{}, err error) error {
+	return err
+}
@@ -0,0 +1,185 @@
+package gophertags
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// Path-derived keys let one backed-up seed hold a separate tagging identity
+// for each contact, addressed BIP32-style as m/ft/<account>'/<contact>'. Each
+// step derives a child seed from its parent:
+//
+//	seed' = HMAC-SHA512(seed, "gophertags path v1" || uint32be(index + 2^31))[:32]
+//
+// and the key at the final seed is derived as by NewSecretKeyFromSeed, so the
+// empty path m/ft names the seed's own key. Only hardened steps exist: there
+// is no way to derive child public keys from a parent public key (see the
+// README on diversified public keys).
+//
+// Keys record their path, and their wire encodings carry it as a last tail,
+// after any threshold or watermark:
+//
+//	index_1 || ... || index_k (4 bytes each, big-endian) || 0x80 + k (1 byte)
+//
+// Every other part of an encoding has an even length, so an odd length says a
+// path tail is present, and its last byte must have the high bit set. A key
+// without a path has no tail, and encodes as it did before paths existed.
+
+const pathLabel = "gophertags path v1"
+
+// pathRoot is the required prefix of every path string, "ft" being the
+// purpose reserved for fuzzy tagging keys.
+const pathRoot = "m/ft"
+
+// maxPathDepth is the most steps a path may have, so that its length fits
+// the low bits of the last byte of its encoding, marked by pathTag.
+const (
+	maxPathDepth = 127
+	pathTag      = 0x80
+)
+
+// hardenedOffset is added to every index before hashing, following BIP32's
+// numbering of hardened children.
+const hardenedOffset = 1 << 31
+
+// ErrDerivationPath is returned for malformed derivation paths.
+var ErrDerivationPath = newKindError("gophertags: malformed derivation path", ErrInvalidEncoding)
+
+// DerivationPath is a sequence of hardened child indices below the m/ft root.
+// Each index is less than 2^31, and there are at most 127 of them.
+type DerivationPath []uint32
+
+// ParseDerivationPath parses a path such as m/ft/0'/7'. Every step must be
+// hardened, marked with ' or h.
+func ParseDerivationPath(s string) (DerivationPath, error) {
+	if s != pathRoot && !strings.HasPrefix(s, pathRoot+"/") {
+		return nil, ErrDerivationPath
+	}
+	steps := strings.Split(s, "/")[2:]
+	if len(steps) > maxPathDepth {
+		return nil, ErrDerivationPath
+	}
+	var path DerivationPath
+	for _, step := range steps {
+		if !strings.HasSuffix(step, "'") && !strings.HasSuffix(step, "h") {
+			return nil, ErrDerivationPath
+		}
+		digits := step[:len(step)-1]
+		if digits == "" || (len(digits) > 1 && digits[0] == '0') {
+			return nil, ErrDerivationPath
+		}
+		i, err := strconv.ParseUint(digits, 10, 31)
+		if err != nil {
+			return nil, ErrDerivationPath
+		}
+		path = append(path, uint32(i))
+	}
+	return path, nil
+}
+
+// String returns the path in its canonical form, with ' marking hardened steps.
+func (p DerivationPath) String() string {
+	var b strings.Builder
+	b.WriteString(pathRoot)
+	for _, i := range p {
+		b.WriteByte('/')
+		b.WriteString(strconv.FormatUint(uint64(i), 10))
+		b.WriteByte('\'')
+	}
+	return b.String()
+}
+
+// Child returns the path extended by one hardened step. It panics if i >= 2^31
+// or p already has 127 steps.
+func (p DerivationPath) Child(i uint32) DerivationPath {
+	if i >= hardenedOffset {
+		panic("gophertags: derivation index out of range")
+	}
+	if len(p) >= maxPathDepth {
+		panic("gophertags: derivation path too deep")
+	}
+	return append(append(DerivationPath(nil), p...), i)
+}
+
+// NewSecretKeyFromPath derives the secret key with the given gamma at path
+// below a seed of SeedSize bytes. The key records its path, which is carried
+// by the public and detection keys extracted from it and by their encodings,
+// so a client holding many per-contact keys can tell which each one is. It
+// panics if len(seed) != SeedSize, any index is 2^31 or more, or the path has
+// more than 127 steps.
+func NewSecretKeyFromPath(gamma int, seed []byte, path DerivationPath) *SecretKey {
+	if len(seed) != SeedSize {
+		panic("gophertags: bad seed length")
+	}
+	if len(path) > maxPathDepth {
+		panic("gophertags: derivation path too deep")
+	}
+	child := append([]byte(nil), seed...)
+	for _, i := range path {
+		if i >= hardenedOffset {
+			panic("gophertags: derivation index out of range")
+		}
+		child = deriveChildSeed(child, i)
+	}
+	key := NewSecretKeyFromSeed(gamma, child)
+	key.path = path.String()
+	return key
+}
+
+// deriveChildSeed returns the seed of hardened child i of seed.
+func deriveChildSeed(seed []byte, i uint32) []byte {
+	message := make([]byte, len(pathLabel)+4)
+	copy(message, pathLabel)
+	binary.BigEndian.PutUint32(message[len(pathLabel):], i+hardenedOffset)
+
+	mac := hmac.New(sha512.New, seed)
+	mac.Write(message)
+	return mac.Sum(nil)[:SeedSize]
+}
+
+// appendPath appends the encoding of a key's path, if it has one, to b.
+func appendPath(b []byte, path string) []byte {
+	if path == "" {
+		return b
+	}
+	p, _ := ParseDerivationPath(path) // always canonical
+	for _, i := range p {
+		var t [4]byte
+		binary.BigEndian.PutUint32(t[:], i)
+		b = append(b, t[:]...)
+	}
+	return append(b, pathTag|byte(len(p)))
+}
+
+// pathSize returns the length of the encoding of a key's path.
+func pathSize(path string) int {
+	if path == "" {
+		return 0
+	}
+	return 4*(strings.Count(path, "/")-1) + 1
+}
+
+// splitPath splits the path tail, if there is one, off the end of the body of
+// an encoding, returning the rest of the body and the canonical path. An odd
+// tail that isn't a path is a length error, as it was before paths existed.
+func splitPath(typ string, body []byte) ([]byte, string, error) {
+	if len(body)%2 == 0 {
+		return body, "", nil
+	}
+	last := body[len(body)-1]
+	start := len(body) - 1 - 4*int(last&^pathTag)
+	if last&pathTag == 0 || start < 0 {
+		return nil, "", &DecodeError{typ, schemeIDSize + len(body), ErrLength}
+	}
+	path := make(DerivationPath, last&^pathTag)
+	for i := range path {
+		path[i] = binary.BigEndian.Uint32(body[start+4*i:])
+		if path[i] >= hardenedOffset {
+			return nil, "", &DecodeError{typ, schemeIDSize + len(body), ErrLength}
+		}
+	}
+	return body[:start], path.String(), nil
+}
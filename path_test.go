@@ -0,0 +1,170 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseDerivationPath(t *testing.T) {
+	for s, want := range map[string]string{
+		"m/ft":             "m/ft",
+		"m/ft/0'":          "m/ft/0'",
+		"m/ft/0h/7h":       "m/ft/0'/7'",
+		"m/ft/2147483647'": "m/ft/2147483647'",
+	} {
+		p, err := ParseDerivationPath(s)
+		if err != nil || p.String() != want {
+			t.Errorf("ParseDerivationPath(%q) = %v, %v; want %s", s, p, err, want)
+		}
+	}
+	for _, s := range []string{
+		"", "m", "m/44'/0'", "m/ft/0", "m/ft/'", "m/ft/01'", "m/ft/-1'",
+		"m/ft/2147483648'", "m/ft/0'/", "m/ftx/0'",
+	} {
+		if _, err := ParseDerivationPath(s); !errors.Is(err, ErrDerivationPath) {
+			t.Errorf("ParseDerivationPath(%q): got %v, want ErrDerivationPath", s, err)
+		}
+	}
+}
+
+func TestNewSecretKeyFromPath(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, SeedSize)
+	account := DerivationPath{0}
+
+	root := NewSecretKeyFromPath(16, seed, nil)
+	if root.PublicKey().Fingerprint() != NewSecretKeyFromSeed(16, seed).PublicKey().Fingerprint() || root.Path() != "m/ft" {
+		t.Error("the empty path doesn't name the seed's own key")
+	}
+
+	alice := NewSecretKeyFromPath(16, seed, account.Child(1))
+	bob := NewSecretKeyFromPath(16, seed, account.Child(2))
+	if alice.PublicKey().Fingerprint() == bob.PublicKey().Fingerprint() || alice.PublicKey().Fingerprint() == root.PublicKey().Fingerprint() {
+		t.Fatal("distinct paths derived the same key")
+	}
+	if again := NewSecretKeyFromPath(16, seed, DerivationPath{0, 1}); again.PublicKey().Fingerprint() != alice.PublicKey().Fingerprint() {
+		t.Error("path derivation is not deterministic")
+	}
+	if alice.PublicKey().Path() != "m/ft/0'/1'" || alice.ExtractDetectionKey(4).Path() != "m/ft/0'/1'" {
+		t.Error("extracted keys lost their path")
+	}
+	if !alice.ExtractDetectionKey(4).Test(alice.PublicKey().GenerateFlag()) {
+		t.Error("path-derived key doesn't detect its own flags")
+	}
+
+	pk, err := ParsePublicKeyURI(alice.PublicKey().URI())
+	if err != nil || pk.Path() != "m/ft/0'/1'" {
+		t.Fatalf("path lost in URI round trip: %v", err)
+	}
+	dk, err := ParseDetectionKeyURI(bob.ExtractDetectionKey(4).URI())
+	if err != nil || dk.Path() != "m/ft/0'/2'" {
+		t.Fatalf("path lost in URI round trip: %v", err)
+	}
+	if _, err := ParseURI(NewSecretKey(8).PublicKey().URI() + "&p=m/44'"); err == nil {
+		t.Error("accepted a URI with a malformed path")
+	}
+}
+
+func TestPathEncoding(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, SeedSize)
+	sk := NewSecretKeyFromPath(16, seed, DerivationPath{0, 1})
+	pk := sk.PublicKey()
+	dk := sk.WatermarkDetectionKey(sk.ExtractDetectionKeyRate(0.01), 42)
+	const want = "m/ft/0'/1'"
+	tail := 2*4 + 1
+
+	if n := len(pk.Encode(nil)); n != PublicKeySize(16)+tail {
+		t.Errorf("public key with a path is %d bytes", n)
+	}
+	if n := len(sk.Encode(nil)); n != SecretKeySize(16)+tail {
+		t.Errorf("secret key with a path is %d bytes", n)
+	}
+	for _, m := range []interface {
+		MarshalMsgpack() ([]byte, error)
+		Msgsize() int
+	}{pk, dk, sk} {
+		if b, _ := m.MarshalMsgpack(); len(b) != m.Msgsize() {
+			t.Errorf("%T with a path: MessagePack encoding is %d bytes, Msgsize says %d", m, len(b), m.Msgsize())
+		}
+	}
+
+	sk2, err := DecodeSecretKey(sk.Encode(nil))
+	if err != nil || sk2.Path() != want || !bytes.Equal(sk2.Encode(nil), sk.Encode(nil)) {
+		t.Errorf("secret key round trip: path %q, %v", sk2.Path(), err)
+	}
+	decodedPKs := map[string]func() (*PublicKey, error){
+		"binary":  func() (*PublicKey, error) { return DecodePublicKey(pk.Encode(nil)) },
+		"Base58":  func() (*PublicKey, error) { return DecodePublicKeyBase58(pk.Base58()) },
+		"Bech32":  func() (*PublicKey, error) { return DecodePublicKeyBech32(pk.Bech32()) },
+		"URI":     func() (*PublicKey, error) { return ParsePublicKeyURI(pk.URI()) },
+		"lenient": func() (*PublicKey, error) { return Decoder{Gamma: 16, Lenient: true}.PublicKey(pk.Encode(nil)) },
+		"msgpack": func() (*PublicKey, error) {
+			b, _ := pk.MarshalMsgpack()
+			pk2 := new(PublicKey)
+			return pk2, pk2.UnmarshalMsgpack(b)
+		},
+	}
+	for name, decode := range decodedPKs {
+		pk2, err := decode()
+		if err != nil || pk2.Path() != want || !bytes.Equal(pk2.Encode(nil), pk.Encode(nil)) {
+			t.Errorf("%s public key round trip: path %q, %v", name, pk2.Path(), err)
+		}
+	}
+	decodedDKs := map[string]func() (*DetectionKey, error){
+		"binary":  func() (*DetectionKey, error) { return DecodeDetectionKey(dk.Encode(nil)) },
+		"Base58":  func() (*DetectionKey, error) { return DecodeDetectionKeyBase58(dk.Base58()) },
+		"Bech32":  func() (*DetectionKey, error) { return DecodeDetectionKeyBech32(dk.Bech32()) },
+		"URI":     func() (*DetectionKey, error) { return ParseDetectionKeyURI(dk.URI()) },
+		"lenient": func() (*DetectionKey, error) { return Decoder{Lenient: true}.DetectionKey(dk.Encode(nil)) },
+		"msgpack": func() (*DetectionKey, error) {
+			b, _ := dk.MarshalMsgpack()
+			dk2 := new(DetectionKey)
+			return dk2, dk2.UnmarshalMsgpack(b)
+		},
+	}
+	for name, decode := range decodedDKs {
+		dk2, err := decode()
+		if err != nil || dk2.Path() != want || !bytes.Equal(dk2.Encode(nil), dk.Encode(nil)) {
+			t.Errorf("%s detection key round trip: path %q, %v", name, dk2.Path(), err)
+			continue
+		}
+		if mark, err := sk.VerifyWatermark(dk2); err != nil || mark != 42 || dk2.threshold != dk.threshold {
+			t.Errorf("%s detection key lost its other tails: mark %d, %v", name, mark, err)
+		}
+	}
+
+	// The path is metadata: it doesn't change key IDs or fingerprints, and
+	// the empty path is encoded too.
+	plain := NewSecretKeyFromSeed(16, deriveChildSeed(deriveChildSeed(seed, 0), 1)).PublicKey()
+	if plain.Fingerprint() != pk.Fingerprint() || plain.KeyID() != pk.KeyID() || plain.Path() != "" {
+		t.Error("the path changed the key's fingerprint or ID")
+	}
+	if pk2, err := DecodePublicKey(plain.Encode(nil)); err != nil || pk2.Path() != "" {
+		t.Errorf("key without a path decoded with path %q, %v", pk2.Path(), err)
+	}
+	root := NewSecretKeyFromPath(16, seed, nil).PublicKey()
+	if pk2, err := DecodePublicKey(root.Encode(nil)); err != nil || pk2.Path() != "m/ft" {
+		t.Errorf("root key decoded with path %q, %v", pk2.Path(), err)
+	}
+
+	// Links written before encodings carried paths gave them as a parameter.
+	old := plain.URI() + "&p=" + want
+	if pk2, err := ParsePublicKeyURI(old); err != nil || pk2.Path() != want {
+		t.Errorf("path parameter ignored: %q, %v", pk2.Path(), err)
+	}
+	if _, err := ParsePublicKeyURI(pk.URI() + "&p=m/ft/0'/2'"); err == nil {
+		t.Error("accepted a URI whose path parameter disagrees with its key")
+	}
+
+	encoded := pk.Encode(nil)
+	for name, in := range map[string][]byte{
+		"truncated path":     encoded[:len(encoded)-4],
+		"untagged count":     append(encoded[:len(encoded)-1:len(encoded)-1], 2),
+		"overlong count":     append(encoded[:len(encoded)-1:len(encoded)-1], pathTag|127),
+		"unhardenable index": append(append(encoded[:PublicKeySize(16):PublicKeySize(16)], 0x80, 0, 0, 0), pathTag|1),
+	} {
+		if _, err := DecodePublicKey(in); !errors.Is(err, ErrLength) {
+			t.Errorf("%s: got %v, want ErrLength", name, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package gophertags
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// A mailbox can return a ResultProof with each detection result, so that the
+// recipient can spot-check that it really tested flags rather than silently
+// answering "no match". The proof is a digest of the flag, the points x_i·u
+// the test computed and the result:
+//
+//	SHA3-256("gophertags result proof" || flag || x_1·u || ... || x_n·u || result)[:16]
+//
+// Producing it takes the detection key's scalar multiplications, so a mailbox
+// can't skip the work. Anyone holding the detection key, including the
+// recipient, can recompute it. For a flag the recipient knows, such as a
+// canary it sent itself, a wrong result or an invented proof is caught.
+
+const resultProofLabel = "gophertags result proof"
+
+// ResultProofSize is the length in bytes of a ResultProof.
+const ResultProofSize = 16
+
+// ResultProof is evidence that a detection result came from running Test.
+type ResultProof [ResultProofSize]byte
+
+// String returns the proof in hex.
+func (p ResultProof) String() string {
+	return hex.EncodeToString(p[:])
+}
+
+// TestWithProof is like Test, but also returns a proof of the result for the
+// recipient to check with VerifyResult.
+func (dk *DetectionKey) TestWithProof(f *Flag) (bool, ResultProof) {
+	pass, xU := dk.evaluate(f, nil)
+
+	digest := sha3.New256()
+	digest.Write([]byte(resultProofLabel))
+	digest.Write(f.Encode(nil))
+	var buf [elementSize]byte
+	for _, P := range xU {
+		digest.Write(P.Encode(buf[:0]))
+	}
+	if pass {
+		digest.Write([]byte{1})
+	} else {
+		digest.Write([]byte{0})
+	}
+	var proof ResultProof
+	copy(proof[:], digest.Sum(nil))
+	return pass, proof
+}
+
+// VerifyResult reports whether matched and proof are what TestWithProof
+// returns for the flag.
+func (dk *DetectionKey) VerifyResult(f *Flag, matched bool, proof ResultProof) bool {
+	pass, want := dk.TestWithProof(f)
+	return pass == matched && subtle.ConstantTimeCompare(proof[:], want[:]) == 1
+}
@@ -0,0 +1,35 @@
+package gophertags
+
+import "testing"
+
+func TestResultProof(t *testing.T) {
+	sk := NewSecretKey(16)
+	dk := sk.ExtractDetectionKey(8)
+	mine, other := sk.PublicKey().GenerateFlag(), NewSecretKey(16).PublicKey().GenerateFlag()
+
+	for _, f := range []*Flag{mine, other} {
+		matched, proof := dk.TestWithProof(f)
+		if matched != dk.Test(f) {
+			t.Fatal("TestWithProof disagrees with Test")
+		}
+		if !dk.VerifyResult(f, matched, proof) {
+			t.Error("honest result doesn't verify")
+		}
+		if dk.VerifyResult(f, !matched, proof) {
+			t.Error("flipped result verifies")
+		}
+		var invented ResultProof
+		if dk.VerifyResult(f, matched, invented) {
+			t.Error("invented proof verifies")
+		}
+	}
+
+	// A proof is specific to the flag and to the key's computation.
+	_, proof := dk.TestWithProof(mine)
+	if _, p := dk.TestWithProof(other); p == proof {
+		t.Error("proofs for different flags agree")
+	}
+	if _, p := NewSecretKey(16).ExtractDetectionKey(8).TestWithProof(mine); p == proof {
+		t.Error("proofs from different keys agree")
+	}
+}
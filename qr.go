@@ -0,0 +1,93 @@
+package gophertags
+
+import (
+	"strings"
+)
+
+// QR codes store text drawn from a 45-character alphabet ("alphanumeric
+// mode") in 5.5 bits per character, against 8 bits for arbitrary bytes. The QR
+// encoding of a public key is its wire encoding in Base45 (RFC 9285), which
+// uses exactly that alphabet, behind a short prefix:
+//
+//	GTPK:<Base45(encoding)>
+//
+// A gamma=24 key fits in about 1160 characters, a QR code of version 25 or so
+// at low error correction. Public key elements are uniformly random, so no
+// general-purpose compression would shrink them further.
+
+// QRPrefix starts every QR-encoded public key.
+const QRPrefix = "GTPK:"
+
+// ErrBase45 is returned for QR strings whose Base45 payload is malformed.
+var ErrBase45 = newKindError("gophertags: invalid Base45 encoding", ErrInvalidEncoding)
+
+const base45Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+func base45Encode(in []byte) string {
+	var b strings.Builder
+	b.Grow((len(in)*3 + 1) / 2)
+	for i := 0; i+1 < len(in); i += 2 {
+		n := int(in[i])<<8 | int(in[i+1])
+		b.WriteByte(base45Alphabet[n%45])
+		b.WriteByte(base45Alphabet[n/45%45])
+		b.WriteByte(base45Alphabet[n/(45*45)])
+	}
+	if len(in)%2 == 1 {
+		n := int(in[len(in)-1])
+		b.WriteByte(base45Alphabet[n%45])
+		b.WriteByte(base45Alphabet[n/45])
+	}
+	return b.String()
+}
+
+func base45Decode(s string) ([]byte, error) {
+	if len(s)%3 == 1 {
+		return nil, ErrBase45
+	}
+	digits := make([]int, len(s))
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(base45Alphabet, s[i])
+		if d < 0 {
+			return nil, ErrBase45
+		}
+		digits[i] = d
+	}
+
+	out := make([]byte, 0, len(s)*2/3+1)
+	for i := 0; i < len(digits); i += 3 {
+		if i+2 < len(digits) {
+			n := digits[i] + digits[i+1]*45 + digits[i+2]*45*45
+			if n > 0xffff {
+				return nil, ErrBase45
+			}
+			out = append(out, byte(n>>8), byte(n))
+		} else {
+			n := digits[i] + digits[i+1]*45
+			if n > 0xff {
+				return nil, ErrBase45
+			}
+			out = append(out, byte(n))
+		}
+	}
+	return out, nil
+}
+
+// QR returns the public key in a form suited to QR codes' alphanumeric mode.
+func (pk *PublicKey) QR() string {
+	return QRPrefix + base45Encode(pk.Encode(nil))
+}
+
+// DecodePublicKeyQR parses a public key from a string made by PublicKey.QR.
+// QR scanners may report alphanumeric text in lower case, so the input is
+// matched case-insensitively.
+func DecodePublicKeyQR(s string) (*PublicKey, error) {
+	s = strings.ToUpper(s)
+	if !strings.HasPrefix(s, QRPrefix) {
+		return nil, newKindError("gophertags: missing "+QRPrefix+" prefix", ErrInvalidEncoding)
+	}
+	encoded, err := base45Decode(s[len(QRPrefix):])
+	if err != nil {
+		return nil, err
+	}
+	return DecodePublicKey(encoded)
+}
@@ -0,0 +1,49 @@
+package gophertags
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBase45Vectors(t *testing.T) {
+	// From RFC 9285, section 4.
+	for _, tc := range []struct{ in, out string }{
+		{"AB", "BB8"},
+		{"Hello!!", "%69 VD92EX0"},
+		{"base-45", "UJCLQE7W581"},
+		{"ietf!", "QED8WEX0"},
+	} {
+		if got := base45Encode([]byte(tc.in)); got != tc.out {
+			t.Errorf("encode %q = %q, want %q", tc.in, got, tc.out)
+		}
+		if got, err := base45Decode(tc.out); err != nil || !bytes.Equal(got, []byte(tc.in)) {
+			t.Errorf("decode %q = %q, %v", tc.out, got, err)
+		}
+	}
+	for _, bad := range []string{"A", "GGW", "a1", "ZZZZ"} {
+		if _, err := base45Decode(bad); !errors.Is(err, ErrBase45) {
+			t.Errorf("decode %q: got %v, want ErrBase45", bad, err)
+		}
+	}
+}
+
+func TestPublicKeyQR(t *testing.T) {
+	pk := NewSecretKey(24).PublicKey()
+	s := pk.QR()
+	for _, c := range s {
+		if !strings.ContainsRune(base45Alphabet, c) {
+			t.Fatalf("QR string has %q, outside the alphanumeric alphabet", c)
+		}
+	}
+	for _, in := range []string{s, strings.ToLower(s)} {
+		got, err := DecodePublicKeyQR(in)
+		if err != nil || got.Fingerprint() != pk.Fingerprint() {
+			t.Errorf("round trip: %v", err)
+		}
+	}
+	if _, err := DecodePublicKeyQR(s[len(QRPrefix):]); err == nil {
+		t.Error("string without prefix accepted")
+	}
+}
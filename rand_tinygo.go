@@ -0,0 +1,14 @@
+//go:build tinygo
+// +build tinygo
+
+package gophertags
+
+import "io"
+
+// SetRandReader replaces crypto/rand as the source of randomness for key and
+// flag generation, for devices where TinyGo has no entropy source or a
+// hardware RNG should be used instead. r must be a cryptographically secure
+// generator. Call it at startup, before generating anything.
+func SetRandReader(r io.Reader) {
+	randReader = r
+}
@@ -0,0 +1,82 @@
+package gophertags
+
+import (
+	"encoding/binary"
+	"math"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+// Fractional rates, from section 4 of the FMD paper: a detection key of
+// precision n+1 whose last bit is only tested for some flags has a false
+// positive rate between 2^-(n+1) and 2^-n. Which flags is decided by a coin
+// hashed from the flag, so repeated tests of one flag always agree. True
+// flags pass every bit, so they still always match.
+
+const (
+	rateLabel = "gophertags rate"
+	rateSize  = 8
+)
+
+var rateLabelBytes = []byte(rateLabel) // for Scratch, which mustn't allocate
+
+// ExtractDetectionKeyRate produces a detection key whose false positive rate
+// is p, which must be in [2^-gamma, 1]. Rates that are powers of two give the
+// same key as ExtractDetectionKey.
+//
+// Fractional keys encode with a trailing 8-byte threshold, so decoders that
+// predate them reject rather than misread them.
+func (sk *SecretKey) ExtractDetectionKeyRate(p float64) *DetectionKey {
+	if !(p > 0 && p <= 1) {
+		panic("gophertags: false positive rate must be in (0, 1]")
+	}
+	// 2^-(n+1) < p <= 2^-n
+	frac, exp := math.Frexp(p) // p = frac * 2^exp, frac in [0.5, 1)
+	n := -exp
+	if n+1 > len(sk.sk) {
+		panic("gophertags: false positive rate below 2^-gamma")
+	}
+	if frac == 0.5 {
+		return sk.ExtractDetectionKey(n + 1)
+	}
+	// Testing the last bit with probability q gives a rate of 2^-n * (1 - q/2).
+	q := 2 * (1 - frac)
+	dk := sk.ExtractDetectionKey(n + 1)
+	dk.threshold = rateThreshold(q)
+	return dk
+}
+
+// rateThreshold scales a probability in (0, 1) to a nonzero coin threshold.
+func rateThreshold(q float64) uint64 {
+	t := math.Ldexp(q, 64)
+	if t >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+	if t < 1 {
+		return 1
+	}
+	return uint64(t)
+}
+
+// testsLastBit reports whether the flag's coin requires testing dk's last bit.
+func (dk *DetectionKey) testsLastBit(f *Flag) bool {
+	if dk.threshold == 0 {
+		return true
+	}
+	digest := dk.scheme().NewBitHash()
+	digest.Write(dk.contextPrefix())
+	digest.Write([]byte(rateLabel))
+	digest.Write(f.encodedU())
+	digest.Write(f.y.Encode(nil))
+	return binary.BigEndian.Uint64(digest.Sum(nil)) < dk.threshold
+}
+
+// rate returns the false positive rate of a key with the given scalars and
+// threshold.
+func rate(scalars []*r255.Scalar, threshold uint64) float64 {
+	if threshold == 0 {
+		return math.Ldexp(1, -len(scalars))
+	}
+	q := float64(threshold) / math.Ldexp(1, 64)
+	return math.Ldexp(1-q/2, 1-len(scalars))
+}
@@ -0,0 +1,102 @@
+package gophertags
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExtractDetectionKeyRate(t *testing.T) {
+	sk := NewSecretKey(8)
+
+	if dk := sk.ExtractDetectionKeyRate(0.25); dk.Precision() != 2 || dk.threshold != 0 {
+		t.Errorf("power-of-two rate gave precision %d, threshold %d", dk.Precision(), dk.threshold)
+	}
+	dk := sk.ExtractDetectionKeyRate(0.3)
+	if dk.Precision() != 2 {
+		t.Errorf("rate 0.3 gave precision %d, want 2", dk.Precision())
+	}
+	if r := dk.FalsePositiveRate(); math.Abs(r-0.3) > 1e-9 {
+		t.Errorf("FalsePositiveRate() = %v, want 0.3", r)
+	}
+
+	// True flags always match, however the coin falls.
+	pk := sk.PublicKey()
+	for i := 0; i < 100; i++ {
+		if !dk.Test(pk.GenerateFlag()) {
+			t.Fatal("fractional key missed a true flag")
+		}
+	}
+
+	// Repeated tests of a flag agree.
+	other := NewSecretKey(8).PublicKey()
+	f := other.GenerateFlag()
+	first := dk.Test(f)
+	for i := 0; i < 10; i++ {
+		if dk.Test(f) != first {
+			t.Fatal("fractional key gave inconsistent results for one flag")
+		}
+	}
+
+	// Rates at and just below 2^-gamma.
+	if dk := sk.ExtractDetectionKeyRate(math.Ldexp(1, -8)); dk.Precision() != 8 {
+		t.Errorf("rate 2^-8 gave precision %d, want 8", dk.Precision())
+	}
+	for _, p := range []float64{math.Ldexp(1, -9), math.Ldexp(1.5, -9)} {
+		func() {
+			defer func() {
+				if msg, _ := recover().(string); msg != "gophertags: false positive rate below 2^-gamma" {
+					t.Errorf("rate %v panicked with %v", p, msg)
+				}
+			}()
+			sk.ExtractDetectionKeyRate(p)
+		}()
+	}
+}
+
+func TestFractionalFalsePositives(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+	dk := NewSecretKey(8).ExtractDetectionKeyRate(0.375)
+	other := NewSecretKey(8).PublicKey()
+
+	const trials = 2000
+	matches := 0
+	for i := 0; i < trials; i++ {
+		if dk.Test(other.GenerateFlag()) {
+			matches++
+		}
+	}
+	// Mean 750, standard deviation about 22.
+	if matches < 650 || matches > 850 {
+		t.Errorf("%d of %d false positives, want about %d", matches, trials, trials*3/8)
+	}
+}
+
+func TestFractionalEncoding(t *testing.T) {
+	sk := NewSecretKey(8)
+	dk := sk.ExtractDetectionKeyRate(0.1)
+	encoded := dk.Encode(nil)
+	if len(encoded) != DetectionKeySize(dk.Precision())+rateSize {
+		t.Errorf("encoded length %d", len(encoded))
+	}
+
+	decoded, err := DecodeDetectionKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.threshold != dk.threshold || decoded.FalsePositiveRate() != dk.FalsePositiveRate() {
+		t.Error("threshold lost in round trip")
+	}
+	if parsed, err := ParseDetectionKeyURI(dk.URI()); err != nil || parsed.threshold != dk.threshold {
+		t.Errorf("URI round trip: %v", err)
+	}
+	if msg, _ := dk.MarshalMsg(nil); len(msg) != dk.Msgsize() {
+		t.Error("Msgsize doesn't match MarshalMsg")
+	}
+
+	zero := append(sk.ExtractDetectionKey(2).Encode(nil), make([]byte, rateSize)...)
+	if _, err := DecodeDetectionKey(zero); err == nil {
+		t.Error("decoded a key with a zero threshold")
+	}
+}
@@ -0,0 +1,114 @@
+package gophertags
+
+import (
+	"encoding/binary"
+	"hash"
+	"io"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+// Scratch holds the temporaries of DetectionKey.TestScratch, so a detection
+// loop that reuses one Scratch allocates nothing per flag once it has warmed
+// up. The zero value is ready to use. A Scratch must not be used by more than
+// one goroutine at a time.
+type Scratch struct {
+	m     r255.Scalar
+	w, xU r255.Element
+	wEnc  [elementSize]byte
+	buf   [64]byte
+	bits  []byte
+
+	schemeID   byte
+	squeeze    bool // hashes are SHA3, whose Sum allocates; read them instead
+	bitHash    hash.Hash
+	scalarHash hash.Hash
+	context    string
+	prefix     []byte
+}
+
+// reset prepares the scratch hashes for keys with params p.
+func (s *Scratch) reset(p params) {
+	scheme := p.scheme()
+	if s.bitHash == nil || s.schemeID != scheme.ID() {
+		s.schemeID = scheme.ID()
+		_, s.squeeze = scheme.(sha3Scheme)
+		s.bitHash, s.scalarHash = scheme.NewBitHash(), scheme.NewScalarHash()
+		s.context, s.prefix = p.context, p.contextPrefix()
+	} else if s.context != p.context {
+		s.context, s.prefix = p.context, p.contextPrefix()
+	}
+}
+
+// sum returns the digest of h in s.buf.
+func (s *Scratch) sum(h hash.Hash) []byte {
+	if r, ok := h.(io.Reader); ok && s.squeeze {
+		// x/crypto's SHA3 Sum copies the state to the heap and reads the
+		// copy. Reading h directly is the same, and h is reset before reuse.
+		// SHA3 implementations that can't be read, such as the standard
+		// library's, fall back to Sum.
+		out := s.buf[:h.Size()]
+		r.Read(out)
+		return out
+	}
+	return h.Sum(s.buf[:0])
+}
+
+// TestScratch is Test using s for every temporary. For flags from Decode,
+// DecodeFlagBorrowed, DecodeFlags or GenerateFlag it allocates nothing. It
+// bypasses any Accelerator.
+func (dk *DetectionKey) TestScratch(f *Flag, s *Scratch) bool {
+	if f.u.Equal(identityElement) == 1 || f.y.Equal(zeroScalar) == 1 {
+		return false
+	}
+	if dk.scheme().ID() != schemeOf(f.hash).ID() {
+		return false
+	}
+	s.reset(dk.params)
+	uEnc := f.encodedU()
+
+	m := &f.m
+	if !f.hasM || f.mContext != dk.context {
+		// As hashFlagToScalar, with no binding.
+		packed := f.borrowed
+		if packed == nil {
+			s.bits = appendBits(s.bits[:0], f.ciphertexts, f.gamma)
+			packed = s.bits
+		}
+		s.scalarHash.Reset()
+		s.scalarHash.Write(s.prefix)
+		s.scalarHash.Write(packed)
+		s.scalarHash.Write(uEnc)
+		m = s.m.FromUniformBytes(s.sum(s.scalarHash))
+	}
+
+	// m and y are public, so w may be computed in variable time.
+	s.w.VarTimeDoubleScalarBaseMult(f.y, f.u, m)
+	s.w.Encode(s.wEnc[:0])
+
+	xs := dk.internal
+	if len(xs) > 0 && dk.threshold != 0 {
+		// As testsLastBit.
+		s.bitHash.Reset()
+		s.bitHash.Write(s.prefix)
+		s.bitHash.Write(rateLabelBytes)
+		s.bitHash.Write(uEnc)
+		s.bitHash.Write(f.y.Encode(s.buf[:0]))
+		if binary.BigEndian.Uint64(s.sum(s.bitHash)) >= dk.threshold {
+			xs = xs[:len(xs)-1]
+		}
+	}
+
+	var pass uint = 0x01
+	for i, x := range xs {
+		s.xU.ScalarMult(x, f.u)
+		s.bitHash.Reset()
+		s.bitHash.Write(s.prefix)
+		s.bitHash.Write(uEnc)
+		s.bitHash.Write(s.xU.Encode(s.buf[:0]))
+		s.bitHash.Write(s.wEnc[:])
+		k := uint(s.sum(s.bitHash)[0] & 0x01)
+		pass &= k ^ f.bit(i)
+	}
+	return pass == 0x01
+}
@@ -0,0 +1,65 @@
+package gophertags
+
+import (
+	"bytes"
+	"hash"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+func TestTestScratch(t *testing.T) {
+	var s Scratch
+	keys := []*SecretKey{NewSecretKey(16), NewSecretKeyWithContext(16, "app"), NewSecretKeyWithHash(16, BLAKE2b)}
+	for _, sk := range keys {
+		other := NewSecretKey(16).PublicKey().WithContext(sk.Context())
+		for _, dk := range []*DetectionKey{sk.ExtractDetectionKey(3), sk.ExtractDetectionKeyRate(0.2)} {
+			for i := 0; i < 40; i++ {
+				f := other.GenerateFlag()
+				if i%2 == 0 {
+					f = sk.PublicKey().GenerateFlag()
+				}
+				decoded, _ := DecodeFlagBorrowed(f.Encode(nil), 16)
+				for _, g := range []*Flag{f, decoded} {
+					if got, want := dk.TestScratch(g, &s), dk.Test(g); got != want {
+						t.Fatalf("%v: TestScratch = %v, Test = %v", sk, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTestScratchAllocs(t *testing.T) {
+	sk := NewSecretKey(16)
+	contextKey := NewSecretKeyWithContext(16, "app")
+	f := new(Flag)
+	if err := f.Decode(sk.PublicKey().GenerateFlag().Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+	for name, dk := range map[string]*DetectionKey{
+		"plain":      sk.ExtractDetectionKey(16),
+		"fractional": sk.ExtractDetectionKeyRate(0.3),
+		"context":    contextKey.ExtractDetectionKey(16),
+	} {
+		var s Scratch
+		dk.TestScratch(f, &s)
+		if allocs := testing.AllocsPerRun(20, func() { dk.TestScratch(f, &s) }); allocs != 0 {
+			t.Errorf("%s: TestScratch allocates %v times per run", name, allocs)
+		}
+	}
+}
+
+// sumOnly hides any Read method of its hash, like SHA3 implementations that
+// can only Sum.
+type sumOnly struct{ hash.Hash }
+
+func TestScratchSumUnreadable(t *testing.T) {
+	s := Scratch{squeeze: true}
+	h := sumOnly{sha3.New512()}
+	h.Write([]byte("flag"))
+	want := sha3.Sum512([]byte("flag"))
+	if got := s.sum(h); !bytes.Equal(got, want[:]) {
+		t.Errorf("sum = %x, want %x", got, want)
+	}
+}
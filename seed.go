@@ -0,0 +1,85 @@
+package gophertags
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+// Seed-derived keys compute secret scalar i as
+//
+//	x_i = FromUniformBytes(HMAC-SHA512(seed, "gophertags seed v1" || uint32be(i)))
+//
+// HMAC-SHA512 was chosen because hardware tokens implement it natively
+// (PKCS#11 CKM_SHA512_HMAC), so the seed never has to leave the token.
+
+const seedLabel = "gophertags seed v1"
+
+// SeedSize is the length in bytes of seeds for NewSecretKeyFromSeed. Seeds have
+// a fixed length because HMAC treats keys that differ only in trailing zero
+// bytes as the same key.
+const SeedSize = 32
+
+// SeedPRF computes HMAC-SHA512 keyed with a secret seed. It is the only
+// operation needed on the seed, so the seed itself can live in hardware.
+type SeedPRF interface {
+	HMACSHA512(message []byte) ([]byte, error)
+}
+
+type softwareSeed []byte
+
+func (seed softwareSeed) HMACSHA512(message []byte) ([]byte, error) {
+	mac := hmac.New(sha512.New, seed)
+	mac.Write(message)
+	return mac.Sum(nil), nil
+}
+
+// NewSecretKeyFromSeed deterministically derives a secret key with the given
+// gamma from a uniformly random seed of SeedSize bytes. Keys derived from the
+// same seed with different gammas agree on their common prefix. It panics if
+// len(seed) != SeedSize.
+//
+// It is NewSecretKey(gamma, WithSeed(seed)).
+func NewSecretKeyFromSeed(gamma int, seed []byte) *SecretKey {
+	return NewSecretKey(gamma, WithSeed(seed))
+}
+
+// newLazySecretKeyFromSeed is NewSecretKeyFromSeed without the public key.
+func newLazySecretKeyFromSeed(gamma int, seed []byte) *SecretKey {
+	if len(seed) != SeedSize {
+		panic("gophertags: bad seed length")
+	}
+	// Each scalar depends only on the seed and its index, so deriving them in
+	// parallel gives the same key.
+	key := &SecretKey{sk: make([]*r255.Scalar, gamma)}
+	errs := make([]error, gamma)
+	parallelFor(gamma, func(i int) {
+		key.sk[i], errs[i] = deriveScalar(softwareSeed(seed), i)
+	})
+	for _, err := range errs {
+		if err != nil {
+			panic("gophertags: software HMAC failed: " + err.Error())
+		}
+	}
+	return key
+}
+
+var errShortPRFOutput = errors.New("gophertags: SeedPRF returned fewer than 64 bytes")
+
+func deriveScalar(prf SeedPRF, i int) (*r255.Scalar, error) {
+	message := make([]byte, len(seedLabel)+4)
+	copy(message, seedLabel)
+	binary.BigEndian.PutUint32(message[len(seedLabel):], uint32(i))
+
+	out, err := prf.HMACSHA512(message)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) < 64 {
+		return nil, errShortPRFOutput
+	}
+	return r255.NewScalar().FromUniformBytes(out[:64]), nil
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// anomalyThreshold is how many standard deviations a key's match count may
+// stray from what its precision predicts before its stats are marked
+// anomalous. By chance, about one key in 16,000 strays this far.
+const anomalyThreshold = 4
+
+// matchCounts tallies, for each registered key, how many submitted flags were
+// tested against it and how many matched. It is safe for concurrent use.
+type matchCounts struct {
+	mu     sync.Mutex
+	counts map[gophertags.KeyID]*keyCounts
+}
+
+type keyCounts struct {
+	tested, matched uint64
+}
+
+func newMatchCounts() *matchCounts {
+	return &matchCounts{counts: make(map[gophertags.KeyID]*keyCounts)}
+}
+
+func (m *matchCounts) record(results []Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range results {
+		c := m.counts[r.KeyID]
+		if c == nil {
+			c = new(keyCounts)
+			m.counts[r.KeyID] = c
+		}
+		c.tested++
+		if r.Matched {
+			c.matched++
+		}
+	}
+}
+
+// get returns the counts for id.
+func (m *matchCounts) get(id gophertags.KeyID) keyCounts {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c := m.counts[id]; c != nil {
+		return *c
+	}
+	return keyCounts{}
+}
+
+// retain forgets the counts of keys not in ids.
+func (m *matchCounts) retain(ids []gophertags.KeyID) {
+	keep := make(map[gophertags.KeyID]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.counts {
+		if !keep[id] {
+			delete(m.counts, id)
+		}
+	}
+}
+
+type adminStatsResponse struct {
+	Keys     int               `json:"keys"`
+	KeyStats []keyStats        `json:"key_stats"`
+	Queues   map[string]int    `json:"queues,omitempty"`
+	Watch    *watchStatsJSON   `json:"watch,omitempty"`
+	Storage  *storageStatsJSON `json:"storage,omitempty"`
+}
+
+// keyStats compares a key's matches with what its precision predicts. A key
+// with a large positive deviation matches more than false positives explain:
+// its recipient gets enough real traffic to stand out from the cover its
+// precision gives, or the key was registered at a lower precision than its
+// owner intended. A large negative deviation suggests flags made for the key
+// don't test as the server expects, such as ones with the wrong gamma.
+type keyStats struct {
+	KeyID        string  `json:"key_id"`
+	Precision    int     `json:"precision"`
+	ExpectedRate float64 `json:"expected_rate"`
+	Tested       uint64  `json:"tested"`
+	Matched      uint64  `json:"matched"`
+	ObservedRate float64 `json:"observed_rate"`
+	Deviation    float64 `json:"deviation"` // in standard deviations
+	Anomalous    bool    `json:"anomalous,omitempty"`
+}
+
+type watchStatsJSON struct {
+	Keys          int `json:"keys"`
+	Subscriptions int `json:"subscriptions"`
+	Buffered      int `json:"buffered"`
+}
+
+type storageStatsJSON struct {
+	Messages int64 `json:"messages"`
+	Matches  int64 `json:"matches"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func newKeyStats(id gophertags.KeyID, dk *gophertags.DetectionKey, c keyCounts) keyStats {
+	p := dk.FalsePositiveRate()
+	ks := keyStats{
+		KeyID:        id.String(),
+		Precision:    dk.Precision(),
+		ExpectedRate: p,
+		Tested:       c.tested,
+		Matched:      c.matched,
+	}
+	if c.tested == 0 {
+		return ks
+	}
+	n := float64(c.tested)
+	ks.ObservedRate = float64(c.matched) / n
+	if sd := math.Sqrt(n * p * (1 - p)); sd > 0 {
+		ks.Deviation = (float64(c.matched) - n*p) / sd
+		ks.Anomalous = math.Abs(ks.Deviation) >= anomalyThreshold
+	}
+	return ks
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := s.authorize(w, r, ScopeAdmin); !ok {
+		return
+	}
+	ids := s.detector.IDs()
+	s.counts.retain(ids)
+	resp := adminStatsResponse{KeyStats: make([]keyStats, 0, len(ids))}
+	for _, id := range ids {
+		dk, ok := s.detector.Key(id)
+		if !ok {
+			continue // removed since IDs
+		}
+		resp.KeyStats = append(resp.KeyStats, newKeyStats(id, dk, s.counts.get(id)))
+	}
+	resp.Keys = len(resp.KeyStats)
+	if len(s.queues) > 0 {
+		resp.Queues = make(map[string]int, len(s.queues))
+		for name, depth := range s.queues {
+			resp.Queues[name] = depth()
+		}
+	}
+	if s.watchers != nil {
+		ws := s.watchers.Stats()
+		resp.Watch = &watchStatsJSON{Keys: ws.Keys, Subscriptions: ws.Subscriptions, Buffered: ws.Buffered}
+	}
+	if reporter, ok := s.store.(mailbox.UsageReporter); ok {
+		u, err := reporter.Usage(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "querying storage usage failed")
+			return
+		}
+		resp.Storage = &storageStatsJSON{Messages: u.Messages, Matches: u.Matches, Bytes: u.Bytes}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+// withToken sends every request to h with the API token.
+func withToken(h http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func TestAdminStats(t *testing.T) {
+	keys := NewAPIKeys()
+	keys.Add("admin", APIKey{Tenant: "ops", Scopes: ScopeAdmin | ScopeSubmit})
+	keys.Add("reader", APIKey{Tenant: "alice", Scopes: ScopeRead})
+	s := New(Config{
+		APIKeys:  keys,
+		Watchers: NewWatchers(),
+		Queues:   map[string]func() int{"pipeline": func() int { return 3 }},
+	})
+	admin := withToken(s, "admin")
+
+	// Every flag is for alice, whose precision-2 key should match only a
+	// quarter of flags meant for others, so her count stands out. Bob's
+	// precision-0 key matches everything, as it predicts.
+	alice, bob := gophertags.NewSecretKey(8), gophertags.NewSecretKey(8)
+	aliceID := s.Detector().Add(alice.ExtractDetectionKey(2))
+	bobID := s.Detector().Add(bob.ExtractDetectionKey(0))
+	const n = 40
+	for i := 0; i < n; i++ {
+		if w := submit(t, admin, alice.PublicKey().GenerateFlag(), "x"); w.Code != http.StatusAccepted {
+			t.Fatalf("submitting: %d %s", w.Code, w.Body)
+		}
+	}
+	sub := s.watchers.Subscribe(aliceID, 0)
+	defer sub.Close()
+
+	if w := do(t, withToken(s, "reader"), http.MethodGet, "/v1/admin/stats", nil); w.Code != http.StatusForbidden {
+		t.Errorf("stats without ScopeAdmin: %d", w.Code)
+	}
+	if w := do(t, admin, http.MethodPost, "/v1/admin/stats", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST stats: %d", w.Code)
+	}
+	w := do(t, admin, http.MethodGet, "/v1/admin/stats", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("stats: %d %s", w.Code, w.Body)
+	}
+	var resp adminStatsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Keys != 2 || len(resp.KeyStats) != 2 {
+		t.Fatalf("stats = %+v", resp)
+	}
+	byID := map[string]keyStats{}
+	for _, ks := range resp.KeyStats {
+		byID[ks.KeyID] = ks
+	}
+	a := byID[aliceID.String()]
+	if a.Precision != 2 || a.ExpectedRate != 0.25 || a.Tested != n || a.Matched != n || a.ObservedRate != 1 || a.Deviation < anomalyThreshold || !a.Anomalous {
+		t.Errorf("alice's stats = %+v", a)
+	}
+	b := byID[bobID.String()]
+	if b.Precision != 0 || b.ExpectedRate != 1 || b.Tested != n || b.Matched != n || b.Deviation != 0 || b.Anomalous {
+		t.Errorf("bob's stats = %+v", b)
+	}
+	if resp.Queues["pipeline"] != 3 {
+		t.Errorf("queues = %v", resp.Queues)
+	}
+	if resp.Watch == nil || resp.Watch.Keys != 1 || resp.Watch.Subscriptions != 1 || resp.Watch.Buffered != 0 {
+		t.Errorf("watch = %+v", resp.Watch)
+	}
+	if resp.Storage == nil || resp.Storage.Messages != n || resp.Storage.Matches != 2*n {
+		t.Errorf("storage = %+v", resp.Storage)
+	}
+
+	if !s.Detector().Remove(bobID) {
+		t.Fatal("bob's key wasn't registered")
+	}
+	w = do(t, admin, http.MethodGet, "/v1/admin/stats", nil)
+	resp = adminStatsResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Keys != 1 || len(s.counts.counts) != 1 {
+		t.Errorf("stats after removing a key = %+v, %d counted", resp, len(s.counts.counts))
+	}
+
+	if w := do(t, New(Config{}), http.MethodGet, "/v1/admin/stats", nil); w.Code != http.StatusNotFound {
+		t.Errorf("stats without API keys: %d", w.Code)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gtank/gophertags"
+	"golang.org/x/crypto/sha3"
+)
+
+// Scope is a set of permissions granted by an API key. In a real deployment
+// the three roles belong to different parties: senders submit flags,
+// recipients or their agents register detection keys, and recipients read
+// their matches.
+type Scope uint8
+
+const (
+	// ScopeSubmit permits POST /v1/messages.
+	ScopeSubmit Scope = 1 << iota
+	// ScopeRegister permits POST /v1/keys.
+	ScopeRegister
+	// ScopeRead permits GET /v1/matches and /v1/watch.
+	ScopeRead
+	// ScopeAdmin permits GET /v1/admin/stats.
+	ScopeAdmin
+
+	// ScopeAll is every permission.
+	ScopeAll = ScopeSubmit | ScopeRegister | ScopeRead | ScopeAdmin
+)
+
+// APIKey is what an API token grants: a tenant and scopes. The tenant owns
+// the detection keys its tokens register, only its tokens may read their
+// matches, and rate limits can be accounted to it.
+type APIKey struct {
+	Tenant string
+	Scopes Scope
+}
+
+// apiTokenPrefix marks gophertags API tokens, so they are recognizable in
+// logs and secret scanners.
+const apiTokenPrefix = "gtk_"
+
+// APIKeys maps API tokens, sent as "Authorization: Bearer <token>", to
+// tenants and scopes. Set Config.APIKeys to make a Server require them. Only
+// digests of the tokens are held, so a memory dump doesn't reveal them. It is
+// safe for concurrent use.
+type APIKeys struct {
+	mu   sync.RWMutex
+	keys map[[32]byte]APIKey
+}
+
+// NewAPIKeys returns an empty set of API keys.
+func NewAPIKeys() *APIKeys {
+	return &APIKeys{keys: make(map[[32]byte]APIKey)}
+}
+
+// Issue returns a new random token granting key.
+func (k *APIKeys) Issue(key APIKey) (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b[:])
+	k.Add(token, key)
+	return token, nil
+}
+
+// Add makes token grant key, for tokens issued earlier and kept in
+// configuration. It replaces whatever token granted before.
+func (k *APIKeys) Add(token string, key APIKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[sha3.Sum256([]byte(token))] = key
+}
+
+// Revoke makes token grant nothing.
+func (k *APIKeys) Revoke(token string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, sha3.Sum256([]byte(token)))
+}
+
+// Lookup returns what token grants.
+func (k *APIKeys) Lookup(token string) (APIKey, bool) {
+	if token == "" {
+		return APIKey{}, false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[sha3.Sum256([]byte(token))]
+	return key, ok
+}
+
+// ClientID identifies a request by the tenant of its token, or by its remote
+// host if it has no valid token. Use it as RateLimitConfig.ClientID so that a
+// tenant's limits follow it across addresses.
+func (k *APIKeys) ClientID(r *http.Request) string {
+	if key, ok := k.Lookup(bearerToken(r)); ok {
+		return "tenant:" + key.Tenant
+	}
+	return remoteHost(r)
+}
+
+// bearerToken returns the request's token from its Authorization header or,
+// failing that, from its access_token parameter, since browsers can't set
+// headers on WebSocket handshakes. Tokens in URLs end up in logs, so clients
+// that can set the header should.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// authorize reports whether the request may proceed with the given scope,
+// responding 401 or 403 if not, and returns what its token grants. Without
+// API keys every request may, as the zero APIKey.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, scope Scope) (APIKey, bool) {
+	if s.apiKeys == nil {
+		return APIKey{}, true
+	}
+	key, ok := s.apiKeys.Lookup(bearerToken(r))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gophertags"`)
+		writeError(w, http.StatusUnauthorized, "missing or unknown API token")
+		return APIKey{}, false
+	}
+	if key.Scopes&scope != scope {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gophertags", error="insufficient_scope"`)
+		writeError(w, http.StatusForbidden, "API token lacks the scope for this request")
+		return APIKey{}, false
+	}
+	return key, true
+}
+
+// owns reports whether the tenant of key may read the matches of the
+// detection key with the given ID, responding 404 if not. Without API keys
+// anyone may. Unknown keys and other tenants' keys get the same answer, so
+// tenants can't probe which keys are registered.
+func (s *Server) owns(w http.ResponseWriter, key APIKey, id gophertags.KeyID) bool {
+	if s.registry == nil {
+		return true
+	}
+	if tenant, ok := s.registry.Tenant(id); !ok || tenant != key.Tenant {
+		writeError(w, http.StatusNotFound, "no such key")
+		return false
+	}
+	return true
+}
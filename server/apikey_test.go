@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestAPIKeys(t *testing.T) {
+	keys := NewAPIKeys()
+	sender, err := keys.Issue(APIKey{Tenant: "relay", Scopes: ScopeSubmit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, _ := keys.Issue(APIKey{Tenant: "alice", Scopes: ScopeRegister | ScopeRead})
+	if !strings.HasPrefix(sender, apiTokenPrefix) || sender == recipient {
+		t.Errorf("tokens %q and %q", sender, recipient)
+	}
+	s := New(Config{APIKeys: keys, Watchers: NewWatchers()})
+	sk := gophertags.NewSecretKey(8)
+	flag, _ := json.Marshal(messageRequest{Flag: sk.PublicKey().GenerateFlag().Encode(nil)})
+	key := "?key=" + sk.PublicKey().KeyID().String()
+
+	request := func(method, target, token string, body []byte) int {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(string(body)))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: 401 without WWW-Authenticate", method, target)
+		}
+		return w.Code
+	}
+	for _, tc := range []struct {
+		name, method, target, token string
+		body                        []byte
+		want                        int
+	}{
+		{"register without token", http.MethodPost, "/v1/keys", "", sk.ExtractDetectionKey(4).Encode(nil), http.StatusUnauthorized},
+		{"register with unknown token", http.MethodPost, "/v1/keys", "gtk_nope", sk.ExtractDetectionKey(4).Encode(nil), http.StatusUnauthorized},
+		{"register as sender", http.MethodPost, "/v1/keys", sender, sk.ExtractDetectionKey(4).Encode(nil), http.StatusForbidden},
+		{"register as recipient", http.MethodPost, "/v1/keys", recipient, sk.ExtractDetectionKey(4).Encode(nil), http.StatusCreated},
+		{"submit as recipient", http.MethodPost, "/v1/messages", recipient, flag, http.StatusForbidden},
+		{"submit as sender", http.MethodPost, "/v1/messages", sender, flag, http.StatusAccepted},
+		{"read as sender", http.MethodGet, "/v1/matches" + key, sender, nil, http.StatusForbidden},
+		{"read as recipient", http.MethodGet, "/v1/matches" + key, recipient, nil, http.StatusOK},
+		{"watch without token", http.MethodGet, "/v1/watch" + key, "", nil, http.StatusUnauthorized},
+		{"watch with token parameter", http.MethodGet, "/v1/watch" + key + "&access_token=" + recipient, "", nil, http.StatusBadRequest}, // not a WebSocket handshake
+	} {
+		if got := request(tc.method, tc.target, tc.token, tc.body); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	keys.Revoke(sender)
+	if got := request(http.MethodPost, "/v1/messages", sender, flag); got != http.StatusUnauthorized {
+		t.Errorf("revoked token: got %d", got)
+	}
+	keys.Add("configured", APIKey{Tenant: "ops", Scopes: ScopeAll})
+	if got := request(http.MethodPost, "/v1/messages", "configured", flag); got != http.StatusAccepted {
+		t.Errorf("added token: got %d", got)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/matches", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	if got := keys.ClientID(r); got != "192.0.2.1" {
+		t.Errorf("client ID without token = %q", got)
+	}
+	r.Header.Set("Authorization", "bearer "+recipient)
+	if got := keys.ClientID(r); got != "tenant:alice" {
+		t.Errorf("client ID with token = %q", got)
+	}
+}
+
+func TestAPIKeyTenants(t *testing.T) {
+	keys := NewAPIKeys()
+	keys.Add("sender", APIKey{Tenant: "relay", Scopes: ScopeSubmit})
+	keys.Add("alice", APIKey{Tenant: "alice", Scopes: ScopeRegister | ScopeRead})
+	keys.Add("bob", APIKey{Tenant: "bob", Scopes: ScopeRegister | ScopeRead})
+	keys.Add("bob-reader", APIKey{Tenant: "bob", Scopes: ScopeRead})
+	s := New(Config{APIKeys: keys, Watchers: NewWatchers()})
+
+	alice, bob := gophertags.NewSecretKey(8), gophertags.NewSecretKey(8)
+	request := func(method, target, token string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		return do(t, withToken(s, token), method, target, body)
+	}
+	if w := request(http.MethodPost, "/v1/keys", "alice", alice.ExtractDetectionKey(4).Encode(nil)); w.Code != http.StatusCreated {
+		t.Fatalf("registering alice's key: %d %s", w.Code, w.Body)
+	}
+	if w := request(http.MethodPost, "/v1/keys", "bob", bob.ExtractDetectionKey(4).Encode(nil)); w.Code != http.StatusCreated {
+		t.Fatalf("registering bob's key: %d %s", w.Code, w.Body)
+	}
+	flag, _ := json.Marshal(messageRequest{Flag: alice.PublicKey().GenerateFlag().Encode(nil)})
+	if w := request(http.MethodPost, "/v1/messages", "sender", flag); w.Code != http.StatusAccepted {
+		t.Fatalf("submitting: %d %s", w.Code, w.Body)
+	}
+	if tenant, _ := s.Registry().Tenant(alice.PublicKey().KeyID()); tenant != "alice" {
+		t.Errorf("alice's key registered to %q", tenant)
+	}
+
+	aliceKey := "?key=" + alice.PublicKey().KeyID().String()
+	unknownKey := "?key=" + gophertags.NewSecretKey(8).PublicKey().KeyID().String()
+	direct := gophertags.NewSecretKey(8)
+	s.Detector().Add(direct.ExtractDetectionKey(4))
+	directKey := "?key=" + direct.PublicKey().KeyID().String()
+	for _, tc := range []struct {
+		name, method, target, token string
+		body                        []byte
+		want                        int
+	}{
+		{"owner reads", http.MethodGet, "/v1/matches" + aliceKey, "alice", nil, http.StatusOK},
+		{"owner watches", http.MethodGet, "/v1/watch" + aliceKey, "alice", nil, http.StatusBadRequest}, // not a WebSocket handshake
+		{"other tenant reads", http.MethodGet, "/v1/matches" + aliceKey, "bob", nil, http.StatusNotFound},
+		{"other tenant's read token reads", http.MethodGet, "/v1/matches" + aliceKey, "bob-reader", nil, http.StatusNotFound},
+		{"other tenant watches", http.MethodGet, "/v1/watch" + aliceKey, "bob-reader", nil, http.StatusNotFound},
+		{"unknown key", http.MethodGet, "/v1/matches" + unknownKey, "alice", nil, http.StatusNotFound},
+		{"key added without a tenant", http.MethodGet, "/v1/matches" + directKey, "alice", nil, http.StatusNotFound},
+		{"other tenant registers owned key", http.MethodPost, "/v1/keys", "bob", alice.ExtractDetectionKey(2).Encode(nil), http.StatusForbidden},
+	} {
+		w := request(tc.method, tc.target, tc.token, tc.body)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if tc.want == http.StatusNotFound && strings.Contains(w.Body.String(), "messages") {
+			t.Errorf("%s: response leaks matches: %s", tc.name, w.Body)
+		}
+	}
+
+	var resp matchesResponse
+	w := request(http.MethodGet, "/v1/matches"+aliceKey, "alice", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Messages) != 1 {
+		t.Errorf("owner's matches = %s, %v", w.Body, err)
+	}
+}
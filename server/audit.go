@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"golang.org/x/crypto/sha3"
+)
+
+// AuditEntry records that a flag was tested against a detection key.
+//
+// Entries form a hash chain: each entry's Hash covers its fields and the
+// previous entry's Hash, so an operator who publishes or timestamps the
+// latest Hash commits to the whole history before it. An AuditAnchorer
+// timestamps it periodically.
+type AuditEntry struct {
+	Seq        uint64 // 1 for the first entry
+	Time       time.Time
+	KeyID      gophertags.KeyID
+	FlagDigest [32]byte // Flag.Digest of the tested flag
+	Matched    bool
+	Proof      gophertags.ResultProof // zero in entries written before proofs existed
+	Prev       [32]byte               // Hash of the previous entry, zero for the first
+	Hash       [32]byte
+}
+
+const auditLabel = "gophertags audit v1"
+
+// ErrAuditChain is returned by VerifyAuditLog for a log whose entries don't
+// form an unbroken hash chain.
+var ErrAuditChain = errors.New("server: audit log hash chain broken")
+
+func (e *AuditEntry) computeHash() [32]byte {
+	var buf [8]byte
+	digest := sha3.New256()
+	digest.Write([]byte(auditLabel))
+	digest.Write(e.Prev[:])
+	binary.BigEndian.PutUint64(buf[:], e.Seq)
+	digest.Write(buf[:])
+	binary.BigEndian.PutUint64(buf[:], uint64(e.Time.UnixNano()))
+	digest.Write(buf[:])
+	digest.Write(e.KeyID[:])
+	digest.Write(e.FlagDigest[:])
+	if e.Matched {
+		digest.Write([]byte{1})
+	} else {
+		digest.Write([]byte{0})
+	}
+	// Hashing the proof only when there is one keeps older logs verifiable.
+	if e.Proof != (gophertags.ResultProof{}) {
+		digest.Write(e.Proof[:])
+	}
+	var sum [32]byte
+	copy(sum[:], digest.Sum(nil))
+	return sum
+}
+
+// auditRecord is the JSON form of an AuditEntry, one per line.
+type auditRecord struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	KeyID      string    `json:"key_id"`
+	FlagDigest string    `json:"flag_digest"`
+	Matched    bool      `json:"matched"`
+	Proof      string    `json:"proof,omitempty"`
+	Prev       string    `json:"prev"`
+	Hash       string    `json:"hash"`
+}
+
+func (e *AuditEntry) record() auditRecord {
+	return auditRecord{
+		Seq:        e.Seq,
+		Time:       e.Time,
+		KeyID:      e.KeyID.String(),
+		FlagDigest: hex.EncodeToString(e.FlagDigest[:]),
+		Matched:    e.Matched,
+		Proof:      proofString(e.Proof),
+		Prev:       hex.EncodeToString(e.Prev[:]),
+		Hash:       hex.EncodeToString(e.Hash[:]),
+	}
+}
+
+func proofString(p gophertags.ResultProof) string {
+	if p == (gophertags.ResultProof{}) {
+		return ""
+	}
+	return p.String()
+}
+
+func (e *AuditEntry) fromRecord(r *auditRecord) error {
+	e.Seq, e.Time, e.Matched = r.Seq, r.Time, r.Matched
+	fields := []struct {
+		dst []byte
+		src string
+	}{
+		{e.KeyID[:], r.KeyID},
+		{e.FlagDigest[:], r.FlagDigest},
+		{e.Prev[:], r.Prev},
+		{e.Hash[:], r.Hash},
+	}
+	if r.Proof != "" {
+		fields = append(fields, struct {
+			dst []byte
+			src string
+		}{e.Proof[:], r.Proof})
+	}
+	for _, field := range fields {
+		b, err := hex.DecodeString(field.src)
+		if err != nil || len(b) != len(field.dst) {
+			return errors.New("server: malformed audit entry")
+		}
+		copy(field.dst, b)
+	}
+	return nil
+}
+
+// Check reports whether the entry is an honest record of testing f against
+// dk: that it names f and dk, and that its result and proof are what dk
+// computes. Recipients spot-check a mailbox by checking the entries for flags
+// they know were sent to them.
+func (e *AuditEntry) Check(dk *gophertags.DetectionKey, f *gophertags.Flag) bool {
+	return e.KeyID == dk.KeyID() && e.FlagDigest == f.Digest() && dk.VerifyResult(f, e.Matched, e.Proof)
+}
+
+// AuditLog appends hash-chained AuditEntry records to a writer as JSON lines.
+// It is safe for concurrent use.
+type AuditLog struct {
+	mu   sync.Mutex
+	w    io.Writer
+	last AuditEntry
+	now  func() time.Time
+}
+
+// NewAuditLog starts a new log on w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w, now: time.Now}
+}
+
+// ContinueAuditLog appends to an existing log whose last entry is last, as
+// returned by VerifyAuditLog.
+func ContinueAuditLog(w io.Writer, last AuditEntry) *AuditLog {
+	return &AuditLog{w: w, last: last, now: time.Now}
+}
+
+// Record appends an entry for each key the flag was tested against.
+func (l *AuditLog) Record(flagDigest [32]byte, results []Result) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now().UTC()
+	for _, result := range results {
+		e := AuditEntry{
+			Seq:        l.last.Seq + 1,
+			Time:       now,
+			KeyID:      result.KeyID,
+			FlagDigest: flagDigest,
+			Matched:    result.Matched,
+			Proof:      result.Proof,
+			Prev:       l.last.Hash,
+		}
+		e.Hash = e.computeHash()
+		line, err := json.Marshal(e.record())
+		if err != nil {
+			return err
+		}
+		if _, err := l.w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		l.last = e
+	}
+	return nil
+}
+
+// Head returns the most recent entry, whose Hash commits to the whole log.
+// It is the zero AuditEntry for an empty log.
+func (l *AuditLog) Head() AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// VerifyAuditLog reads a log written by AuditLog and checks its hash chain,
+// returning the last entry. It returns an error wrapping ErrAuditChain if any
+// entry was altered, removed, reordered or inserted.
+func VerifyAuditLog(r io.Reader) (AuditEntry, error) {
+	var last AuditEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return last, fmt.Errorf("server: audit entry %d: %v", last.Seq+1, err)
+		}
+		var e AuditEntry
+		if err := e.fromRecord(&rec); err != nil {
+			return last, err
+		}
+		if e.Seq != last.Seq+1 || e.Prev != last.Hash || e.Hash != e.computeHash() {
+			return last, fmt.Errorf("%w at entry %d", ErrAuditChain, last.Seq+1)
+		}
+		last = e
+	}
+	return last, scanner.Err()
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewAuditLog(&buf)
+	l.Record([32]byte{1}, []Result{{KeyID: gophertags.KeyID{1}, Matched: true}, {KeyID: gophertags.KeyID{2}}})
+	l.Record([32]byte{2}, []Result{{KeyID: gophertags.KeyID{1}}})
+
+	head, err := VerifyAuditLog(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != l.Head() || head.Seq != 3 {
+		t.Errorf("verified head %+v, log head %+v", head, l.Head())
+	}
+
+	// Continuing the log extends the same chain.
+	ContinueAuditLog(&buf, head).Record([32]byte{3}, []Result{{KeyID: gophertags.KeyID{2}, Matched: true}})
+	if head, err := VerifyAuditLog(&buf); err != nil || head.Seq != 4 {
+		t.Errorf("continued log: head %d, err %v", head.Seq, err)
+	}
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewAuditLog(&buf)
+	l.now = func() time.Time { return time.Unix(1600000000, 0) }
+	for i := byte(0); i < 3; i++ {
+		l.Record([32]byte{i}, []Result{{KeyID: gophertags.KeyID{i}}})
+	}
+	lines := strings.SplitAfter(buf.String(), "\n")[:3]
+
+	for name, tampered := range map[string]string{
+		"altered":   lines[0] + strings.Replace(lines[1], `"matched":false`, `"matched":true`, 1) + lines[2],
+		"removed":   lines[0] + lines[2],
+		"reordered": lines[1] + lines[0] + lines[2],
+	} {
+		if _, err := VerifyAuditLog(strings.NewReader(tampered)); !errors.Is(err, ErrAuditChain) {
+			t.Errorf("%s log: got %v, want ErrAuditChain", name, err)
+		}
+	}
+}
+
+func TestAuditEntryCheck(t *testing.T) {
+	sk := gophertags.NewSecretKey(16)
+	dk := sk.ExtractDetectionKey(8)
+	md := NewMultiDetector()
+	md.Add(dk)
+	canary := sk.PublicKey().GenerateFlag()
+
+	var buf bytes.Buffer
+	l := NewAuditLog(&buf)
+	l.Record(canary.Digest(), md.Results(canary))
+	head, err := VerifyAuditLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Proof == (gophertags.ResultProof{}) || !head.Check(dk, canary) {
+		t.Fatal("honest audit entry doesn't check")
+	}
+
+	// A mailbox that drops the match without testing is caught.
+	dropped := head
+	dropped.Matched, dropped.Proof = false, gophertags.ResultProof{}
+	if dropped.Check(dk, canary) {
+		t.Error("dropped match checks")
+	}
+	if head.Check(dk, sk.PublicKey().GenerateFlag()) {
+		t.Error("entry checks against another flag")
+	}
+}
@@ -0,0 +1,115 @@
+// Package server contains building blocks for detection servers: the
+// adversarial mailboxes that hold detection keys and test inbound flags.
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// DedupConfig bounds the memory used by a DedupIndex.
+type DedupConfig struct {
+	// MaxEntries is the maximum number of digests retained. When the index is
+	// full, the least recently seen digest is evicted. Zero means 65536.
+	MaxEntries int
+
+	// TTL is how long a digest is remembered after it was last seen. Zero
+	// means entries only leave the index through capacity eviction.
+	TTL time.Duration
+}
+
+const defaultDedupEntries = 1 << 16
+
+type dedupEntry struct {
+	digest   [32]byte
+	lastSeen time.Time
+}
+
+// DedupIndex remembers recently seen flags so a server can skip re-testing
+// identical flags that arrive via multiple routes. It is safe for concurrent use.
+type DedupIndex struct {
+	mu      sync.Mutex
+	config  DedupConfig
+	entries map[[32]byte]*list.Element
+	order   *list.List // front is most recently seen
+	now     func() time.Time
+}
+
+// NewDedupIndex returns an empty index with the given bounds.
+func NewDedupIndex(config DedupConfig) *DedupIndex {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaultDedupEntries
+	}
+	return &DedupIndex{
+		config:  config,
+		entries: make(map[[32]byte]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Seen records the flag and reports whether an identical flag was already in the index.
+func (d *DedupIndex) Seen(f *gophertags.Flag) bool {
+	return d.SeenDigest(f.Digest())
+}
+
+// SeenDigest is like Seen, but takes a precomputed digest.
+func (d *DedupIndex) SeenDigest(digest [32]byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.expire(now)
+
+	if elem, ok := d.entries[digest]; ok {
+		elem.Value.(*dedupEntry).lastSeen = now
+		d.order.MoveToFront(elem)
+		return true
+	}
+
+	d.entries[digest] = d.order.PushFront(&dedupEntry{digest: digest, lastSeen: now})
+	for d.order.Len() > d.config.MaxEntries {
+		d.evict(d.order.Back())
+	}
+	return false
+}
+
+// Forget removes a digest from the index, so that a flag whose processing
+// was abandoned isn't treated as a duplicate when it is resubmitted.
+func (d *DedupIndex) Forget(digest [32]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if elem, ok := d.entries[digest]; ok {
+		d.evict(elem)
+	}
+}
+
+// Len returns the number of digests currently retained.
+func (d *DedupIndex) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(d.now())
+	return d.order.Len()
+}
+
+// expire drops entries older than the TTL. Entries are ordered by last use,
+// so it only ever has to look at the back of the list.
+func (d *DedupIndex) expire(now time.Time) {
+	if d.config.TTL <= 0 {
+		return
+	}
+	for back := d.order.Back(); back != nil; back = d.order.Back() {
+		if now.Sub(back.Value.(*dedupEntry).lastSeen) < d.config.TTL {
+			return
+		}
+		d.evict(back)
+	}
+}
+
+func (d *DedupIndex) evict(elem *list.Element) {
+	d.order.Remove(elem)
+	delete(d.entries, elem.Value.(*dedupEntry).digest)
+}
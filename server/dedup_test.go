@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestDedupIndex(t *testing.T) {
+	pk := gophertags.NewSecretKey(8).PublicKey()
+	f1, f2 := pk.GenerateFlag(), pk.GenerateFlag()
+
+	d := NewDedupIndex(DedupConfig{MaxEntries: 2})
+	if d.Seen(f1) {
+		t.Error("fresh flag reported as seen")
+	}
+	if !d.Seen(f1) {
+		t.Error("repeated flag not reported as seen")
+	}
+	if d.Seen(f2) {
+		t.Error("distinct flag reported as seen")
+	}
+}
+
+func TestDedupEviction(t *testing.T) {
+	d := NewDedupIndex(DedupConfig{MaxEntries: 2})
+
+	d.SeenDigest([32]byte{1})
+	d.SeenDigest([32]byte{2})
+	d.SeenDigest([32]byte{1}) // refresh 1, so 2 is now the oldest
+	d.SeenDigest([32]byte{3})
+
+	if d.Len() != 2 {
+		t.Fatalf("index holds %d entries, want 2", d.Len())
+	}
+	if !d.SeenDigest([32]byte{1}) {
+		t.Error("recently used digest was evicted")
+	}
+	if d.SeenDigest([32]byte{2}) {
+		t.Error("least recently used digest was not evicted")
+	}
+}
+
+func TestDedupTTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := NewDedupIndex(DedupConfig{TTL: time.Minute})
+	d.now = func() time.Time { return now }
+
+	d.SeenDigest([32]byte{1})
+	now = now.Add(30 * time.Second)
+	if !d.SeenDigest([32]byte{1}) {
+		t.Error("digest expired before its TTL")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if d.SeenDigest([32]byte{1}) {
+		t.Error("digest survived past its TTL")
+	}
+}
+
+func TestDedupForget(t *testing.T) {
+	d := NewDedupIndex(DedupConfig{})
+	d.SeenDigest([32]byte{1})
+	d.Forget([32]byte{1})
+	d.Forget([32]byte{2})
+	if d.Len() != 0 || d.SeenDigest([32]byte{1}) {
+		t.Error("forgotten digest still in the index")
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/gtank/gophertags"
+)
+
+// MultiDetector tests flags against every registered detection key, indexed
+// by KeyID. It is safe for concurrent use.
+type MultiDetector struct {
+	mu   sync.RWMutex
+	keys map[gophertags.KeyID]*gophertags.DetectionKey
+}
+
+// NewMultiDetector returns a detector with no keys.
+func NewMultiDetector() *MultiDetector {
+	return &MultiDetector{keys: make(map[gophertags.KeyID]*gophertags.DetectionKey)}
+}
+
+// Add registers a detection key, replacing any key with the same KeyID.
+func (m *MultiDetector) Add(dk *gophertags.DetectionKey) gophertags.KeyID {
+	id := dk.KeyID()
+	m.mu.Lock()
+	m.keys[id] = dk
+	m.mu.Unlock()
+	return id
+}
+
+// Remove unregisters the key with the given ID, reporting whether it was present.
+func (m *MultiDetector) Remove(id gophertags.KeyID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.keys[id]
+	delete(m.keys, id)
+	return ok
+}
+
+// Key returns the registered key with the given ID.
+func (m *MultiDetector) Key(id gophertags.KeyID) (*gophertags.DetectionKey, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dk, ok := m.keys[id]
+	return dk, ok
+}
+
+// IDs returns the IDs of the registered keys in ascending order.
+func (m *MultiDetector) IDs() []gophertags.KeyID {
+	m.mu.RLock()
+	ids := make([]gophertags.KeyID, 0, len(m.keys))
+	for id := range m.keys {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return string(ids[i][:]) < string(ids[j][:]) })
+	return ids
+}
+
+// Len returns the number of registered keys.
+func (m *MultiDetector) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.keys)
+}
+
+// Result is the outcome of testing a flag against one detection key.
+type Result struct {
+	KeyID   gophertags.KeyID
+	Matched bool
+	Proof   gophertags.ResultProof // see DetectionKey.TestWithProof
+}
+
+// Results tests the flag against every registered key, returning one Result
+// per key in ascending KeyID order.
+func (m *MultiDetector) Results(f *gophertags.Flag) []Result {
+	results, _ := m.ResultsContext(context.Background(), f)
+	return results
+}
+
+// ResultsContext is like Results, but checks ctx between keys and returns
+// ctx.Err() if it is done before every key has been tested.
+func (m *MultiDetector) ResultsContext(ctx context.Context, f *gophertags.Flag) ([]Result, error) {
+	m.mu.RLock()
+	results := make([]Result, 0, len(m.keys))
+	for id, dk := range m.keys {
+		if err := ctx.Err(); err != nil {
+			m.mu.RUnlock()
+			return nil, err
+		}
+		matched, proof := dk.TestWithProof(f)
+		results = append(results, Result{KeyID: id, Matched: matched, Proof: proof})
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return string(results[i].KeyID[:]) < string(results[j].KeyID[:])
+	})
+	return results, nil
+}
+
+// Match returns the IDs of all registered keys the flag matches, in ascending order.
+func (m *MultiDetector) Match(f *gophertags.Flag) []gophertags.KeyID {
+	return matchedKeys(m.Results(f))
+}
+
+func matchedKeys(results []Result) []gophertags.KeyID {
+	var matches []gophertags.KeyID
+	for _, r := range results {
+		if r.Matched {
+			matches = append(matches, r.KeyID)
+		}
+	}
+	return matches
+}
+
+// Detect implements gophertags.Detector, reporting whether the flag matches any registered key.
+func (m *MultiDetector) Detect(flag []byte) (bool, error) {
+	f := new(gophertags.Flag)
+	if err := f.Decode(flag); err != nil {
+		return false, err
+	}
+	return len(m.Match(f)) > 0, nil
+}
+
+var _ gophertags.Detector = (*MultiDetector)(nil)
+
+// DetectStream reads encoded flags from in and sends those d detects to out,
+// until in is closed or ctx is done. Flags that can't be decoded are skipped.
+// It returns nil when in is closed and ctx.Err() otherwise.
+func DetectStream(ctx context.Context, d gophertags.Detector, in <-chan []byte, out chan<- []byte) error {
+	for {
+		var flag []byte
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case f, ok := <-in:
+			if !ok {
+				return nil
+			}
+			flag = f
+		}
+		if ok, err := d.Detect(flag); err != nil || !ok {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- flag:
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestMultiDetector(t *testing.T) {
+	alice, bob := gophertags.NewSecretKey(16), gophertags.NewSecretKey(16)
+
+	m := NewMultiDetector()
+	aliceID := m.Add(alice.ExtractDetectionKey(16))
+	bobID := m.Add(bob.ExtractDetectionKey(16))
+
+	matches := m.Match(alice.PublicKey().GenerateFlag())
+	if len(matches) != 1 || matches[0] != aliceID {
+		t.Errorf("flag for alice matched %v, want [%v]", matches, aliceID)
+	}
+
+	ok, err := m.Detect(bob.PublicKey().GenerateFlag().Encode(nil))
+	if err != nil || !ok {
+		t.Errorf("Detect(flag for bob) = %v, %v", ok, err)
+	}
+
+	if ids := m.IDs(); len(ids) != 2 || string(ids[0][:]) > string(ids[1][:]) || (ids[0] != aliceID && ids[1] != aliceID) {
+		t.Errorf("IDs = %v", ids)
+	}
+	if dk, ok := m.Key(bobID); !ok || dk.KeyID() != bobID {
+		t.Errorf("Key(bob) = %v, %v", dk, ok)
+	}
+
+	if !m.Remove(aliceID) || m.Remove(aliceID) {
+		t.Error("Remove doesn't report presence correctly")
+	}
+	if len(m.Match(alice.PublicKey().GenerateFlag())) != 0 {
+		t.Error("removed key still matches")
+	}
+	if _, ok := m.Key(aliceID); ok {
+		t.Error("Key returned a removed key")
+	}
+}
+
+func TestResultsContext(t *testing.T) {
+	sk := gophertags.NewSecretKey(8)
+	m := NewMultiDetector()
+	m.Add(sk.ExtractDetectionKey(8))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.ResultsContext(ctx, sk.PublicKey().GenerateFlag()); err != context.Canceled {
+		t.Errorf("cancelled ResultsContext returned %v", err)
+	}
+}
+
+func TestDetectStream(t *testing.T) {
+	alice, bob := gophertags.NewSecretKey(16), gophertags.NewSecretKey(16)
+	forAlice := alice.PublicKey().GenerateFlag().Encode(nil)
+
+	in, out := make(chan []byte, 3), make(chan []byte, 3)
+	in <- bob.PublicKey().GenerateFlag().Encode(nil)
+	in <- []byte("garbage")
+	in <- forAlice
+	close(in)
+	if err := DetectStream(context.Background(), alice.ExtractDetectionKey(16), in, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var got [][]byte
+	for flag := range out {
+		got = append(got, flag)
+	}
+	if len(got) != 1 || string(got[0]) != string(forAlice) {
+		t.Errorf("stream passed %d flags, want only alice's", len(got))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := DetectStream(ctx, alice.ExtractDetectionKey(16), make(chan []byte), out); err != context.Canceled {
+		t.Errorf("cancelled stream returned %v", err)
+	}
+}
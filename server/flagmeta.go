@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"golang.org/x/crypto/sha3"
+)
+
+// FlagMetadata is a flag with the routing metadata an ingestion tier attaches
+// to it, for handing to detection workers through queues or storage that
+// neither tier fully trusts. Seal and Open it with a MetadataKey, encoded as
+//
+//	version (1 byte) || uint16be(gamma) || uint64be(received unix nanos) || flag || MAC (32 bytes)
+//
+// where MAC is HMAC-SHA3-256 under the key of
+//
+//	"gophertags flag metadata v1" || everything before the MAC
+//
+// so a worker can trust that the flag, when it arrived and the gamma it was
+// routed by are as the ingestion tier recorded them.
+type FlagMetadata struct {
+	Flag     []byte
+	Received time.Time
+	Gamma    int
+}
+
+// MetadataKeySize is the size of a MetadataKey.
+const MetadataKeySize = 32
+
+// MetadataKey authenticates FlagMetadata. It is shared by the servers of one
+// deployment and never leaves them.
+type MetadataKey [MetadataKeySize]byte
+
+const (
+	flagMetadataVersion = 1
+	flagMetadataLabel   = "gophertags flag metadata v1"
+	flagMetadataMACSize = 32
+	flagMetadataHeader  = 1 + 2 + 8
+)
+
+var (
+	// ErrFlagMetadata is returned by MetadataKey.Open for data that is
+	// malformed or wasn't sealed under the key.
+	ErrFlagMetadata = errors.New("server: malformed or unauthentic flag metadata")
+	// ErrFlagGamma is returned by MetadataKey.Seal for flags whose length
+	// doesn't match their gamma.
+	ErrFlagGamma = errors.New("server: flag length doesn't match gamma")
+)
+
+// GenerateMetadataKey returns a new random MetadataKey.
+func GenerateMetadataKey() (*MetadataKey, error) {
+	key := new(MetadataKey)
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encodes and authenticates m.
+func (k *MetadataKey) Seal(m FlagMetadata) ([]byte, error) {
+	if m.Gamma <= 0 || m.Gamma > 0xFFFF || len(m.Flag) != gophertags.FlagSize(m.Gamma) {
+		return nil, ErrFlagGamma
+	}
+	out := make([]byte, flagMetadataHeader, flagMetadataHeader+len(m.Flag)+flagMetadataMACSize)
+	out[0] = flagMetadataVersion
+	binary.BigEndian.PutUint16(out[1:], uint16(m.Gamma))
+	binary.BigEndian.PutUint64(out[3:], uint64(m.Received.UnixNano()))
+	out = append(out, m.Flag...)
+	return append(out, k.mac(out)...), nil
+}
+
+// Open verifies and decodes metadata sealed under k.
+func (k *MetadataKey) Open(data []byte) (FlagMetadata, error) {
+	if len(data) < flagMetadataHeader+flagMetadataMACSize || data[0] != flagMetadataVersion {
+		return FlagMetadata{}, ErrFlagMetadata
+	}
+	body, mac := data[:len(data)-flagMetadataMACSize], data[len(data)-flagMetadataMACSize:]
+	if !hmac.Equal(mac, k.mac(body)) {
+		return FlagMetadata{}, ErrFlagMetadata
+	}
+	return FlagMetadata{
+		Flag:     append([]byte(nil), body[flagMetadataHeader:]...),
+		Received: time.Unix(0, int64(binary.BigEndian.Uint64(body[3:]))),
+		Gamma:    int(binary.BigEndian.Uint16(body[1:])),
+	}, nil
+}
+
+func (k *MetadataKey) mac(body []byte) []byte {
+	h := hmac.New(sha3.New256, k[:])
+	h.Write([]byte(flagMetadataLabel))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// DecodeFlag decodes the flag, strictly, for keys with the recorded gamma.
+func (m FlagMetadata) DecodeFlag() (*gophertags.Flag, error) {
+	return gophertags.DecodeFlag(m.Flag, m.Gamma)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestFlagMetadata(t *testing.T) {
+	key, err := GenerateMetadataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := gophertags.NewSecretKey(16)
+	flag := sk.PublicKey().GenerateFlag().Encode(nil)
+	received := time.Unix(1700000000, 123)
+
+	sealed, err := key.Seal(FlagMetadata{Flag: flag, Received: received, Gamma: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := key.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.Flag, flag) || !m.Received.Equal(received) || m.Gamma != 16 {
+		t.Errorf("opened %+v", m)
+	}
+	f, err := m.DecodeFlag()
+	if err != nil || !sk.ExtractDetectionKey(16).Test(f) {
+		t.Errorf("DecodeFlag = %v", err)
+	}
+
+	other, _ := GenerateMetadataKey()
+	if _, err := other.Open(sealed); err != ErrFlagMetadata {
+		t.Errorf("wrong key: got %v, want ErrFlagMetadata", err)
+	}
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := key.Open(tampered); err != ErrFlagMetadata {
+			t.Fatalf("byte %d flipped: got %v, want ErrFlagMetadata", i, err)
+		}
+	}
+	if _, err := key.Open(sealed[:flagMetadataHeader]); err != ErrFlagMetadata {
+		t.Errorf("truncated: got %v, want ErrFlagMetadata", err)
+	}
+
+	if _, err := key.Seal(FlagMetadata{Flag: flag, Gamma: 24}); err != ErrFlagGamma {
+		t.Errorf("wrong gamma: got %v, want ErrFlagGamma", err)
+	}
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Store holds submitted messages. Nil means a new mailbox.MemoryStore.
+	Store mailbox.Store
+
+	// Dedup, if set, drops flags identical to recently submitted ones before
+	// they are tested or stored.
+	Dedup *DedupIndex
+
+	// Audit, if set, records the result of testing every submitted flag
+	// against every registered key.
+	Audit *AuditLog
+
+	// Watchers, if set, is told of every stored message's matches, for
+	// clients watching their keys live. Messages are then stored one at a
+	// time, so they are published in ID order.
+	Watchers *Watchers
+
+	// MaxTTL caps the TTL a submitter may set on a message, after which the
+	// store may prune it. Zero means no cap. Prune the store with a
+	// mailbox.Pruner.
+	MaxTTL time.Duration
+
+	// APIKeys, if set, makes every request need an API token with the
+	// scope for its endpoint.
+	APIKeys *APIKeys
+
+	// Queues names functions returning the depths of queues in front of
+	// the server, such as RateLimiter.Waiting and Pipeline.Queued, for the
+	// admin stats.
+	Queues map[string]func() int
+
+	// MaxBodySize bounds request bodies. Zero means 1 MiB.
+	MaxBodySize int64
+}
+
+const defaultMaxBodySize = 1 << 20
+
+// Server is an HTTP detection API. It accepts detection key registrations
+// and flagged messages, tests each message against every registered key, and
+// answers queries for a key's matches:
+//
+//	POST /v1/keys                 body: encoded detection key
+//	POST /v1/messages             body: {"flag": base64, "payload": base64, "ttl": seconds}
+//	GET  /v1/matches?key=<KeyID>  matching messages, oldest first
+//
+// A message's optional TTL, capped at Config.MaxTTL, sets when the store may
+// prune it.
+//
+// Match queries are paginated: after=<ID> returns only messages with greater
+// IDs, and limit=<n> caps the page at n messages, 100 by default and at most
+// 1000. The response's "more" field is set if later messages remain; pass the
+// last message's ID as after to fetch them.
+//
+// If Config.Watchers is set, GET /v1/watch?key=<KeyID>&after=<ID> also pushes
+// the IDs of new matches over a WebSocket, for browsers.
+//
+// If Config.APIKeys is set, requests must carry an API token, as
+// "Authorization: Bearer <token>", whose scopes include ScopeRegister for
+// /v1/keys, ScopeSubmit for /v1/messages, or ScopeRead for /v1/matches and
+// /v1/watch. Requests without a valid token get 401 Unauthorized, and those
+// with too few scopes 403 Forbidden. Keys registered with a token belong to
+// its tenant, and only tokens of that tenant may read or watch their matches;
+// for other keys the server answers 404 Not Found, as for unknown ones. A key
+// can't be registered by a second tenant. With API keys the server also answers
+//
+//	GET  /v1/admin/stats          key, queue, watch and storage statistics
+//
+// for tokens with ScopeAdmin. For each registered key it reports how many
+// flags were tested against it and how many matched, beside the false
+// positive rate its precision predicts and the deviation from it in standard
+// deviations, so operators can spot keys registered at the wrong precision
+// and keys drawing unusual traffic.
+//
+// Wrap it with RateLimit before exposing it publicly.
+type Server struct {
+	detector *MultiDetector
+	store    mailbox.Store
+	dedup    *DedupIndex
+	audit    *AuditLog
+	watchers *Watchers
+	publish  sync.Mutex // orders Put and Publish when watchers is set
+	apiKeys  *APIKeys
+	registry *Registry
+	queues   map[string]func() int
+	counts   *matchCounts
+	maxTTL   time.Duration
+	maxBody  int64
+	mux      *http.ServeMux
+}
+
+// New returns a Server with no registered keys.
+func New(config Config) *Server {
+	s := &Server{
+		detector: NewMultiDetector(),
+		store:    config.Store,
+		dedup:    config.Dedup,
+		audit:    config.Audit,
+		watchers: config.Watchers,
+		apiKeys:  config.APIKeys,
+		queues:   config.Queues,
+		maxTTL:   config.MaxTTL,
+		maxBody:  config.MaxBodySize,
+		mux:      http.NewServeMux(),
+	}
+	if s.store == nil {
+		s.store = mailbox.NewMemoryStore()
+	}
+	if s.maxBody <= 0 {
+		s.maxBody = defaultMaxBodySize
+	}
+	s.mux.HandleFunc("/v1/keys", s.handleKeys)
+	s.mux.HandleFunc("/v1/messages", s.handleMessages)
+	s.mux.HandleFunc("/v1/matches", s.handleMatches)
+	if s.watchers != nil {
+		s.mux.HandleFunc("/v1/watch", s.handleWatch)
+	}
+	if s.apiKeys != nil {
+		s.registry = newRegistry(RegistryConfig{}, s.detector)
+		s.counts = newMatchCounts()
+		s.mux.HandleFunc("/v1/admin/stats", s.handleAdminStats)
+	}
+	return s
+}
+
+// Detector returns the detector holding the server's registered keys.
+func (s *Server) Detector() *MultiDetector {
+	return s.detector
+}
+
+// Registry returns the registry recording which tenant registered each key,
+// or nil if the server has no API keys. Keys added to the Detector directly
+// belong to no tenant, so no token can read their matches.
+func (s *Server) Registry() *Registry {
+	return s.registry
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type keyResponse struct {
+	KeyID string `json:"key_id"`
+}
+
+type messageRequest struct {
+	Flag    []byte `json:"flag"`
+	Payload []byte `json:"payload"`
+	TTL     int64  `json:"ttl,omitempty"` // seconds
+}
+
+type messageResponse struct {
+	ID        uint64 `json:"id,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+type matchedMessage struct {
+	ID       uint64    `json:"id"`
+	Flag     []byte    `json:"flag"`
+	Payload  []byte    `json:"payload"`
+	Received time.Time `json:"received"`
+}
+
+type matchesResponse struct {
+	Messages []matchedMessage `json:"messages"`
+	More     bool             `json:"more,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	key, ok := s.authorize(w, r, ScopeRegister)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+	dk, err := gophertags.DecodeDetectionKey(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.registry == nil {
+		id := s.detector.Add(dk)
+		writeJSON(w, http.StatusCreated, keyResponse{KeyID: id.String()})
+		return
+	}
+	id, err := s.registry.Add(key.Tenant, dk)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusCreated, keyResponse{KeyID: id.String()})
+	case ErrPolicy, ErrKeyOwned, ErrRevoked:
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "registering key failed")
+	}
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if _, ok := s.authorize(w, r, ScopeSubmit); !ok {
+		return
+	}
+	var req messageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+	if req.TTL < 0 || req.TTL > int64(math.MaxInt64/time.Second) {
+		writeError(w, http.StatusBadRequest, "ttl must be a non-negative number of seconds")
+		return
+	}
+	f := new(gophertags.Flag)
+	if err := f.Decode(req.Flag); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	digest := f.Digest()
+	if s.dedup != nil && s.dedup.SeenDigest(digest) {
+		writeJSON(w, http.StatusOK, messageResponse{Duplicate: true})
+		return
+	}
+	results, err := s.detector.ResultsContext(r.Context(), f)
+	if err != nil {
+		if s.dedup != nil {
+			s.dedup.Forget(digest)
+		}
+		writeError(w, http.StatusServiceUnavailable, "request cancelled")
+		return
+	}
+	if s.counts != nil {
+		s.counts.record(results)
+	}
+	if s.audit != nil {
+		if err := s.audit.Record(digest, results); err != nil {
+			writeError(w, http.StatusInternalServerError, "recording audit entry failed")
+			return
+		}
+	}
+	msg := mailbox.Message{Flag: req.Flag, Payload: req.Payload}
+	if s.watchers != nil || req.TTL > 0 {
+		msg.Received = time.Now() // so watchers see what the store keeps
+	}
+	if req.TTL > 0 {
+		ttl := time.Duration(req.TTL) * time.Second
+		if s.maxTTL > 0 && ttl > s.maxTTL {
+			ttl = s.maxTTL
+		}
+		msg.Expires = msg.Received.Add(ttl)
+	}
+	matches := matchedKeys(results)
+	id, err := s.put(r.Context(), msg, matches)
+	if err != nil {
+		if s.dedup != nil {
+			s.dedup.Forget(digest)
+		}
+		writeError(w, http.StatusInternalServerError, "storing message failed")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, messageResponse{ID: id})
+}
+
+// put stores msg and publishes it to the watchers. Follow skips live
+// messages at or below its cursor, so a message published after one with a
+// higher ID would be lost to it: with watchers set, storing and publishing
+// happen under one lock.
+func (s *Server) put(ctx context.Context, msg mailbox.Message, matches []gophertags.KeyID) (uint64, error) {
+	if s.watchers == nil {
+		return s.store.Put(ctx, msg, matches)
+	}
+	s.publish.Lock()
+	defer s.publish.Unlock()
+	id, err := s.store.Put(ctx, msg, matches)
+	if err == nil && len(matches) > 0 {
+		msg.ID = id
+		s.watchers.Publish(msg, matches)
+	}
+	return id, err
+}
+
+func (s *Server) handleMatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	key, ok := s.authorize(w, r, ScopeRead)
+	if !ok {
+		return
+	}
+	id, err := parseKeyID(r.URL.Query().Get("key"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.owns(w, key, id) {
+		return
+	}
+	writeMatches(w, r, s.store, id)
+}
+
+const (
+	defaultMatchesLimit = 100
+	maxMatchesLimit     = 1000
+)
+
+// writeMatches answers a paginated query for the key's matches, using the
+// request's after and limit parameters.
+func writeMatches(w http.ResponseWriter, r *http.Request, store mailbox.Store, id gophertags.KeyID) {
+	q := r.URL.Query()
+	var cursor uint64
+	if after := q.Get("after"); after != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(after, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "after must be a message ID")
+			return
+		}
+	}
+	limit := defaultMatchesLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		if limit = n; limit > maxMatchesLimit {
+			limit = maxMatchesLimit
+		}
+	}
+	// Fetch one extra message to learn whether there are more.
+	messages, err := store.MatchesSince(r.Context(), id, cursor, limit+1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "querying matches failed")
+		return
+	}
+	more := len(messages) > limit
+	if more {
+		messages = messages[:limit]
+	}
+	resp := newMatchesResponse(messages)
+	resp.More = more
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func newMatchesResponse(messages []mailbox.Message) matchesResponse {
+	resp := matchesResponse{Messages: make([]matchedMessage, len(messages))}
+	for i, msg := range messages {
+		resp.Messages[i] = matchedMessage{ID: msg.ID, Flag: msg.Flag, Payload: msg.Payload, Received: msg.Received}
+	}
+	return resp
+}
+
+func parseKeyID(s string) (gophertags.KeyID, error) {
+	var id gophertags.KeyID
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, errors.New("key must be a hex-encoded key ID")
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+func do(t *testing.T, h http.Handler, method, target string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewReader(body)))
+	return w
+}
+
+func submit(t *testing.T, h http.Handler, f *gophertags.Flag, payload string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(messageRequest{Flag: f.Encode(nil), Payload: []byte(payload)})
+	return do(t, h, http.MethodPost, "/v1/messages", body)
+}
+
+func TestServer(t *testing.T) {
+	s := New(Config{Dedup: NewDedupIndex(DedupConfig{})})
+	alice, bob := gophertags.NewSecretKey(16), gophertags.NewSecretKey(16)
+
+	w := do(t, s, http.MethodPost, "/v1/keys", alice.ExtractDetectionKey(16).Encode(nil))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("registering key: %d %s", w.Code, w.Body)
+	}
+	var key keyResponse
+	json.NewDecoder(w.Body).Decode(&key)
+	if key.KeyID != alice.PublicKey().KeyID().String() {
+		t.Errorf("registered key ID %q, want %v", key.KeyID, alice.PublicKey().KeyID())
+	}
+
+	flag := alice.PublicKey().GenerateFlag()
+	if w := submit(t, s, flag, "for alice"); w.Code != http.StatusAccepted {
+		t.Fatalf("submitting message: %d %s", w.Code, w.Body)
+	}
+	if w := submit(t, s, bob.PublicKey().GenerateFlag(), "for bob"); w.Code != http.StatusAccepted {
+		t.Fatalf("submitting message: %d %s", w.Code, w.Body)
+	}
+	w = submit(t, s, flag, "for alice")
+	var dup messageResponse
+	json.NewDecoder(w.Body).Decode(&dup)
+	if w.Code != http.StatusOK || !dup.Duplicate {
+		t.Errorf("resubmitting a flag: %d %+v", w.Code, dup)
+	}
+
+	w = do(t, s, http.MethodGet, "/v1/matches?key="+key.KeyID, nil)
+	var matches matchesResponse
+	json.NewDecoder(w.Body).Decode(&matches)
+	if w.Code != http.StatusOK || len(matches.Messages) != 1 || string(matches.Messages[0].Payload) != "for alice" {
+		t.Errorf("alice's matches: %d %+v", w.Code, matches)
+	}
+}
+
+func TestServerRejectsBadRequests(t *testing.T) {
+	s := New(Config{})
+	for _, tc := range []struct {
+		method, target string
+		body           []byte
+		want           int
+	}{
+		{http.MethodPost, "/v1/keys", []byte{1, 2, 3}, http.StatusBadRequest},
+		{http.MethodGet, "/v1/keys", nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/v1/messages", []byte("{"), http.StatusBadRequest},
+		{http.MethodPost, "/v1/messages", []byte(`{"flag":"AAAA"}`), http.StatusBadRequest},
+		{http.MethodGet, "/v1/matches?key=zz", nil, http.StatusBadRequest},
+		{http.MethodGet, "/v1/matches?key=" + gophertags.KeyID{}.String() + "&after=-1", nil, http.StatusBadRequest},
+		{http.MethodGet, "/v1/matches?key=" + gophertags.KeyID{}.String() + "&limit=0", nil, http.StatusBadRequest},
+		{http.MethodPost, "/v1/keys", make([]byte, defaultMaxBodySize+1), http.StatusRequestEntityTooLarge},
+	} {
+		if w := do(t, s, tc.method, tc.target, tc.body); w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.target, w.Code, tc.want)
+		}
+	}
+}
+
+func TestServerMatchesPagination(t *testing.T) {
+	store := mailbox.NewMemoryStore()
+	s := New(Config{Store: store})
+	key := gophertags.KeyID{1}
+	for i := 0; i < 5; i++ {
+		store.Put(context.Background(), mailbox.Message{Flag: []byte{byte(i)}}, []gophertags.KeyID{key})
+	}
+
+	var cursor uint64
+	var pages [][]uint64
+	for more := true; more; {
+		w := do(t, s, http.MethodGet, fmt.Sprintf("/v1/matches?key=%v&after=%d&limit=2", key, cursor), nil)
+		var resp matchesResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusOK || len(resp.Messages) == 0 {
+			t.Fatalf("page after %d: %d %+v", cursor, w.Code, resp)
+		}
+		var ids []uint64
+		for _, m := range resp.Messages {
+			ids = append(ids, m.ID)
+		}
+		pages = append(pages, ids)
+		cursor, more = ids[len(ids)-1], resp.More
+	}
+	if fmt.Sprint(pages) != "[[1 2] [3 4] [5]]" {
+		t.Errorf("pages = %v", pages)
+	}
+}
+
+func TestServerTTL(t *testing.T) {
+	store := mailbox.NewMemoryStore()
+	s := New(Config{Store: store, MaxTTL: time.Minute})
+	sk := gophertags.NewSecretKey(8)
+	dk := sk.ExtractDetectionKey(8)
+	s.Detector().Add(dk)
+
+	for _, ttl := range []int64{0, 30, 3600} {
+		body, _ := json.Marshal(messageRequest{Flag: sk.PublicKey().GenerateFlag().Encode(nil), TTL: ttl})
+		if w := do(t, s, http.MethodPost, "/v1/messages", body); w.Code != http.StatusAccepted {
+			t.Fatalf("submitting with ttl %d: %d %s", ttl, w.Code, w.Body)
+		}
+	}
+	got, _ := store.Matches(context.Background(), dk.KeyID())
+	if len(got) != 3 {
+		t.Fatalf("stored %d messages", len(got))
+	}
+	for i, want := range []time.Duration{0, 30 * time.Second, time.Minute} {
+		if msg := got[i]; want == 0 && !msg.Expires.IsZero() || want != 0 && msg.Expires.Sub(msg.Received) != want {
+			t.Errorf("message %d received %v expires %v, want after %v", i, msg.Received, msg.Expires, want)
+		}
+	}
+
+	body, _ := json.Marshal(messageRequest{Flag: sk.PublicKey().GenerateFlag().Encode(nil), TTL: -1})
+	if w := do(t, s, http.MethodPost, "/v1/messages", body); w.Code != http.StatusBadRequest {
+		t.Errorf("negative ttl: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestServerAudit(t *testing.T) {
+	var buf bytes.Buffer
+	s := New(Config{Audit: NewAuditLog(&buf)})
+	alice, bob := gophertags.NewSecretKey(16), gophertags.NewSecretKey(16)
+	s.Detector().Add(alice.ExtractDetectionKey(16))
+	s.Detector().Add(bob.ExtractDetectionKey(16))
+
+	submit(t, s, alice.PublicKey().GenerateFlag(), "")
+	head, err := VerifyAuditLog(&buf)
+	if err != nil || head.Seq != 2 {
+		t.Errorf("audit log after one submission: head %d, err %v", head.Seq, err)
+	}
+}
+
+func TestServerCancelledSubmission(t *testing.T) {
+	s := New(Config{Dedup: NewDedupIndex(DedupConfig{})})
+	sk := gophertags.NewSecretKey(16)
+	s.Detector().Add(sk.ExtractDetectionKey(16))
+	flag := sk.PublicKey().GenerateFlag()
+	body, _ := json.Marshal(messageRequest{Flag: flag.Encode(nil)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body)).WithContext(ctx))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("cancelled submission: %d", w.Code)
+	}
+
+	// The abandoned flag isn't remembered as a duplicate.
+	if w := submit(t, s, flag, ""); w.Code != http.StatusAccepted {
+		t.Errorf("resubmission after cancellation: %d %s", w.Code, w.Body)
+	}
+}
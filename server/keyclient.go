@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// KeyClient talks to a KeyService. Its HTTP client must be configured to
+// present a TLS certificate for Identity.
+type KeyClient struct {
+	HTTP     *http.Client
+	BaseURL  string // e.g. "https://detector.example:8443"
+	Identity ed25519.PrivateKey
+}
+
+// Register signs a registration for dk and submits it, returning the key's ID.
+func (c *KeyClient) Register(ctx context.Context, dk *gophertags.DetectionKey) (gophertags.KeyID, error) {
+	reg, err := NewRegistration(c.Identity, dk)
+	if err != nil {
+		return gophertags.KeyID{}, err
+	}
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return gophertags.KeyID{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/v1/register"), bytes.NewReader(body))
+	if err != nil {
+		return gophertags.KeyID{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var resp keyResponse
+	if err := c.do(req, http.StatusCreated, &resp); err != nil {
+		return gophertags.KeyID{}, err
+	}
+	return parseKeyID(resp.KeyID)
+}
+
+// RegisterSigned submits a detection key signed by its recipient, who need
+// not be Identity, returning the key's ID.
+func (c *KeyClient) RegisterSigned(ctx context.Context, signed *SignedDetectionKey) (gophertags.KeyID, error) {
+	body, err := json.Marshal(signed)
+	if err != nil {
+		return gophertags.KeyID{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/v1/keys"), bytes.NewReader(body))
+	if err != nil {
+		return gophertags.KeyID{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var resp keyResponse
+	if err := c.do(req, http.StatusCreated, &resp); err != nil {
+		return gophertags.KeyID{}, err
+	}
+	return parseKeyID(resp.KeyID)
+}
+
+// Revoke signs a revocation list naming the given fingerprints and submits it,
+// returning the IDs of the registered keys it removed. sequence must exceed
+// that of every list Identity has submitted before.
+func (c *KeyClient) Revoke(ctx context.Context, sequence uint64, revoked []gophertags.Fingerprint) ([]gophertags.KeyID, error) {
+	body, err := json.Marshal(NewRevocationList(c.Identity, sequence, revoked))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/v1/revoke"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var resp revokeResponse
+	if err := c.do(req, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+	ids := make([]gophertags.KeyID, len(resp.Revoked))
+	for i, s := range resp.Revoked {
+		if ids[i], err = parseKeyID(s); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// Notifications returns all the messages matching the key with the given
+// ID, which must have been registered by Identity, fetching them a page at a
+// time.
+func (c *KeyClient) Notifications(ctx context.Context, id gophertags.KeyID) ([]mailbox.Message, error) {
+	var all []mailbox.Message
+	var cursor uint64
+	for {
+		messages, more, err := c.NotificationsSince(ctx, id, cursor, 0)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, messages...)
+		if !more || len(messages) == 0 {
+			return all, nil
+		}
+		cursor = messages[len(messages)-1].ID
+	}
+}
+
+// NotificationsSince returns one page of the messages matching the key with
+// the given ID whose IDs are greater than cursor, and whether more remain
+// after it. A limit of zero means the server's default page size.
+func (c *KeyClient) NotificationsSince(ctx context.Context, id gophertags.KeyID, cursor uint64, limit int) (messages []mailbox.Message, more bool, err error) {
+	q := url.Values{"key": {id.String()}}
+	if cursor != 0 {
+		q.Set("after", strconv.FormatUint(cursor, 10))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint("/v1/notifications")+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	var resp matchesResponse
+	if err := c.do(req, http.StatusOK, &resp); err != nil {
+		return nil, false, err
+	}
+	messages = make([]mailbox.Message, len(resp.Messages))
+	for i, m := range resp.Messages {
+		messages[i] = mailbox.Message{ID: m.ID, Flag: m.Flag, Payload: m.Payload, Received: m.Received}
+	}
+	return messages, resp.More, nil
+}
+
+func (c *KeyClient) endpoint(path string) string {
+	return strings.TrimSuffix(c.BaseURL, "/") + path
+}
+
+func (c *KeyClient) do(req *http.Request, want int, v interface{}) error {
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		var e errorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return fmt.Errorf("server: %s: %s", resp.Status, e.Error)
+		}
+		return errors.New("server: unexpected response " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// A Registration asks a KeyService to register a detection key on behalf of
+// the holder of an Ed25519 identity key. The signature covers
+//
+//	"gophertags registration v1" || identity || uint64be(unix nanos) || nonce || key
+//
+// so a registration can't be altered, and the timestamp and nonce let the
+// service refuse to accept it twice.
+type Registration struct {
+	Identity  ed25519.PublicKey `json:"identity"`
+	Key       []byte            `json:"key"`
+	Time      time.Time         `json:"time"`
+	Nonce     []byte            `json:"nonce"`
+	Signature []byte            `json:"signature"`
+}
+
+const (
+	registrationLabel = "gophertags registration v1"
+	nonceSize         = 16
+)
+
+// Errors returned by Registration.Verify.
+var (
+	ErrRegistrationSignature = errors.New("server: invalid registration signature")
+	ErrRegistrationExpired   = errors.New("server: registration timestamp outside the allowed window")
+)
+
+// NewRegistration returns a registration for dk signed by identity, stamped
+// with the current time and a random nonce.
+func NewRegistration(identity ed25519.PrivateKey, dk *gophertags.DetectionKey) (*Registration, error) {
+	reg := &Registration{
+		Identity: identity.Public().(ed25519.PublicKey),
+		Key:      dk.Encode(nil),
+		Time:     time.Now().UTC(),
+		Nonce:    make([]byte, nonceSize),
+	}
+	if _, err := rand.Read(reg.Nonce); err != nil {
+		return nil, err
+	}
+	reg.Signature = ed25519.Sign(identity, reg.signedMessage())
+	return reg, nil
+}
+
+func (reg *Registration) signedMessage() []byte {
+	msg := make([]byte, 0, len(registrationLabel)+len(reg.Identity)+8+len(reg.Nonce)+len(reg.Key))
+	msg = append(msg, registrationLabel...)
+	msg = append(msg, reg.Identity...)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(reg.Time.UnixNano()))
+	msg = append(msg, ts[:]...)
+	msg = append(msg, reg.Nonce...)
+	return append(msg, reg.Key...)
+}
+
+// Verify checks the registration's signature and that its timestamp is within
+// skew of now. It doesn't check for replays; KeyService does that.
+func (reg *Registration) Verify(now time.Time, skew time.Duration) error {
+	if len(reg.Identity) != ed25519.PublicKeySize || len(reg.Nonce) != nonceSize ||
+		!ed25519.Verify(reg.Identity, reg.signedMessage(), reg.Signature) {
+		return ErrRegistrationSignature
+	}
+	if d := now.Sub(reg.Time); d > skew || d < -skew {
+		return ErrRegistrationExpired
+	}
+	return nil
+}
+
+// KeyServiceConfig configures a KeyService.
+type KeyServiceConfig struct {
+	// Registry records registered keys. Tenants are hex-encoded identity keys.
+	Registry *Registry
+
+	// Store holds the messages notifications are read from.
+	Store mailbox.Store
+
+	// MaxSkew bounds how far a registration's timestamp may be from the
+	// service's clock. Zero means five minutes.
+	MaxSkew time.Duration
+
+	// MaxBodySize bounds request bodies. Zero means 1 MiB.
+	MaxBodySize int64
+
+	// ServerID names this service in SignedDetectionKeys. Signed keys are
+	// only accepted if they were issued to it.
+	ServerID string
+
+	// RequireSignedKeys refuses plain registrations, so that only keys their
+	// recipient signed for ServerID are registered. Otherwise anyone who
+	// obtained a detection key could register it under their own identity
+	// and read its notifications.
+	RequireSignedKeys bool
+}
+
+const defaultMaxSkew = 5 * time.Minute
+
+// KeyService is the mutually authenticated half of a detection server. It
+// accepts signed registrations and serves match notifications only to the
+// identity that registered the key:
+//
+//	POST /v1/register                   body: JSON Registration
+//	POST /v1/keys                       body: JSON SignedDetectionKey
+//	POST /v1/revoke                     body: JSON RevocationList
+//	GET  /v1/notifications?key=<KeyID>  matching messages, oldest first
+//
+// Notifications are paginated like Server's matches.
+//
+// Signed keys are registered to the tenant of their signer. They and
+// revocation lists authenticate themselves, so any client may relay one, not
+// only its signer.
+//
+// Serve it over TLS with tls.RequireAndVerifyClientCert. Clients must present
+// a certificate for their Ed25519 identity key, and may only register keys
+// signed by that identity.
+type KeyService struct {
+	registry *Registry
+	store    mailbox.Store
+	skew     time.Duration
+	maxBody  int64
+	serverID string
+	signed   bool
+	mux      *http.ServeMux
+	now      func() time.Time
+
+	mu     sync.Mutex
+	nonces map[[nonceSize]byte]time.Time // nonce -> when it can be forgotten
+}
+
+// NewKeyService returns a KeyService.
+func NewKeyService(config KeyServiceConfig) *KeyService {
+	s := &KeyService{
+		registry: config.Registry,
+		store:    config.Store,
+		skew:     config.MaxSkew,
+		maxBody:  config.MaxBodySize,
+		serverID: config.ServerID,
+		signed:   config.RequireSignedKeys,
+		mux:      http.NewServeMux(),
+		now:      time.Now,
+		nonces:   make(map[[nonceSize]byte]time.Time),
+	}
+	if s.skew <= 0 {
+		s.skew = defaultMaxSkew
+	}
+	if s.maxBody <= 0 {
+		s.maxBody = defaultMaxBodySize
+	}
+	s.mux.HandleFunc("/v1/register", s.handleRegister)
+	s.mux.HandleFunc("/v1/keys", s.handleSignedKey)
+	s.mux.HandleFunc("/v1/revoke", s.handleRevoke)
+	s.mux.HandleFunc("/v1/notifications", s.handleNotifications)
+	return s
+}
+
+func (s *KeyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// peerIdentity returns the Ed25519 key of the client's verified certificate.
+func peerIdentity(r *http.Request) (ed25519.PublicKey, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, false
+	}
+	pub, ok := r.TLS.VerifiedChains[0][0].PublicKey.(ed25519.PublicKey)
+	return pub, ok
+}
+
+// fresh records the nonce, reporting whether it hadn't been seen within the
+// skew window. Nonces are forgotten once their registration would be expired
+// anyway.
+func (s *KeyService) fresh(reg *Registration, now time.Time) bool {
+	var nonce [nonceSize]byte
+	copy(nonce[:], reg.Nonce)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n, expiry := range s.nonces {
+		if now.After(expiry) {
+			delete(s.nonces, n)
+		}
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+	s.nonces[nonce] = reg.Time.Add(s.skew)
+	return true
+}
+
+func (s *KeyService) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	peer, ok := peerIdentity(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "client certificate with an Ed25519 key required")
+		return
+	}
+	if s.signed {
+		writeError(w, http.StatusForbidden, "only signed detection keys are accepted; use /v1/keys")
+		return
+	}
+	var reg Registration
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody)).Decode(&reg); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+	now := s.now()
+	if err := reg.Verify(now, s.skew); err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if !peer.Equal(reg.Identity) {
+		writeError(w, http.StatusForbidden, "registration identity doesn't match client certificate")
+		return
+	}
+	dk, err := gophertags.DecodeDetectionKey(reg.Key)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.fresh(&reg, now) {
+		writeError(w, http.StatusConflict, "registration already processed")
+		return
+	}
+	s.register(w, reg.Identity, dk)
+}
+
+func (s *KeyService) register(w http.ResponseWriter, identity ed25519.PublicKey, dk *gophertags.DetectionKey) {
+	id, err := s.registry.Add(hex.EncodeToString(identity), dk)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusCreated, keyResponse{KeyID: id.String()})
+	case ErrPolicy, ErrKeyOwned, ErrRevoked:
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "registering key failed")
+	}
+}
+
+func (s *KeyService) handleSignedKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if _, ok := peerIdentity(r); !ok {
+		writeError(w, http.StatusUnauthorized, "client certificate with an Ed25519 key required")
+		return
+	}
+	var signed SignedDetectionKey
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody)).Decode(&signed); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+	dk, err := signed.Verify(s.serverID, s.now())
+	switch err {
+	case nil:
+		s.register(w, signed.Identity, dk)
+	case ErrKeySignature, ErrKeyExpired, ErrWrongServer:
+		writeError(w, http.StatusUnauthorized, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// revokeResponse lists the registered keys a revocation list removed.
+type revokeResponse struct {
+	Revoked []string `json:"revoked"`
+}
+
+func (s *KeyService) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if _, ok := peerIdentity(r); !ok {
+		writeError(w, http.StatusUnauthorized, "client certificate with an Ed25519 key required")
+		return
+	}
+	var l RevocationList
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody)).Decode(&l); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+	ids, err := s.registry.Revoke(&l)
+	switch err {
+	case nil:
+		resp := revokeResponse{Revoked: make([]string, len(ids))}
+		for i, id := range ids {
+			resp.Revoked[i] = id.String()
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case ErrRevocationSignature:
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case ErrRevocationStale:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "applying revocation list failed")
+	}
+}
+
+func (s *KeyService) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	peer, ok := peerIdentity(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "client certificate with an Ed25519 key required")
+		return
+	}
+	id, err := parseKeyID(r.URL.Query().Get("key"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Unknown keys and other tenants' keys get the same answer, so clients
+	// can't probe which keys are registered.
+	if tenant, ok := s.registry.Tenant(id); !ok || tenant != hex.EncodeToString(peer) {
+		writeError(w, http.StatusNotFound, "no such key")
+		return
+	}
+	writeMatches(w, r, s.store, id)
+}
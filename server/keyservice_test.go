@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// testPKI issues client certificates for identity keys from a throwaway CA.
+type testPKI struct {
+	ca     *x509.Certificate
+	caKey  ed25519.PrivateKey
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{ca: ca, caKey: caKey, pool: pool, serial: 1}
+}
+
+func (p *testPKI) issue(t *testing.T, identity ed25519.PrivateKey) tls.Certificate {
+	p.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: "recipient"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, identity.Public(), p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: identity}
+}
+
+func newKeyServiceTest(t *testing.T) (*KeyService, *httptest.Server, *testPKI) {
+	return newKeyServiceTestConfig(t, KeyServiceConfig{})
+}
+
+// newKeyServiceTestConfig is newKeyServiceTest with config's registry and
+// store filled in.
+func newKeyServiceTestConfig(t *testing.T, config KeyServiceConfig) (*KeyService, *httptest.Server, *testPKI) {
+	registry, err := OpenRegistry(RegistryConfig{}, NewMultiDetector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Registry, config.Store = registry, mailbox.NewMemoryStore()
+	svc := NewKeyService(config)
+	pki := newTestPKI(t)
+	ts := httptest.NewUnstartedServer(svc)
+	ts.TLS = &tls.Config{ClientCAs: pki.pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return svc, ts, pki
+}
+
+func clientFor(t *testing.T, ts *httptest.Server, pki *testPKI, identity ed25519.PrivateKey) *KeyClient {
+	transport := ts.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{pki.issue(t, identity)}
+	return &KeyClient{HTTP: &http.Client{Transport: transport}, BaseURL: ts.URL, Identity: identity}
+}
+
+func TestKeyService(t *testing.T) {
+	svc, ts, pki := newKeyServiceTest(t)
+	_, aliceID, _ := ed25519.GenerateKey(rand.Reader)
+	_, bobID, _ := ed25519.GenerateKey(rand.Reader)
+	alice, bob := clientFor(t, ts, pki, aliceID), clientFor(t, ts, pki, bobID)
+	ctx := context.Background()
+
+	sk := gophertags.NewSecretKey(16)
+	id, err := alice.Register(ctx, sk.ExtractDetectionKey(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != sk.PublicKey().KeyID() {
+		t.Errorf("registered key ID %v, want %v", id, sk.PublicKey().KeyID())
+	}
+	if _, err := bob.Register(ctx, sk.ExtractDetectionKey(8)); err == nil {
+		t.Error("registered a key already held by another identity")
+	}
+
+	f := sk.PublicKey().GenerateFlag()
+	svc.store.Put(ctx, mailbox.Message{Flag: f.Encode(nil), Payload: []byte("hello")}, svc.registry.detector.Match(f))
+
+	messages, err := alice.Notifications(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || string(messages[0].Payload) != "hello" {
+		t.Errorf("notifications = %+v", messages)
+	}
+	if _, err := bob.Notifications(ctx, id); err == nil {
+		t.Error("fetched notifications for another identity's key")
+	}
+}
+
+func TestKeyServiceRejects(t *testing.T) {
+	svc, ts, pki := newKeyServiceTest(t)
+	_, aliceID, _ := ed25519.GenerateKey(rand.Reader)
+	_, bobID, _ := ed25519.GenerateKey(rand.Reader)
+	alice := clientFor(t, ts, pki, aliceID)
+	dk := gophertags.NewSecretKey(8).ExtractDetectionKey(4)
+
+	post := func(reg *Registration) int {
+		t.Helper()
+		body, _ := json.Marshal(reg)
+		resp, err := alice.HTTP.Post(ts.URL+"/v1/register", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	reg, _ := NewRegistration(aliceID, dk)
+	if code := post(reg); code != http.StatusCreated {
+		t.Fatalf("registering: %d", code)
+	}
+	if code := post(reg); code != http.StatusConflict {
+		t.Errorf("replayed registration: %d, want %d", code, http.StatusConflict)
+	}
+
+	tampered, _ := NewRegistration(aliceID, dk)
+	tampered.Key = gophertags.NewSecretKey(8).ExtractDetectionKey(4).Encode(nil)
+	if code := post(tampered); code != http.StatusUnauthorized {
+		t.Errorf("tampered registration: %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	foreign, _ := NewRegistration(bobID, dk)
+	if code := post(foreign); code != http.StatusForbidden {
+		t.Errorf("registration signed by another identity: %d, want %d", code, http.StatusForbidden)
+	}
+
+	stale, _ := NewRegistration(aliceID, dk)
+	svc.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if code := post(stale); code != http.StatusUnauthorized {
+		t.Errorf("stale registration: %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// Without a client certificate the TLS handshake itself fails.
+	if _, err := ts.Client().Get(ts.URL + "/v1/notifications"); err == nil {
+		t.Error("request without a client certificate succeeded")
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gtank/gophertags"
+)
+
+// OverflowPolicy decides what Pipeline.Submit does when the pipeline is full.
+type OverflowPolicy int
+
+const (
+	// Park makes Submit wait for room, pushing back on the producer.
+	Park OverflowPolicy = iota
+	// DropNewest rejects the submitted item with ErrDropped.
+	DropNewest
+	// DropOldest evicts the oldest waiting item to make room. Evicted items
+	// are passed to OnDrop with ErrDropped.
+	DropOldest
+)
+
+var (
+	// ErrDropped is returned by Pipeline.Submit, and passed to OnDrop, for
+	// items dropped because the pipeline was full.
+	ErrDropped = errors.New("server: pipeline full, item dropped")
+	// ErrPipelineClosed is returned by Pipeline.Submit after Close.
+	ErrPipelineClosed = errors.New("server: pipeline is closed")
+)
+
+// Detected is an item that went through a Pipeline, with the keys its flag
+// matched.
+type Detected struct {
+	Flag    []byte
+	Payload []byte
+	Matches []gophertags.KeyID
+}
+
+// PipelineConfig configures a Pipeline.
+type PipelineConfig struct {
+	Detector *MultiDetector
+
+	// Sink receives every item whose flag decodes, matched or not, from a
+	// single goroutine. An error stops the pipeline.
+	Sink func(ctx context.Context, d Detected) error
+
+	// Buffer is the capacity of the queue in front of each stage. Zero means
+	// 256.
+	Buffer int
+
+	// Workers is the number of goroutines decoding and, separately, testing
+	// flags. Zero means runtime.GOMAXPROCS(0).
+	Workers int
+
+	// Policy applies when the input queue is full.
+	Policy OverflowPolicy
+
+	// OnDrop, if set, is called with items that are dropped, with ErrDropped
+	// for items evicted under DropOldest or with why their flag didn't
+	// decode. It must not block.
+	OnDrop func(flag, payload []byte, err error)
+}
+
+// PipelineStats counts items by what became of them.
+type PipelineStats struct {
+	Submitted uint64 // accepted by Submit
+	Dropped   uint64 // rejected or evicted for lack of room
+	Invalid   uint64 // flag didn't decode
+	Sunk      uint64 // passed to Sink
+}
+
+// Pipeline moves flagged items through bounded queues from decoding to
+// testing to a sink, so that a burst of submissions costs at most the queues'
+// capacity in memory. When the sink or the detector falls behind, the queues
+// between stages fill and stall the stages before them, until the input queue
+// is full and Policy decides between slowing producers and shedding items. It
+// is safe for concurrent use.
+type Pipeline struct {
+	// Atomic counters come first, for 64-bit alignment on 32-bit platforms.
+	submitted, dropped, invalid, sunk uint64
+
+	config PipelineConfig
+
+	input   chan pipelineItem
+	closing chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup // Submit calls that may still send on input
+	once     sync.Once
+}
+
+type pipelineItem struct {
+	flag, payload []byte
+	f             *gophertags.Flag
+	matches       []gophertags.KeyID
+}
+
+const defaultPipelineBuffer = 256
+
+// NewPipeline returns a pipeline. Call Run to start processing.
+func NewPipeline(config PipelineConfig) *Pipeline {
+	if config.Buffer <= 0 {
+		config.Buffer = defaultPipelineBuffer
+	}
+	if config.Workers <= 0 {
+		config.Workers = runtime.GOMAXPROCS(0)
+	}
+	return &Pipeline{
+		config:  config,
+		input:   make(chan pipelineItem, config.Buffer),
+		closing: make(chan struct{}),
+	}
+}
+
+// Submit queues an item per the pipeline's policy. Under Park it waits for
+// room until ctx is done, returning ctx.Err().
+func (p *Pipeline) Submit(ctx context.Context, flag, payload []byte) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPipelineClosed
+	}
+	p.inflight.Add(1)
+	p.mu.Unlock()
+	defer p.inflight.Done()
+
+	item := pipelineItem{flag: flag, payload: payload}
+	switch p.config.Policy {
+	case DropNewest:
+		select {
+		case p.input <- item:
+		default:
+			atomic.AddUint64(&p.dropped, 1)
+			return ErrDropped
+		}
+	case DropOldest:
+		for sent := false; !sent; {
+			select {
+			case p.input <- item:
+				sent = true
+			default:
+				select {
+				case old := <-p.input:
+					atomic.AddUint64(&p.dropped, 1)
+					p.drop(old, ErrDropped)
+				default:
+				}
+			}
+		}
+	default:
+		select {
+		case p.input <- item:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.closing:
+			return ErrPipelineClosed
+		}
+	}
+	atomic.AddUint64(&p.submitted, 1)
+	return nil
+}
+
+// Close stops accepting items. Run returns once the queued ones have reached
+// the sink.
+func (p *Pipeline) Close() {
+	p.once.Do(func() {
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+		close(p.closing)
+		p.inflight.Wait()
+		close(p.input)
+	})
+}
+
+// Stats returns the pipeline's counters.
+func (p *Pipeline) Stats() PipelineStats {
+	return PipelineStats{
+		Submitted: atomic.LoadUint64(&p.submitted),
+		Dropped:   atomic.LoadUint64(&p.dropped),
+		Invalid:   atomic.LoadUint64(&p.invalid),
+		Sunk:      atomic.LoadUint64(&p.sunk),
+	}
+}
+
+// Queued returns the number of items waiting in the input queue.
+func (p *Pipeline) Queued() int {
+	return len(p.input)
+}
+
+// Run processes items until Close has been called and every queued item has
+// been sunk, returning nil, or until ctx is done or the sink fails, returning
+// that error and abandoning queued items. Call it once.
+func (p *Pipeline) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	decoded := make(chan pipelineItem, p.config.Buffer)
+	tested := make(chan pipelineItem, p.config.Buffer)
+
+	stage := func(in <-chan pipelineItem, out chan<- pipelineItem, process func(*pipelineItem) bool) {
+		var wg sync.WaitGroup
+		for i := 0; i < p.config.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					var item pipelineItem
+					var ok bool
+					select {
+					case item, ok = <-in:
+						if !ok {
+							return
+						}
+					case <-ctx.Done():
+						return
+					}
+					if !process(&item) {
+						continue
+					}
+					select {
+					case out <- item:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(out)
+		}()
+	}
+	stage(p.input, decoded, func(item *pipelineItem) bool {
+		item.f = new(gophertags.Flag)
+		if err := item.f.Decode(item.flag); err != nil {
+			atomic.AddUint64(&p.invalid, 1)
+			p.drop(*item, err)
+			return false
+		}
+		return true
+	})
+	stage(decoded, tested, func(item *pipelineItem) bool {
+		item.matches = p.config.Detector.Match(item.f)
+		return true
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case item, ok := <-tested:
+			if !ok {
+				return nil
+			}
+			if err := p.config.Sink(ctx, Detected{Flag: item.flag, Payload: item.payload, Matches: item.matches}); err != nil {
+				return err
+			}
+			atomic.AddUint64(&p.sunk, 1)
+		}
+	}
+}
+
+func (p *Pipeline) drop(item pipelineItem, err error) {
+	if p.config.OnDrop != nil {
+		p.config.OnDrop(item.flag, item.payload, err)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestPipeline(t *testing.T) {
+	sk := gophertags.NewSecretKey(16)
+	detector := NewMultiDetector()
+	id := detector.Add(sk.ExtractDetectionKey(16))
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var sunk []Detected
+	var invalid []error
+	p := NewPipeline(PipelineConfig{
+		Detector: detector,
+		Buffer:   1,
+		Workers:  1,
+		Sink: func(ctx context.Context, d Detected) error {
+			<-release
+			mu.Lock()
+			sunk = append(sunk, d)
+			mu.Unlock()
+			return nil
+		},
+		OnDrop: func(flag, payload []byte, err error) { invalid = append(invalid, err) },
+	})
+	done := make(chan error, 1)
+	go func() { done <- p.Run(context.Background()) }()
+
+	ctx := context.Background()
+	p.Submit(ctx, []byte("not a flag"), nil)
+	// With the sink stalled, the stages fill up and Submit parks.
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		err = p.Submit(short, sk.PublicKey().GenerateFlag().Encode(nil), []byte{byte(i)})
+		cancel()
+	}
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Submit into a stalled pipeline = %v, want DeadlineExceeded", err)
+	}
+
+	close(release)
+	p.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Submit(ctx, nil, nil); err != ErrPipelineClosed {
+		t.Errorf("Submit after Close = %v", err)
+	}
+	stats := p.Stats()
+	if stats.Invalid != 1 || len(invalid) != 1 || stats.Sunk != stats.Submitted-1 || int(stats.Sunk) != len(sunk) {
+		t.Errorf("stats %+v with %d sunk and %d invalid", stats, len(sunk), len(invalid))
+	}
+	for i, d := range sunk {
+		if len(d.Matches) != 1 || d.Matches[0] != id || d.Payload[0] != byte(i) {
+			t.Errorf("sunk item %d: %+v", i, d)
+		}
+	}
+}
+
+func TestPipelineDropPolicies(t *testing.T) {
+	flag := gophertags.NewSecretKey(8).PublicKey().GenerateFlag().Encode(nil)
+	ctx := context.Background()
+
+	newest := NewPipeline(PipelineConfig{Buffer: 2, Policy: DropNewest})
+	for i := 0; i < 2; i++ {
+		if err := newest.Submit(ctx, flag, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newest.Submit(ctx, flag, nil); err != ErrDropped {
+		t.Errorf("Submit to a full DropNewest pipeline = %v", err)
+	}
+	if s := newest.Stats(); s.Submitted != 2 || s.Dropped != 1 {
+		t.Errorf("DropNewest stats %+v", s)
+	}
+
+	var evicted []byte
+	var payloads []byte
+	oldest := NewPipeline(PipelineConfig{
+		Detector: NewMultiDetector(),
+		Buffer:   2,
+		Workers:  1,
+		Policy:   DropOldest,
+		OnDrop:   func(flag, payload []byte, err error) { evicted = append(evicted, payload...) },
+		Sink: func(ctx context.Context, d Detected) error {
+			payloads = append(payloads, d.Payload...)
+			return nil
+		},
+	})
+	for i := byte(0); i < 3; i++ {
+		if err := oldest.Submit(ctx, flag, []byte{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldest.Close()
+	if err := oldest.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if string(evicted) != "\x00" || string(payloads) != "\x01\x02" {
+		t.Errorf("DropOldest evicted %v and sank %v", evicted, payloads)
+	}
+}
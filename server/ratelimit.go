@@ -0,0 +1,257 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestClass is a category of request that is rate limited separately.
+type RequestClass int
+
+const (
+	// FlagSubmission is a request that submits a flagged message.
+	FlagSubmission RequestClass = iota
+	// MatchQuery is any other request, such as a query for matches.
+	MatchQuery
+)
+
+// RateLimitConfig configures RateLimit. Rates are per client, in requests per
+// second, with bursts of up to the given size.
+type RateLimitConfig struct {
+	SubmitRate  float64 // zero means 10
+	SubmitBurst int     // zero means 20
+	QueryRate   float64 // zero means 1
+	QueryBurst  int     // zero means 5
+
+	// MaxConcurrent is the number of requests passed to the handler at once,
+	// across all clients. Zero means 64.
+	MaxConcurrent int
+
+	// MaxQueue is the number of requests allowed to wait for a free slot.
+	// Requests beyond it are rejected immediately. Zero means 256.
+	MaxQueue int
+
+	// QueueTimeout is how long a request may wait for a slot. Zero means one second.
+	QueueTimeout time.Duration
+
+	// ClientID identifies the client a request counts against. Nil means the
+	// host part of the request's RemoteAddr.
+	ClientID func(*http.Request) string
+
+	// Classify picks the limit a request counts against. Nil means POST
+	// /v1/messages is a FlagSubmission and everything else a MatchQuery.
+	Classify func(*http.Request) RequestClass
+}
+
+// How often idle clients are swept out of the limiter.
+const clientSweepInterval = time.Minute
+
+// RateLimiter is an http.Handler that enforces per-client request rates and
+// a bounded queue in front of another handler, since a public mailbox is
+// otherwise trivially flooded. Clients over their rate get 429 Too Many
+// Requests and requests that don't fit in the queue get 503 Service
+// Unavailable, both with a Retry-After header.
+type RateLimiter struct {
+	config RateLimitConfig
+	next   http.Handler
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimits
+	lastSweep time.Time
+	waiting   int
+
+	slots chan struct{}
+	now   func() time.Time
+}
+
+type clientLimits struct {
+	submit, query tokenBucket
+}
+
+// RateLimit wraps next in a RateLimiter.
+func RateLimit(next http.Handler, config RateLimitConfig) *RateLimiter {
+	if config.SubmitRate <= 0 {
+		config.SubmitRate = 10
+	}
+	if config.SubmitBurst <= 0 {
+		config.SubmitBurst = 20
+	}
+	if config.QueryRate <= 0 {
+		config.QueryRate = 1
+	}
+	if config.QueryBurst <= 0 {
+		config.QueryBurst = 5
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = 64
+	}
+	if config.MaxQueue <= 0 {
+		config.MaxQueue = 256
+	}
+	if config.QueueTimeout <= 0 {
+		config.QueueTimeout = time.Second
+	}
+	if config.ClientID == nil {
+		config.ClientID = remoteHost
+	}
+	if config.Classify == nil {
+		config.Classify = classifyRequest
+	}
+	return &RateLimiter{
+		config:  config,
+		next:    next,
+		clients: make(map[string]*clientLimits),
+		slots:   make(chan struct{}, config.MaxConcurrent),
+		now:     time.Now,
+	}
+}
+
+func (l *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ok, wait := l.allow(l.config.ClientID(r), l.config.Classify(r)); !ok {
+		retryAfter(w, wait)
+		writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+	if !l.acquire(r) {
+		retryAfter(w, time.Second)
+		writeError(w, http.StatusServiceUnavailable, "server busy")
+		return
+	}
+	defer func() { <-l.slots }()
+	l.next.ServeHTTP(w, r)
+}
+
+// allow takes a token from the client's bucket for the class, or reports how
+// long until one is available.
+func (l *RateLimiter) allow(client string, class RequestClass) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= clientSweepInterval {
+		l.sweep(now)
+	}
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimits{
+			submit: newTokenBucket(l.config.SubmitRate, l.config.SubmitBurst, now),
+			query:  newTokenBucket(l.config.QueryRate, l.config.QueryBurst, now),
+		}
+		l.clients[client] = c
+	}
+	if class == FlagSubmission {
+		return c.submit.take(now)
+	}
+	return c.query.take(now)
+}
+
+// sweep forgets clients whose buckets have refilled, since they are
+// indistinguishable from new clients.
+func (l *RateLimiter) sweep(now time.Time) {
+	for id, c := range l.clients {
+		if c.submit.full(now) && c.query.full(now) {
+			delete(l.clients, id)
+		}
+	}
+	l.lastSweep = now
+}
+
+// acquire waits for a handler slot, giving up if the queue is full, the
+// queue timeout passes, or the request is cancelled.
+func (l *RateLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	l.mu.Lock()
+	if l.waiting >= l.config.MaxQueue {
+		l.mu.Unlock()
+		return false
+	}
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.config.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// Waiting returns the number of requests waiting for a handler slot.
+func (l *RateLimiter) Waiting() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiting
+}
+
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) tokenBucket {
+	return tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func classifyRequest(r *http.Request) RequestClass {
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/messages" {
+		return FlagSubmission
+	}
+	return MatchQuery
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitPerClient(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	l := RateLimit(ok, RateLimitConfig{SubmitRate: 1, SubmitBurst: 2, QueryRate: 1, QueryBurst: 1})
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	send := func(client, method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = client + ":1234"
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := send("10.0.0.1", http.MethodPost, "/v1/messages"); w.Code != http.StatusOK {
+			t.Fatalf("submission %d within burst: %d", i, w.Code)
+		}
+	}
+	w := send("10.0.0.1", http.MethodPost, "/v1/messages")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("submission over burst: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := send("10.0.0.1", http.MethodGet, "/v1/matches"); w.Code != http.StatusOK {
+		t.Errorf("queries share the submission limit: %d", w.Code)
+	}
+	if w := send("10.0.0.2", http.MethodPost, "/v1/messages"); w.Code != http.StatusOK {
+		t.Errorf("clients share a limit: %d", w.Code)
+	}
+
+	now = now.Add(time.Second)
+	if w := send("10.0.0.1", http.MethodPost, "/v1/messages"); w.Code != http.StatusOK {
+		t.Errorf("submission after refill: %d", w.Code)
+	}
+
+	now = now.Add(time.Hour)
+	send("10.0.0.3", http.MethodGet, "/v1/matches")
+	if len(l.clients) != 1 {
+		t.Errorf("%d clients tracked after sweep, want 1", len(l.clients))
+	}
+}
+
+func TestRateLimitQueue(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	l := RateLimit(blocking, RateLimitConfig{
+		MaxConcurrent: 1,
+		MaxQueue:      1,
+		QueueTimeout:  time.Hour,
+		ClientID:      func(r *http.Request) string { return r.URL.Query().Get("client") },
+	})
+
+	results := make(chan int, 2)
+	send := func(client string) {
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/matches?client="+client, nil))
+		results <- w.Code
+	}
+
+	go send("a")
+	<-entered // holds the only slot
+	go send("b")
+	for l.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/matches?client=c", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("request beyond the queue: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	release <- struct{}{}
+	<-entered // the queued request got the slot
+	release <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if code := <-results; code != http.StatusOK {
+			t.Errorf("admitted request finished with %d", code)
+		}
+	}
+}
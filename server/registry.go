@@ -0,0 +1,359 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/gtank/gophertags"
+)
+
+// Errors returned by Registry.
+var (
+	ErrPolicy     = errors.New("server: detection key violates tenant policy")
+	ErrKeyOwned   = errors.New("server: detection key registered by another tenant")
+	ErrUnknownKey = errors.New("server: no such detection key for tenant")
+)
+
+// TenantPolicy limits the detection keys a tenant may register.
+type TenantPolicy struct {
+	// MinPrecision and MaxPrecision bound key precision. A minimum keeps the
+	// number of matches, and so storage and bandwidth, in check; a maximum
+	// stops tenants giving up their own anonymity by accident. A zero
+	// MaxPrecision means no maximum.
+	MinPrecision, MaxPrecision int
+
+	// MaxKeys bounds how many keys a tenant may hold. Zero means no limit.
+	MaxKeys int
+}
+
+func (p TenantPolicy) allows(dk *gophertags.DetectionKey, held int) bool {
+	n := dk.Precision()
+	return n >= p.MinPrecision &&
+		(p.MaxPrecision == 0 || n <= p.MaxPrecision) &&
+		(p.MaxKeys == 0 || held < p.MaxKeys)
+}
+
+// RegistryConfig configures a Registry.
+type RegistryConfig struct {
+	// Path is the file the registry is persisted to. It is rewritten
+	// atomically after every change. Empty means the registry is not persisted.
+	Path string
+
+	// Policy returns the policy for a tenant. Nil means every tenant gets
+	// DefaultPolicy.
+	Policy func(tenant string) TenantPolicy
+
+	// DefaultPolicy applies when Policy is nil.
+	DefaultPolicy TenantPolicy
+}
+
+// Registry maps tenants, such as accounts, to the detection keys they have
+// registered, and keeps a MultiDetector in sync with it. It is safe for
+// concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	config   RegistryConfig
+	detector *MultiDetector
+	tenants  map[string]map[gophertags.KeyID]*gophertags.DetectionKey
+	owners   map[gophertags.KeyID]string
+
+	// revocations holds each tenant's latest revocation list.
+	revocations map[string]*RevocationList
+}
+
+// registryFile is the persisted registry. Registries written before
+// revocation lists existed are a bare array of records.
+type registryFile struct {
+	Keys        []registryRecord  `json:"keys"`
+	Revocations []*RevocationList `json:"revocations,omitempty"`
+}
+
+// registryRecord is one key in the persisted registry.
+type registryRecord struct {
+	Tenant string `json:"tenant"`
+	Key    []byte `json:"key"`
+}
+
+// OpenRegistry returns a registry that adds keys to detector, loading any
+// keys and revocation lists previously persisted to config.Path. Persisted
+// keys that a persisted list revokes are not loaded.
+func OpenRegistry(config RegistryConfig, detector *MultiDetector) (*Registry, error) {
+	r := newRegistry(config, detector)
+	if config.Path == "" {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(config.Path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var file registryFile
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &file.Keys)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range file.Revocations {
+		if err := l.Verify(); err != nil {
+			return nil, err
+		}
+		r.revocations[revocationTenant(l)] = l
+	}
+	for _, rec := range file.Keys {
+		dk, err := gophertags.DecodeDetectionKey(rec.Key)
+		if err != nil {
+			return nil, err
+		}
+		if r.revoked(rec.Tenant, dk) {
+			continue
+		}
+		r.insert(rec.Tenant, dk)
+	}
+	return r, nil
+}
+
+func newRegistry(config RegistryConfig, detector *MultiDetector) *Registry {
+	return &Registry{
+		config:      config,
+		detector:    detector,
+		tenants:     make(map[string]map[gophertags.KeyID]*gophertags.DetectionKey),
+		owners:      make(map[gophertags.KeyID]string),
+		revocations: make(map[string]*RevocationList),
+	}
+}
+
+// revocationTenant returns the tenant a revocation list applies to: its
+// issuer, hex-encoded as KeyService names tenants.
+func revocationTenant(l *RevocationList) string {
+	return hex.EncodeToString(l.Issuer)
+}
+
+func (r *Registry) revoked(tenant string, dk *gophertags.DetectionKey) bool {
+	l := r.revocations[tenant]
+	return l != nil && l.Revokes(dk)
+}
+
+func (r *Registry) policy(tenant string) TenantPolicy {
+	if r.config.Policy != nil {
+		return r.config.Policy(tenant)
+	}
+	return r.config.DefaultPolicy
+}
+
+func (r *Registry) insert(tenant string, dk *gophertags.DetectionKey) gophertags.KeyID {
+	keys := r.tenants[tenant]
+	if keys == nil {
+		keys = make(map[gophertags.KeyID]*gophertags.DetectionKey)
+		r.tenants[tenant] = keys
+	}
+	id := r.detector.Add(dk)
+	keys[id] = dk
+	r.owners[id] = tenant
+	return id
+}
+
+// Add registers a detection key for the tenant, replacing any key of the same
+// family the tenant already holds. It returns ErrRevoked for keys the
+// tenant's revocation list names.
+func (r *Registry) Add(tenant string, dk *gophertags.DetectionKey) (gophertags.KeyID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := dk.KeyID()
+	if r.revoked(tenant, dk) {
+		return id, ErrRevoked
+	}
+	if owner, ok := r.owners[id]; ok && owner != tenant {
+		return id, ErrKeyOwned
+	}
+	held := len(r.tenants[tenant])
+	if _, replacing := r.tenants[tenant][id]; replacing {
+		held--
+	}
+	if !r.policy(tenant).allows(dk, held) {
+		return id, ErrPolicy
+	}
+
+	previous := r.tenants[tenant][id]
+	r.insert(tenant, dk)
+	if err := r.persist(); err != nil {
+		if previous != nil {
+			r.insert(tenant, previous)
+		} else {
+			r.delete(tenant, id)
+		}
+		return id, err
+	}
+	return id, nil
+}
+
+func (r *Registry) delete(tenant string, id gophertags.KeyID) {
+	delete(r.tenants[tenant], id)
+	if len(r.tenants[tenant]) == 0 {
+		delete(r.tenants, tenant)
+	}
+	delete(r.owners, id)
+	r.detector.Remove(id)
+}
+
+// Remove unregisters one of the tenant's keys.
+func (r *Registry) Remove(tenant string, id gophertags.KeyID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dk, ok := r.tenants[tenant][id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	r.delete(tenant, id)
+	if err := r.persist(); err != nil {
+		r.insert(tenant, dk)
+		return err
+	}
+	return nil
+}
+
+// Revoke verifies a revocation list and applies it to the tenant named by its
+// issuer, hex-encoded as KeyService names tenants. Registered keys the list
+// names are removed, and their IDs returned in ascending order; Add refuses
+// them from then on. A list must be newer than the one it replaces, or Revoke
+// returns ErrRevocationStale.
+func (r *Registry) Revoke(l *RevocationList) ([]gophertags.KeyID, error) {
+	if err := l.Verify(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tenant := revocationTenant(l)
+	previous := r.revocations[tenant]
+	if previous != nil && l.Sequence <= previous.Sequence {
+		return nil, ErrRevocationStale
+	}
+	removed := make(map[gophertags.KeyID]*gophertags.DetectionKey)
+	for id, dk := range r.tenants[tenant] {
+		if l.Revokes(dk) {
+			removed[id] = dk
+		}
+	}
+
+	r.revocations[tenant] = l
+	for id := range removed {
+		r.delete(tenant, id)
+	}
+	if err := r.persist(); err != nil {
+		if previous != nil {
+			r.revocations[tenant] = previous
+		} else {
+			delete(r.revocations, tenant)
+		}
+		for _, dk := range removed {
+			r.insert(tenant, dk)
+		}
+		return nil, err
+	}
+
+	ids := make([]gophertags.KeyID, 0, len(removed))
+	for id := range removed {
+		ids = append(ids, id)
+	}
+	sortKeyIDs(ids)
+	return ids, nil
+}
+
+// List returns the IDs of the tenant's keys in ascending order.
+func (r *Registry) List(tenant string) []gophertags.KeyID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]gophertags.KeyID, 0, len(r.tenants[tenant]))
+	for id := range r.tenants[tenant] {
+		ids = append(ids, id)
+	}
+	sortKeyIDs(ids)
+	return ids
+}
+
+// Tenant returns the tenant that registered the key with the given ID.
+func (r *Registry) Tenant(id gophertags.KeyID) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tenant, ok := r.owners[id]
+	return tenant, ok
+}
+
+// persist writes the registry to a temporary file and renames it over
+// config.Path, so a crash never leaves a partial registry behind.
+func (r *Registry) persist() error {
+	if r.config.Path == "" {
+		return nil
+	}
+	tenants := make([]string, 0, len(r.tenants))
+	for tenant := range r.tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	records := []registryRecord{}
+	for _, tenant := range tenants {
+		ids := make([]gophertags.KeyID, 0, len(r.tenants[tenant]))
+		for id := range r.tenants[tenant] {
+			ids = append(ids, id)
+		}
+		sortKeyIDs(ids)
+		for _, id := range ids {
+			records = append(records, registryRecord{tenant, r.tenants[tenant][id].Encode(nil)})
+		}
+	}
+	file := registryFile{Keys: records}
+	for _, tenant := range sortedTenants(r.revocations) {
+		file.Revocations = append(file.Revocations, r.revocations[tenant])
+	}
+	data, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(r.config.Path), filepath.Base(r.config.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.config.Path)
+}
+
+func sortedTenants(lists map[string]*RevocationList) []string {
+	tenants := make([]string, 0, len(lists))
+	for tenant := range lists {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+func sortKeyIDs(ids []gophertags.KeyID) {
+	sort.Slice(ids, func(i, j int) bool {
+		return string(ids[i][:]) < string(ids[j][:])
+	})
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestRegistryPolicy(t *testing.T) {
+	md := NewMultiDetector()
+	r, err := OpenRegistry(RegistryConfig{
+		DefaultPolicy: TenantPolicy{MinPrecision: 2, MaxPrecision: 6, MaxKeys: 1},
+	}, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := gophertags.NewSecretKey(8)
+
+	if _, err := r.Add("alice", sk.ExtractDetectionKey(1)); err != ErrPolicy {
+		t.Errorf("precision below minimum: got %v, want ErrPolicy", err)
+	}
+	if _, err := r.Add("alice", sk.ExtractDetectionKey(7)); err != ErrPolicy {
+		t.Errorf("precision above maximum: got %v, want ErrPolicy", err)
+	}
+	id, err := r.Add("alice", sk.ExtractDetectionKey(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replacing a key of the same family doesn't count against MaxKeys.
+	if _, err := r.Add("alice", sk.ExtractDetectionKey(3)); err != nil {
+		t.Errorf("replacing key: %v", err)
+	}
+	if _, err := r.Add("alice", gophertags.NewSecretKey(8).ExtractDetectionKey(4)); err != ErrPolicy {
+		t.Errorf("too many keys: got %v, want ErrPolicy", err)
+	}
+	if _, err := r.Add("bob", sk.ExtractDetectionKey(4)); err != ErrKeyOwned {
+		t.Errorf("another tenant's key: got %v, want ErrKeyOwned", err)
+	}
+
+	if ids := r.List("alice"); len(ids) != 1 || ids[0] != id {
+		t.Errorf("List = %x, want [%x]", ids, id)
+	}
+	if tenant, ok := r.Tenant(id); !ok || tenant != "alice" {
+		t.Errorf("Tenant = %q, %v", tenant, ok)
+	}
+	if md.Len() != 1 {
+		t.Errorf("detector holds %d keys, want 1", md.Len())
+	}
+
+	if err := r.Remove("bob", id); err != ErrUnknownKey {
+		t.Errorf("removing another tenant's key: got %v, want ErrUnknownKey", err)
+	}
+	if err := r.Remove("alice", id); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.List("alice")) != 0 || md.Len() != 0 {
+		t.Error("removed key is still registered")
+	}
+}
+
+func TestRegistryPersistence(t *testing.T) {
+	config := RegistryConfig{
+		Path: filepath.Join(t.TempDir(), "registry.json"),
+		Policy: func(tenant string) TenantPolicy {
+			if tenant == "restricted" {
+				return TenantPolicy{MaxKeys: 1}
+			}
+			return TenantPolicy{}
+		},
+	}
+	sk1, sk2 := gophertags.NewSecretKey(8), gophertags.NewSecretKey(8)
+
+	r, err := OpenRegistry(config, NewMultiDetector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id1, err := r.Add("alice", sk1.ExtractDetectionKey(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := r.Add("restricted", sk2.ExtractDetectionKey(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Add("restricted", gophertags.NewSecretKey(8).ExtractDetectionKey(5)); err != ErrPolicy {
+		t.Errorf("per-tenant policy not applied: got %v", err)
+	}
+
+	md := NewMultiDetector()
+	reopened, err := OpenRegistry(config, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := reopened.List("alice"); len(ids) != 1 || ids[0] != id1 {
+		t.Errorf("alice's keys after reopening = %x", ids)
+	}
+	if tenant, _ := reopened.Tenant(id2); tenant != "restricted" {
+		t.Errorf("owner after reopening = %q", tenant)
+	}
+	// Other low-precision keys may match too, as false positives.
+	if matches := md.Match(sk1.PublicKey().GenerateFlag()); !containsKeyID(matches, id1) {
+		t.Errorf("reloaded key not registered with detector: %x", matches)
+	}
+}
+
+func containsKeyID(ids []gophertags.KeyID, id gophertags.KeyID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// A RevocationList names the detection keys, by fingerprint, that the holder
+// of an Ed25519 identity key has revoked, so a recipient can cut off a server
+// that leaked a key or that it no longer trusts. The signature covers
+//
+//	"gophertags revocation v1" || issuer || uint64be(sequence) || uint64be(unix nanos) || fingerprints
+//
+// Each list replaces the issuer's previous one and must have a higher
+// sequence number, so an old list can't be replayed to lift a revocation.
+// Fingerprints name one copy of a key: a watermarked copy can be revoked
+// without revoking the copies given to other servers.
+type RevocationList struct {
+	Issuer    ed25519.PublicKey        `json:"issuer"`
+	Sequence  uint64                   `json:"sequence"`
+	Time      time.Time                `json:"time"`
+	Revoked   []gophertags.Fingerprint `json:"revoked"`
+	Signature []byte                   `json:"signature"`
+}
+
+const revocationLabel = "gophertags revocation v1"
+
+// Errors returned for revocation lists.
+var (
+	ErrRevocationSignature = errors.New("server: invalid revocation list signature")
+	ErrRevocationStale     = errors.New("server: revocation list is not newer than the current one")
+	ErrRevoked             = errors.New("server: detection key has been revoked")
+)
+
+// NewRevocationList returns a list revoking the given fingerprints, signed by identity.
+func NewRevocationList(identity ed25519.PrivateKey, sequence uint64, revoked []gophertags.Fingerprint) *RevocationList {
+	l := &RevocationList{
+		Issuer:   identity.Public().(ed25519.PublicKey),
+		Sequence: sequence,
+		Time:     time.Now().UTC(),
+		Revoked:  append([]gophertags.Fingerprint(nil), revoked...),
+	}
+	l.Signature = ed25519.Sign(identity, l.signedMessage())
+	return l
+}
+
+func (l *RevocationList) signedMessage() []byte {
+	msg := make([]byte, 0, len(revocationLabel)+len(l.Issuer)+16+len(l.Revoked)*gophertags.FingerprintSize)
+	msg = append(msg, revocationLabel...)
+	msg = append(msg, l.Issuer...)
+	var u [8]byte
+	binary.BigEndian.PutUint64(u[:], l.Sequence)
+	msg = append(msg, u[:]...)
+	binary.BigEndian.PutUint64(u[:], uint64(l.Time.UnixNano()))
+	msg = append(msg, u[:]...)
+	for _, fp := range l.Revoked {
+		msg = append(msg, fp[:]...)
+	}
+	return msg
+}
+
+// Verify checks the list's signature.
+func (l *RevocationList) Verify() error {
+	if len(l.Issuer) != ed25519.PublicKeySize || !ed25519.Verify(l.Issuer, l.signedMessage(), l.Signature) {
+		return ErrRevocationSignature
+	}
+	return nil
+}
+
+// Revokes reports whether the list names dk.
+func (l *RevocationList) Revokes(dk *gophertags.DetectionKey) bool {
+	fp := dk.Fingerprint()
+	for _, r := range l.Revoked {
+		if r == fp {
+			return true
+		}
+	}
+	return false
+}
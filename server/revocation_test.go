@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestRevocationList(t *testing.T) {
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	sk := gophertags.NewSecretKey(8)
+	leaked := sk.WatermarkDetectionKey(sk.ExtractDetectionKey(4), 1)
+	kept := sk.WatermarkDetectionKey(sk.ExtractDetectionKey(4), 2)
+
+	l := NewRevocationList(identity, 1, []gophertags.Fingerprint{leaked.Fingerprint()})
+	if err := l.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Revokes(leaked) || l.Revokes(kept) {
+		t.Error("revocation didn't single out the leaked copy")
+	}
+	l.Sequence++
+	if err := l.Verify(); err != ErrRevocationSignature {
+		t.Errorf("modified list: got %v, want ErrRevocationSignature", err)
+	}
+}
+
+func TestRegistryRevoke(t *testing.T) {
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	tenant := hex.EncodeToString(identity.Public().(ed25519.PublicKey))
+	config := RegistryConfig{Path: filepath.Join(t.TempDir(), "registry.json")}
+	sk1, sk2 := gophertags.NewSecretKey(8), gophertags.NewSecretKey(8)
+	dk1, dk2 := sk1.ExtractDetectionKey(4), sk2.ExtractDetectionKey(4)
+
+	md := NewMultiDetector()
+	r, err := OpenRegistry(config, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id1, _ := r.Add(tenant, dk1)
+	id2, _ := r.Add(tenant, dk2)
+	// Lists only apply to their issuer's keys.
+	dk3 := gophertags.NewSecretKey(8).ExtractDetectionKey(4)
+	other, _ := r.Add("someone else", dk3)
+
+	ids, err := r.Revoke(NewRevocationList(identity, 1, []gophertags.Fingerprint{dk1.Fingerprint(), dk3.Fingerprint()}))
+	if err != nil || len(ids) != 1 || ids[0] != id1 {
+		t.Fatalf("Revoke = %x, %v", ids, err)
+	}
+	if list := r.List(tenant); len(list) != 1 || list[0] != id2 {
+		t.Errorf("keys after revocation = %x", list)
+	}
+	if containsKeyID(md.Match(sk1.PublicKey().GenerateFlag()), id1) {
+		t.Error("revoked key still detects")
+	}
+	if _, err := r.Add(tenant, dk1); err != ErrRevoked {
+		t.Errorf("re-adding a revoked key: got %v, want ErrRevoked", err)
+	}
+	if tenant, ok := r.Tenant(other); !ok || tenant != "someone else" {
+		t.Error("a revocation list removed another tenant's key")
+	}
+
+	if _, err := r.Revoke(NewRevocationList(identity, 1, nil)); err != ErrRevocationStale {
+		t.Errorf("replayed sequence: got %v, want ErrRevocationStale", err)
+	}
+
+	reopened, err := OpenRegistry(config, NewMultiDetector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Add(tenant, dk1); err != ErrRevoked {
+		t.Errorf("revocation not persisted: got %v", err)
+	}
+	if _, err := reopened.Revoke(NewRevocationList(identity, 1, nil)); err != ErrRevocationStale {
+		t.Errorf("sequence not persisted: got %v", err)
+	}
+
+	// A newer list that drops the fingerprint lifts the revocation.
+	if _, err := reopened.Revoke(NewRevocationList(identity, 2, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Add(tenant, dk1); err != nil {
+		t.Errorf("adding after the revocation was lifted: %v", err)
+	}
+}
+
+func TestKeyServiceRevoke(t *testing.T) {
+	_, ts, pki := newKeyServiceTest(t)
+	_, aliceID, _ := ed25519.GenerateKey(rand.Reader)
+	alice := clientFor(t, ts, pki, aliceID)
+	ctx := context.Background()
+
+	dk := gophertags.NewSecretKey(8).ExtractDetectionKey(4)
+	id, err := alice.Register(ctx, dk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := alice.Revoke(ctx, 1, []gophertags.Fingerprint{dk.Fingerprint()})
+	if err != nil || len(ids) != 1 || ids[0] != id {
+		t.Fatalf("Revoke = %x, %v", ids, err)
+	}
+	if _, err := alice.Register(ctx, dk); err == nil {
+		t.Error("registered a revoked key")
+	}
+	if _, err := alice.Revoke(ctx, 1, nil); err == nil {
+		t.Error("accepted a stale revocation list")
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gtank/gophertags"
+	"golang.org/x/crypto/sha3"
+)
+
+const shardLabel = "gophertags shard v1"
+
+// HashRing assigns key IDs to shards by consistent hashing, so adding or
+// removing a shard moves only the keys it gains or loses. Each shard is
+// placed at several points on the ring to even out the load.
+type HashRing struct {
+	points ringPoints
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard string
+}
+
+type ringPoints []ringPoint
+
+func (p ringPoints) Len() int           { return len(p) }
+func (p ringPoints) Less(i, j int) bool { return p[i].hash < p[j].hash }
+func (p ringPoints) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+const defaultReplicas = 64
+
+// NewHashRing returns a ring of the named shards, each placed at replicas
+// points. Zero replicas means 64.
+func NewHashRing(shards []string, replicas int) *HashRing {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	ring := &HashRing{}
+	for _, name := range shards {
+		for i := 0; i < replicas; i++ {
+			ring.points = append(ring.points, ringPoint{ringHash(name, uint32(i)), name})
+		}
+	}
+	sort.Sort(ring.points)
+	return ring
+}
+
+// Shard returns the shard owning the key, the first at or after the key's
+// point on the ring. It returns "" if the ring is empty.
+func (r *HashRing) Shard(id gophertags.KeyID) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := binary.BigEndian.Uint64(id[:8])
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard
+}
+
+// ringHash places a shard's replica on the ring. Key IDs are already hashes,
+// so they are placed by their first eight bytes.
+func ringHash(shard string, replica uint32) uint64 {
+	h := sha3.New256()
+	h.Write([]byte(shardLabel))
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], replica)
+	h.Write(b[:])
+	h.Write([]byte(shard))
+	return binary.BigEndian.Uint64(h.Sum(nil)[:8])
+}
+
+// RouterConfig configures a Router.
+type RouterConfig struct {
+	// Shards are the detector processes keys are partitioned across, by
+	// name. Each serves the Server API; use httputil.NewSingleHostReverseProxy
+	// for remote ones.
+	Shards map[string]http.Handler
+
+	// Replicas is the number of ring points per shard. Zero means 64.
+	Replicas int
+
+	// MaxBodySize bounds request bodies. Zero means 1 MiB.
+	MaxBodySize int64
+}
+
+// Router spreads a detection deployment across several Server processes. It
+// serves the same API as Server: each key is registered with, and its
+// matches are served by, the one shard the key's ID hashes to, while every
+// submitted message is sent to all shards, each testing it against its own
+// keys. Detection work per shard thus shrinks as shards are added.
+//
+// Message IDs are assigned by each shard, so submissions through a Router
+// return no ID; match query cursors are the owning shard's IDs and work
+// unchanged. A submission is a duplicate only if every shard says so.
+//
+// Changing the set of shards moves some keys to new owners. Their old
+// matches stay with the old shard, and they must be registered again with
+// the new one.
+type Router struct {
+	ring    *HashRing
+	shards  map[string]http.Handler
+	names   []string
+	maxBody int64
+	mux     *http.ServeMux
+}
+
+// NewRouter returns a router over the configured shards.
+func NewRouter(config RouterConfig) *Router {
+	r := &Router{
+		shards:  config.Shards,
+		maxBody: config.MaxBodySize,
+		mux:     http.NewServeMux(),
+	}
+	for name := range config.Shards {
+		r.names = append(r.names, name)
+	}
+	sort.Strings(r.names)
+	r.ring = NewHashRing(r.names, config.Replicas)
+	if r.maxBody <= 0 {
+		r.maxBody = defaultMaxBodySize
+	}
+	r.mux.HandleFunc("/v1/keys", r.handleKeys)
+	r.mux.HandleFunc("/v1/messages", r.handleMessages)
+	r.mux.HandleFunc("/v1/matches", r.handleMatches)
+	return r
+}
+
+// Shard returns the name of the shard that owns the key.
+func (r *Router) Shard(id gophertags.KeyID) string {
+	return r.ring.Shard(id)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+func (r *Router) handleKeys(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, r.maxBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+	dk, err := gophertags.DecodeDetectionKey(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.forward(w, req, r.Shard(dk.KeyID()), body)
+}
+
+func (r *Router) handleMatches(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	id, err := parseKeyID(req.URL.Query().Get("key"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.forward(w, req, r.Shard(id), nil)
+}
+
+func (r *Router) handleMessages(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, r.maxBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+	if len(r.names) == 0 {
+		writeError(w, http.StatusServiceUnavailable, "no shards")
+		return
+	}
+
+	responses := make([]*bufferedResponse, len(r.names))
+	var wg sync.WaitGroup
+	for i, name := range r.names {
+		wg.Add(1)
+		go func(i int, shard http.Handler) {
+			defer wg.Done()
+			responses[i] = newBufferedResponse()
+			shard.ServeHTTP(responses[i], cloneRequest(req, body))
+		}(i, r.shards[name])
+	}
+	wg.Wait()
+
+	duplicate := true
+	for _, resp := range responses {
+		if resp.status >= 400 {
+			// Malformed submissions fail identically everywhere, so pass
+			// client errors through; anything else is the shard's fault.
+			if resp.status < 500 {
+				resp.copyTo(w)
+			} else {
+				writeError(w, http.StatusBadGateway, "shard failed to accept message")
+			}
+			return
+		}
+		var m messageResponse
+		json.Unmarshal(resp.body.Bytes(), &m)
+		duplicate = duplicate && m.Duplicate
+	}
+	if duplicate {
+		writeJSON(w, http.StatusOK, messageResponse{Duplicate: true})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, messageResponse{})
+}
+
+// forward passes the request to the named shard, with body replacing the
+// request's own if it has already been read.
+func (r *Router) forward(w http.ResponseWriter, req *http.Request, name string, body []byte) {
+	shard, ok := r.shards[name]
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, "no shards")
+		return
+	}
+	if body != nil {
+		req = cloneRequest(req, body)
+	}
+	shard.ServeHTTP(w, req)
+}
+
+func cloneRequest(req *http.Request, body []byte) *http.Request {
+	out := req.Clone(req.Context())
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	return out
+}
+
+// bufferedResponse is an http.ResponseWriter that keeps the response in
+// memory, for combining the responses of several shards.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+
+func (b *bufferedResponse) copyTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
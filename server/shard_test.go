@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := NewHashRing([]string{"a", "b", "c"}, 0)
+	counts := make(map[string]int)
+	owners := make(map[gophertags.KeyID]string)
+	for i := 0; i < 3000; i++ {
+		id := gophertags.KeyID{byte(i), byte(i >> 8), 7}
+		owners[id] = ring.Shard(id)
+		counts[owners[id]]++
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if counts[name] < 500 {
+			t.Errorf("shard %q owns only %d of 3000 keys", name, counts[name])
+		}
+	}
+
+	// Adding a shard only moves keys to the new shard.
+	grown := NewHashRing([]string{"a", "b", "c", "d"}, 0)
+	for id, owner := range owners {
+		if got := grown.Shard(id); got != owner && got != "d" {
+			t.Fatalf("key %v moved from %q to %q", id, owner, got)
+		}
+	}
+
+	if got := NewHashRing(nil, 0).Shard(gophertags.KeyID{}); got != "" {
+		t.Errorf("empty ring assigned shard %q", got)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	shards := map[string]*Server{}
+	handlers := map[string]http.Handler{}
+	for _, name := range []string{"s1", "s2", "s3"} {
+		shards[name] = New(Config{Dedup: NewDedupIndex(DedupConfig{})})
+		handlers[name] = shards[name]
+	}
+	r := NewRouter(RouterConfig{Shards: handlers})
+
+	keys := make([]*gophertags.SecretKey, 6)
+	for i := range keys {
+		keys[i] = gophertags.NewSecretKey(16)
+		w := do(t, r, http.MethodPost, "/v1/keys", keys[i].ExtractDetectionKey(16).Encode(nil))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("registering key %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+	total := 0
+	for _, s := range shards {
+		total += s.Detector().Len()
+	}
+	if total != len(keys) {
+		t.Errorf("%d keys registered across shards, want %d", total, len(keys))
+	}
+	for _, sk := range keys {
+		id := sk.PublicKey().KeyID()
+		matches := shards[r.Shard(id)].Detector().Match(sk.PublicKey().GenerateFlag())
+		if len(matches) != 1 || matches[0] != id {
+			t.Errorf("owner %q of key %v matched %v", r.Shard(id), id, matches)
+		}
+	}
+
+	f := keys[4].PublicKey().GenerateFlag()
+	if w := submit(t, r, f, "for key 4"); w.Code != http.StatusAccepted {
+		t.Fatalf("submitting: %d %s", w.Code, w.Body)
+	}
+	w := submit(t, r, f, "for key 4")
+	var dup messageResponse
+	json.NewDecoder(w.Body).Decode(&dup)
+	if w.Code != http.StatusOK || !dup.Duplicate {
+		t.Errorf("resubmitting: %d %+v", w.Code, dup)
+	}
+	if w := do(t, r, http.MethodPost, "/v1/messages", []byte(`{"flag":"AAAA"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed flag: %d", w.Code)
+	}
+
+	w = do(t, r, http.MethodGet, fmt.Sprintf("/v1/matches?key=%v", keys[4].PublicKey().KeyID()), nil)
+	var matches matchesResponse
+	json.NewDecoder(w.Body).Decode(&matches)
+	if w.Code != http.StatusOK || len(matches.Messages) != 1 || string(matches.Messages[0].Payload) != "for key 4" {
+		t.Errorf("matches through router: %d %+v", w.Code, matches)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// ShufflerConfig configures a Shuffler.
+type ShufflerConfig struct {
+	// Epoch is how long messages are buffered before release. Zero means one
+	// minute.
+	Epoch time.Duration
+
+	// Release receives each epoch's messages, shuffled, with Received set to
+	// a random time within the epoch and in that order. DetectAndStore
+	// returns a Release that tests and stores them.
+	Release func(ctx context.Context, batch []mailbox.Message) error
+}
+
+const defaultEpoch = time.Minute
+
+// ErrShufflerClosed is returned by Shuffler.Add after Run has returned.
+var ErrShufflerClosed = errors.New("server: shuffler is closed")
+
+// Shuffler buffers incoming messages into epochs and releases each epoch in
+// random order with randomized timestamps, so that neither the order nor the
+// time of match notifications reveals when, relative to each other, messages
+// arrived. It is safe for concurrent use.
+type Shuffler struct {
+	config ShufflerConfig
+	now    func() time.Time
+
+	mu     sync.Mutex
+	buffer []mailbox.Message
+	start  time.Time // of the current epoch
+	closed bool
+}
+
+// NewShuffler returns a Shuffler. Call Run to release epochs.
+func NewShuffler(config ShufflerConfig) *Shuffler {
+	if config.Epoch <= 0 {
+		config.Epoch = defaultEpoch
+	}
+	s := &Shuffler{config: config, now: time.Now}
+	s.start = s.now()
+	return s
+}
+
+// Add buffers a message until the end of the current epoch.
+func (s *Shuffler) Add(msg mailbox.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrShufflerClosed
+	}
+	s.buffer = append(s.buffer, msg)
+	return nil
+}
+
+// Run releases an epoch every config.Epoch until ctx is done, then releases
+// what remains and returns ctx.Err(). It returns early with any error from
+// Release; the failed epoch's messages are lost, as a crashed server's would be.
+func (s *Shuffler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.config.Epoch)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.closed = true
+			s.mu.Unlock()
+			if err := s.Flush(context.Background()); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := s.Flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Flush ends the current epoch now, releasing its messages.
+func (s *Shuffler) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	batch, start, end := s.buffer, s.start, s.now()
+	s.buffer, s.start = nil, end
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	span := uint64(end.Sub(start))
+	for i := range batch {
+		var offset time.Duration
+		if span > 0 {
+			offset = time.Duration(randUint64() % span)
+		}
+		batch[i].Received = start.Add(offset)
+	}
+	// Sorting by a uniformly random time is a uniformly random shuffle, up
+	// to ties, which are broken by a second random key.
+	tiebreak := make([]uint64, len(batch))
+	for i := range tiebreak {
+		tiebreak[i] = randUint64()
+	}
+	sort.Sort(byReceived{batch, tiebreak})
+	return s.config.Release(ctx, batch)
+}
+
+type byReceived struct {
+	messages []mailbox.Message
+	tiebreak []uint64
+}
+
+func (b byReceived) Len() int { return len(b.messages) }
+
+func (b byReceived) Less(i, j int) bool {
+	ti, tj := b.messages[i].Received, b.messages[j].Received
+	if ti.Equal(tj) {
+		return b.tiebreak[i] < b.tiebreak[j]
+	}
+	return ti.Before(tj)
+}
+
+func (b byReceived) Swap(i, j int) {
+	b.messages[i], b.messages[j] = b.messages[j], b.messages[i]
+	b.tiebreak[i], b.tiebreak[j] = b.tiebreak[j], b.tiebreak[i]
+}
+
+func randUint64() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("server: crypto/rand failed: " + err.Error())
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// DetectAndStore returns a ShufflerConfig.Release that tests each message's
+// flag against the detector and stores it with its matches. Messages whose
+// flags don't decode are dropped.
+func DetectAndStore(detector *MultiDetector, store mailbox.Store) func(context.Context, []mailbox.Message) error {
+	return func(ctx context.Context, batch []mailbox.Message) error {
+		for _, msg := range batch {
+			f := new(gophertags.Flag)
+			if err := f.Decode(msg.Flag); err != nil {
+				continue
+			}
+			if _, err := store.Put(ctx, msg, detector.Match(f)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
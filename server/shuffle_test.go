@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+func TestShufflerFlush(t *testing.T) {
+	var released []mailbox.Message
+	s := NewShuffler(ShufflerConfig{Release: func(ctx context.Context, batch []mailbox.Message) error {
+		released = append(released, batch...)
+		return nil
+	}})
+	start := time.Unix(1600000000, 0)
+	clock := start
+	s.now = func() time.Time { return clock }
+	s.start = start
+
+	for i := 0; i < 100; i++ {
+		s.Add(mailbox.Message{Payload: []byte{byte(i)}, Received: start})
+	}
+	clock = start.Add(time.Minute)
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(released) != 100 {
+		t.Fatalf("released %d messages, want 100", len(released))
+	}
+	seen, inOrder := make(map[byte]bool), true
+	for i, msg := range released {
+		seen[msg.Payload[0]] = true
+		inOrder = inOrder && msg.Payload[0] == byte(i)
+		if msg.Received.Before(start) || !msg.Received.Before(clock) {
+			t.Errorf("timestamp %v outside the epoch", msg.Received)
+		}
+		if i > 0 && msg.Received.Before(released[i-1].Received) {
+			t.Error("released out of timestamp order")
+		}
+	}
+	if len(seen) != 100 || inOrder {
+		t.Errorf("batch lost messages or wasn't shuffled: %d distinct, in order %v", len(seen), inOrder)
+	}
+}
+
+func TestShufflerRun(t *testing.T) {
+	sk := gophertags.NewSecretKey(8)
+	md := NewMultiDetector()
+	id := md.Add(sk.ExtractDetectionKey(8))
+	store := mailbox.NewMemoryStore()
+	s := NewShuffler(ShufflerConfig{Epoch: time.Hour, Release: DetectAndStore(md, store)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+	s.Add(mailbox.Message{Flag: sk.PublicKey().GenerateFlag().Encode(nil), Payload: []byte("hello")})
+	s.Add(mailbox.Message{Flag: []byte("junk")})
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run returned %v", err)
+	}
+
+	// The final epoch is released when Run stops.
+	if messages, _ := store.Matches(context.Background(), id); len(messages) != 1 || string(messages[0].Payload) != "hello" {
+		t.Errorf("matches after Run = %+v", messages)
+	}
+	if err := s.Add(mailbox.Message{}); err != ErrShufflerClosed {
+		t.Errorf("Add after Run: got %v", err)
+	}
+}
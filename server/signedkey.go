@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// A SignedDetectionKey is a detection key the holder of an Ed25519 identity
+// key has issued to one server until an expiry time. The signature covers
+//
+//	"gophertags signed key v1" || identity || uint32be(precision) || uint64be(expiry unix nanos) || uvarint(len(server)) || server || key
+//
+// A server that accepts only signed keys for its own ID can't be made to
+// detect for a key by anyone who merely obtained it, such as another server
+// the recipient gave it to: they can't sign it for this server.
+type SignedDetectionKey struct {
+	Identity  ed25519.PublicKey `json:"identity"`
+	Key       []byte            `json:"key"`
+	Precision int               `json:"precision"`
+	Expiry    time.Time         `json:"expiry"`
+	Server    string            `json:"server"`
+	Signature []byte            `json:"signature"`
+}
+
+const signedKeyLabel = "gophertags signed key v1"
+
+// Errors returned by SignedDetectionKey.Verify.
+var (
+	ErrKeySignature = errors.New("server: invalid detection key signature")
+	ErrKeyExpired   = errors.New("server: signed detection key has expired")
+	ErrWrongServer  = errors.New("server: detection key was issued to another server")
+)
+
+// SignDetectionKey issues dk to the server with the given ID until expiry.
+func SignDetectionKey(identity ed25519.PrivateKey, dk *gophertags.DetectionKey, server string, expiry time.Time) *SignedDetectionKey {
+	s := &SignedDetectionKey{
+		Identity:  identity.Public().(ed25519.PublicKey),
+		Key:       dk.Encode(nil),
+		Precision: dk.Precision(),
+		Expiry:    expiry.UTC(),
+		Server:    server,
+	}
+	s.Signature = ed25519.Sign(identity, s.signedMessage())
+	return s
+}
+
+func (s *SignedDetectionKey) signedMessage() []byte {
+	msg := make([]byte, 0, len(signedKeyLabel)+len(s.Identity)+12+binary.MaxVarintLen64+len(s.Server)+len(s.Key))
+	msg = append(msg, signedKeyLabel...)
+	msg = append(msg, s.Identity...)
+	var u [binary.MaxVarintLen64]byte
+	binary.BigEndian.PutUint32(u[:], uint32(s.Precision))
+	msg = append(msg, u[:4]...)
+	binary.BigEndian.PutUint64(u[:], uint64(s.Expiry.UnixNano()))
+	msg = append(msg, u[:8]...)
+	msg = append(msg, u[:binary.PutUvarint(u[:], uint64(len(s.Server)))]...)
+	msg = append(msg, s.Server...)
+	return append(msg, s.Key...)
+}
+
+// Verify checks the signature, that the key was issued to server and hasn't
+// expired at now, and returns the decoded key.
+func (s *SignedDetectionKey) Verify(server string, now time.Time) (*gophertags.DetectionKey, error) {
+	if len(s.Identity) != ed25519.PublicKeySize || !ed25519.Verify(s.Identity, s.signedMessage(), s.Signature) {
+		return nil, ErrKeySignature
+	}
+	if s.Server != server {
+		return nil, ErrWrongServer
+	}
+	if !now.Before(s.Expiry) {
+		return nil, ErrKeyExpired
+	}
+	dk, err := gophertags.DecodeDetectionKey(s.Key)
+	if err != nil {
+		return nil, err
+	}
+	if dk.Precision() != s.Precision {
+		return nil, ErrKeySignature
+	}
+	return dk, nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+func TestSignedDetectionKey(t *testing.T) {
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	dk := gophertags.NewSecretKey(8).ExtractDetectionKey(5)
+	now := time.Now()
+	signed := SignDetectionKey(identity, dk, "detector-a", now.Add(time.Hour))
+
+	got, err := signed.Verify("detector-a", now)
+	if err != nil || got.Fingerprint() != dk.Fingerprint() {
+		t.Fatalf("Verify: %v", err)
+	}
+	if _, err := signed.Verify("detector-b", now); err != ErrWrongServer {
+		t.Errorf("other server: got %v, want ErrWrongServer", err)
+	}
+	if _, err := signed.Verify("detector-a", now.Add(2*time.Hour)); err != ErrKeyExpired {
+		t.Errorf("after expiry: got %v, want ErrKeyExpired", err)
+	}
+
+	for name, tamper := range map[string]func(s *SignedDetectionKey){
+		"server":    func(s *SignedDetectionKey) { s.Server = "detector-b" },
+		"precision": func(s *SignedDetectionKey) { s.Precision = 4 },
+		"expiry":    func(s *SignedDetectionKey) { s.Expiry = s.Expiry.Add(time.Hour) },
+		"key":       func(s *SignedDetectionKey) { s.Key = gophertags.NewSecretKey(8).ExtractDetectionKey(5).Encode(nil) },
+	} {
+		s := *signed
+		tamper(&s)
+		if _, err := s.Verify(s.Server, now); err != ErrKeySignature {
+			t.Errorf("tampered %s: got %v, want ErrKeySignature", name, err)
+		}
+	}
+}
+
+func TestKeyServiceSignedKeys(t *testing.T) {
+	svc, ts, pki := newKeyServiceTestConfig(t, KeyServiceConfig{ServerID: "detector-a", RequireSignedKeys: true})
+	_, recipientID, _ := ed25519.GenerateKey(rand.Reader)
+	_, relayID, _ := ed25519.GenerateKey(rand.Reader)
+	relay := clientFor(t, ts, pki, relayID)
+	ctx := context.Background()
+
+	dk := gophertags.NewSecretKey(8).ExtractDetectionKey(5)
+	if _, err := relay.Register(ctx, dk); err == nil {
+		t.Error("accepted a plain registration from a party holding the key")
+	}
+	if _, err := relay.RegisterSigned(ctx, SignDetectionKey(recipientID, dk, "detector-b", time.Now().Add(time.Hour))); err == nil {
+		t.Error("accepted a key signed for another server")
+	}
+
+	id, err := relay.RegisterSigned(ctx, SignDetectionKey(recipientID, dk, "detector-a", time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := hex.EncodeToString(recipientID.Public().(ed25519.PublicKey))
+	if tenant, _ := svc.registry.Tenant(id); tenant != recipient {
+		t.Errorf("signed key registered to %q, want the recipient", tenant)
+	}
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timestamp is a trusted third party's attestation that a hash existed at a
+// time.
+type Timestamp struct {
+	Time  time.Time // as the authority attests
+	Token []byte    // the authority's signed token, such as an RFC 3161 TimeStampToken
+}
+
+// Timestamper obtains trusted timestamps, such as from an RFC 3161
+// timestamp authority with RFC3161Timestamper.
+type Timestamper interface {
+	Timestamp(ctx context.Context, hash [32]byte) (Timestamp, error)
+}
+
+// AuditAnchor records a timestamp on the Hash of an audit log entry. Since
+// the Hash commits to every entry before it, the anchor proves that the log
+// up to Seq existed at Time, so a mailbox can't later rewrite when it tested
+// or matched a flag without breaking either the chain or the timestamp.
+type AuditAnchor struct {
+	Seq  uint64
+	Hash [32]byte
+	Timestamp
+}
+
+type anchorRecord struct {
+	Seq   uint64    `json:"seq"`
+	Hash  string    `json:"hash"`
+	Time  time.Time `json:"time"`
+	Token []byte    `json:"token"`
+}
+
+// ErrAuditAnchor is returned by VerifyAuditAnchors for anchors that don't
+// match the log.
+var ErrAuditAnchor = errors.New("server: audit anchor doesn't match log")
+
+// AnchorConfig configures an AuditAnchorer.
+type AnchorConfig struct {
+	// Interval is how often the log's head is timestamped, if it has moved.
+	// Zero means one hour.
+	Interval time.Duration
+
+	// OnAnchor, if set, is called with each anchor written.
+	OnAnchor func(AuditAnchor)
+
+	// OnError, if set, is called when timestamping fails, and Run carries
+	// on at the next interval. If nil, Run returns the error.
+	OnError func(error)
+}
+
+const defaultAnchorInterval = time.Hour
+
+// AuditAnchorer timestamps the head of an AuditLog in the background and
+// appends the anchors to a writer as JSON lines.
+type AuditAnchorer struct {
+	log    *AuditLog
+	ts     Timestamper
+	config AnchorConfig
+
+	mu   sync.Mutex
+	w    io.Writer
+	last uint64 // Seq of the last anchored entry
+}
+
+// NewAuditAnchorer returns an anchorer for log that writes to w. Call Run to
+// start anchoring.
+func NewAuditAnchorer(log *AuditLog, ts Timestamper, w io.Writer, config AnchorConfig) *AuditAnchorer {
+	if config.Interval <= 0 {
+		config.Interval = defaultAnchorInterval
+	}
+	return &AuditAnchorer{log: log, ts: ts, config: config, w: w}
+}
+
+// Run anchors the log now and then every config.Interval until ctx is done,
+// returning ctx.Err(), or until anchoring fails without an OnError, returning
+// that error.
+func (a *AuditAnchorer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	for {
+		if _, _, err := a.Anchor(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if a.config.OnError == nil {
+				return err
+			}
+			a.config.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Anchor timestamps the log's head once, unless the log is empty or its head
+// is already anchored, reporting whether it wrote an anchor.
+func (a *AuditAnchorer) Anchor(ctx context.Context) (AuditAnchor, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	head := a.log.Head()
+	if head.Seq == 0 || head.Seq == a.last {
+		return AuditAnchor{}, false, nil
+	}
+	ts, err := a.ts.Timestamp(ctx, head.Hash)
+	if err != nil {
+		return AuditAnchor{}, false, err
+	}
+	anchor := AuditAnchor{Seq: head.Seq, Hash: head.Hash, Timestamp: ts}
+	line, err := json.Marshal(anchorRecord{Seq: anchor.Seq, Hash: hex.EncodeToString(anchor.Hash[:]), Time: ts.Time.UTC(), Token: ts.Token})
+	if err != nil {
+		return AuditAnchor{}, false, err
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		return AuditAnchor{}, false, err
+	}
+	a.last = head.Seq
+	if a.config.OnAnchor != nil {
+		a.config.OnAnchor(anchor)
+	}
+	return anchor, true, nil
+}
+
+// VerifyAuditAnchors reads a log written by AuditLog and the anchors written
+// for it by an AuditAnchorer, and checks that every anchor names an entry of
+// the log, that anchors are in order of both Seq and Time, and that the hash
+// chain is unbroken up to the last anchor. It returns the anchors, or an error
+// wrapping ErrAuditAnchor or ErrAuditChain. It doesn't check the tokens,
+// which only the authority's verifier can do; for RFC 3161 tokens, see
+// CheckRFC3161Token.
+func VerifyAuditAnchors(log, anchors io.Reader) ([]AuditAnchor, error) {
+	var list []AuditAnchor
+	scanner := bufio.NewScanner(anchors)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var rec anchorRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("server: audit anchor %d: %v", len(list)+1, err)
+		}
+		anchor := AuditAnchor{Seq: rec.Seq, Timestamp: Timestamp{Time: rec.Time, Token: rec.Token}}
+		b, err := hex.DecodeString(rec.Hash)
+		if err != nil || len(b) != len(anchor.Hash) {
+			return nil, errors.New("server: malformed audit anchor")
+		}
+		copy(anchor.Hash[:], b)
+		if n := len(list); n > 0 && (anchor.Seq <= list[n-1].Seq || anchor.Time.Before(list[n-1].Time)) {
+			return nil, fmt.Errorf("%w: anchor %d is out of order", ErrAuditAnchor, n+1)
+		}
+		list = append(list, anchor)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Check the anchors against the chain while verifying it.
+	next := 0
+	var last AuditEntry
+	lines := bufio.NewScanner(log)
+	for lines.Scan() && next < len(list) {
+		var rec auditRecord
+		if err := json.Unmarshal(lines.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("server: audit entry %d: %v", last.Seq+1, err)
+		}
+		var e AuditEntry
+		if err := e.fromRecord(&rec); err != nil {
+			return nil, err
+		}
+		if e.Seq != last.Seq+1 || e.Prev != last.Hash || e.Hash != e.computeHash() {
+			return nil, fmt.Errorf("%w at entry %d", ErrAuditChain, last.Seq+1)
+		}
+		if e.Seq == list[next].Seq {
+			if e.Hash != list[next].Hash {
+				return nil, fmt.Errorf("%w: entry %d has another hash", ErrAuditAnchor, e.Seq)
+			}
+			next++
+		}
+		last = e
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	if next < len(list) {
+		return nil, fmt.Errorf("%w: entry %d isn't in the log", ErrAuditAnchor, list[next].Seq)
+	}
+	return list, nil
+}
+
+// ErrTimestamp is returned, wrapped, when a timestamp authority refuses a
+// request or answers with a token that doesn't fit it.
+var ErrTimestamp = errors.New("server: timestamp authority failed")
+
+// RFC3161Timestamper obtains timestamps from an RFC 3161 timestamp authority
+// over HTTP. The message imprint it submits is the SHA-256 of the hash, as
+// authorities commonly accept only SHA-2 imprints.
+type RFC3161Timestamper struct {
+	// URL is the authority's endpoint.
+	URL string
+
+	// Client sends requests. Nil means http.DefaultClient.
+	Client *http.Client
+
+	// CertReq asks the authority to include its certificate in tokens, so
+	// they can be verified without fetching it separately.
+	CertReq bool
+}
+
+var (
+	oidSHA256  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// pkiStatusInfo's optional text and failure info are ignored.
+type pkiStatusInfo struct {
+	Status int
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT
+}
+
+// signedData's certificates and signer infos are ignored, since checking
+// the signature needs the authority's certificate chain.
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     []byte `asn1:"explicit,tag:0"`
+	}
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional"`
+	Nonce          *big.Int  `asn1:"optional"`
+	// tsa and extensions follow.
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// Timestamp implements Timestamper.
+func (t *RFC3161Timestamper) Timestamp(ctx context.Context, hash [32]byte) (Timestamp, error) {
+	imprint := sha256.Sum256(hash[:])
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return Timestamp{}, err
+	}
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: imprint[:],
+		},
+		Nonce:   nonce,
+		CertReq: t.CertReq,
+	})
+	if err != nil {
+		return Timestamp{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(req))
+	if err != nil {
+		return Timestamp{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/timestamp-query")
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return Timestamp{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Timestamp{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Timestamp{}, fmt.Errorf("%w: %s", ErrTimestamp, resp.Status)
+	}
+
+	var tsResp timeStampResp
+	if rest, err := asn1.Unmarshal(body, &tsResp); err != nil || len(rest) > 0 {
+		return Timestamp{}, fmt.Errorf("%w: malformed response", ErrTimestamp)
+	}
+	// 0 is granted and 1 granted with modifications.
+	if tsResp.Status.Status > 1 || len(tsResp.TimeStampToken.FullBytes) == 0 {
+		return Timestamp{}, fmt.Errorf("%w: request refused with status %d", ErrTimestamp, tsResp.Status.Status)
+	}
+	token := tsResp.TimeStampToken.FullBytes
+	info, err := parseTSTInfo(token)
+	if err != nil {
+		return Timestamp{}, err
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return Timestamp{}, fmt.Errorf("%w: token has the wrong nonce", ErrTimestamp)
+	}
+	if err := info.checkImprint(hash); err != nil {
+		return Timestamp{}, err
+	}
+	return Timestamp{Time: info.GenTime, Token: token}, nil
+}
+
+// CheckRFC3161Token checks that an RFC 3161 token from RFC3161Timestamper
+// is for hash, and returns the time it attests. It doesn't verify the
+// authority's signature, which needs the authority's certificate chain: check
+// it with, for example, openssl ts -verify.
+func CheckRFC3161Token(token []byte, hash [32]byte) (time.Time, error) {
+	info, err := parseTSTInfo(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := info.checkImprint(hash); err != nil {
+		return time.Time{}, err
+	}
+	return info.GenTime, nil
+}
+
+func parseTSTInfo(token []byte) (*tstInfo, error) {
+	malformed := fmt.Errorf("%w: malformed token", ErrTimestamp)
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(token, &ci); err != nil || ci.Content.Class != asn1.ClassContextSpecific || ci.Content.Tag != 0 {
+		return nil, malformed
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil || !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, malformed
+	}
+	info := new(tstInfo)
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent, info); err != nil {
+		return nil, malformed
+	}
+	return info, nil
+}
+
+func (info *tstInfo) checkImprint(hash [32]byte) error {
+	imprint := sha256.Sum256(hash[:])
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || !bytes.Equal(info.MessageImprint.HashedMessage, imprint[:]) {
+		return fmt.Errorf("%w: token is for another hash", ErrTimestamp)
+	}
+	return nil
+}
+
+var _ Timestamper = (*RFC3161Timestamper)(nil)
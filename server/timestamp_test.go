@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// tsaTime is when the fake authority says every timestamp was made.
+var tsaTime = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+// fakeTSA answers RFC 3161 requests with unsigned tokens that are otherwise
+// well formed. tamper, if set, changes the TSTInfo before it is encoded.
+func fakeTSA(t *testing.T, tamper func(*tstInfo)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(body, &req); err != nil || r.Header.Get("Content-Type") != "application/timestamp-query" {
+			t.Errorf("malformed request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		info := tstInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3},
+			MessageImprint: req.MessageImprint,
+			SerialNumber:   big.NewInt(42),
+			GenTime:        tsaTime,
+			Nonce:          req.Nonce,
+		}
+		if tamper != nil {
+			tamper(&info)
+		}
+		infoDER, err := asn1.Marshal(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sd signedData
+		sd.Version = 3
+		sd.DigestAlgorithms = asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+		sd.EncapContentInfo.EContentType = oidTSTInfo
+		sd.EncapContentInfo.EContent = infoDER
+		sdDER, _ := asn1.Marshal(sd)
+		token, _ := asn1.Marshal(contentInfo{
+			ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdDER},
+		})
+		resp, _ := asn1.Marshal(timeStampResp{TimeStampToken: asn1.RawValue{FullBytes: token}})
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(resp)
+	}))
+}
+
+func TestRFC3161Timestamper(t *testing.T) {
+	tsa := fakeTSA(t, nil)
+	defer tsa.Close()
+	hash := [32]byte{1, 2, 3}
+	ts, err := (&RFC3161Timestamper{URL: tsa.URL}).Timestamp(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Time.Equal(tsaTime) || len(ts.Token) == 0 {
+		t.Errorf("timestamp = %+v", ts)
+	}
+	if got, err := CheckRFC3161Token(ts.Token, hash); err != nil || !got.Equal(tsaTime) {
+		t.Errorf("CheckRFC3161Token = %v, %v", got, err)
+	}
+	if _, err := CheckRFC3161Token(ts.Token, [32]byte{9}); !errors.Is(err, ErrTimestamp) {
+		t.Errorf("token checked against another hash: %v", err)
+	}
+	if _, err := CheckRFC3161Token(ts.Token[:len(ts.Token)-1], hash); !errors.Is(err, ErrTimestamp) {
+		t.Errorf("truncated token: %v", err)
+	}
+
+	for name, tamper := range map[string]func(*tstInfo){
+		"nonce":   func(info *tstInfo) { info.Nonce = big.NewInt(7) },
+		"imprint": func(info *tstInfo) { info.MessageImprint.HashedMessage = make([]byte, 32) },
+		"hash": func(info *tstInfo) {
+			info.MessageImprint.HashAlgorithm = pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2}}
+		},
+	} {
+		bad := fakeTSA(t, tamper)
+		if _, err := (&RFC3161Timestamper{URL: bad.URL}).Timestamp(context.Background(), hash); !errors.Is(err, ErrTimestamp) {
+			t.Errorf("token with the wrong %s: %v", name, err)
+		}
+		bad.Close()
+	}
+
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, _ := asn1.Marshal(timeStampResp{Status: pkiStatusInfo{Status: 2}})
+		w.Write(resp)
+	}))
+	defer refusing.Close()
+	if _, err := (&RFC3161Timestamper{URL: refusing.URL}).Timestamp(context.Background(), hash); !errors.Is(err, ErrTimestamp) {
+		t.Errorf("refused request: %v", err)
+	}
+}
+
+// failingTimestamper fails its first fail requests.
+type failingTimestamper struct {
+	Timestamper
+	fail int
+}
+
+func (f *failingTimestamper) Timestamp(ctx context.Context, hash [32]byte) (Timestamp, error) {
+	if f.fail > 0 {
+		f.fail--
+		return Timestamp{}, errors.New("authority unreachable")
+	}
+	return f.Timestamper.Timestamp(ctx, hash)
+}
+
+func TestAuditAnchorer(t *testing.T) {
+	tsa := fakeTSA(t, nil)
+	defer tsa.Close()
+	var logBuf, anchorBuf bytes.Buffer
+	l := NewAuditLog(&logBuf)
+	ts := &failingTimestamper{Timestamper: &RFC3161Timestamper{URL: tsa.URL}, fail: 1}
+	var errs int
+	a := NewAuditAnchorer(l, ts, &anchorBuf, AnchorConfig{OnError: func(error) { errs++ }})
+	ctx := context.Background()
+
+	if _, ok, err := a.Anchor(ctx); ok || err != nil {
+		t.Errorf("anchoring an empty log: %v, %v", ok, err)
+	}
+	l.Record([32]byte{1}, []Result{{KeyID: gophertags.KeyID{1}, Matched: true}, {KeyID: gophertags.KeyID{2}}})
+	if _, ok, err := a.Anchor(ctx); ok || err == nil {
+		t.Errorf("anchoring with a failing authority: %v, %v", ok, err)
+	}
+	anchor, ok, err := a.Anchor(ctx)
+	if err != nil || !ok || anchor.Seq != 2 || anchor.Hash != l.Head().Hash {
+		t.Fatalf("Anchor = %+v, %v, %v", anchor, ok, err)
+	}
+	if _, ok, _ := a.Anchor(ctx); ok {
+		t.Error("anchored an unchanged head twice")
+	}
+	l.Record([32]byte{2}, []Result{{KeyID: gophertags.KeyID{1}}})
+
+	// Run anchors the new head at once, then waits for the interval.
+	ctx, cancel := context.WithCancel(ctx)
+	a.config.OnAnchor = func(AuditAnchor) { cancel() }
+	if err := a.Run(ctx); err != context.Canceled || errs != 0 {
+		t.Errorf("Run = %v after %d errors", err, errs)
+	}
+	l.Record([32]byte{3}, []Result{{KeyID: gophertags.KeyID{3}}})
+
+	anchors, err := VerifyAuditAnchors(bytes.NewReader(logBuf.Bytes()), bytes.NewReader(anchorBuf.Bytes()))
+	if err != nil || len(anchors) != 2 || anchors[0].Seq != 2 || anchors[1].Seq != 3 || !anchors[1].Time.Equal(tsaTime) {
+		t.Fatalf("VerifyAuditAnchors = %+v, %v", anchors, err)
+	}
+	if _, err := CheckRFC3161Token(anchors[1].Token, anchors[1].Hash); err != nil {
+		t.Errorf("anchor's token doesn't check: %v", err)
+	}
+
+	// A log rewritten after it was anchored no longer matches its anchors.
+	lines := strings.SplitAfter(logBuf.String(), "\n")
+	var rewrittenBuf bytes.Buffer
+	NewAuditLog(&rewrittenBuf).Record([32]byte{1}, []Result{{KeyID: gophertags.KeyID{1}}, {KeyID: gophertags.KeyID{2}}})
+	for name, log := range map[string]string{
+		"truncated": lines[0],
+		"rewritten": rewrittenBuf.String() + strings.Join(lines[2:], ""),
+	} {
+		if _, err := VerifyAuditAnchors(strings.NewReader(log), bytes.NewReader(anchorBuf.Bytes())); !errors.Is(err, ErrAuditAnchor) && !errors.Is(err, ErrAuditChain) {
+			t.Errorf("%s log: %v", name, err)
+		}
+	}
+	anchorLines := strings.SplitAfter(anchorBuf.String(), "\n")
+	if _, err := VerifyAuditAnchors(bytes.NewReader(logBuf.Bytes()), strings.NewReader(anchorLines[1]+anchorLines[0])); !errors.Is(err, ErrAuditAnchor) {
+		t.Errorf("reordered anchors: %v", err)
+	}
+}
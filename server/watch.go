@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// ErrWatchOverflow is returned by Subscription.Err when the subscriber fell
+// too far behind and was dropped. Resume with a match query after the last
+// message received.
+var ErrWatchOverflow = errors.New("server: match subscriber fell behind")
+
+// Watchers fans out new matches to live subscribers, so clients can be told
+// of messages as they are stored instead of polling. Set Config.Watchers to
+// have a Server publish to it. It is safe for concurrent use.
+type Watchers struct {
+	mu   sync.Mutex
+	subs map[gophertags.KeyID]map[*Subscription]struct{}
+}
+
+// NewWatchers returns an empty set of watchers.
+func NewWatchers() *Watchers {
+	return &Watchers{subs: make(map[gophertags.KeyID]map[*Subscription]struct{})}
+}
+
+// Subscription receives the messages matching one key as they are stored.
+type Subscription struct {
+	// C delivers messages, oldest first. It is closed when the subscription
+	// ends, after which Err reports why.
+	C <-chan mailbox.Message
+
+	c       chan mailbox.Message
+	key     gophertags.KeyID
+	parent  *Watchers
+	err     error
+	stopped bool
+}
+
+const defaultWatchBuffer = 64
+
+// Subscribe starts delivering the key's new matches. Up to buffer messages,
+// 64 if zero, are held for a slow subscriber; if it falls further behind, the
+// subscription ends with ErrWatchOverflow rather than block the server.
+func (w *Watchers) Subscribe(key gophertags.KeyID, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = defaultWatchBuffer
+	}
+	c := make(chan mailbox.Message, buffer)
+	sub := &Subscription{C: c, c: c, key: key, parent: w}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subs[key] == nil {
+		w.subs[key] = make(map[*Subscription]struct{})
+	}
+	w.subs[key][sub] = struct{}{}
+	return sub
+}
+
+// Publish delivers a stored message to the subscribers of each key it matched.
+// Publish messages in the order the store assigned their IDs; Follow relies
+// on it.
+func (w *Watchers) Publish(msg mailbox.Message, matches []gophertags.KeyID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range matches {
+		for sub := range w.subs[key] {
+			select {
+			case sub.c <- msg:
+			default:
+				w.stop(sub, ErrWatchOverflow)
+			}
+		}
+	}
+}
+
+// WatchStats describes the live subscriptions.
+type WatchStats struct {
+	Keys          int // watched keys
+	Subscriptions int
+	Buffered      int // messages delivered but not yet received
+}
+
+// Stats returns counts of the current subscriptions.
+func (w *Watchers) Stats() WatchStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := WatchStats{Keys: len(w.subs)}
+	for _, subs := range w.subs {
+		for sub := range subs {
+			stats.Subscriptions++
+			stats.Buffered += len(sub.c)
+		}
+	}
+	return stats
+}
+
+// stop ends a subscription. The caller holds w.mu.
+func (w *Watchers) stop(sub *Subscription, err error) {
+	if sub.stopped {
+		return
+	}
+	sub.stopped, sub.err = true, err
+	close(sub.c)
+	delete(w.subs[sub.key], sub)
+	if len(w.subs[sub.key]) == 0 {
+		delete(w.subs, sub.key)
+	}
+}
+
+// Close ends the subscription. C is closed and Err returns nil.
+func (s *Subscription) Close() {
+	s.parent.mu.Lock()
+	defer s.parent.mu.Unlock()
+	s.parent.stop(s, nil)
+}
+
+// Err returns why the subscription ended, or nil if it was closed or hasn't
+// ended.
+func (s *Subscription) Err() error {
+	s.parent.mu.Lock()
+	defer s.parent.mu.Unlock()
+	return s.err
+}
+
+// Follow calls send with each message matching key that has an ID greater
+// than cursor, first from the store and then live from w, until ctx is done,
+// send fails, or the subscription overflows. It subscribes before reading the
+// store, so no message is missed between the two, nor delivered twice, as
+// long as messages are published in ID order, as a Server publishes them.
+func (w *Watchers) Follow(ctx context.Context, store mailbox.Store, key gophertags.KeyID, cursor uint64, send func(mailbox.Message) error) error {
+	sub := w.Subscribe(key, 0)
+	defer sub.Close()
+	for {
+		page, err := store.MatchesSince(ctx, key, cursor, maxMatchesLimit)
+		if err != nil {
+			return err
+		}
+		for _, msg := range page {
+			if err := send(msg); err != nil {
+				return err
+			}
+			cursor = msg.ID
+		}
+		if len(page) < maxMatchesLimit {
+			break
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-sub.C:
+			if !ok {
+				return sub.Err()
+			}
+			if msg.ID <= cursor {
+				continue // already sent from the store
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+			cursor = msg.ID
+		}
+	}
+}
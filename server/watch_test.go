@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+func TestWatchers(t *testing.T) {
+	w := NewWatchers()
+	alice, bob := gophertags.KeyID{1}, gophertags.KeyID{2}
+	sub := w.Subscribe(alice, 2)
+
+	w.Publish(mailbox.Message{ID: 1}, []gophertags.KeyID{bob})
+	w.Publish(mailbox.Message{ID: 2}, []gophertags.KeyID{alice, bob})
+	if msg := <-sub.C; msg.ID != 2 {
+		t.Errorf("got message %d, want 2", msg.ID)
+	}
+
+	// A subscriber that falls behind is dropped.
+	for id := uint64(3); id <= 5; id++ {
+		w.Publish(mailbox.Message{ID: id}, []gophertags.KeyID{alice})
+	}
+	var got []uint64
+	for msg := range sub.C {
+		got = append(got, msg.ID)
+	}
+	if len(got) != 2 || sub.Err() != ErrWatchOverflow {
+		t.Errorf("overflowing subscriber got %v, err %v", got, sub.Err())
+	}
+	sub.Close() // no-op after overflow
+
+	sub = w.Subscribe(alice, 0)
+	sub.Close()
+	if _, ok := <-sub.C; ok || sub.Err() != nil {
+		t.Errorf("closed subscription: open %v, err %v", ok, sub.Err())
+	}
+	w.Publish(mailbox.Message{ID: 6}, []gophertags.KeyID{alice})
+	if len(w.subs) != 0 {
+		t.Errorf("closed subscriptions still registered: %v", w.subs)
+	}
+}
+
+func TestServerPublishesMatches(t *testing.T) {
+	watchers := NewWatchers()
+	s := New(Config{Watchers: watchers})
+	sk := gophertags.NewSecretKey(16)
+	id := s.Detector().Add(sk.ExtractDetectionKey(16))
+	sub := watchers.Subscribe(id, 0)
+	defer sub.Close()
+
+	submit(t, s, sk.PublicKey().GenerateFlag(), "live")
+	msg := <-sub.C
+	if string(msg.Payload) != "live" || msg.ID == 0 || msg.Received.IsZero() {
+		t.Errorf("published message %+v", msg)
+	}
+	stored, _ := s.store.Matches(context.Background(), id)
+	if len(stored) != 1 || !stored[0].Received.Equal(msg.Received) {
+		t.Errorf("stored %+v, published %+v", stored, msg)
+	}
+}
+
+// slowPutStore widens the window between a message being stored and the
+// server publishing it, so concurrent submissions finish out of ID order.
+type slowPutStore struct {
+	mailbox.Store
+}
+
+func (s slowPutStore) Put(ctx context.Context, msg mailbox.Message, matches []gophertags.KeyID) (uint64, error) {
+	id, err := s.Store.Put(ctx, msg, matches)
+	time.Sleep(time.Duration(id%3) * time.Millisecond)
+	return id, err
+}
+
+func TestFollowConcurrentSubmissions(t *testing.T) {
+	watchers := NewWatchers()
+	store := slowPutStore{mailbox.NewMemoryStore()}
+	s := New(Config{Store: store, Watchers: watchers})
+	sk := gophertags.NewSecretKey(16)
+	key := s.Detector().Add(sk.ExtractDetectionKey(16))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan uint64, 64)
+	go watchers.Follow(ctx, store, key, 0, func(msg mailbox.Message) error {
+		got <- msg.ID
+		return nil
+	})
+	for watchers.Stats().Subscriptions == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	const n = 50
+	ids := make([]uint64, n)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := submit(t, s, sk.PublicKey().GenerateFlag(), "")
+			var resp messageResponse
+			if w.Code != http.StatusAccepted || json.NewDecoder(w.Body).Decode(&resp) != nil {
+				t.Errorf("submitting message: %d %s", w.Code, w.Body)
+			}
+			ids[i] = resp.ID
+		}(i)
+	}
+	wg.Wait()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, want := range ids {
+		select {
+		case id := <-got:
+			if id != want {
+				t.Fatalf("followed message %d, want %d", id, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d never followed", want)
+		}
+	}
+}
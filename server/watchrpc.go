@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// ErrInsufficientScope is returned by WatchService.WatchMatches when the
+// caller's API key lacks ScopeRead.
+var ErrInsufficientScope = errors.New("server: API key lacks the scope for this request")
+
+// WatchMatchesRequest asks for the matches of a key after a cursor.
+type WatchMatchesRequest struct {
+	// Caller is what the caller's API token grants, as looked up by the RPC
+	// glue with APIKeys.Lookup. Callers without a valid token pass the zero
+	// APIKey, which grants nothing.
+	Caller APIKey
+	KeyID  gophertags.KeyID
+	After  uint64 // message ID; zero means from the beginning
+}
+
+// MatchEvent is a matched message sent on a watch stream.
+type MatchEvent struct {
+	ID       uint64
+	Flag     []byte
+	Payload  []byte
+	Received time.Time
+}
+
+// MatchEventStream is the server side of a WatchMatches stream. It has the
+// method set of a gRPC ServerStream specialized to MatchEvent.
+type MatchEventStream interface {
+	Context() context.Context
+	Send(*MatchEvent) error
+}
+
+// WatchService implements the server-streaming WatchMatches RPC defined in
+// proto/watch.proto, which pushes each match to the client as the flag is
+// tested instead of making it poll. Like packages stream/kafka and
+// stream/redis, it doesn't depend on a gRPC implementation: a few lines of
+// glue adapt it to stubs generated by protoc-gen-go-grpc.
+//
+//	func (g glue) WatchMatches(req *pb.WatchMatchesRequest, stream pb.Matches_WatchMatchesServer) error {
+//		var id gophertags.KeyID
+//		if len(req.KeyId) != len(id) {
+//			return status.Error(codes.InvalidArgument, "bad key ID")
+//		}
+//		copy(id[:], req.KeyId)
+//		caller, _ := g.apiKeys.Lookup(tokenFromMetadata(stream.Context()))
+//		err := g.svc.WatchMatches(&server.WatchMatchesRequest{Caller: caller, KeyID: id, After: req.After}, adapter{stream})
+//		switch err {
+//		case server.ErrInsufficientScope:
+//			return status.Error(codes.PermissionDenied, err.Error())
+//		case server.ErrUnknownKey:
+//			return status.Error(codes.NotFound, err.Error())
+//		case server.ErrWatchOverflow:
+//			return status.Error(codes.ResourceExhausted, err.Error())
+//		}
+//		return err
+//	}
+//
+// where adapter's Send converts MatchEvents to pb.MatchEvents.
+//
+// Watches are authorized like Server's match queries: with a registry, only
+// the tenant that registered a key may watch it.
+type WatchService struct {
+	store    mailbox.Store
+	watchers *Watchers
+	registry *Registry
+}
+
+// NewWatchService returns a service streaming matches from store and, as they
+// arrive, from watchers, which should be the Watchers of the Server filling
+// store. If registry, which should be the Server's Registry, is nil, anyone
+// who knows a key ID may watch it, as on a Server without API keys.
+func NewWatchService(store mailbox.Store, watchers *Watchers, registry *Registry) *WatchService {
+	return &WatchService{store: store, watchers: watchers, registry: registry}
+}
+
+// WatchMatches sends the key's stored matches after req.After, then new
+// matches as they arrive, until the stream's context is done or sending
+// fails. With a registry, it returns ErrInsufficientScope if req.Caller lacks
+// ScopeRead, and ErrUnknownKey if the key is unknown or belongs to another
+// tenant, without telling the two apart, so tenants can't probe which keys
+// are registered. It returns ErrWatchOverflow if the client reads too slowly
+// to keep up; the client should reconnect with After set to the last ID it
+// received.
+func (s *WatchService) WatchMatches(req *WatchMatchesRequest, stream MatchEventStream) error {
+	if s.registry != nil {
+		if req.Caller.Scopes&ScopeRead == 0 {
+			return ErrInsufficientScope
+		}
+		if tenant, ok := s.registry.Tenant(req.KeyID); !ok || tenant != req.Caller.Tenant {
+			return ErrUnknownKey
+		}
+	}
+	return s.watchers.Follow(stream.Context(), s.store, req.KeyID, req.After, func(msg mailbox.Message) error {
+		return stream.Send(&MatchEvent{ID: msg.ID, Flag: msg.Flag, Payload: msg.Payload, Received: msg.Received})
+	})
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+type fakeMatchStream struct {
+	ctx    context.Context
+	events chan *MatchEvent
+}
+
+func (s *fakeMatchStream) Context() context.Context { return s.ctx }
+
+func (s *fakeMatchStream) Send(e *MatchEvent) error {
+	s.events <- e
+	return nil
+}
+
+func TestWatchService(t *testing.T) {
+	store, watchers := mailbox.NewMemoryStore(), NewWatchers()
+	svc := NewWatchService(store, watchers, nil)
+	key := gophertags.KeyID{1}
+	put := func(payload string) uint64 {
+		id, _ := store.Put(context.Background(), mailbox.Message{Payload: []byte(payload)}, []gophertags.KeyID{key})
+		return id
+	}
+	first := put("old")
+	put("stored")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &fakeMatchStream{ctx: ctx, events: make(chan *MatchEvent, 8)}
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.WatchMatches(&WatchMatchesRequest{KeyID: key, After: first}, stream)
+	}()
+
+	if e := <-stream.events; string(e.Payload) != "stored" {
+		t.Errorf("replayed %q, want the stored message after the cursor", e.Payload)
+	}
+	// The watch subscribed before replaying, so this arrives live.
+	id := put("live")
+	watchers.Publish(mailbox.Message{ID: id, Payload: []byte("live")}, []gophertags.KeyID{key})
+	if e := <-stream.events; e.ID != id || string(e.Payload) != "live" {
+		t.Errorf("live event %+v", e)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("WatchMatches after cancel = %v", err)
+	}
+	select {
+	case e := <-stream.events:
+		t.Errorf("duplicate event %+v", e)
+	default:
+	}
+}
+
+func TestWatchServiceAuthorization(t *testing.T) {
+	watchers := NewWatchers()
+	s := New(Config{Watchers: watchers, APIKeys: NewAPIKeys()})
+	svc := NewWatchService(s.store, watchers, s.Registry())
+	sk := gophertags.NewSecretKey(16)
+	key, err := s.Registry().Add("alice", sk.ExtractDetectionKey(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.store.Put(context.Background(), mailbox.Message{Payload: []byte("for alice")}, []gophertags.KeyID{key})
+
+	for _, tt := range []struct {
+		caller APIKey
+		key    gophertags.KeyID
+		want   error
+	}{
+		{APIKey{}, key, ErrInsufficientScope},
+		{APIKey{Tenant: "alice", Scopes: ScopeSubmit | ScopeRegister}, key, ErrInsufficientScope},
+		{APIKey{Tenant: "bob", Scopes: ScopeRead}, key, ErrUnknownKey},
+		{APIKey{Tenant: "alice", Scopes: ScopeRead}, gophertags.KeyID{1}, ErrUnknownKey},
+	} {
+		stream := &fakeMatchStream{ctx: context.Background(), events: make(chan *MatchEvent, 1)}
+		if err := svc.WatchMatches(&WatchMatchesRequest{Caller: tt.caller, KeyID: tt.key}, stream); err != tt.want {
+			t.Errorf("%+v watching %v: got %v, want %v", tt.caller, tt.key, err, tt.want)
+		}
+		if len(stream.events) != 0 {
+			t.Errorf("%+v watching %v was sent %q", tt.caller, tt.key, (<-stream.events).Payload)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &fakeMatchStream{ctx: ctx, events: make(chan *MatchEvent, 1)}
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.WatchMatches(&WatchMatchesRequest{Caller: APIKey{Tenant: "alice", Scopes: ScopeRead}, KeyID: key}, stream)
+	}()
+	if e := <-stream.events; string(e.Payload) != "for alice" {
+		t.Errorf("owner was sent %q", e.Payload)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("WatchMatches after cancel = %v", err)
+	}
+}
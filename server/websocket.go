@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// The watch endpoint pushes match notifications to browsers over a
+// WebSocket (RFC 6455), of which the server side needs only a few frame
+// types, so it is implemented here rather than pulled in as a dependency:
+//
+//	GET /v1/watch?key=<KeyID>&after=<ID>
+//
+// Each text message is {"id": <ID>} for a message matching the key, oldest
+// first, starting after the given ID. IDs are the sequence numbers of the
+// stream: to resume after a disconnect, reconnect with after set to the last
+// ID received. Fetch the messages themselves with /v1/matches. A client that
+// reads too slowly is disconnected with close code 1008. Browsers, which
+// can't set an Authorization header on the handshake, may pass an API token
+// as the access_token parameter.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+	wsMaxControl   = 125 // bytes in a control frame's payload
+)
+
+type watchEvent struct {
+	ID uint64 `json:"id"`
+}
+
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	key, ok := s.authorize(w, r, ScopeRead)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	id, err := parseKeyID(q.Get("key"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.owns(w, key, id) {
+		return
+	}
+	var cursor uint64
+	if after := q.Get("after"); after != "" {
+		if cursor, err = strconv.ParseUint(after, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "after must be a message ID")
+			return
+		}
+	}
+	ws, err := acceptWebSocket(w, r)
+	if err != nil {
+		return // acceptWebSocket has responded
+	}
+	defer ws.conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		ws.readLoop()
+		cancel()
+	}()
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if ws.writeFrame(wsPing, nil) != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = s.watchers.Follow(ctx, s.store, id, cursor, func(msg mailbox.Message) error {
+		event, _ := json.Marshal(watchEvent{ID: msg.ID})
+		return ws.writeFrame(wsText, event)
+	})
+	switch {
+	case err == ErrWatchOverflow:
+		ws.writeClose(1008, "fell behind; reconnect with after")
+	case ctx.Err() == nil:
+		ws.writeClose(1011, "internal error")
+	}
+}
+
+// webSocket is the server end of an accepted WebSocket connection.
+type webSocket struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu     sync.Mutex // serializes writes
+	closed bool       // a close frame has been sent
+}
+
+// acceptWebSocket completes the opening handshake and takes over the
+// connection. On failure it has already written an error response.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*webSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		writeError(w, http.StatusBadRequest, "WebSocket upgrade required")
+		return nil, errors.New("not a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusBadRequest, "unsupported WebSocket version")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "connection can't be upgraded")
+		return nil, errors.New("response writer can't hijack")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	rw.WriteString(base64.StdEncoding.EncodeToString(digest[:]))
+	rw.WriteString("\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{}) // the server's timeouts are for requests
+	return &webSocket{conn: conn, r: rw.Reader}, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame sends an unfragmented, unmasked frame, as servers must.
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, byte(n>>8), byte(n))
+	default:
+		header[1] = 127
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		header = append(header, b[:]...)
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	if opcode == wsClose {
+		ws.closed = true
+	}
+	return nil
+}
+
+func (ws *webSocket) writeClose(code uint16, reason string) {
+	payload := []byte{byte(code >> 8), byte(code)}
+	ws.writeFrame(wsClose, append(payload, reason...))
+}
+
+// readLoop reads the client's frames, answering pings and discarding data,
+// until the client closes the connection or breaks the protocol.
+func (ws *webSocket) readLoop() {
+	for {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			ws.writeClose(1002, "protocol error")
+			return
+		}
+		switch opcode {
+		case wsClose:
+			ws.writeClose(1000, "")
+			return
+		case wsPing:
+			ws.writeFrame(wsPong, payload)
+		}
+	}
+}
+
+// readFrame reads one masked client frame. Data frames are discarded rather
+// than buffered, since the endpoint doesn't take input.
+func (ws *webSocket) readFrame() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(ws.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(ws.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	if n > 1<<63-1 {
+		return 0, nil, errors.New("oversized frame")
+	}
+	if opcode&0x8 == 0 {
+		_, err := io.CopyN(ioutil.Discard, ws.r, int64(n))
+		return opcode, nil, err
+	}
+	if n > wsMaxControl {
+		return 0, nil, errors.New("oversized control frame")
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(ws.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
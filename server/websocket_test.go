@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/mailbox"
+)
+
+// dialWatch opens a WebSocket to the server's watch endpoint.
+func dialWatch(t *testing.T, ts *httptest.Server, query string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET /v1/watch?%s HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", query)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sample key and accept value from RFC 6455, section 1.3.
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %s %v", resp.Status, resp.Header)
+	}
+	return conn, r
+}
+
+// readServerFrame reads one unmasked frame with a short payload.
+func readServerFrame(t *testing.T, r *bufio.Reader) (opcode byte, payload []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	payload = make([]byte, header[1]&0x7F)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+// writeClientFrame writes a masked frame with a short payload.
+func writeClientFrame(conn net.Conn, opcode byte, payload []byte) {
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	for i, b := range payload {
+		frame = append(frame, b^frame[2+i%4])
+	}
+	conn.Write(frame)
+}
+
+func TestWebSocketWatch(t *testing.T) {
+	watchers := NewWatchers()
+	store := mailbox.NewMemoryStore()
+	s := New(Config{Store: store, Watchers: watchers})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	sk := gophertags.NewSecretKey(16)
+	id := s.Detector().Add(sk.ExtractDetectionKey(16))
+	first, _ := store.Put(context.Background(), mailbox.Message{}, []gophertags.KeyID{id})
+	second, _ := store.Put(context.Background(), mailbox.Message{}, []gophertags.KeyID{id})
+
+	conn, r := dialWatch(t, ts, fmt.Sprintf("key=%v&after=%d", id, first))
+	event := func() uint64 {
+		t.Helper()
+		opcode, payload := readServerFrame(t, r)
+		var e watchEvent
+		if opcode != wsText || json.Unmarshal(payload, &e) != nil {
+			t.Fatalf("frame %d %q", opcode, payload)
+		}
+		return e.ID
+	}
+	if got := event(); got != second {
+		t.Errorf("replayed ID %d, want %d", got, second)
+	}
+	submit(t, s, sk.PublicKey().GenerateFlag(), "")
+	if got := event(); got != second+1 {
+		t.Errorf("live ID %d, want %d", got, second+1)
+	}
+
+	writeClientFrame(conn, wsPing, []byte("hi"))
+	if opcode, payload := readServerFrame(t, r); opcode != wsPong || string(payload) != "hi" {
+		t.Errorf("ping answered with %d %q", opcode, payload)
+	}
+	writeClientFrame(conn, wsClose, []byte{0x03, 0xE8})
+	if opcode, payload := readServerFrame(t, r); opcode != wsClose || binary.BigEndian.Uint16(payload) != 1000 {
+		t.Errorf("close answered with %d %q", opcode, payload)
+	}
+}
+
+// TestWebSocketWatchConcurrentPosts checks that a watcher is told of every
+// message when submissions race, so resuming after the last ID loses none.
+func TestWebSocketWatchConcurrentPosts(t *testing.T) {
+	s := New(Config{Store: slowPutStore{mailbox.NewMemoryStore()}, Watchers: NewWatchers()})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	sk := gophertags.NewSecretKey(16)
+	key := s.Detector().Add(sk.ExtractDetectionKey(16))
+	conn, r := dialWatch(t, ts, fmt.Sprintf("key=%v", key))
+
+	const n = 40
+	ids := make([]uint64, n)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, _ := json.Marshal(messageRequest{Flag: sk.PublicKey().GenerateFlag().Encode(nil)})
+			resp, err := http.Post(ts.URL+"/v1/messages", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			var m messageResponse
+			if resp.StatusCode != http.StatusAccepted || json.NewDecoder(resp.Body).Decode(&m) != nil {
+				t.Errorf("submitting message: %s", resp.Status)
+			}
+			ids[i] = m.ID
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, want := range ids {
+		opcode, payload := readServerFrame(t, r)
+		var e watchEvent
+		if opcode != wsText || json.Unmarshal(payload, &e) != nil {
+			t.Fatalf("frame %d %q", opcode, payload)
+		}
+		if e.ID != want {
+			t.Fatalf("watched message %d, want %d", e.ID, want)
+		}
+	}
+}
+
+func TestWebSocketWatchRejectsPlainRequests(t *testing.T) {
+	s := New(Config{Watchers: NewWatchers()})
+	if w := do(t, s, http.MethodGet, "/v1/watch?key="+gophertags.KeyID{}.String(), nil); w.Code != http.StatusBadRequest {
+		t.Errorf("plain GET: %d", w.Code)
+	}
+	if w := do(t, New(Config{}), http.MethodGet, "/v1/watch?key="+gophertags.KeyID{}.String(), nil); w.Code != http.StatusNotFound {
+		t.Errorf("watch without Watchers: %d", w.Code)
+	}
+}
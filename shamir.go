@@ -0,0 +1,166 @@
+package gophertags
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Secret keys are split with Shamir's scheme over GF(2^8), applied bytewise to
+// the key's encoding followed by a short checksum. Each share is
+//
+//	x (1 byte, nonzero) || k (1 byte) || f_1(x) || ... || f_L(x)
+//
+// where f_j is a random polynomial of degree k-1 whose constant term is byte j
+// of the protected payload.
+
+// ErrShares is returned when shares are malformed, inconsistent, or too few to
+// recover the secret key.
+var ErrShares = errors.New("gophertags: invalid or insufficient secret key shares")
+
+const (
+	shareHeaderSize = 2
+	checksumSize    = 8
+	checksumLabel   = "gophertags shamir checksum"
+)
+
+// Split divides the secret key into n shares, any k of which recover it with
+// CombineSecretKey. Fewer than k shares reveal nothing about the key.
+// It requires 1 <= k <= n <= 255.
+func (sk *SecretKey) Split(k, n int) ([][]byte, error) {
+	if k < 1 || n < k || n > 255 {
+		return nil, errors.New("gophertags: Split requires 1 <= k <= n <= 255")
+	}
+
+	payload := sk.Encode(nil)
+	payload = append(payload, shareChecksum(payload)...)
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, shareHeaderSize, shareHeaderSize+len(payload))
+		shares[i][0] = byte(i + 1)
+		shares[i][1] = byte(k)
+	}
+
+	coefficients := make([]byte, k)
+	for _, secret := range payload {
+		coefficients[0] = secret
+		if _, err := io.ReadFull(randReader, coefficients[1:]); err != nil {
+			return nil, entropyError(err)
+		}
+		for i := range shares {
+			shares[i] = append(shares[i], gfEvaluate(coefficients, byte(i+1)))
+		}
+	}
+	return shares, nil
+}
+
+// CombineSecretKey recovers a secret key from at least k of the shares produced
+// by SecretKey.Split. It returns ErrShares if the shares don't reconstruct a key.
+func CombineSecretKey(shares [][]byte) (*SecretKey, error) {
+	if len(shares) == 0 || len(shares[0]) <= shareHeaderSize+checksumSize {
+		return nil, ErrShares
+	}
+	k, length := int(shares[0][1]), len(shares[0])
+	if k == 0 || len(shares) < k {
+		return nil, ErrShares
+	}
+
+	// Any k distinct shares determine the polynomials; extra ones are ignored.
+	xs := make([]byte, 0, k)
+	used := make([][]byte, 0, k)
+	for _, share := range shares {
+		if len(share) != length || int(share[1]) != k || share[0] == 0 {
+			return nil, ErrShares
+		}
+		if bytes.IndexByte(xs, share[0]) >= 0 {
+			return nil, ErrShares
+		}
+		xs = append(xs, share[0])
+		used = append(used, share)
+		if len(used) == k {
+			break
+		}
+	}
+
+	payload := make([]byte, length-shareHeaderSize)
+	ys := make([]byte, k)
+	for j := range payload {
+		for i, share := range used {
+			ys[i] = share[shareHeaderSize+j]
+		}
+		payload[j] = gfInterpolateAtZero(xs, ys)
+	}
+
+	encoded, checksum := payload[:len(payload)-checksumSize], payload[len(payload)-checksumSize:]
+	if subtle.ConstantTimeCompare(checksum, shareChecksum(encoded)) != 1 {
+		return nil, ErrShares
+	}
+	sk := new(SecretKey)
+	if err := sk.Decode(encoded); err != nil {
+		return nil, ErrShares
+	}
+	return sk, nil
+}
+
+func shareChecksum(encoded []byte) []byte {
+	digest := sha3.New256()
+	digest.Write([]byte(checksumLabel))
+	digest.Write(encoded)
+	return digest.Sum(nil)[:checksumSize]
+}
+
+// Arithmetic in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+// Secret bytes pass through gfMul, so it avoids lookup tables and branches.
+
+// gfMul multiplies in GF(2^8) without secret-dependent branches or lookups.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		carry := -(a >> 7)
+		a = (a << 1) ^ (carry & 0x1b)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInverse returns a^-1 = a^254 for nonzero a.
+func gfInverse(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 7; i++ {
+		a = gfMul(a, a)
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+// gfEvaluate evaluates the polynomial with the given coefficients (constant term first) at x.
+func gfEvaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+	return y
+}
+
+// gfInterpolateAtZero returns f(0) for the unique polynomial of degree
+// len(xs)-1 through the points (xs[i], ys[i]).
+func gfInterpolateAtZero(xs, ys []byte) byte {
+	var result byte
+	for i := range xs {
+		// Lagrange basis polynomial at zero: prod_{j != i} x_j / (x_j - x_i).
+		// Subtraction is XOR in characteristic 2.
+		basis := byte(1)
+		for j := range xs {
+			if i != j {
+				basis = gfMul(basis, gfMul(xs[j], gfInverse(xs[j]^xs[i])))
+			}
+		}
+		result ^= gfMul(ys[i], basis)
+	}
+	return result
+}
@@ -0,0 +1,65 @@
+package gophertags
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShamirRoundTrip(t *testing.T) {
+	sk := NewSecretKeyWithHash(24, BLAKE2b)
+	shares, err := sk.Split(3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var picked [][]byte
+		for _, i := range subset {
+			picked = append(picked, shares[i])
+		}
+		recovered, err := CombineSecretKey(picked)
+		if err != nil {
+			t.Fatalf("shares %v: %v", subset, err)
+		}
+		if !bytes.Equal(recovered.Encode(nil), sk.Encode(nil)) {
+			t.Errorf("shares %v recovered a different key", subset)
+		}
+	}
+}
+
+func TestShamirRejectsBadShares(t *testing.T) {
+	shares, err := NewSecretKey(8).Split(3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CombineSecretKey(shares[:2]); err != ErrShares {
+		t.Errorf("two of three shares: got %v", err)
+	}
+	if _, err := CombineSecretKey([][]byte{shares[0], shares[0], shares[1]}); err != ErrShares {
+		t.Errorf("duplicated share: got %v", err)
+	}
+
+	tampered := append([]byte{}, shares[2]...)
+	tampered[10] ^= 1
+	if _, err := CombineSecretKey([][]byte{shares[0], shares[1], tampered}); err != ErrShares {
+		t.Errorf("tampered share: got %v", err)
+	}
+
+	other, _ := NewSecretKey(8).Split(3, 5)
+	if _, err := CombineSecretKey([][]byte{shares[0], shares[1], other[2]}); err != ErrShares {
+		t.Errorf("shares of different keys: got %v", err)
+	}
+}
+
+func TestGF256(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInverse(byte(a))) != 1 {
+			t.Fatalf("%#x * %#x^-1 != 1", a, a)
+		}
+	}
+	// 0x53 * 0xca = 0x01 is the worked example from FIPS-197.
+	if gfMul(0x53, 0xca) != 0x01 || gfMul(0x57, 0x83) != 0xc1 {
+		t.Error("gfMul disagrees with FIPS-197")
+	}
+}
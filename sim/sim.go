@@ -0,0 +1,133 @@
+// Package sim simulates fuzzy tag deployments: it generates a population of
+// recipients and a stream of traffic, runs real detection over it, and reports
+// how matches are distributed and what detection cost the server. It is meant
+// for evaluating parameter choices before deployment, not for production use.
+package sim
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/gtank/gophertags"
+)
+
+// Config describes a simulated workload.
+type Config struct {
+	// Recipients is the number of recipients, each registering one detection key.
+	Recipients int
+
+	// Gamma is the size of every recipient's public key. Zero means 24.
+	Gamma int
+
+	// Precision returns the detection key precision recipient i registers.
+	// Nil means every recipient registers a key of precision Gamma/3.
+	Precision func(i int) int
+
+	// Messages is the number of messages sent.
+	Messages int
+
+	// Pick chooses the recipient of each message. Nil means uniformly at random.
+	Pick func(rng *rand.Rand) int
+
+	// Seed makes the population and the choice of recipients reproducible.
+	// Flags themselves are always freshly random.
+	Seed int64
+}
+
+// RecipientReport is what the server saw of one recipient.
+type RecipientReport struct {
+	KeyID     gophertags.KeyID
+	Precision int
+	Sent      int // messages truly addressed to the recipient
+	True      int // of those, how many matched; always Sent, as the scheme has no false negatives
+	False     int // matches on other recipients' messages
+}
+
+// ObservedRate is the fraction of other recipients' messages that matched.
+func (r RecipientReport) ObservedRate(messages int) float64 {
+	if others := messages - r.Sent; others > 0 {
+		return float64(r.False) / float64(others)
+	}
+	return 0
+}
+
+// Report is the outcome of a simulation.
+type Report struct {
+	Recipients []RecipientReport
+	Messages   int
+
+	Tests        int           // DetectionKey.Test calls the server made
+	DetectTime   time.Duration // time spent in those calls
+	GenerateTime time.Duration // time senders spent generating flags
+}
+
+// TestsPerSecond is the server's detection throughput during the simulation.
+func (r *Report) TestsPerSecond() float64 {
+	if r.DetectTime <= 0 {
+		return 0
+	}
+	return float64(r.Tests) / r.DetectTime.Seconds()
+}
+
+// Run generates the workload and tests every message against every key.
+func Run(config Config) (*Report, error) {
+	if config.Recipients < 1 || config.Messages < 0 {
+		return nil, errors.New("sim: need at least one recipient and a non-negative message count")
+	}
+	if config.Gamma == 0 {
+		config.Gamma = 24
+	}
+	if config.Precision == nil {
+		gamma := config.Gamma
+		config.Precision = func(int) int { return gamma / 3 }
+	}
+	rng := rand.New(rand.NewSource(config.Seed))
+	if config.Pick == nil {
+		n := config.Recipients
+		config.Pick = func(rng *rand.Rand) int { return rng.Intn(n) }
+	}
+
+	report := &Report{Recipients: make([]RecipientReport, config.Recipients), Messages: config.Messages}
+	pks := make([]*gophertags.PublicKey, config.Recipients)
+	dks := make([]*gophertags.DetectionKey, config.Recipients)
+	seed := make([]byte, gophertags.SeedSize)
+	for i := range pks {
+		n := config.Precision(i)
+		if n < 0 || n > config.Gamma {
+			return nil, errors.New("sim: precision out of range")
+		}
+		rng.Read(seed)
+		sk := gophertags.NewSecretKeyFromSeed(config.Gamma, seed)
+		pks[i], dks[i] = sk.PublicKey(), sk.ExtractDetectionKey(n)
+		report.Recipients[i].KeyID = pks[i].KeyID()
+		report.Recipients[i].Precision = n
+	}
+
+	for m := 0; m < config.Messages; m++ {
+		to := config.Pick(rng)
+		if to < 0 || to >= config.Recipients {
+			return nil, errors.New("sim: Pick returned an invalid recipient")
+		}
+		report.Recipients[to].Sent++
+
+		start := time.Now()
+		flag := pks[to].GenerateFlag()
+		report.GenerateTime += time.Since(start)
+
+		start = time.Now()
+		for i, dk := range dks {
+			if !dk.Test(flag) {
+				continue
+			}
+			if i == to {
+				report.Recipients[i].True++
+			} else {
+				report.Recipients[i].False++
+			}
+		}
+		report.DetectTime += time.Since(start)
+		report.Tests += len(dks)
+	}
+	return report, nil
+}
@@ -0,0 +1,54 @@
+package sim
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	report, err := Run(Config{
+		Recipients: 4,
+		Gamma:      8,
+		Precision:  func(i int) int { return i },
+		Messages:   64,
+		Seed:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tests != 4*64 {
+		t.Errorf("%d tests, want %d", report.Tests, 4*64)
+	}
+	sent := 0
+	for i, r := range report.Recipients {
+		sent += r.Sent
+		if r.True != r.Sent {
+			t.Errorf("recipient %d: %d of %d messages matched", i, r.True, r.Sent)
+		}
+		if r.Precision == 0 && r.False != 64-r.Sent {
+			t.Errorf("precision 0 key matched %d of %d other messages", r.False, 64-r.Sent)
+		}
+	}
+	if sent != 64 {
+		t.Errorf("%d messages sent, want 64", sent)
+	}
+
+	again, _ := Run(Config{Recipients: 4, Gamma: 8, Precision: func(i int) int { return i }, Messages: 64, Seed: 1})
+	for i := range report.Recipients {
+		if again.Recipients[i].KeyID != report.Recipients[i].KeyID || again.Recipients[i].Sent != report.Recipients[i].Sent {
+			t.Fatal("runs with the same seed differ in population or traffic")
+		}
+	}
+}
+
+func TestRunRejectsBadConfig(t *testing.T) {
+	if _, err := Run(Config{}); err == nil {
+		t.Error("no recipients accepted")
+	}
+	if _, err := Run(Config{Recipients: 1, Gamma: 8, Precision: func(int) int { return 9 }}); err == nil {
+		t.Error("precision above gamma accepted")
+	}
+	if _, err := Run(Config{Recipients: 1, Messages: 1, Pick: func(*rand.Rand) int { return 1 }}); err == nil {
+		t.Error("out-of-range recipient accepted")
+	}
+}
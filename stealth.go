@@ -0,0 +1,86 @@
+package gophertags
+
+import (
+	"io"
+
+	r255 "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/sha3"
+)
+
+// Stealth addresses give a wallet a fresh one-time address for every
+// incoming payment or contact, unlinkable to its published keys. A wallet
+// publishes a view key V = v·B and a spend key S = s·B. The sender reuses the
+// flag's ephemeral randomness r, so the flag's u = r·B doubles as the
+// stealth ephemeral key, and derives
+//
+//	t = FromUniformBytes(SHA3-512("gophertags stealth" || r·V || u))
+//	P = t·B + S
+//
+// The mailbox detects the flag as usual. The wallet recomputes t from v·u,
+// and only it can spend from P, with the one-time secret t + s. Reusing r is
+// safe because each use hashes a Diffie-Hellman value with a different key
+// under its own label.
+
+const stealthLabel = "gophertags stealth"
+
+// StealthSecretKey is a wallet's view and spend secrets.
+type StealthSecretKey struct {
+	view, spend *r255.Scalar
+}
+
+// StealthPublicKey is a wallet's published view and spend keys.
+type StealthPublicKey struct {
+	View, Spend *r255.Element
+}
+
+// NewStealthSecretKey generates a stealth secret key.
+func NewStealthSecretKey() *StealthSecretKey {
+	return newStealthSecretKey(randReader)
+}
+
+func newStealthSecretKey(entropy io.Reader) *StealthSecretKey {
+	randBytes := make([]byte, 128)
+	if _, err := io.ReadFull(entropy, randBytes); err != nil {
+		panic(entropyError(err))
+	}
+	return &StealthSecretKey{
+		view:  r255.NewScalar().FromUniformBytes(randBytes[:64]),
+		spend: r255.NewScalar().FromUniformBytes(randBytes[64:]),
+	}
+}
+
+// PublicKey returns the wallet's public keys.
+func (k *StealthSecretKey) PublicKey() *StealthPublicKey {
+	return &StealthPublicKey{
+		View:  r255.NewElement().ScalarBaseMult(k.view),
+		Spend: r255.NewElement().ScalarBaseMult(k.spend),
+	}
+}
+
+// GenerateStealthFlag generates a flag for pk and, from the same randomness,
+// a one-time address P for the wallet with public keys to.
+func (pk *PublicKey) GenerateStealthFlag(to *StealthPublicKey) (f *Flag, address *r255.Element) {
+	r, z := sampleFlagScalars(randReader)
+	u := r255.NewElement().ScalarBaseMult(r)
+	t := stealthScalar(r255.NewElement().ScalarMult(r, to.View), u)
+	address = r255.NewElement().ScalarBaseMult(t)
+	address.Add(address, to.Spend)
+	return pk.generateFlagFrom(r, z, nil, nil), address
+}
+
+// Receive returns the one-time address a stealth flag pays to, and the secret
+// key that spends from it.
+func (k *StealthSecretKey) Receive(f *Flag) (address *r255.Element, secret *r255.Scalar) {
+	t := stealthScalar(r255.NewElement().ScalarMult(k.view, f.u), f.u)
+	secret = r255.NewScalar().Add(t, k.spend)
+	return r255.NewElement().ScalarBaseMult(secret), secret
+}
+
+func stealthScalar(shared, u *r255.Element) *r255.Scalar {
+	input := make([]byte, 0, len(stealthLabel)+2*elementSize)
+	input = append(input, stealthLabel...)
+	input = shared.Encode(input)
+	input = u.Encode(input)
+	digest := sha3.Sum512(input)
+	return r255.NewScalar().FromUniformBytes(digest[:])
+}
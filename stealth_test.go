@@ -0,0 +1,36 @@
+package gophertags
+
+import (
+	"testing"
+
+	r255 "github.com/gtank/ristretto255"
+)
+
+func TestStealthFlag(t *testing.T) {
+	sk := NewSecretKey(16)
+	wallet := NewStealthSecretKey()
+
+	f, address := sk.PublicKey().GenerateStealthFlag(wallet.PublicKey())
+	if !sk.ExtractDetectionKey(16).Test(f) {
+		t.Fatal("stealth flag doesn't match the recipient's detection key")
+	}
+	got, secret := wallet.Receive(f)
+	if got.Equal(address) != 1 {
+		t.Fatal("wallet derived a different one-time address")
+	}
+	if r255.NewElement().ScalarBaseMult(secret).Equal(address) != 1 {
+		t.Error("one-time secret doesn't spend from the address")
+	}
+
+	// Addresses are one-time, and other wallets can't find them.
+	_, again := sk.PublicKey().GenerateStealthFlag(wallet.PublicKey())
+	if again.Equal(address) == 1 {
+		t.Error("two payments share a one-time address")
+	}
+	if other, _ := NewStealthSecretKey().Receive(f); other.Equal(address) == 1 {
+		t.Error("another wallet derived the address")
+	}
+	if address.Equal(wallet.PublicKey().Spend) == 1 {
+		t.Error("one-time address is the published spend key")
+	}
+}
@@ -0,0 +1,126 @@
+// Package kafka runs fuzzy detection over Kafka topics: a Consumer reads
+// flag-bearing records, tests them against every registered detection key,
+// and writes a match record to an output topic for each hit.
+//
+// The package doesn't speak the Kafka protocol itself. Reader and Writer are
+// small interfaces modeled on github.com/segmentio/kafka-go's Reader and
+// Writer, which a few lines of glue adapt to that or any other client.
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/server"
+)
+
+// Header is a record header.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a Kafka record.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+}
+
+// Reader fetches records from a consumer group without committing them.
+type Reader interface {
+	FetchMessage(ctx context.Context) (Message, error)
+	CommitMessages(ctx context.Context, msgs ...Message) error
+}
+
+// Writer produces records.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...Message) error
+}
+
+// SourceHeader names the header a match record uses to identify the input
+// record it came from, as "topic/partition/offset". Delivery is at least
+// once, so consumers of the output topic can use it to drop duplicates.
+const SourceHeader = "gophertags-source"
+
+// Consumer moves records from a Reader through a detector to a Writer.
+type Consumer struct {
+	Reader   Reader
+	Writer   Writer
+	Detector *server.MultiDetector
+
+	// OutputTopic is the topic match records are written to. Each has the
+	// recipient's KeyID as its key, so one recipient's matches stay in order
+	// on one partition, and the input record's value as its value.
+	OutputTopic string
+
+	// Flag extracts the encoded flag from a record. Nil means the record's value.
+	Flag func(Message) ([]byte, error)
+
+	// OnError, if set, is called with records that can't be decoded. They
+	// are committed and skipped either way, so they don't block the partition.
+	OnError func(Message, error)
+}
+
+// Run processes records until ctx is done or the Reader or Writer fails.
+//
+// A record is committed only after all of its match records are written, so
+// a crash between the two redelivers it: every match is written at least
+// once, possibly more.
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		m, err := c.Reader.FetchMessage(ctx)
+		if err != nil {
+			return err
+		}
+		if err := c.Process(ctx, m); err != nil {
+			return err
+		}
+	}
+}
+
+// Process handles one record: it writes its match records, then commits it.
+func (c *Consumer) Process(ctx context.Context, m Message) error {
+	matches, err := c.match(m)
+	if err != nil {
+		if c.OnError != nil {
+			c.OnError(m, err)
+		}
+	} else if len(matches) > 0 {
+		if err := c.Writer.WriteMessages(ctx, matches...); err != nil {
+			return err
+		}
+	}
+	return c.Reader.CommitMessages(ctx, m)
+}
+
+func (c *Consumer) match(m Message) ([]Message, error) {
+	encoded := m.Value
+	if c.Flag != nil {
+		var err error
+		if encoded, err = c.Flag(m); err != nil {
+			return nil, err
+		}
+	}
+	f := new(gophertags.Flag)
+	if err := f.Decode(encoded); err != nil {
+		return nil, err
+	}
+
+	source := []byte(m.Topic + "/" + strconv.Itoa(m.Partition) + "/" + strconv.FormatInt(m.Offset, 10))
+	var out []Message
+	for _, id := range c.Detector.Match(f) {
+		key := id
+		out = append(out, Message{
+			Topic:   c.OutputTopic,
+			Key:     key[:],
+			Value:   m.Value,
+			Headers: []Header{{Key: SourceHeader, Value: source}},
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/server"
+)
+
+type fakeReader struct {
+	pending   []Message
+	committed []int64
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (Message, error) {
+	if len(r.pending) == 0 {
+		return Message{}, io.EOF
+	}
+	m := r.pending[0]
+	r.pending = r.pending[1:]
+	return m, nil
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...Message) error {
+	for _, m := range msgs {
+		r.committed = append(r.committed, m.Offset)
+	}
+	return nil
+}
+
+type fakeWriter struct {
+	written []Message
+	fail    bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...Message) error {
+	if w.fail {
+		return errors.New("broker down")
+	}
+	w.written = append(w.written, msgs...)
+	return nil
+}
+
+func TestConsumer(t *testing.T) {
+	alice, bob := gophertags.NewSecretKey(16), gophertags.NewSecretKey(16)
+	d := server.NewMultiDetector()
+	aliceID := d.Add(alice.ExtractDetectionKey(16))
+
+	forAlice := alice.PublicKey().GenerateFlag().Encode(nil)
+	r := &fakeReader{pending: []Message{
+		{Topic: "in", Partition: 3, Offset: 10, Value: forAlice},
+		{Topic: "in", Partition: 3, Offset: 11, Value: []byte("garbage")},
+		{Topic: "in", Partition: 3, Offset: 12, Value: bob.PublicKey().GenerateFlag().Encode(nil)},
+	}}
+	w := &fakeWriter{}
+	var bad int
+	c := &Consumer{Reader: r, Writer: w, Detector: d, OutputTopic: "matches", OnError: func(Message, error) { bad++ }}
+
+	if err := c.Run(context.Background()); err != io.EOF {
+		t.Fatalf("Run returned %v", err)
+	}
+	if len(r.committed) != 3 || bad != 1 {
+		t.Errorf("committed %v with %d bad records", r.committed, bad)
+	}
+	if len(w.written) != 1 {
+		t.Fatalf("wrote %d match records, want 1", len(w.written))
+	}
+	m := w.written[0]
+	if m.Topic != "matches" || !bytes.Equal(m.Key, aliceID[:]) || !bytes.Equal(m.Value, forAlice) {
+		t.Errorf("match record %+v", m)
+	}
+	if len(m.Headers) != 1 || m.Headers[0].Key != SourceHeader || string(m.Headers[0].Value) != "in/3/10" {
+		t.Errorf("match record headers %+v", m.Headers)
+	}
+}
+
+func TestConsumerDoesNotCommitUnwrittenMatches(t *testing.T) {
+	sk := gophertags.NewSecretKey(8)
+	d := server.NewMultiDetector()
+	d.Add(sk.ExtractDetectionKey(8))
+
+	r := &fakeReader{pending: []Message{{Offset: 1, Value: sk.PublicKey().GenerateFlag().Encode(nil)}}}
+	c := &Consumer{Reader: r, Writer: &fakeWriter{fail: true}, Detector: d}
+	if err := c.Run(context.Background()); err == nil || err == io.EOF {
+		t.Errorf("Run returned %v, want the write error", err)
+	}
+	if len(r.committed) != 0 {
+		t.Error("record committed although its match wasn't written")
+	}
+}
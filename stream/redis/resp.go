@@ -0,0 +1,171 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Conn is a minimal Redis client speaking RESP2 over a single connection,
+// enough to run a Worker without pulling in a full client library. It is safe
+// for concurrent use, though commands are serialized.
+type Conn struct {
+	mu sync.Mutex
+	c  net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+// Dial connects to the Redis server at addr.
+func Dial(ctx context.Context, addr string) (*Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c), nil
+}
+
+// NewConn returns a client using an established connection, such as one
+// wrapped in TLS.
+func NewConn(c net.Conn) *Conn {
+	return &Conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.c.Close()
+}
+
+// Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Do sends a command and returns its reply: a string for simple strings, an
+// int64, a []byte or nil for bulk strings, or a []interface{} or nil for
+// arrays. Error replies are returned as an Error. The context's deadline, if
+// any, bounds the whole exchange.
+func (c *Conn) Do(ctx context.Context, args ...[]byte) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n", len(arg))
+		c.w.Write(arg)
+		c.w.WriteString("\r\n")
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// BLPop implements Client.
+func (c *Conn) BLPop(ctx context.Context, key string, timeout time.Duration) ([]byte, error) {
+	seconds := strconv.FormatFloat(timeout.Seconds(), 'f', 3, 64)
+	// The server answers no later than the timeout; leave it some slack.
+	ctx, cancel := context.WithTimeout(ctx, timeout+5*time.Second)
+	defer cancel()
+	reply, err := c.Do(ctx, []byte("BLPOP"), []byte(key), []byte(seconds))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	pair, ok := reply.([]interface{})
+	if !ok || len(pair) != 2 {
+		return nil, errors.New("redis: unexpected BLPOP reply")
+	}
+	value, ok := pair[1].([]byte)
+	if !ok {
+		return nil, errors.New("redis: unexpected BLPOP reply")
+	}
+	return value, nil
+}
+
+// Publish implements Client.
+func (c *Conn) Publish(ctx context.Context, channel string, message []byte) error {
+	_, err := c.Do(ctx, []byte("PUBLISH"), []byte(channel), message)
+	return err
+}
+
+// RPush appends values to the list at key.
+func (c *Conn) RPush(ctx context.Context, key string, values ...[]byte) error {
+	args := append([][]byte{[]byte("RPUSH"), []byte(key)}, values...)
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// maxBulkSize bounds the replies readReply accepts, matching Redis's own limit.
+const maxBulkSize = 512 << 20
+
+// maxArrayPrealloc bounds the space readReply reserves for an array before
+// reading its elements, so a bad length can't exhaust memory on its own.
+const maxArrayPrealloc = 1024
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 || n > maxBulkSize {
+			return nil, errors.New("redis: malformed bulk length")
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 || n > maxBulkSize {
+			return nil, errors.New("redis: malformed array length")
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		prealloc := n
+		if prealloc > maxArrayPrealloc {
+			prealloc = maxArrayPrealloc
+		}
+		out := make([]interface{}, 0, prealloc)
+		for i := 0; i < n; i++ {
+			elem, err := readReply(r)
+			if err != nil {
+				if _, isReply := err.(Error); !isReply {
+					return nil, err
+				}
+				elem = err
+			}
+			out = append(out, elem)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
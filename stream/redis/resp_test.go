@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer answers each command read from c with the next canned reply and
+// records the commands it saw.
+func fakeServer(t *testing.T, c net.Conn, replies []string, commands chan<- []string) {
+	r := bufio.NewReader(c)
+	for _, reply := range replies {
+		v, err := readReply(r)
+		if err != nil {
+			t.Errorf("server reading command: %v", err)
+			return
+		}
+		var command []string
+		for _, arg := range v.([]interface{}) {
+			command = append(command, string(arg.([]byte)))
+		}
+		commands <- command
+		c.Write([]byte(reply))
+	}
+}
+
+func TestConn(t *testing.T) {
+	client, srv := net.Pipe()
+	defer client.Close()
+	commands := make(chan []string, 4)
+	go fakeServer(t, srv, []string{
+		"*2\r\n$5\r\nflags\r\n$3\r\nabc\r\n",
+		"*-1\r\n",
+		":2\r\n",
+		"-ERR wrong type\r\n",
+	}, commands)
+
+	c := NewConn(client)
+	ctx := context.Background()
+
+	item, err := c.BLPop(ctx, "flags", time.Second)
+	if err != nil || string(item) != "abc" {
+		t.Errorf("BLPop = %q, %v", item, err)
+	}
+	if got := <-commands; !reflect.DeepEqual(got, []string{"BLPOP", "flags", "1.000"}) {
+		t.Errorf("sent %q", got)
+	}
+
+	if item, err := c.BLPop(ctx, "flags", time.Second); item != nil || err != nil {
+		t.Errorf("BLPop timeout = %q, %v", item, err)
+	}
+	<-commands
+
+	if err := c.Publish(ctx, "matches:00", []byte("x")); err != nil {
+		t.Errorf("Publish: %v", err)
+	}
+	if got := <-commands; !reflect.DeepEqual(got, []string{"PUBLISH", "matches:00", "x"}) {
+		t.Errorf("sent %q", got)
+	}
+
+	var redisErr Error
+	if err := c.RPush(ctx, "flags", []byte("y")); !errors.As(err, &redisErr) || string(redisErr) != "ERR wrong type" {
+		t.Errorf("error reply: got %v", err)
+	}
+}
+
+func TestReadReplyLengths(t *testing.T) {
+	for _, reply := range []string{
+		"*9223372036854775807\r\n",
+		"*536870913\r\n",
+		"$536870913\r\n",
+		"*-2\r\n",
+	} {
+		if _, err := readReply(bufio.NewReader(strings.NewReader(reply))); err == nil {
+			t.Errorf("readReply(%q) succeeded", reply)
+		}
+	}
+
+	// A large length is accepted but needs the elements to follow.
+	if _, err := readReply(bufio.NewReader(strings.NewReader("*100000000\r\n:1\r\n"))); err != io.EOF {
+		t.Errorf("truncated array: err = %v", err)
+	}
+	v, err := readReply(bufio.NewReader(strings.NewReader("*2\r\n:1\r\n$2\r\nok\r\n")))
+	if err != nil || !reflect.DeepEqual(v, []interface{}{int64(1), []byte("ok")}) {
+		t.Errorf("readReply = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package redis bolts fuzzy detection onto Redis-based message pipelines: a
+// Worker pops serialized flags from a list, tests them against every
+// registered detection key, and publishes each match on a per-recipient
+// channel.
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/server"
+)
+
+// Client is the subset of Redis a Worker uses. Conn implements it; so can a
+// thin wrapper around an existing client library.
+type Client interface {
+	// BLPop pops the first element of the list at key, waiting up to timeout
+	// for one to arrive. It returns nil, nil if the timeout passes.
+	BLPop(ctx context.Context, key string, timeout time.Duration) ([]byte, error)
+	// Publish posts a message on a channel.
+	Publish(ctx context.Context, channel string, message []byte) error
+}
+
+// Worker moves items from a Redis list through a detector.
+type Worker struct {
+	Client   Client
+	Detector *server.MultiDetector
+
+	// Source is the list items are popped from.
+	Source string
+
+	// ChannelPrefix is prepended to a recipient's KeyID, in hex, to form the
+	// channel its matches are published on.
+	ChannelPrefix string
+
+	// Flag extracts the encoded flag from an item. Nil means the whole item
+	// is the flag. Matching items are published unchanged.
+	Flag func(item []byte) ([]byte, error)
+
+	// OnError, if set, is called with items that can't be decoded. They are
+	// dropped either way.
+	OnError func(item []byte, err error)
+
+	// PollInterval bounds how long a pop blocks, and so how quickly Run
+	// notices cancellation. Zero means one second.
+	PollInterval time.Duration
+}
+
+// Channel returns the channel a recipient's matches are published on.
+func (w *Worker) Channel(id gophertags.KeyID) string {
+	return w.ChannelPrefix + id.String()
+}
+
+// Run processes items until ctx is done or Redis returns an error. Items are
+// removed from the list before they are processed, so an item being processed
+// when the worker dies is lost; Redis lists offer no acknowledgements.
+func (w *Worker) Run(ctx context.Context) error {
+	poll := w.PollInterval
+	if poll <= 0 {
+		poll = time.Second
+	}
+	for ctx.Err() == nil {
+		item, err := w.Client.BLPop(ctx, w.Source, poll)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		if item == nil {
+			continue
+		}
+		if err := w.Process(ctx, item); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Process tests one item and publishes it to every matching recipient's channel.
+func (w *Worker) Process(ctx context.Context, item []byte) error {
+	encoded := item
+	if w.Flag != nil {
+		var err error
+		if encoded, err = w.Flag(item); err != nil {
+			w.dropped(item, err)
+			return nil
+		}
+	}
+	f := new(gophertags.Flag)
+	if err := f.Decode(encoded); err != nil {
+		w.dropped(item, err)
+		return nil
+	}
+	for _, id := range w.Detector.Match(f) {
+		if err := w.Client.Publish(ctx, w.Channel(id), item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Worker) dropped(item []byte, err error) {
+	if w.OnError != nil {
+		w.OnError(item, err)
+	}
+}
@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gtank/gophertags"
+	"github.com/gtank/gophertags/server"
+)
+
+type fakeClient struct {
+	mu        sync.Mutex
+	list      [][]byte
+	published map[string][][]byte
+}
+
+func (c *fakeClient) BLPop(ctx context.Context, key string, timeout time.Duration) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.list) == 0 {
+		return nil, nil
+	}
+	item := c.list[0]
+	c.list = c.list[1:]
+	return item, nil
+}
+
+func (c *fakeClient) Publish(ctx context.Context, channel string, message []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published[channel] = append(c.published[channel], message)
+	return nil
+}
+
+func TestWorker(t *testing.T) {
+	alice, bob := gophertags.NewSecretKey(16), gophertags.NewSecretKey(16)
+	d := server.NewMultiDetector()
+	d.Add(alice.ExtractDetectionKey(16))
+	d.Add(bob.ExtractDetectionKey(16))
+
+	forAlice := alice.PublicKey().GenerateFlag().Encode(nil)
+	client := &fakeClient{
+		list:      [][]byte{forAlice, []byte("garbage"), bob.PublicKey().GenerateFlag().Encode(nil)},
+		published: make(map[string][][]byte),
+	}
+	var dropped int
+	w := &Worker{
+		Client:        client,
+		Detector:      d,
+		Source:        "flags",
+		ChannelPrefix: "matches:",
+		OnError:       func([]byte, error) { dropped++ },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	for {
+		client.mu.Lock()
+		empty := len(client.list) == 0
+		client.mu.Unlock()
+		if empty {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v", err)
+	}
+
+	got := client.published[w.Channel(alice.PublicKey().KeyID())]
+	if len(got) != 1 || string(got[0]) != string(forAlice) {
+		t.Errorf("alice's channel got %d messages", len(got))
+	}
+	if len(client.published[w.Channel(bob.PublicKey().KeyID())]) != 1 {
+		t.Error("bob's flag wasn't published to bob's channel")
+	}
+	if dropped != 1 {
+		t.Errorf("%d items dropped, want 1", dropped)
+	}
+}
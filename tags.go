@@ -3,176 +3,461 @@ package gophertags
 
 import (
 	"crypto/rand"
-	"math/big"
-	"math/bits"
+	"io"
+	"sync"
 
 	r255 "github.com/gtank/ristretto255"
 	"golang.org/x/crypto/sha3"
 )
 
+// randReader is the source of randomness for key and flag generation. Tests
+// may replace it with a deterministic stream to make failures reproducible.
+var randReader io.Reader = rand.Reader
+
 // SecretKey is the secret key held by the ultimate recipient of the messages.
 // It is used to derive public keys and detection keys for distribution.
 // Internally, it's a vector of Ristretto255 scalars (the detection key) and Ristretto255 elements (the public key).
 type SecretKey struct {
-	sk []*r255.Scalar
-	pk []*r255.Element
+	sk   []*r255.Scalar
+	pk   []*r255.Element // nil until first needed for lazily generated keys
+	pkMu sync.Mutex      // guards pk
+	params
 }
 
 // PublicKey is the public key that will be used to send messages to the recipient.
 type PublicKey struct {
 	internal []*r255.Element
+	params
 }
 
 // DetectionKey is given to the adversarial mailbox to test inbound messages for a given recipient.
 // Detection keys have an inherent false positive rate set at construction.
 type DetectionKey struct {
 	internal []*r255.Scalar
+	params
+
+	// threshold, if nonzero, makes the last scalar one that is only tested
+	// for flags whose coin is below it. See ExtractDetectionKeyRate.
+	threshold uint64
+
+	// watermark is nil or a mark and its tag. See WatermarkDetectionKey.
+	watermark []byte
 }
 
+// Flag is the ciphertext attached to a message that detection keys are tested against.
 type Flag struct {
 	u           *r255.Element
 	y           *r255.Scalar
-	ciphertexts *big.Int // as bitvec
+	ciphertexts *bitVector // as bitvec
+	gamma       int        // number of meaningful bits in ciphertexts
+	hash        HashScheme // nil means SHA3
+
+	// borrowed holds the ciphertexts instead, packed as in the encoding, for
+	// flags from DecodeFlagBorrowed. It aliases the caller's input.
+	borrowed []byte
+
+	// Decoding and generation cache the encoding of u and the scalar m, which
+	// are otherwise recomputed for every key a flag is tested against. m is
+	// only valid for keys with context mContext and no message binding.
+	uEnc     [elementSize]byte
+	hasUEnc  bool
+	m        r255.Scalar
+	mContext string
+	hasM     bool
+}
+
+// encodedU returns the canonical encoding of u.
+func (f *Flag) encodedU() []byte {
+	if f.hasUEnc {
+		return f.uEnc[:]
+	}
+	return f.u.Encode(nil)
+}
+
+// cache records uEnc, the encoding of u, and the scalar m under p.
+func (f *Flag) cache(p params, uEnc []byte) {
+	copy(f.uEnc[:], uEnc)
+	f.hasUEnc = true
+	f.m = *p.hashFlagToScalar(f, nil)
+	f.mContext, f.hasM = p.context, true
+}
+
+// flagScalar returns the flag's m as computed by keys with params p.
+func (p params) flagScalar(f *Flag, binding []byte) *r255.Scalar {
+	if binding == nil && f.hasM && f.mContext == p.context {
+		return &f.m
+	}
+	return p.hashFlagToScalar(f, binding)
+}
+
+// bit returns ciphertext bit i, which is zero beyond the stored bits.
+func (f *Flag) bit(i int) uint {
+	if f.borrowed == nil {
+		return f.ciphertexts.Bit(i)
+	}
+	if i/8 >= len(f.borrowed) {
+		return 0
+	}
+	return uint(f.borrowed[i/8]>>(i%8)) & 1
+}
+
+// Digest returns a SHA3-256 digest of the flag's encoding, suitable as a
+// compact identifier when deduplicating flags.
+func (f *Flag) Digest() [32]byte {
+	return sha3.Sum256(f.Encode(nil))
 }
 
 // NewSecretKey constructs a secret key with a maximum false positive rate of 2^-gamma.
-func NewSecretKey(gamma int) *SecretKey {
-	key := &SecretKey{
-		sk: make([]*r255.Scalar, gamma),
-		pk: make([]*r255.Element, gamma),
+// For large gamma, the public key's elements are computed on several goroutines.
+// Options select the source of the scalars, the application context, the hash
+// scheme and whether the public key is computed up front. It panics if the
+// source of randomness fails, or if WithSeed is given a seed of the wrong length.
+func NewSecretKey(gamma int, opts ...Option) *SecretKey {
+	o := keyOptions{rand: randReader}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var key *SecretKey
+	if o.seed != nil {
+		key = newLazySecretKeyFromSeed(gamma, o.seed)
+	} else {
+		key = newLazySecretKey(gamma, o.rand)
 	}
+	if !o.lazy {
+		key.publicElements()
+	}
+	key.context = o.context
+	key.hash = o.hash
+	return key
+}
+
+// NewSecretKeyWithContext is like NewSecretKey, but binds the key and everything
+// derived from it to an application context. The context is mixed into every
+// hash, so flags generated under one context never match detection keys under
+// another. The empty context is the default.
+//
+// Contexts are not part of any wire encoding: each application is expected to
+// know its own and reapply it to decoded keys with WithContext.
+//
+// It is NewSecretKey(gamma, WithContext(context)).
+func NewSecretKeyWithContext(gamma int, context string) *SecretKey {
+	return NewSecretKey(gamma, WithContext(context))
+}
+
+// NewSecretKeyWithHash is like NewSecretKey, but instantiates the scheme with the
+// given hash functions instead of SHA3. Keys and flags carry the scheme's ID in
+// their encodings, and flags never match keys of a different scheme.
+//
+// It is NewSecretKey(gamma, WithHash(h)).
+func NewSecretKeyWithHash(gamma int, h HashScheme) *SecretKey {
+	return NewSecretKey(gamma, WithHash(h))
+}
+
+// NewLazySecretKey is like NewSecretKey, but defers the gamma scalar base
+// multiplications that compute the public key until it is first needed, by
+// PublicKey or String. Servers that only extract detection keys never pay for them.
+//
+// It is NewSecretKey(gamma, WithLazyPublicKey()).
+func NewLazySecretKey(gamma int) *SecretKey {
+	return NewSecretKey(gamma, WithLazyPublicKey())
+}
+
+// newSecretKey is NewSecretKey with an explicit source of randomness.
+func newSecretKey(gamma int, entropy io.Reader) *SecretKey {
+	key := newLazySecretKey(gamma, entropy)
+	key.publicElements()
+	return key
+}
 
-	randBytes := make([]byte, 64)
+// newLazySecretKey is NewLazySecretKey with an explicit source of randomness.
+func newLazySecretKey(gamma int, entropy io.Reader) *SecretKey {
+	key := &SecretKey{sk: make([]*r255.Scalar, gamma)}
 
-	for i := 0; i < gamma; i++ {
-		n, err := rand.Read(randBytes)
-		if n != 64 || err != nil {
-			// If you aren't getting randomness, there's no way the rest of this is going to work.
-			// TODO: It would be good to add a function that takes a custom reader for more predictable testing.
-			panic("panic! at the keygen")
-		}
+	// One read for all the scalars, rather than a system call each. The
+	// stream is consumed in the same order either way.
+	randBytes := make([]byte, 64*gamma)
+	if _, err := io.ReadFull(entropy, randBytes); err != nil {
+		// If you aren't getting randomness, there's no way the rest of this is going to work.
+		panic(entropyError(err))
+	}
 
-		key.sk[i] = r255.NewScalar().FromUniformBytes(randBytes)
-		key.pk[i] = r255.NewElement().ScalarBaseMult(key.sk[i])
+	scalars := make([]r255.Scalar, gamma)
+	for i := range key.sk {
+		key.sk[i] = scalars[i].FromUniformBytes(randBytes[64*i : 64*(i+1)])
+	}
+	for i := range randBytes {
+		randBytes[i] = 0
 	}
 
 	return key
 }
 
+// baseMultAll returns x·B for each scalar x, allocating the elements together.
+// ScalarBaseMult already uses a precomputed table of multiples of B, so what
+// remains to save is the per-element allocation and, for large keys, the
+// wall-clock time of computing the elements one after another.
+func baseMultAll(scalars []*r255.Scalar) []*r255.Element {
+	storage := make([]r255.Element, len(scalars))
+	elements := make([]*r255.Element, len(scalars))
+	parallelFor(len(scalars), func(i int) {
+		elements[i] = storage[i].ScalarBaseMult(scalars[i])
+	})
+	return elements
+}
+
+// publicElements returns the public key elements, computing them on first use
+// if the key was generated or decoded lazily. It is safe for concurrent use.
+func (sk *SecretKey) publicElements() []*r255.Element {
+	sk.pkMu.Lock()
+	defer sk.pkMu.Unlock()
+	if sk.pk == nil {
+		sk.pk = baseMultAll(sk.sk)
+	}
+	return sk.pk
+}
+
 // PublicKey returns a deep copy of the secret key's associated public key.
 func (sk *SecretKey) PublicKey() *PublicKey {
-	// Language Wars Episode 2: The Lack of the Clones
-	// TODO: https://github.com/gtank/ristretto255/issues/35
-	pkCopy := make([]*r255.Element, len(sk.pk))
-	for i := 0; i < len(pkCopy); i++ {
-		byteRepr := sk.pk[i].Encode(nil)
-		pkCopy[i] = r255.NewElement()
-		_ = pkCopy[i].Decode(byteRepr)
-	}
-	return &PublicKey{internal: pkCopy}
+	return &PublicKey{internal: cloneElements(sk.publicElements()), params: sk.params}
 }
 
 // ExtractDetectionKey produces a detection key with false positive rate 0 <= 2^-n <= 2^-gamma.
 // Internally, it's a copy of the first n scalars in the secret key.
 func (sk *SecretKey) ExtractDetectionKey(n int) *DetectionKey {
-	secrets := make([]*r255.Scalar, n)
-	for i := 0; i < n; i++ {
-		byteRepr := sk.sk[i].Encode(nil)
-		secrets[i] = r255.NewScalar()
-		_ = secrets[i].Decode(byteRepr)
+	return &DetectionKey{internal: cloneScalars(sk.sk[:n]), params: sk.params}
+}
+
+// Clone returns a deep copy of the secret key. The copy of a lazy key is also lazy.
+func (sk *SecretKey) Clone() *SecretKey {
+	clone := &SecretKey{sk: cloneScalars(sk.sk), params: sk.params}
+	sk.pkMu.Lock()
+	if sk.pk != nil {
+		clone.pk = cloneElements(sk.pk)
 	}
-	return &DetectionKey{internal: secrets}
+	sk.pkMu.Unlock()
+	return clone
 }
 
-// hashG3Bit implements H: G^3 -> {0,1} in a manner consistent with the Rust crate `fuzzytags`
-func hashG3ToBit(rB, rH, zB *r255.Element) uint {
-	digest := sha3.New256()
-	digest.Write(rB.Encode(nil))
-	digest.Write(rH.Encode(nil))
-	digest.Write(zB.Encode(nil))
-	return uint(digest.Sum(nil)[0] & 0x01)
+// Clone returns a deep copy of the public key.
+func (pk *PublicKey) Clone() *PublicKey {
+	return &PublicKey{internal: cloneElements(pk.internal), params: pk.params}
 }
 
-// hashGVecToScalar hashes a Ristretto element and a bit vector of ciphertexts to a
-// Ristretto scalar in a manner consistent with the Rust crate `fuzzytags`.
-func hashGVecToScalar(u *r255.Element, bitVec *big.Int) *r255.Scalar {
-	// TODO: Recall enough big.Int internals to use Bytes() or FillBytes() here?
+// Clone returns a deep copy of the detection key.
+func (dk *DetectionKey) Clone() *DetectionKey {
+	clone := &DetectionKey{internal: cloneScalars(dk.internal), params: dk.params, threshold: dk.threshold}
+	if dk.watermark != nil {
+		clone.watermark = append([]byte(nil), dk.watermark...)
+	}
+	return clone
+}
 
-	// Pack bits into byte slice of necessary size, implicitly zero-padded to nearest byte.
-	byteRepr := make([]byte, 0, bitVec.BitLen()+7/8)
-	for _, word := range bitVec.Bits() {
-		for i := 0; i < bits.UintSize; i += 8 {
-			if len(byteRepr) >= cap(byteRepr) {
-				break
-			}
-			byteRepr = append(byteRepr, byte(word))
-			word >>= 8
-		}
+// Clone returns a deep copy of the flag. The copy never aliases the input of
+// DecodeFlagBorrowed.
+func (f *Flag) Clone() *Flag {
+	u, y := *f.u, *f.y
+	clone := *f
+	clone.u, clone.y, clone.borrowed = &u, &y, nil
+	if f.borrowed != nil {
+		clone.ciphertexts = setBits(new(bitVector), f.borrowed)
+	} else {
+		clone.ciphertexts = new(bitVector).Set(f.ciphertexts)
 	}
+	return &clone
+}
 
-	digest := sha3.Sum512(u.Encode(byteRepr))
-	return r255.NewScalar().FromUniformBytes(digest[:])
+// Elements and scalars are plain values with no internal pointers, so they
+// can be copied by assignment. ristretto255 has no Clone methods of its own
+// (https://github.com/gtank/ristretto255/issues/35); this avoids the
+// encode/decode round trip that used to stand in for them.
+
+func cloneElements(in []*r255.Element) []*r255.Element {
+	values := make([]r255.Element, len(in))
+	out := make([]*r255.Element, len(in))
+	for i, e := range in {
+		values[i] = *e
+		out[i] = &values[i]
+	}
+	return out
+}
+
+func cloneScalars(in []*r255.Scalar) []*r255.Scalar {
+	values := make([]r255.Scalar, len(in))
+	out := make([]*r255.Scalar, len(in))
+	for i, x := range in {
+		values[i] = *x
+		out[i] = &values[i]
+	}
+	return out
+}
+
+// Precision returns n, the number of secret scalars in the detection key.
+func (dk *DetectionKey) Precision() int {
+	return len(dk.internal)
+}
+
+// FalsePositiveRate returns the probability that the detection key matches a
+// flag generated for some other public key: 2^-n, unless the key came from
+// ExtractDetectionKeyRate.
+func (dk *DetectionKey) FalsePositiveRate() float64 {
+	return rate(dk.internal, dk.threshold)
+}
+
+// WithContext returns a copy of the public key bound to the given application context.
+// The copy shares the receiver's (immutable) key material.
+func (pk *PublicKey) WithContext(context string) *PublicKey {
+	p := pk.params
+	p.context = context
+	return &PublicKey{internal: pk.internal, params: p}
+}
+
+// WithContext returns a copy of the detection key bound to the given application context.
+// The copy shares the receiver's (immutable) key material.
+func (dk *DetectionKey) WithContext(context string) *DetectionKey {
+	p := dk.params
+	p.context = context
+	return &DetectionKey{internal: dk.internal, params: p, threshold: dk.threshold, watermark: dk.watermark}
 }
 
 // GenerateFlag creates a randomized flag ciphertext for the given public key.
 func (pk *PublicKey) GenerateFlag() *Flag {
+	return pk.generateFlag(randReader, nil, nil)
+}
+
+// GenerateBoundFlag is like GenerateFlag, but binds the flag to msgHash, a
+// collision-resistant hash of the message it will be attached to. The hash is
+// mixed into the flag's scalar m, so the flag only matches when tested with
+// TestBound and the same hash, and can't be detached and replayed onto
+// another message. msgHash must not be nil.
+func (pk *PublicKey) GenerateBoundFlag(msgHash []byte) *Flag {
+	if msgHash == nil {
+		panic("gophertags: GenerateBoundFlag requires a message hash")
+	}
+	return pk.generateFlag(randReader, msgHash, nil)
+}
+
+// generateFlag is GenerateFlag with an explicit source of randomness, an
+// optional message binding and, if S is not nil, the public key of a
+// designated verifier, which replaces the binding.
+func (pk *PublicKey) generateFlag(entropy io.Reader, binding []byte, S *r255.Element) *Flag {
+	r, z := sampleFlagScalars(entropy)
+	return pk.generateFlagFrom(r, z, binding, S)
+}
+
+// sampleFlagScalars returns the random scalars r and z of a new flag.
+func sampleFlagScalars(entropy io.Reader) (r, z *r255.Scalar) {
 	uniformBytes := make([]byte, 128)
-	_, err := rand.Read(uniformBytes)
+	_, err := io.ReadFull(entropy, uniformBytes)
 	if err != nil {
-		panic("error sampling scalar entropy")
+		panic(entropyError(err))
 	}
+	r = r255.NewScalar().FromUniformBytes(uniformBytes[0:64])
+	z = r255.NewScalar().FromUniformBytes(uniformBytes[64:128])
+	return r, z
+}
 
+// generateFlagFrom is generateFlag with the random scalars already sampled.
+// It overwrites z.
+func (pk *PublicKey) generateFlagFrom(r, z *r255.Scalar, binding []byte, S *r255.Element) *Flag {
 	// Random group elements
-	r := r255.NewScalar().FromUniformBytes(uniformBytes[0:64])
-	z := r255.NewScalar().FromUniformBytes(uniformBytes[64:128])
 	u := r255.NewElement().ScalarBaseMult(r)
 	w := r255.NewElement().ScalarBaseMult(z)
+	if S != nil {
+		binding = designatedBinding(r255.NewElement().ScalarMult(r, S))
+	}
 
-	// TODO need to double check that this actually behaves like I think it does. Specifically check padding.
-	bitVec := new(big.Int)
+	// Bit i of the ciphertexts is bit i of bitVec. Bits at and above gamma
+	// are never set, so the encoding's padding bits are zero, and m hashes
+	// the ciphertexts as encoded.
+	bitVec := new(bitVector)
+	f := &Flag{u: u, ciphertexts: bitVec, gamma: len(pk.internal), hash: pk.hash, hasUEnc: true}
+	u.Encode(f.uEnc[:0])
 
+	h := pk.newBitHasher(f.uEnc[:], w)
+	rH := r255.NewElement()
 	for i, H := range pk.internal {
-		rH := r255.NewElement().ScalarMult(r, H)
-		c := hashG3ToBit(u, rH, w) ^ 0x01
+		c := h.bit(rH.ScalarMult(r, H)) ^ 0x01
 		bitVec.SetBit(bitVec, i, c)
 	}
 
-	m := hashGVecToScalar(u, bitVec)
+	m := pk.hashToScalar(f.uEnc[:], bitVec, f.gamma, binding)
+	if binding == nil {
+		f.m, f.mContext, f.hasM = *m, pk.context, true
+	}
 
 	// y = 1/r * (z - m)
 	y := r255.NewScalar().Invert(r)
 	y.Multiply(y, z.Subtract(z, m)) // smashes z
 
-	return &Flag{u, y, bitVec}
+	f.y = y
+	return f
 }
 
 // Test returns true if the given flag matches the detection key.
 func (dk *DetectionKey) Test(f *Flag) bool {
+	return dk.test(f, nil)
+}
+
+// TestBound is like Test for flags from GenerateBoundFlag. It returns true
+// only if the flag matches and was bound to msgHash; a bound flag tested
+// against any other hash, or with Test, matches no more often than a flag for
+// someone else. msgHash must not be nil.
+func (dk *DetectionKey) TestBound(f *Flag, msgHash []byte) bool {
+	if msgHash == nil {
+		panic("gophertags: TestBound requires a message hash")
+	}
+	return dk.test(f, msgHash)
+}
+
+func (dk *DetectionKey) test(f *Flag, binding []byte) bool {
+	pass, _ := dk.evaluate(f, binding)
+	return pass
+}
+
+// evaluate tests the flag, also returning the points x_i·u for the bits it
+// checked, or nil if the flag was rejected without checking any.
+func (dk *DetectionKey) evaluate(f *Flag, binding []byte) (bool, []*r255.Element) {
 	// Thanks to Lee Bousfield and Sarah Jamie Lewis, without whom I would also
 	// have written a universal tag bug here. See
 	// https://git.openprivacy.ca/openprivacy/fuzzytags/commit/e19b99112e3fe70cb92b09db9595d3e05ef26f7c
 	if f.u.Equal(r255.NewElement()) == 1 || f.y.Equal(r255.NewScalar()) == 1 {
-		return false
+		return false, nil
 	}
 
-	m := hashGVecToScalar(f.u, f.ciphertexts)
+	// Flags from a different instantiation of the scheme can't be for us.
+	if dk.scheme().ID() != schemeOf(f.hash).ID() {
+		return false, nil
+	}
+
+	m := dk.flagScalar(f, binding)
+
+	uEnc := f.encodedU()
+	acc := currentAccelerator()
+	w := acc.MultiScalarMult([]*r255.Scalar{m, f.y}, []*r255.Element{r255.NewElement().Base(), f.u})
 
-	scalars := []*r255.Scalar{m, f.y}
-	elements := []*r255.Element{r255.NewElement().Base(), f.u}
-	w := r255.NewElement().MultiScalarMult(scalars, elements)
+	xs := dk.internal
+	if len(xs) > 0 && !dk.testsLastBit(f) {
+		xs = xs[:len(xs)-1]
+	}
+	xU := make([]*r255.Element, len(xs))
+	values := make([]r255.Element, len(xs))
+	for i := range xU {
+		xU[i] = &values[i]
+	}
+	acc.ScalarMults(xU, xs, f.u)
 
 	var pass uint = 0x01
 
-	for i, x_i := range dk.internal {
-		xU := r255.NewElement().ScalarMult(x_i, f.u)
-		k := hashG3ToBit(f.u, xU, w)
-		b := k ^ f.ciphertexts.Bit(i)
+	h := dk.newBitHasher(uEnc, w)
+	for i := range xs {
+		k := h.bit(xU[i])
+		b := k ^ f.bit(i)
 		pass = pass & b
 	}
 
 	if pass == 0x01 {
-		return true
+		return true, xU
 	} else {
-		return false
+		return false, xU
 	}
 }
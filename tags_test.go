@@ -1,9 +1,10 @@
 package gophertags
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"math"
-	"math/big"
 	"testing"
 	"testing/quick"
 
@@ -35,13 +36,13 @@ func TestUniversalValues(t *testing.T) {
 	zeroFlag := &Flag{
 		u:           ristretto255.NewElement(),
 		y:           ristretto255.NewScalar(),
-		ciphertexts: new(big.Int),
+		ciphertexts: new(bitVector),
 	}
 
 	onesFlag := &Flag{
 		u:           ristretto255.NewElement(),
 		y:           ristretto255.NewScalar(),
-		ciphertexts: new(big.Int).SetUint64((1 << 24) - 1),
+		ciphertexts: onesBits(24),
 	}
 
 	sk := NewSecretKey(24)
@@ -56,25 +57,275 @@ func TestUniversalValues(t *testing.T) {
 	}
 }
 
+// onesBits returns a bit vector with the low n bits set.
+func onesBits(n int) *bitVector {
+	z := new(bitVector)
+	for i := 0; i < n; i++ {
+		z.SetBit(z, i, 1)
+	}
+	return z
+}
+
+func TestLargeGamma(t *testing.T) {
+	for _, gamma := range []int{65, 128, 256} {
+		sk := NewSecretKey(gamma)
+		pk := sk.PublicKey()
+		dk := sk.ExtractDetectionKey(gamma)
+
+		for i := 0; i < 4; i++ {
+			encoded := pk.GenerateFlag().Encode(nil)
+			if want := 1 + 32 + 32 + (gamma+7)/8; len(encoded) != want {
+				t.Fatalf("gamma %d: flag encodes to %d bytes, want %d", gamma, len(encoded), want)
+			}
+			f := new(Flag)
+			if err := f.Decode(encoded); err != nil {
+				t.Fatal(err)
+			}
+			if !dk.Test(f) {
+				t.Errorf("gamma %d: flag not detected at full precision", gamma)
+			}
+
+			// Flipping the highest ciphertext bit, which a packing that
+			// dropped bits past 64 would miss, must break the match.
+			flipped := append([]byte(nil), encoded...)
+			flipped[65+(gamma-1)/8] ^= 1 << ((gamma - 1) % 8)
+			if err := f.Decode(flipped); err != nil {
+				t.Fatal(err)
+			}
+			if dk.Test(f) {
+				t.Errorf("gamma %d: flag with its top bit flipped still detected", gamma)
+			}
+		}
+	}
+}
+
+// These flags control the statistical false positive test, which fails for a
+// correct implementation with probability under 10^-4 for a fresh seed: about
+// 10^-5 from the chi-square test and 4·10^-5 at most from the four interval
+// checks together. With the defaults, a bit that unrelated flags pass with
+// probability 1/4 or 1 instead of 1/2, halving or doubling the rate from that
+// precision on, fails the chi-square test with probability over 0.99 if it is
+// one of the first three bits. At the fourth bit doubling still fails it
+// almost surely, but halving only about two times in three. Raise
+// -fp.trials to catch smaller errors.
+var (
+	falsePositiveTrials = flag.Int("fp.trials", 1000, "number of unrelated flags to test in TestFalsePositives")
+	falsePositiveZ      = flag.Float64("fp.z", 4.5, "width of the TestFalsePositives confidence interval, in standard deviations")
+	falsePositiveSeed   = flag.String("fp.seed", "TestFalsePositives", "seed for the randomness used by TestFalsePositives")
+)
+
+// chiSquare5 is the critical value of the chi-square distribution with five
+// degrees of freedom at significance 10^-5.
+const chiSquare5 = 30.86
+
 func TestFalsePositives(t *testing.T) {
+	// A fixed stream keeps the outcome stable across runs; vary the seed
+	// with -fp.seed to sample fresh keys and flags.
+	withDeterministicRand(t, *falsePositiveSeed)
+
 	gamma := 8
-	numMessages := 1000
+	numMessages := *falsePositiveTrials
 	sk := NewSecretKey(gamma)
-	dsk := sk.ExtractDetectionKey(3)
-	falsePositives := 0
+	otherPK := NewSecretKey(gamma).PublicKey()
 
+	// depth[k] counts unrelated flags matched at precision k but not k+1.
+	// An unrelated flag passes each further bit with probability 1/2, so
+	// depth k has probability 2^-(k+1), and depths are counted once per flag.
+	depth := make([]int, gamma+1)
 	for i := 0; i < numMessages; i++ {
-		sk2 := NewSecretKey(gamma)
-		f := sk2.PublicKey().GenerateFlag()
-		sk2.ExtractDetectionKey(3).Test(f)
+		f := otherPK.GenerateFlag()
+		n := 0
+		for n < gamma && sk.ExtractDetectionKey(n+1).Test(f) {
+			n++
+		}
+		depth[n]++
+	}
+
+	// Pearson's chi-square statistic over depths 0 to 4 and 5 or more.
+	chiSquare := 0.0
+	deep := numMessages
+	for k := 0; k <= 5; k++ {
+		observed, p := deep, math.Exp2(float64(-k))
+		if k < 5 {
+			observed, p = depth[k], math.Exp2(float64(-k-1))
+		}
+		deep -= observed
+		expected := p * float64(numMessages)
+		chiSquare += (float64(observed) - expected) * (float64(observed) - expected) / expected
+	}
+	t.Logf("depths %v, chi-square %.2f", depth, chiSquare)
+	if chiSquare > chiSquare5 {
+		t.Errorf("chi-square %.2f over %d depths exceeds %.2f: false positives aren't halved per bit", chiSquare, 6, chiSquare5)
+	}
 
-		if dsk.Test(f) {
-			falsePositives += 1
+	// The rate at each precision n counts the flags of depth n or more. The
+	// checks share flags, so they aren't independent; their spurious
+	// failure rates only add up to a bound.
+	matched := numMessages
+	for n := 1; n <= 4; n++ {
+		matched -= depth[n-1]
+		expectedRate := math.Exp2(float64(-n))
+		actualRate := float64(matched) / float64(numMessages)
+		low, high := wilsonInterval(matched, numMessages, *falsePositiveZ)
+		t.Logf("n=%d: expected rate %f, actual rate %f, interval [%f, %f]", n, expectedRate, actualRate, low, high)
+		if expectedRate < low || expectedRate > high {
+			t.Errorf("n=%d: false positive rate %f outside [%f, %f], expected %f",
+				n, actualRate, low, high, expectedRate)
 		}
 	}
+}
+
+// wilsonInterval returns the Wilson score interval for a binomial proportion
+// with the given number of successes out of trials, at z standard deviations.
+func wilsonInterval(successes, trials int, z float64) (low, high float64) {
+	n := float64(trials)
+	p := float64(successes) / n
+	z2 := z * z
+
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	halfWidth := z / (1 + z2/n) * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+	return center - halfWidth, center + halfWidth
+}
+
+func TestDetectionKeyRate(t *testing.T) {
+	dk := NewSecretKey(24).ExtractDetectionKey(10)
+	if dk.Precision() != 10 || dk.FalsePositiveRate() != 1.0/1024 {
+		t.Errorf("precision %d, rate %v", dk.Precision(), dk.FalsePositiveRate())
+	}
+}
+
+func TestClone(t *testing.T) {
+	sk := NewSecretKeyWithContext(16, "clone")
+	pk, dk := sk.PublicKey(), sk.ExtractDetectionKey(4)
+	f := pk.GenerateFlag()
+
+	for name, pair := range map[string][2][]byte{
+		"secret key":    {sk.Encode(nil), sk.Clone().Encode(nil)},
+		"public key":    {pk.Encode(nil), pk.Clone().Encode(nil)},
+		"detection key": {dk.Encode(nil), dk.Clone().Encode(nil)},
+		"flag":          {f.Encode(nil), f.Clone().Encode(nil)},
+	} {
+		if !bytes.Equal(pair[0], pair[1]) {
+			t.Errorf("cloned %s encodes differently", name)
+		}
+	}
+	if !sk.Clone().ExtractDetectionKey(4).WithContext("clone").Test(f) || !dk.Clone().Test(f) {
+		t.Error("cloned keys don't detect the flag")
+	}
+
+	// Clones don't share storage with the original.
+	clone := dk.Clone()
+	clone.internal[0].Zero()
+	if dk.internal[0].Equal(ristretto255.NewScalar()) == 1 {
+		t.Error("zeroing a cloned scalar changed the original")
+	}
+	fc := f.Clone()
+	setBits(fc.ciphertexts, nil)
+	if !dk.Test(f) {
+		t.Error("changing a cloned flag changed the original")
+	}
+}
+
+func TestLazySecretKey(t *testing.T) {
+	sk := NewLazySecretKey(16)
+	if sk.pk != nil {
+		t.Fatal("lazy key computed its public key at generation")
+	}
+	f := sk.Clone().PublicKey().GenerateFlag()
+	if sk.pk != nil {
+		t.Error("cloning a lazy key computed the original's public key")
+	}
+	if !sk.ExtractDetectionKey(16).Test(f) {
+		t.Error("lazy key doesn't detect flags for its public key")
+	}
+	if sk.PublicKey().Fingerprint() != sk.Clone().PublicKey().Fingerprint() {
+		t.Error("public key changed between calls")
+	}
+
+	// Decoded keys are lazy too.
+	decoded, err := DecodeSecretKey(sk.Encode(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.pk != nil || decoded.PublicKey().Fingerprint() != sk.PublicKey().Fingerprint() {
+		t.Error("decoded key isn't lazy or has the wrong public key")
+	}
+}
+
+func TestBoundFlag(t *testing.T) {
+	sk := NewSecretKey(24)
+	dk := sk.ExtractDetectionKey(24)
+	msgHash := []byte("message hash")
+
+	f := sk.PublicKey().GenerateBoundFlag(msgHash)
+	if !dk.TestBound(f, msgHash) {
+		t.Fatal("bound flag doesn't match its own message hash")
+	}
+	if dk.TestBound(f, []byte("other message hash")) {
+		t.Error("bound flag matches a different message hash")
+	}
+	if dk.TestBound(f, []byte{}) {
+		t.Error("bound flag matches an empty message hash")
+	}
+	if dk.Test(f) {
+		t.Error("bound flag matches unbound Test")
+	}
+	if dk.TestBound(sk.PublicKey().GenerateFlag(), msgHash) {
+		t.Error("unbound flag matches TestBound")
+	}
 
-	expectedRate := math.Exp2(float64(0 - len(dsk.internal)))
-	actualRate := float64(falsePositives) / float64(numMessages)
+	decoded := new(Flag)
+	if err := decoded.Decode(f.Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if !dk.TestBound(decoded, msgHash) {
+		t.Error("decoded bound flag doesn't match")
+	}
+	borrowed, err := DecodeFlagBorrowed(f.Encode(nil), 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dk.TestBound(borrowed, msgHash) {
+		t.Error("borrowed bound flag doesn't match")
+	}
+}
+
+func TestFlagCache(t *testing.T) {
+	sk := NewSecretKeyWithContext(16, "app")
+	generated := sk.PublicKey().GenerateFlag()
+	decoded := new(Flag)
+	if err := decoded.Decode(generated.Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
 
-	fmt.Printf("Expected rate %f, actual rate %f\n", expectedRate, actualRate)
+	for _, f := range []*Flag{generated, decoded, decoded.Clone()} {
+		if !bytes.Equal(f.encodedU(), f.u.Encode(nil)) {
+			t.Error("cached encoding of u is wrong")
+		}
+		if !f.hasM {
+			t.Fatal("m is not cached")
+		}
+		want := (params{hash: f.hash, context: f.mContext}).hashFlagToScalar(&Flag{u: f.u, ciphertexts: f.ciphertexts, borrowed: f.borrowed, gamma: f.gamma}, nil)
+		if f.m.Equal(want) != 1 {
+			t.Error("cached m is wrong")
+		}
+	}
+	// Decoding doesn't know the context, so the cache must not be used for it.
+	if decoded.mContext != "" || !sk.ExtractDetectionKey(16).Test(decoded) {
+		t.Error("context-bound key doesn't match a decoded flag")
+	}
+	if bound := sk.PublicKey().GenerateBoundFlag([]byte("msg")); bound.hasM {
+		t.Error("m of a bound flag was cached")
+	}
+}
+
+func BenchmarkNewSecretKey(b *testing.B) {
+	for _, gamma := range []int{24, 64} {
+		b.Run(fmt.Sprintf("gamma=%d", gamma), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				NewSecretKey(gamma)
+			}
+		})
+	}
 }
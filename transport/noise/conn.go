@@ -0,0 +1,284 @@
+package noise
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config configures one end of a Noise connection.
+type Config struct {
+	// Pattern is the handshake pattern. The zero value is XX.
+	Pattern Pattern
+
+	// Static is this end's long-term key pair.
+	Static KeyPair
+
+	// RemoteStatic is the server's static public key. Clients must set it
+	// for IK; it is ignored otherwise.
+	RemoteStatic []byte
+
+	// Prologue is data both ends must agree on, such as a protocol version.
+	// It is authenticated by the handshake but not sent.
+	Prologue []byte
+
+	// VerifyPeer, if set, is called with the peer's static public key once
+	// the handshake completes. Returning an error aborts the connection.
+	// Without it any peer is accepted, so servers should use it to check
+	// clients against an allowlist, and XX clients to pin the server's key.
+	VerifyPeer func(remoteStatic []byte) error
+
+	// Rand is the source of ephemeral keys. Nil means crypto/rand.
+	Rand io.Reader
+}
+
+// Conn is a net.Conn secured with Noise. The handshake runs on the first
+// Read or Write, or on an explicit call to Handshake.
+type Conn struct {
+	conn      net.Conn
+	config    *Config
+	initiator bool
+
+	handshakeMu  sync.Mutex
+	handshakeErr error
+	done         bool
+	remoteStatic []byte
+
+	readMu  sync.Mutex
+	in      cipherState
+	pending []byte
+	readBuf []byte
+
+	writeMu  sync.Mutex
+	out      cipherState
+	writeBuf []byte
+}
+
+// Client returns a client-side (initiator) Noise connection over conn.
+func Client(conn net.Conn, config *Config) *Conn {
+	return &Conn{conn: conn, config: config, initiator: true}
+}
+
+// Server returns a server-side (responder) Noise connection over conn.
+func Server(conn net.Conn, config *Config) *Conn {
+	return &Conn{conn: conn, config: config}
+}
+
+// Handshake runs the Noise handshake if it hasn't already run.
+func (c *Conn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	if c.done || c.handshakeErr != nil {
+		return c.handshakeErr
+	}
+	c.handshakeErr = c.handshake()
+	c.done = c.handshakeErr == nil
+	return c.handshakeErr
+}
+
+func (c *Conn) handshake() error {
+	hs, err := newHandshakeState(c.config, c.initiator)
+	if err != nil {
+		return err
+	}
+	writing := c.initiator
+	for len(hs.messages) > 0 {
+		if writing {
+			msg, err := hs.writeMessage(make([]byte, 2, 2+96), nil)
+			if err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint16(msg, uint16(len(msg)-2))
+			if _, err := c.conn.Write(msg); err != nil {
+				return err
+			}
+		} else {
+			msg, err := c.readFrame(nil)
+			if err != nil {
+				return err
+			}
+			if _, err := hs.readMessage(msg); err != nil {
+				return err
+			}
+		}
+		writing = !writing
+	}
+
+	c1, c2 := hs.ss.split()
+	if c.initiator {
+		c.out, c.in = c1, c2
+	} else {
+		c.in, c.out = c1, c2
+	}
+	c.remoteStatic = hs.rs
+	if c.config.VerifyPeer != nil {
+		if err := c.config.VerifyPeer(c.remoteStatic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) readFrame(buf []byte) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(header[:]))
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// RemoteStatic returns the peer's static public key, running the handshake
+// first if necessary.
+func (c *Conn) RemoteStatic() ([]byte, error) {
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), c.remoteStatic...), nil
+}
+
+// Read reads decrypted data from the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.pending) == 0 {
+		frame, err := c.readFrame(c.readBuf)
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf = frame
+		// Decrypt in place; the plaintext is a prefix of the frame.
+		if c.pending, err = c.in.decrypt(frame[:0], nil, frame); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write encrypts and writes data to the connection, splitting it into as many
+// Noise messages as needed.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxMessageSize-tagSize {
+			chunk = chunk[:maxMessageSize-tagSize]
+		}
+		frame, err := c.out.encrypt(append(c.writeBuf[:0], 0, 0), nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		binary.BigEndian.PutUint16(frame, uint16(len(frame)-2))
+		c.writeBuf = frame
+		if _, err := c.conn.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close closes the underlying connection.
+func (c *Conn) Close() error { return c.conn.Close() }
+
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+type listener struct {
+	net.Listener
+	config *Config
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Server(conn, l.config), nil
+}
+
+// NewListener returns a listener whose accepted connections are server-side
+// Noise connections over those accepted by inner.
+func NewListener(inner net.Listener, config *Config) net.Listener {
+	return &listener{Listener: inner, config: config}
+}
+
+// Listen announces on the local network address and accepts Noise connections.
+func Listen(network, address string, config *Config) (net.Listener, error) {
+	if config == nil {
+		return nil, errors.New("noise: Listen requires a config")
+	}
+	inner, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(inner, config), nil
+}
+
+// Dial connects to address and completes a client-side handshake.
+func Dial(network, address string, config *Config) (*Conn, error) {
+	return DialContext(context.Background(), network, address, config)
+}
+
+// DialContext is like Dial, but ctx bounds both connecting and the handshake.
+// Its signature suits http.Transport.DialContext once config is bound:
+//
+//	transport := &http.Transport{
+//		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+//			return noise.DialContext(ctx, network, addr, config)
+//		},
+//	}
+func DialContext(ctx context.Context, network, address string, config *Config) (*Conn, error) {
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		raw.SetDeadline(deadline)
+	}
+	conn := Client(raw, config)
+	errc := make(chan error, 1)
+	go func() { errc <- conn.Handshake() }()
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		raw.Close()
+		<-errc
+		err = ctx.Err()
+	}
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	raw.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,355 @@
+// Package noise secures connections between detection clients and servers
+// with the Noise protocol framework, for deployments that don't want to
+// depend on the web PKI. It implements Noise_XX_25519_ChaChaPoly_SHA256 and
+// Noise_IK_25519_ChaChaPoly_SHA256 without PSKs or fallback.
+//
+// Conn implements net.Conn, so Listen and Dial can carry the HTTP API from
+// package server unchanged:
+//
+//	ln, _ := noise.Listen("tcp", ":7000", &noise.Config{Static: serverKey})
+//	http.Serve(ln, s)
+//
+// Handshake and transport messages are prefixed with a 2-byte big-endian
+// length, as Noise messages are at most 65535 bytes.
+package noise
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Pattern selects a Noise handshake pattern. Both ends must use the same one.
+type Pattern int
+
+const (
+	// XX exchanges static keys during the handshake. Neither side needs to
+	// know the other's key in advance.
+	XX Pattern = iota
+
+	// IK requires the client to know the server's static key, and saves a
+	// round trip by encrypting the first message to it.
+	IK
+)
+
+func (p Pattern) String() string {
+	switch p {
+	case XX:
+		return "XX"
+	case IK:
+		return "IK"
+	}
+	return "unknown"
+}
+
+// KeySize is the size of Curve25519 public and private keys.
+const KeySize = 32
+
+// KeyPair is a Curve25519 key pair.
+type KeyPair struct {
+	Private, Public [KeySize]byte
+}
+
+// GenerateKeyPair returns a new key pair using randomness from r, or from
+// crypto/rand if r is nil.
+func GenerateKeyPair(r io.Reader) (KeyPair, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	var kp KeyPair
+	if _, err := io.ReadFull(r, kp.Private[:]); err != nil {
+		return KeyPair{}, err
+	}
+	pub, err := curve25519.X25519(kp.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	copy(kp.Public[:], pub)
+	return kp, nil
+}
+
+const (
+	maxMessageSize = 65535
+	tagSize        = 16
+	hashSize       = sha256.Size
+)
+
+var (
+	// ErrHandshake is returned when a handshake message is malformed or
+	// fails authentication.
+	ErrHandshake = errors.New("noise: handshake failed")
+
+	// ErrDecrypt is returned when a transport message fails authentication.
+	ErrDecrypt = errors.New("noise: message authentication failed")
+
+	errNonceExhausted = errors.New("noise: nonce space exhausted")
+)
+
+type cipherState struct {
+	aead cipher.AEAD
+	n    uint64
+}
+
+func (cs *cipherState) initializeKey(k []byte) {
+	cs.aead, _ = chacha20poly1305.New(k[:chacha20poly1305.KeySize])
+	cs.n = 0
+}
+
+func (cs *cipherState) nonce() []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], cs.n)
+	return nonce[:]
+}
+
+func (cs *cipherState) encrypt(out, ad, plaintext []byte) ([]byte, error) {
+	if cs.aead == nil {
+		return append(out, plaintext...), nil
+	}
+	if cs.n == ^uint64(0) {
+		return nil, errNonceExhausted
+	}
+	out = cs.aead.Seal(out, cs.nonce(), plaintext, ad)
+	cs.n++
+	return out, nil
+}
+
+func (cs *cipherState) decrypt(out, ad, ciphertext []byte) ([]byte, error) {
+	if cs.aead == nil {
+		return append(out, ciphertext...), nil
+	}
+	if cs.n == ^uint64(0) {
+		return nil, errNonceExhausted
+	}
+	out, err := cs.aead.Open(out, cs.nonce(), ciphertext, ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	cs.n++
+	return out, nil
+}
+
+type symmetricState struct {
+	cs    cipherState
+	ck, h [hashSize]byte
+}
+
+func (ss *symmetricState) initialize(protocolName string) {
+	if len(protocolName) <= hashSize {
+		copy(ss.h[:], protocolName)
+	} else {
+		ss.h = sha256.Sum256([]byte(protocolName))
+	}
+	ss.ck = ss.h
+}
+
+func (ss *symmetricState) mixHash(data []byte) {
+	digest := sha256.New()
+	digest.Write(ss.h[:])
+	digest.Write(data)
+	digest.Sum(ss.h[:0])
+}
+
+func (ss *symmetricState) mixKey(ikm []byte) {
+	ck, k := hkdf(ss.ck[:], ikm)
+	copy(ss.ck[:], ck)
+	ss.cs.initializeKey(k)
+}
+
+func (ss *symmetricState) encryptAndHash(out, plaintext []byte) ([]byte, error) {
+	start := len(out)
+	out, err := ss.cs.encrypt(out, ss.h[:], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	ss.mixHash(out[start:])
+	return out, nil
+}
+
+func (ss *symmetricState) decryptAndHash(out, ciphertext []byte) ([]byte, error) {
+	out, err := ss.cs.decrypt(out, ss.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	ss.mixHash(ciphertext)
+	return out, nil
+}
+
+func (ss *symmetricState) split() (c1, c2 cipherState) {
+	k1, k2 := hkdf(ss.ck[:], nil)
+	c1.initializeKey(k1)
+	c2.initializeKey(k2)
+	return c1, c2
+}
+
+// hkdf is the two-output HKDF from section 4.3 of the Noise specification.
+func hkdf(chainingKey, ikm []byte) (out1, out2 []byte) {
+	mac := hmac.New(sha256.New, chainingKey)
+	mac.Write(ikm)
+	tempKey := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, tempKey)
+	mac.Write([]byte{0x01})
+	out1 = mac.Sum(nil)
+
+	mac.Reset()
+	mac.Write(out1)
+	mac.Write([]byte{0x02})
+	out2 = mac.Sum(nil)
+	return out1, out2
+}
+
+type token int
+
+const (
+	tokenE token = iota
+	tokenS
+	tokenEE
+	tokenES
+	tokenSE
+	tokenSS
+)
+
+var patterns = map[Pattern][][]token{
+	XX: {
+		{tokenE},
+		{tokenE, tokenEE, tokenS, tokenES},
+		{tokenS, tokenSE},
+	},
+	IK: {
+		{tokenE, tokenES, tokenS, tokenSS},
+		{tokenE, tokenEE, tokenSE},
+	},
+}
+
+type handshakeState struct {
+	ss        symmetricState
+	s, e      KeyPair
+	rs, re    []byte
+	initiator bool
+	messages  [][]token
+	rand      io.Reader
+}
+
+func newHandshakeState(config *Config, initiator bool) (*handshakeState, error) {
+	messages, ok := patterns[config.Pattern]
+	if !ok {
+		return nil, errors.New("noise: unknown handshake pattern")
+	}
+	hs := &handshakeState{
+		s:         config.Static,
+		initiator: initiator,
+		messages:  messages,
+		rand:      config.Rand,
+	}
+	hs.ss.initialize("Noise_" + config.Pattern.String() + "_25519_ChaChaPoly_SHA256")
+	hs.ss.mixHash(config.Prologue)
+
+	// IK's pre-message pattern is "<- s": the responder's static key.
+	if config.Pattern == IK {
+		if initiator {
+			if len(config.RemoteStatic) != KeySize {
+				return nil, errors.New("noise: IK requires the responder's static key")
+			}
+			hs.rs = append([]byte(nil), config.RemoteStatic...)
+			hs.ss.mixHash(hs.rs)
+		} else {
+			hs.ss.mixHash(hs.s.Public[:])
+		}
+	}
+	return hs, nil
+}
+
+func (hs *handshakeState) dh(local KeyPair, remote []byte) error {
+	shared, err := curve25519.X25519(local.Private[:], remote)
+	if err != nil {
+		return ErrHandshake
+	}
+	hs.ss.mixKey(shared)
+	return nil
+}
+
+func (hs *handshakeState) mixDH(t token) error {
+	switch {
+	case t == tokenEE:
+		return hs.dh(hs.e, hs.re)
+	case t == tokenSS:
+		return hs.dh(hs.s, hs.rs)
+	case (t == tokenES) == hs.initiator:
+		// es for the initiator, se for the responder.
+		return hs.dh(hs.e, hs.rs)
+	default:
+		return hs.dh(hs.s, hs.re)
+	}
+}
+
+// writeMessage appends the next handshake message, carrying payload, to out.
+func (hs *handshakeState) writeMessage(out, payload []byte) ([]byte, error) {
+	tokens := hs.messages[0]
+	hs.messages = hs.messages[1:]
+	var err error
+	for _, t := range tokens {
+		switch t {
+		case tokenE:
+			if hs.e, err = GenerateKeyPair(hs.rand); err != nil {
+				return nil, err
+			}
+			out = append(out, hs.e.Public[:]...)
+			hs.ss.mixHash(hs.e.Public[:])
+		case tokenS:
+			if out, err = hs.ss.encryptAndHash(out, hs.s.Public[:]); err != nil {
+				return nil, err
+			}
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return hs.ss.encryptAndHash(out, payload)
+}
+
+// readMessage processes the next handshake message, returning its payload.
+func (hs *handshakeState) readMessage(msg []byte) ([]byte, error) {
+	tokens := hs.messages[0]
+	hs.messages = hs.messages[1:]
+	for _, t := range tokens {
+		switch t {
+		case tokenE:
+			if len(msg) < KeySize {
+				return nil, ErrHandshake
+			}
+			hs.re = append([]byte(nil), msg[:KeySize]...)
+			msg = msg[KeySize:]
+			hs.ss.mixHash(hs.re)
+		case tokenS:
+			n := KeySize
+			if hs.ss.cs.aead != nil {
+				n += tagSize
+			}
+			if len(msg) < n {
+				return nil, ErrHandshake
+			}
+			rs, err := hs.ss.decryptAndHash(nil, msg[:n])
+			if err != nil {
+				return nil, ErrHandshake
+			}
+			hs.rs, msg = rs, msg[n:]
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	payload, err := hs.ss.decryptAndHash(nil, msg)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	return payload, nil
+}
@@ -0,0 +1,276 @@
+package noise
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gtank/gophertags/server"
+)
+
+func keyPair(t *testing.T) KeyPair {
+	t.Helper()
+	kp, err := GenerateKeyPair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+// pair runs a handshake between a client and server over a pipe.
+func pair(t *testing.T, clientConfig, serverConfig *Config) (*Conn, *Conn, error, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	client, srv := Client(a, clientConfig), Server(b, serverConfig)
+	errc := make(chan error, 1)
+	go func() {
+		err := srv.Handshake()
+		if err != nil {
+			b.Close()
+		}
+		errc <- err
+	}()
+	clientErr := client.Handshake()
+	if clientErr != nil {
+		a.Close()
+	}
+	return client, srv, clientErr, <-errc
+}
+
+func TestHandshake(t *testing.T) {
+	for _, pattern := range []Pattern{XX, IK} {
+		t.Run(pattern.String(), func(t *testing.T) {
+			clientKey, serverKey := keyPair(t), keyPair(t)
+			client, srv, cerr, serr := pair(t,
+				&Config{Pattern: pattern, Static: clientKey, RemoteStatic: serverKey.Public[:], Prologue: []byte("v1")},
+				&Config{Pattern: pattern, Static: serverKey, Prologue: []byte("v1")})
+			if cerr != nil || serr != nil {
+				t.Fatalf("handshake: client %v, server %v", cerr, serr)
+			}
+			defer client.Close()
+
+			if rs, _ := client.RemoteStatic(); !bytes.Equal(rs, serverKey.Public[:]) {
+				t.Error("client learned the wrong server key")
+			}
+			if rs, _ := srv.RemoteStatic(); !bytes.Equal(rs, clientKey.Public[:]) {
+				t.Error("server learned the wrong client key")
+			}
+
+			// Larger than one Noise message, so Write has to split it.
+			msg := bytes.Repeat([]byte("flag"), 50000)
+			go func() {
+				client.Write(msg)
+				client.Write([]byte("done"))
+			}()
+			got := make([]byte, len(msg)+4)
+			if _, err := io.ReadFull(srv, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, append(msg, "done"...)) {
+				t.Error("server received corrupted data")
+			}
+		})
+	}
+}
+
+func TestHandshakeFailures(t *testing.T) {
+	clientKey, serverKey, otherKey := keyPair(t), keyPair(t), keyPair(t)
+
+	_, _, cerr, serr := pair(t,
+		&Config{Static: clientKey, Prologue: []byte("v1")},
+		&Config{Static: serverKey, Prologue: []byte("v2")})
+	if cerr == nil && serr == nil {
+		t.Error("handshake succeeded with mismatched prologues")
+	}
+
+	_, _, cerr, serr = pair(t,
+		&Config{Pattern: IK, Static: clientKey, RemoteStatic: otherKey.Public[:]},
+		&Config{Pattern: IK, Static: serverKey})
+	if serr != ErrHandshake {
+		t.Errorf("IK to the wrong server key: server got %v, want ErrHandshake", serr)
+	}
+
+	errDenied := errors.New("denied")
+	_, _, cerr, _ = pair(t,
+		&Config{Static: clientKey, VerifyPeer: func(rs []byte) error {
+			if !bytes.Equal(rs, otherKey.Public[:]) {
+				return errDenied
+			}
+			return nil
+		}},
+		&Config{Static: serverKey})
+	if cerr != errDenied {
+		t.Errorf("pinned server key mismatch: client got %v", cerr)
+	}
+
+	if _, _, cerr, _ = pair(t, &Config{Pattern: IK, Static: clientKey}, &Config{Pattern: IK, Static: serverKey}); cerr == nil {
+		t.Error("IK handshake without the server's key succeeded")
+	}
+}
+
+func TestTamperedMessage(t *testing.T) {
+	a, b := net.Pipe()
+	client, srv := Client(a, &Config{Static: keyPair(t)}), Server(b, &Config{Static: keyPair(t)})
+	go srv.Handshake()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	frame, _ := client.out.encrypt([]byte{0, 0}, nil, []byte("flag"))
+	frame[0], frame[1] = 0, byte(len(frame)-2)
+	frame[len(frame)-1] ^= 1
+	go a.Write(frame)
+	if _, err := srv.Read(make([]byte, 16)); err != ErrDecrypt {
+		t.Errorf("reading tampered message: got %v, want ErrDecrypt", err)
+	}
+}
+
+func TestHTTPOverNoise(t *testing.T) {
+	serverKey := keyPair(t)
+	ln, err := Listen("tcp", "127.0.0.1:0", &Config{Pattern: IK, Static: serverKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: server.New(server.Config{})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	config := &Config{Pattern: IK, Static: keyPair(t), RemoteStatic: serverKey.Public[:]}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return DialContext(ctx, network, addr, config)
+		},
+	}}
+	resp, err := client.Get("http://" + ln.Addr().String() + "/v1/matches?key=00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("key")) {
+		t.Errorf("unexpected response %d %s", resp.StatusCode, body)
+	}
+}
+
+// TestVectors checks the handshakes and transport messages against vectors
+// from an independent Noise implementation, in testdata/vectors.txt.
+func TestVectors(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vectors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, block := range strings.Split(string(data), "\n\n") {
+		fields := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(block), "\n") {
+			if kv := strings.SplitN(line, "=", 2); len(kv) == 2 && !strings.HasPrefix(line, "#") {
+				fields[kv[0]] = kv[1]
+			}
+		}
+		if fields["handshake"] == "" {
+			continue
+		}
+		n++
+		name := fmt.Sprintf("%d/%s", n, fields["handshake"])
+		t.Run(name, func(t *testing.T) { testVector(t, fields) })
+	}
+	if n != 8 {
+		t.Errorf("ran %d vectors, want 8", n)
+	}
+}
+
+func testVector(t *testing.T, fields map[string]string) {
+	hexField := func(name string) []byte {
+		t.Helper()
+		b, err := hex.DecodeString(fields[name])
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return b
+	}
+	staticKey := func(name string) KeyPair {
+		t.Helper()
+		kp, err := GenerateKeyPair(bytes.NewReader(hexField(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kp
+	}
+	pattern := XX
+	if fields["handshake"] == "Noise_IK_25519_ChaChaPoly_SHA256" {
+		pattern = IK
+	}
+	initStatic, respStatic := staticKey("init_static"), staticKey("resp_static")
+	initConfig := &Config{Pattern: pattern, Static: initStatic, Prologue: hexField("prologue"),
+		Rand: bytes.NewReader(hexField("gen_init_ephemeral"))}
+	respConfig := &Config{Pattern: pattern, Static: respStatic, Prologue: hexField("prologue"),
+		Rand: bytes.NewReader(hexField("gen_resp_ephemeral"))}
+	if pattern == IK {
+		initConfig.RemoteStatic = respStatic.Public[:]
+	}
+	hsI, err := newHandshakeState(initConfig, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsR, err := newHandshakeState(respConfig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handshake := len(patterns[pattern])
+	// The sending and receiving ends of each direction: initiator to
+	// responder, then back.
+	var send, receive [2]cipherState
+	for i := 0; ; i++ {
+		ciphertext, ok := fields[fmt.Sprintf("msg_%d_ciphertext", i)]
+		if !ok {
+			if i <= handshake {
+				t.Fatalf("vector ends after %d messages", i)
+			}
+			return
+		}
+		payload := hexField(fmt.Sprintf("msg_%d_payload", i))
+		if i >= handshake {
+			d := (i - handshake) % 2
+			msg, err := send[d].encrypt(nil, nil, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(msg); got != ciphertext {
+				t.Fatalf("message %d = %s, want %s", i, got, ciphertext)
+			}
+			if got, err := receive[d].decrypt(nil, nil, msg); err != nil || !bytes.Equal(got, payload) {
+				t.Fatalf("decrypting message %d: %x, %v", i, got, err)
+			}
+			continue
+		}
+		writer, reader := hsI, hsR
+		if i%2 != 0 {
+			writer, reader = hsR, hsI
+		}
+		msg, err := writer.writeMessage(nil, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(msg); got != ciphertext {
+			t.Fatalf("message %d = %s, want %s", i, got, ciphertext)
+		}
+		got, err := reader.readMessage(msg)
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("reading message %d: %x, %v", i, got, err)
+		}
+		if i == handshake-1 {
+			i1, i2 := hsI.ss.split()
+			r1, r2 := hsR.ss.split()
+			send, receive = [2]cipherState{i1, r2}, [2]cipherState{r1, i2}
+		}
+	}
+}
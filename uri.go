@@ -0,0 +1,151 @@
+package gophertags
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Keys can be carried in URIs, for deep links and invites:
+//
+//	gophertags:pk?g=<gamma>&k=<key>[&c=<context>][&p=<path>]
+//	gophertags:dk?n=<precision>&k=<key>[&c=<context>][&p=<path>]
+//
+// where key is the wire encoding in unpadded base64url and context is the
+// application context, which the wire encoding doesn't carry. The wire
+// encoding carries the derivation path, so path is only read, for links
+// written before it did; if both give a path, they must agree. The size
+// parameter is redundant with the key, which lets readers check it before
+// decoding and reject truncated links. Parsers also accept the
+// gophertags://pk?... spelling.
+
+// URIScheme is the scheme of key URIs.
+const URIScheme = "gophertags"
+
+// ErrURI is returned for malformed key URIs.
+var ErrURI = newKindError("gophertags: malformed key URI", ErrInvalidEncoding)
+
+const (
+	uriPublicKey    = "pk"
+	uriDetectionKey = "dk"
+)
+
+func formatURI(kind, sizeParam string, size int, encoded []byte, p params) string {
+	q := url.Values{}
+	q.Set(sizeParam, strconv.Itoa(size))
+	q.Set("k", base64.RawURLEncoding.EncodeToString(encoded))
+	if p.context != "" {
+		q.Set("c", p.context)
+	}
+	return URIScheme + ":" + kind + "?" + q.Encode()
+}
+
+// URI returns a gophertags:pk URI carrying the public key, its context and its path.
+func (pk *PublicKey) URI() string {
+	return formatURI(uriPublicKey, "g", len(pk.internal), pk.Encode(nil), pk.params)
+}
+
+// URI returns a gophertags:dk URI carrying the detection key, its context and its path.
+func (dk *DetectionKey) URI() string {
+	return formatURI(uriDetectionKey, "n", len(dk.internal), dk.Encode(nil), dk.params)
+}
+
+// ParseURI parses a key URI, returning a *PublicKey or a *DetectionKey bound
+// to the URI's context and recording its path.
+func ParseURI(s string) (interface{}, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != URIScheme {
+		return nil, ErrURI
+	}
+	kind := u.Opaque
+	if kind == "" {
+		kind = u.Host
+	}
+	q := u.Query()
+	encoded, err := base64.RawURLEncoding.DecodeString(q.Get("k"))
+	if err != nil {
+		return nil, ErrURI
+	}
+	context := q.Get("c")
+	var path string
+	if q.Get("p") != "" {
+		p, err := ParseDerivationPath(q.Get("p"))
+		if err != nil {
+			return nil, ErrURI
+		}
+		path = p.String()
+	}
+
+	switch strings.ToLower(kind) {
+	case uriPublicKey:
+		pk := new(PublicKey)
+		pk.context = context
+		if err := pk.Decode(encoded); err != nil {
+			return nil, err
+		}
+		if !sizeMatches(q.Get("g"), PublicKeySize, len(encoded)-pathSize(pk.path)) || !pathAgrees(&pk.params, path) {
+			return nil, ErrURI
+		}
+		return pk, nil
+	case uriDetectionKey:
+		dk := new(DetectionKey)
+		dk.context = context
+		if err := dk.Decode(encoded); err != nil {
+			return nil, err
+		}
+		if !pathAgrees(&dk.params, path) {
+			return nil, ErrURI
+		}
+		// Keys from ExtractDetectionKeyRate are longer than DetectionKeySize(n).
+		if n, err := strconv.Atoi(q.Get("n")); err != nil || n != len(dk.internal) {
+			return nil, ErrURI
+		}
+		return dk, nil
+	}
+	return nil, ErrURI
+}
+
+// pathAgrees reports whether the path of a URI, if it has one, agrees with
+// that of the decoded key, and records it in the key if the key has none.
+func pathAgrees(p *params, path string) bool {
+	if path == "" || p.path == path {
+		return true
+	}
+	if p.path != "" {
+		return false
+	}
+	p.path = path
+	return true
+}
+
+func sizeMatches(param string, size func(int) int, length int) bool {
+	n, err := strconv.Atoi(param)
+	return err == nil && n >= 0 && size(n) == length
+}
+
+// ParsePublicKeyURI parses a gophertags:pk URI.
+func ParsePublicKeyURI(s string) (*PublicKey, error) {
+	key, err := ParseURI(s)
+	if err != nil {
+		return nil, err
+	}
+	pk, ok := key.(*PublicKey)
+	if !ok {
+		return nil, ErrURI
+	}
+	return pk, nil
+}
+
+// ParseDetectionKeyURI parses a gophertags:dk URI.
+func ParseDetectionKeyURI(s string) (*DetectionKey, error) {
+	key, err := ParseURI(s)
+	if err != nil {
+		return nil, err
+	}
+	dk, ok := key.(*DetectionKey)
+	if !ok {
+		return nil, ErrURI
+	}
+	return dk, nil
+}
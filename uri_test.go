@@ -0,0 +1,48 @@
+package gophertags
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestURIRoundTrip(t *testing.T) {
+	sk := NewSecretKeyWithContext(24, "chat app/v2")
+	pk, dk := sk.PublicKey(), sk.ExtractDetectionKey(6)
+
+	s := pk.URI()
+	if !strings.HasPrefix(s, "gophertags:pk?") || !strings.Contains(s, "g=24") {
+		t.Errorf("unexpected public key URI %s", s)
+	}
+	gotPK, err := ParsePublicKeyURI(s)
+	if err != nil || gotPK.Fingerprint() != pk.Fingerprint() || gotPK.Context() != "chat app/v2" {
+		t.Fatalf("public key round trip: %v", err)
+	}
+	gotDK, err := ParseDetectionKeyURI(strings.Replace(dk.URI(), "gophertags:", "gophertags://", 1))
+	if err != nil || gotDK.Fingerprint() != dk.Fingerprint() {
+		t.Fatalf("detection key round trip via gophertags://: %v", err)
+	}
+	if !gotDK.Test(gotPK.GenerateFlag()) {
+		t.Error("keys parsed from URIs lost their context")
+	}
+}
+
+func TestURIRejectsMalformed(t *testing.T) {
+	pk := NewSecretKey(8).PublicKey()
+	good := pk.URI()
+	for name, s := range map[string]string{
+		"wrong scheme":  strings.Replace(good, "gophertags:", "https:", 1),
+		"unknown kind":  strings.Replace(good, ":pk?", ":sk?", 1),
+		"wrong gamma":   strings.Replace(good, "g=8", "g=9", 1),
+		"missing gamma": strings.Replace(good, "g=8", "", 1),
+		"bad base64":    good + "!",
+		"truncated":     good[:len(good)-10],
+	} {
+		if _, err := ParseURI(s); !errors.Is(err, ErrURI) {
+			t.Errorf("%s: got %v, want ErrURI", name, err)
+		}
+	}
+	if _, err := ParseDetectionKeyURI(good); !errors.Is(err, ErrURI) {
+		t.Errorf("public key URI as detection key: got %v", err)
+	}
+}
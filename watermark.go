@@ -0,0 +1,89 @@
+package gophertags
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// A recipient who gives copies of one detection key to several servers can
+// watermark each copy, and tell from a leaked copy which server it came from.
+// The watermark is a recipient-chosen 8-byte mark followed by an 8-byte tag,
+// appended to the detection key encoding after any threshold:
+//
+//	tag = SHA3-256("gophertags watermark" || macKey || encoding || mark)[:8]
+//	macKey = SHA3-256("gophertags watermark key" || x_1 || ... || x_gamma)
+//
+// where encoding is the key's encoding without the watermark. The tag is keyed
+// by the whole secret key, so a server holding a key of precision n < gamma
+// can't forge another server's mark to frame it. It can still strip its own
+// mark; a stripped copy only narrows the leak to the servers given no mark.
+// Watermarks don't change which flags a key matches.
+
+const (
+	watermarkLabel    = "gophertags watermark"
+	watermarkKeyLabel = "gophertags watermark key"
+	watermarkMarkSize = 8
+	watermarkTagSize  = 8
+	watermarkSize     = watermarkMarkSize + watermarkTagSize
+)
+
+// ErrWatermark is returned by VerifyWatermark for keys without a watermark or
+// with one not made by the secret key.
+var ErrWatermark = errors.New("gophertags: missing or forged watermark")
+
+// WatermarkDetectionKey returns a copy of dk carrying mark, authenticated
+// under sk. dk must have been extracted from sk, with any precision or rate;
+// an existing watermark is replaced.
+func (sk *SecretKey) WatermarkDetectionKey(dk *DetectionKey, mark uint64) *DetectionKey {
+	marked := dk.WithContext(dk.context)
+	marked.watermark = nil
+	body := marked.Encode(nil)
+
+	watermark := make([]byte, watermarkMarkSize, watermarkSize)
+	binary.BigEndian.PutUint64(watermark, mark)
+	marked.watermark = append(watermark, sk.watermarkTag(body, watermark)...)
+	return marked
+}
+
+// Watermark returns the mark dk carries, without checking its tag. Only the
+// secret key can check it, with VerifyWatermark.
+func (dk *DetectionKey) Watermark() (mark uint64, ok bool) {
+	if dk.watermark == nil {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(dk.watermark), true
+}
+
+// VerifyWatermark returns the mark of a detection key watermarked by sk, or
+// ErrWatermark if dk has no watermark or its tag doesn't verify.
+func (sk *SecretKey) VerifyWatermark(dk *DetectionKey) (uint64, error) {
+	if dk.watermark == nil {
+		return 0, ErrWatermark
+	}
+	unmarked := dk.WithContext(dk.context)
+	unmarked.watermark = nil
+	mark := dk.watermark[:watermarkMarkSize]
+	tag := sk.watermarkTag(unmarked.Encode(nil), mark)
+	if subtle.ConstantTimeCompare(tag, dk.watermark[watermarkMarkSize:]) != 1 {
+		return 0, ErrWatermark
+	}
+	return binary.BigEndian.Uint64(mark), nil
+}
+
+func (sk *SecretKey) watermarkTag(encoding, mark []byte) []byte {
+	keyDigest := sha3.New256()
+	keyDigest.Write([]byte(watermarkKeyLabel))
+	for _, x := range sk.sk {
+		keyDigest.Write(x.Encode(nil))
+	}
+
+	digest := sha3.New256()
+	digest.Write([]byte(watermarkLabel))
+	digest.Write(keyDigest.Sum(nil))
+	digest.Write(encoding)
+	digest.Write(mark)
+	return digest.Sum(nil)[:watermarkTagSize]
+}
@@ -0,0 +1,57 @@
+package gophertags
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWatermark(t *testing.T) {
+	sk := NewSecretKey(16)
+	pk := sk.PublicKey()
+	for name, dk := range map[string]*DetectionKey{
+		"plain":      sk.ExtractDetectionKey(6),
+		"fractional": sk.ExtractDetectionKeyRate(0.3),
+	} {
+		a, b := sk.WatermarkDetectionKey(dk, 1), sk.WatermarkDetectionKey(dk, 2)
+		if a.Fingerprint() == b.Fingerprint() || a.KeyID() != dk.KeyID() {
+			t.Errorf("%s: watermarked copies are indistinguishable or changed KeyID", name)
+		}
+
+		leaked, err := DecodeDetectionKey(b.Encode(nil))
+		if err != nil {
+			t.Fatalf("%s: decoding watermarked key: %v", name, err)
+		}
+		if mark, err := sk.VerifyWatermark(leaked); err != nil || mark != 2 {
+			t.Errorf("%s: VerifyWatermark = %d, %v; want 2", name, mark, err)
+		}
+		if leaked.FalsePositiveRate() != dk.FalsePositiveRate() {
+			t.Errorf("%s: watermark changed the false positive rate", name)
+		}
+		for i := 0; i < 20; i++ {
+			f := pk.GenerateFlag()
+			if !leaked.Test(f) {
+				t.Fatalf("%s: watermarked key missed a true flag", name)
+			}
+			other := NewSecretKey(16).PublicKey().GenerateFlag()
+			if leaked.Test(other) != dk.Test(other) {
+				t.Fatalf("%s: watermark changed detection", name)
+			}
+		}
+
+		// A server can't relabel its copy as another server's.
+		forged := leaked.Encode(nil)
+		forged[len(forged)-watermarkSize] ^= 1
+		if f, err := DecodeDetectionKey(forged); err != nil {
+			t.Fatal(err)
+		} else if _, err := sk.VerifyWatermark(f); !errors.Is(err, ErrWatermark) {
+			t.Errorf("%s: forged mark: got %v, want ErrWatermark", name, err)
+		}
+	}
+
+	if _, err := sk.VerifyWatermark(sk.ExtractDetectionKey(6)); !errors.Is(err, ErrWatermark) {
+		t.Errorf("unmarked key: got %v, want ErrWatermark", err)
+	}
+	if _, err := NewSecretKey(16).VerifyWatermark(sk.WatermarkDetectionKey(sk.ExtractDetectionKey(6), 1)); !errors.Is(err, ErrWatermark) {
+		t.Errorf("another recipient's key: got %v, want ErrWatermark", err)
+	}
+}